@@ -3,28 +3,77 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
-	"sahand.dev/chisme/internal/command_runner"
-	"sahand.dev/chisme/internal/package_manager"
-	"sahand.dev/chisme/internal/package_manager/apt"
-	"sahand.dev/chisme/internal/package_manager/models"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/packagemanager/apk"
+	"sahand.dev/chisme/internal/packagemanager/apt"
+	"sahand.dev/chisme/internal/packagemanager/detect"
+	"sahand.dev/chisme/internal/packagemanager/dnf"
+	"sahand.dev/chisme/internal/packagemanager/pacman"
+	"sahand.dev/chisme/internal/packagemanager/versioncmp"
+	"sahand.dev/chisme/internal/packagemanager/zypper"
+	"sahand.dev/chisme/internal/persistence/models"
 )
 
 func main() {
 	// Define command-line arguments
-	packageManager := flag.String("package_manager", "apt", "The package_manager manager to use (e.g., apt, yum)")
+	packageManager := flag.String("package_manager", "auto", "The package_manager manager to use (e.g., auto, apt, apt-get, apk, dnf, dnf5, yum, pacman, zypper)")
 	command := flag.String("command", "list_upgradable", "The command to run (e.g., list_upgradable, update, remove, install)")
+	dryRun := flag.Bool("dry-run", false, "Only run read-only commands, log the commands that would change the system")
+	shell := flag.String("shell", "sh", "The shell running command lines (sh, bash or none)")
+	runAsUser := flag.String("user", "", "Run the commands as this user")
+	runAs := flag.String("run-as", "sudo", "The program switching to another user or to root (sudo or setpriv)")
+	only := flag.String("only", "", "Only list the upgrades of these comma separated kinds (epoch, major, minor, patch, revision)")
 
 	flag.Parse()
 	args := flag.Args()
 
+	var commandRunner commandrunner.CommandRunner = &commandrunner.LocalCommandRunner{
+		Shell: commandrunner.Shell(*shell),
+		User:  *runAsUser,
+		RunAs: commandrunner.RunAs(*runAs),
+	}
+	if *dryRun {
+		commandRunner = commandrunner.NewDryRunRunner(commandRunner, slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	}
+
 	// Initialize the appropriate package_manager manager
-	var pkgManager package_manager.PackageManger
+	var pkgManager packagemanager.PackageManger
 	switch *packageManager {
-	case "apt":
+	case "auto":
+		host, err := detect.Detect(commandRunner)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error detecting the package_manager manager: %s\n", err.Error())
+			os.Exit(1)
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "Detected %s %s %s, using %s\n", host.OS.ID, host.OS.VersionID, host.OS.Codename, host.PackageManagerName)
+		pkgManager = host.PackageManager
+	case "apt", "apt-get":
 		pkgManager = &apt.Apt{
-			CommandRunner: &command_runner.BashCommandRunner{},
-			CLI:           "apt",
+			CommandRunner: commandRunner,
+			CLI:           *packageManager,
+		}
+	case "apk":
+		pkgManager = &apk.Apk{
+			CommandRunner: commandRunner,
+			CLI:           "apk",
+		}
+	case "dnf", "dnf5", "yum":
+		pkgManager = &dnf.Dnf{
+			CommandRunner: commandRunner,
+			CLI:           *packageManager,
+		}
+	case "pacman":
+		pkgManager = &pacman.Pacman{
+			CommandRunner: commandRunner,
+			CLI:           "pacman",
+		}
+	case "zypper":
+		pkgManager = &zypper.Zypper{
+			CommandRunner: commandRunner,
+			CLI:           "zypper",
 		}
 	default:
 		_, _ = fmt.Fprintf(os.Stderr, "Unsupported package_manager manager: %s\n", *packageManager)
@@ -38,8 +87,16 @@ func main() {
 			_, _ = fmt.Fprintf(os.Stderr, "Error listing upgradable packages: %s\n", err.Error())
 			os.Exit(1)
 		}
+		if *only != "" {
+			kinds, ok := versioncmp.ParseUpgradeKinds(*only)
+			if !ok {
+				_, _ = fmt.Fprintf(os.Stderr, "Unsupported upgrade kinds: %s\n", *only)
+				os.Exit(1)
+			}
+			packages = versioncmp.FilterUpgrades(packages, kinds...)
+		}
 		for _, pkg := range packages {
-			fmt.Printf("Package: %s, Current Version: %s, New Version: %s\n", pkg.Name, pkg.CurrVersion, pkg.Version)
+			fmt.Printf("Package: %s, Current Version: %s, New Version: %s, Kind: %s\n", pkg.Name, pkg.InstalledVersion, pkg.Version, versioncmp.Classify(pkg.InstalledVersion, pkg.Version))
 		}
 	case "list_installed":
 		packages, err := pkgManager.GetPackages()
@@ -49,7 +106,7 @@ func main() {
 		}
 		for _, pkg := range packages {
 			if pkg.Installed {
-				fmt.Printf("Package: %s, Current Version: %s, New Version: %s\n", pkg.Name, pkg.CurrVersion, pkg.Version)
+				fmt.Printf("Package: %s, Current Version: %s, New Version: %s\n", pkg.Name, pkg.InstalledVersion, pkg.Version)
 			}
 		}
 	case "install":
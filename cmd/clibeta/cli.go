@@ -1,9 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/joho/godotenv"
 	"log"
+	"log/slog"
 	"os"
 	"sahand.dev/chisme/internal/commandrunner"
 	"strconv"
@@ -12,55 +14,118 @@ import (
 
 func main() {
 	loadEnv()
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: go run cli.go <COMMAND>\n")
+	dryRun := flag.Bool("dry-run", false, "Only run read-only commands, log the commands that would change the system")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: go run cli.go [--dry-run] <COMMAND>\n")
 		os.Exit(1)
 	}
 
-	command := strings.Join(os.Args[1:], " ")
-	privateKey, err := os.ReadFile(getEnv("SSH_PRIVATE_KEY_PATH", ""))
+	os.Exit(run(strings.Join(flag.Args(), " "), *dryRun))
+}
+
+// run runs the command elevated on the host and returns the exit code of the program, the runner is closed
+// before returning so that the askpass helper does not stay on the host
+func run(command string, dryRun bool) int {
+	sshConfig, err := sshConfigFromEnv()
 	if err != nil {
-		log.Fatalf("Error reading private key: %s", err)
+		log.Printf("Error loading SSH config: %s", err)
+		return 1
 	}
-	sshConfig := commandrunner.SSHConfig{
-		Host:               getEnv("SSH_HOST", "host"),
-		Port:               getEnvAsInt("SSH_PORT", 22),
-		User:               getEnv("SSH_USER", "u"),
-		PrivateKey:         privateKey,
-		PrivateKeyPassword: getEnv("SSH_PRIVATE_KEY_PASSWORD", "p"),
-	}
-	commandRunner, err := commandrunner.NewSSHCommandRunner(sshConfig)
+	sshRunner, err := commandrunner.NewSSHCommandRunner(sshConfig)
 	if err != nil {
-		log.Fatalf("Error creating SSH command runner: %s", err)
+		log.Printf("Error creating SSH command runner: %s", err)
+		return 1
 	}
+	defer sshRunner.Close()
 
-	//if getEnv("SSH_ASKPASS_PATH", "") != "" {
-	//	commandRunner.AskPassPath = getEnv("SSH_ASKPASS_PATH", "")
-	//}
-	ec := commandrunner.ExecCommand{Command: command, Elevated: true}
+	var commandRunner commandrunner.CommandRunner = sshRunner
+	if dryRun {
+		commandRunner = commandrunner.NewDryRunRunner(sshRunner, slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	}
 
-	ec.Input = strings.NewReader("PASS")
+	if askPassPath := getEnv("SSH_ASKPASS_PATH", ""); askPassPath != "" {
+		sshRunner.AskPassPath = askPassPath
+	}
+	ec := commandrunner.ExecCommand{Command: command, Elevated: true}
 
-	output, errorsChan, err := commandRunner.RunCommandAsync(ec)
+	events, err := commandRunner.RunCommandAsync(ec)
 	if err != nil {
-		log.Fatalf("Failed to run command: %v", err)
+		log.Printf("Failed to run command: %v", err)
+		return 1
 	}
 
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
-		for line := range output {
-			fmt.Println(line)
+	exitCode := 0
+	for event := range events {
+		switch event.Type {
+		case commandrunner.EventStdout:
+			fmt.Println(event.Line)
+		case commandrunner.EventStderr:
+			fmt.Fprintln(os.Stderr, event.Line)
+		case commandrunner.EventExit:
+			exitCode = event.ExitCode
+		case commandrunner.EventError:
+			log.Printf("Command execution error: %v", event.Err)
+			exitCode = 1
 		}
-	}()
+	}
+	return exitCode
+}
+
+// sshConfigFromEnv resolves SSH_HOST through ~/.ssh/config, SSH_PORT, SSH_USER and the authentication
+// and host key env vars take precedence over the settings found there. SUDO_PASSWORD is given to sudo when it asks for one,
+// through an askpass helper uploaded to the host when SUDO_ASKPASS_HELPER is true
+func sshConfigFromEnv() (commandrunner.SSHConfig, error) {
+	sshConfig, err := commandrunner.LoadSSHConfig(getEnv("SSH_HOST", "host"))
+	if err != nil {
+		return commandrunner.SSHConfig{}, err
+	}
 
-	select {
-	case err := <-errorsChan:
+	sshConfig.Port = getEnvAsInt("SSH_PORT", sshConfig.Port)
+	sshConfig.User = getEnv("SSH_USER", sshConfig.User)
+	authMethods, err := authMethodsFromEnv()
+	if err != nil {
+		return commandrunner.SSHConfig{}, err
+	}
+	sshConfig.AuthMethods = append(authMethods, sshConfig.AuthMethods...)
+	sshConfig.HostKey = hostKeyConfigFromEnv(sshConfig.HostKey)
+	if password := getEnv("SUDO_PASSWORD", ""); password != "" {
+		sshConfig.SudoPassword = commandrunner.StaticSecret(password)
+	}
+	sshConfig.AskPass, _ = strconv.ParseBool(getEnv("SUDO_ASKPASS_HELPER", "false"))
+
+	return sshConfig, nil
+}
+
+// authMethodsFromEnv tries the private key at SSH_PRIVATE_KEY_PATH and SSH_PASSWORD, in that order, skipping
+// the ones that are not set. The ssh-agent at SSH_AUTH_SOCK is added by the ssh config loader
+func authMethodsFromEnv() ([]commandrunner.AuthMethod, error) {
+	var methods []commandrunner.AuthMethod
+
+	if path := getEnv("SSH_PRIVATE_KEY_PATH", ""); path != "" {
+		privateKey, err := os.ReadFile(path)
 		if err != nil {
-			log.Fatalf("Command execution error: %v", err)
+			return nil, fmt.Errorf("failed to read private key: %w", err)
 		}
-	case <-done:
+		methods = append(methods, commandrunner.PrivateKeyAuth(privateKey, getEnv("SSH_PRIVATE_KEY_PASSWORD", "")))
+	}
+	if password := getEnv("SSH_PASSWORD", ""); password != "" {
+		methods = append(methods, commandrunner.PasswordAuth(password), commandrunner.AuthMethod{Type: commandrunner.AuthKeyboardInteractive, Password: password})
+	}
+
+	return methods, nil
+}
+
+// hostKeyConfigFromEnv verifies the host key against SSH_HOST_KEY_FINGERPRINT when set,
+// otherwise against the known_hosts file at SSH_KNOWN_HOSTS_PATH or as set by the ssh config
+func hostKeyConfigFromEnv(fallback commandrunner.HostKeyConfig) commandrunner.HostKeyConfig {
+	if fingerprint := getEnv("SSH_HOST_KEY_FINGERPRINT", ""); fingerprint != "" {
+		return commandrunner.HostKeyConfig{Policy: commandrunner.HostKeyFingerprint, Fingerprint: fingerprint}
+	}
+	if knownHostsPath := getEnv("SSH_KNOWN_HOSTS_PATH", ""); knownHostsPath != "" {
+		return commandrunner.HostKeyConfig{Policy: commandrunner.HostKeyKnownHosts, KnownHostsPath: knownHostsPath}
 	}
+	return fallback
 }
 
 func getEnv(key, fallback string) string {
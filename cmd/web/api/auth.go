@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// contextKey is the type of the request context keys set by the middlewares
+type contextKey string
+
+// operatorContextKey holds the name of the operator authenticated by requireOperator
+const operatorContextKey = contextKey("operator")
+
+// shellOperator is an operator allowed to open shells, identified by the SHA-256 digest of their token
+type shellOperator struct {
+	name        string
+	tokenDigest [sha256.Size]byte
+}
+
+// loadShellOperators reads the operators from a file with one "<name> <sha256 of the token in hex>" line per
+// operator, empty lines and lines starting with # are skipped
+func loadShellOperators(path string) ([]shellOperator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open shell operators file: %w", err)
+	}
+	defer file.Close()
+
+	var operators []shellOperator
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("failed to parse shell operators file: line %d: want <name> <token sha256>", lineNumber)
+		}
+		digest, err := hex.DecodeString(fields[1])
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("failed to parse shell operators file: line %d: invalid token sha256", lineNumber)
+		}
+
+		operator := shellOperator{name: fields[0]}
+		copy(operator.tokenDigest[:], digest)
+		operators = append(operators, operator)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read shell operators file: %w", err)
+	}
+	if len(operators) == 0 {
+		return nil, fmt.Errorf("no operators in shell operators file %s", path)
+	}
+	return operators, nil
+}
+
+// authenticateOperator returns the name of the operator owning the bearer token of the request. Every operator
+// is compared in constant time so the response time does not tell which digest matched
+func (app *application) authenticateOperator(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+
+	digest := sha256.Sum256([]byte(token))
+	name := ""
+	for _, operator := range app.shellOperators {
+		if subtle.ConstantTimeCompare(digest[:], operator.tokenDigest[:]) == 1 {
+			name = operator.name
+		}
+	}
+	return name, name != ""
+}
+
+// operatorFromContext returns the name of the operator authenticated by requireOperator
+func operatorFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(operatorContextKey).(string)
+	return name
+}
@@ -5,21 +5,50 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"sahand.dev/chisme/internal/commandrunner"
+	"strings"
 )
 
 type application struct {
 	logger *slog.Logger
+	// dryRun only lets read-only commands reach the hosts, the others are logged
+	dryRun bool
+	// shell lets operators open an emergency shell on the hosts over WebSocket
+	shell bool
+	// shellHosts are the ssh_config aliases of the hosts a shell may be opened on
+	shellHosts []string
+	// shellOperators may open shells, they authenticate with their bearer token
+	shellOperators []shellOperator
 }
 
 func SetUpAPI() {
-	addr := flag.String("addr", ":4004", "HTTP network address")
+	addr := flag.String("addr", "127.0.0.1:4004", "HTTP network address")
+	dryRun := flag.Bool("dry-run", false, "Only run read-only commands, log the commands that would change the system")
+	shell := flag.Bool("shell", false, "Let operators open an emergency shell on the hosts of --shell-hosts over WebSocket")
+	shellHosts := flag.String("shell-hosts", "", "Comma-separated ssh_config aliases of the hosts a shell may be opened on")
+	shellOperators := flag.String("shell-operators", "", "File with a \"<name> <token sha256>\" line per operator allowed to open shells")
 	flag.Parse()
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	app := &application{
 		logger: logger,
+		dryRun: *dryRun,
+		shell:  *shell,
 	}
-	logger.Info("starting server", "addr", *addr)
+	if app.shell {
+		app.shellHosts = splitList(*shellHosts)
+		if len(app.shellHosts) == 0 {
+			logger.Error("--shell requires --shell-hosts")
+			os.Exit(1)
+		}
+		operators, err := loadShellOperators(*shellOperators)
+		if err != nil {
+			logger.Error("--shell requires --shell-operators", "error", err)
+			os.Exit(1)
+		}
+		app.shellOperators = operators
+	}
+	logger.Info("starting server", "addr", *addr, "dry_run", *dryRun, "shell", *shell, "shell_hosts", app.shellHosts)
 
 	err := http.ListenAndServe(*addr, app.routes())
 	if err != nil {
@@ -28,3 +57,23 @@ func SetUpAPI() {
 
 	os.Exit(1)
 }
+
+// commandRunner wraps the runner of a host, every handler runs its commands through the returned runner. In
+// dry-run mode the commands changing the host are intercepted
+func (app *application) commandRunner(runner commandrunner.CommandRunner) commandrunner.CommandRunner {
+	if app.dryRun {
+		return commandrunner.NewDryRunRunner(runner, app.logger)
+	}
+	return runner
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 )
@@ -17,6 +18,31 @@ func commonHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// dryRunHeader tells clients that the server does not change the hosts
+func (app *application) dryRunHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.dryRun {
+			w.Header().Set("X-Dry-Run", "true")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireOperator only lets requests through that carry the bearer token of a shell operator, the name of
+// the operator is added to the request context
+func (app *application) requireOperator(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		operator, ok := app.authenticateOperator(r)
+		if !ok {
+			app.logger.Warn("shell authentication failed", "ip", r.RemoteAddr, "uri", r.URL.RequestURI())
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.clientError(w, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), operatorContextKey, operator)))
+	}
+}
+
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var (
@@ -18,5 +18,9 @@ func (app *application) routes() http.Handler {
 	mux.HandleFunc("GET /mock/server/{server}/application/{application}", getApplicationByID)
 	mux.HandleFunc("GET /mock/server/{server}/resource/{resource}", getResourceByID)
 
-	return app.recoverPanic(app.logRequest(commonHeaders(mux)))
+	if app.shell {
+		mux.HandleFunc("GET /hosts/{host}/shell", app.requireOperator(app.hostShell))
+	}
+
+	return app.recoverPanic(app.logRequest(commonHeaders(app.dryRunHeader(mux))))
 }
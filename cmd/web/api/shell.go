@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"sahand.dev/chisme/internal/commandrunner"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// shellCommand starts the login shell of the user on the host
+const shellCommand = `exec "${SHELL:-/bin/sh}" -l`
+
+// shellUpgrader upgrades shell requests to WebSocket connections, only pages served by the API itself
+// may open them as the origin must match the host
+var shellUpgrader = websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 32 * 1024}
+
+// shellMessage is a control message of the shell WebSocket, sent as JSON in a text message. The keystrokes of
+// the operator and the output of the shell are sent as binary messages
+type shellMessage struct {
+	// Type is resize from the operator, exit or error from the server
+	Type  string `json:"type"`
+	Rows  int    `json:"rows,omitempty"`
+	Cols  int    `json:"cols,omitempty"`
+	Code  int    `json:"code"`
+	Error string `json:"error,omitempty"`
+}
+
+// hostShell opens an emergency shell on the host named by its ssh_config alias and proxies it over a WebSocket.
+// Only the hosts of --shell-hosts are served. The initial terminal size is given by the rows and cols query parameters
+func (app *application) hostShell(w http.ResponseWriter, r *http.Request) {
+	operator := operatorFromContext(r.Context())
+	host := r.PathValue("host")
+	if !slices.Contains(app.shellHosts, host) {
+		app.logger.Warn("shell refused for host not allowed", "operator", operator, "host", host, "ip", r.RemoteAddr)
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	size, err := terminalSize(r.URL.Query())
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	config, err := commandrunner.LoadSSHConfig(host)
+	if err != nil {
+		app.logger.Warn("failed to resolve shell host", "operator", operator, "host", host, "error", err)
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	sshRunner, err := commandrunner.NewSSHCommandRunner(config)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	defer sshRunner.Close()
+
+	// the dry-run runner cannot tell what an interactive shell does, so it offers none
+	runner, ok := app.commandRunner(sshRunner).(commandrunner.InteractiveRunner)
+	if !ok {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	conn, err := shellUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied with an error
+		return
+	}
+	defer conn.Close()
+
+	session, err := runner.RunInteractive(r.Context(), commandrunner.ExecCommand{
+		Command: shellCommand,
+		PTY:     &commandrunner.PTY{Size: size},
+	})
+	if err != nil {
+		app.logger.Error("failed to open shell", "operator", operator, "host", host, "ip", r.RemoteAddr, "error", err)
+		_ = writeShellMessage(conn, shellMessage{Type: "error", Error: err.Error()})
+		return
+	}
+	defer session.Close()
+
+	startedAt := time.Now()
+	app.logger.Info("shell opened", "operator", operator, "host", host, "ip", r.RemoteAddr)
+	go forwardShellInput(conn, session)
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := session.Read(buf)
+		if n > 0 {
+			if err := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	code, err := session.Wait()
+	app.logger.Info("shell closed", "operator", operator, "host", host, "ip", r.RemoteAddr,
+		"duration", time.Since(startedAt), "exit_code", code, "error", err)
+	if err != nil {
+		_ = writeShellMessage(conn, shellMessage{Type: "error", Error: err.Error()})
+	} else {
+		_ = writeShellMessage(conn, shellMessage{Type: "exit", Code: code})
+	}
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+// forwardShellInput writes the keystrokes of the operator to the shell and resizes its terminal, the shell
+// is closed once the operator disconnects
+func forwardShellInput(conn *websocket.Conn, session commandrunner.InteractiveSession) {
+	defer session.Close()
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		switch messageType {
+		case websocket.BinaryMessage:
+			if _, err := session.Write(data); err != nil {
+				return
+			}
+		case websocket.TextMessage:
+			var message shellMessage
+			if json.Unmarshal(data, &message) != nil || message.Type != "resize" {
+				continue
+			}
+			_ = session.Resize(commandrunner.TerminalSize{Rows: message.Rows, Cols: message.Cols})
+		}
+	}
+}
+
+// writeShellMessage sends a control message to the operator
+func writeShellMessage(conn *websocket.Conn, message shellMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// terminalSize returns the terminal size given by the rows and cols query parameters, the default size when absent
+func terminalSize(query url.Values) (commandrunner.TerminalSize, error) {
+	var size commandrunner.TerminalSize
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{{"rows", &size.Rows}, {"cols", &size.Cols}} {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 || value > 1000 {
+			return size, errors.New("invalid terminal size")
+		}
+		*param.value = value
+	}
+	return size, nil
+}
@@ -3,9 +3,14 @@ module sahand.dev/chisme
 go 1.23
 
 require (
+	github.com/gorilla/websocket v1.5.3
 	github.com/joho/godotenv v1.5.1
 	github.com/mattn/go-sqlite3 v1.14.23
+	github.com/pkg/sftp v1.13.7
 	golang.org/x/crypto v0.27.0
 )
 
-require golang.org/x/sys v0.25.0 // indirect
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.25.0 // indirect
+)
@@ -0,0 +1,90 @@
+package commandrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// askPassHelper is the askpass program uploaded to the host. It prints the password read from the one-shot pipe
+// named by CHISME_ASKPASS_PIPE and removes the pipe, so a second prompt after a rejected password gets nothing
+const askPassHelper = `#!/bin/sh
+pipe=$CHISME_ASKPASS_PIPE
+[ -p "$pipe" ] || exit 1
+cat "$pipe"
+rm -f "$pipe"
+`
+
+// askPassCleanupTimeout limits how long Close waits for the askpass helper to be removed
+const askPassCleanupTimeout = 10 * time.Second
+
+// askPassHelperPath returns the path of the askpass helper on the host, it is uploaded on first use
+// into a new private directory that also holds the pipes the password is passed through
+func (s *SSHCommandRunner) askPassHelperPath(ctx context.Context) (string, error) {
+	s.sudoMu.Lock()
+	defer s.sudoMu.Unlock()
+
+	if s.askPass != "" {
+		return s.askPass, nil
+	}
+
+	result, err := s.RunCommandContext(ctx, ExecCommand{Args: []string{"sh", "-c", `mktemp -d "${TMPDIR:-/tmp}/chisme-askpass.XXXXXX"`}})
+	if err != nil {
+		return "", fmt.Errorf("failed to create askpass directory: %w", err)
+	}
+	dir := strings.TrimSpace(result.Stdout.String())
+	if dir == "" {
+		return "", errors.New("failed to create askpass directory: mktemp printed no path")
+	}
+
+	helper := path.Join(dir, "askpass")
+	if err := s.Upload(ctx, strings.NewReader(askPassHelper), helper, FileOptions{Mode: 0700}); err != nil {
+		_, _ = s.RunCommandContext(ctx, ExecCommand{Args: []string{"rm", "-rf", "--", dir}})
+		return "", fmt.Errorf("failed to upload askpass helper: %w", err)
+	}
+
+	s.askPass = helper
+	return helper, nil
+}
+
+// removeAskPassHelper removes the directory of the askpass helper from the host, if it was uploaded
+func (s *SSHCommandRunner) removeAskPassHelper() error {
+	s.sudoMu.Lock()
+	defer s.sudoMu.Unlock()
+
+	if s.askPass == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), askPassCleanupTimeout)
+	defer cancel()
+
+	_, err := s.RunCommandContext(ctx, ExecCommand{Args: []string{"rm", "-rf", "--", path.Dir(s.askPass)}})
+	s.askPass = ""
+	if err != nil {
+		return fmt.Errorf("failed to remove askpass helper: %w", err)
+	}
+	return nil
+}
+
+// askPassCommand returns the command line running the command with sudo -A and the askpass helper. The password
+// is the first line of stdin, the shell reads it with a builtin and writes it into a one-shot pipe the helper reads,
+// so it never shows up on a command line. The rest of stdin is left to the command
+func askPassCommand(helper, command string) string {
+	script := strings.Join([]string{
+		"IFS= read -r password || exit 1",
+		"pipe=$(mktemp -u " + ShellQuote(path.Join(path.Dir(helper), "pipe.XXXXXX")) + ") && mkfifo -m 600 \"$pipe\" || exit 1",
+		`(printf '%s\n' "$password" > "$pipe") &`,
+		"writer=$!",
+		"unset password",
+		`CHISME_ASKPASS_PIPE="$pipe" SUDO_ASKPASS=` + ShellQuote(helper) + " sudo -A -k " + command,
+		"status=$?",
+		`kill "$writer" 2>/dev/null`,
+		`rm -f "$pipe"`,
+		`exit "$status"`,
+	}, "\n")
+	return "sh -c " + ShellQuote(script)
+}
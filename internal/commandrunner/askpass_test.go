@@ -0,0 +1,123 @@
+package commandrunner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFakeAskPassSudo writes a sudo to dir that asks the askpass program for the password with -A,
+// up to three times like sudo does, and runs the command once it gets the password
+func writeFakeAskPassSudo(t *testing.T, dir, password string) {
+	t.Helper()
+
+	script := `#!/bin/sh
+askpass=0
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-A) askpass=1; shift ;;
+	-n|-k|-S) shift ;;
+	--) shift; break ;;
+	*) break ;;
+	esac
+done
+if [ $askpass = 1 ]; then
+	for attempt in 1 2 3; do
+		if [ "$("$SUDO_ASKPASS" "[sudo] password:")" = ` + ShellQuote(password) + ` ]; then
+			exec "$@"
+		fi
+		echo "Sorry, try again." >&2
+	done
+	echo "sudo: 3 incorrect password attempts" >&2
+	exit 1
+fi
+exec "$@"
+`
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake sudo: %v", err)
+	}
+}
+
+// startAskPassRunner starts a shell test SSH server with a sudo expecting the password secret and returns a runner
+// providing password through an askpass helper, the helper is created in the returned directory
+func startAskPassRunner(t *testing.T, password string) (*SSHCommandRunner, *testSSHServer, string) {
+	t.Helper()
+
+	sudoDir, tmpDir := t.TempDir(), t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+	writeFakeAskPassSudo(t, sudoDir, "secret")
+	server := startTestSSHServerWithOptions(t, testSSHServerOptions{shell: true, shellPath: sudoDir})
+	t.Cleanup(server.Close)
+
+	runner, err := NewSSHCommandRunner(SSHConfig{
+		Host:         server.Host,
+		Port:         server.Port,
+		User:         "test",
+		PrivateKey:   generateClientPrivateKey(t),
+		SudoPassword: StaticSecret(password),
+		AskPass:      true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create SSH command runner: %v", err)
+	}
+	t.Cleanup(func() { runner.Close() })
+	return runner, server, tmpDir
+}
+
+func TestSSHCommandRunner_AskPass(t *testing.T) {
+	runner, server, tmpDir := startAskPassRunner(t, "secret")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, ec := range []ExecCommand{
+		{Command: "echo Hello", Elevated: true},
+		{Command: "cat", Elevated: true, Input: strings.NewReader("Hello\n")},
+	} {
+		result, err := runner.RunCommandContext(ctx, ec)
+		if err != nil {
+			t.Fatalf("RunCommand(%q) error = %v", ec.Command, err)
+		}
+		if got := result.Stdout.String(); got != "Hello\n" {
+			t.Errorf("RunCommand(%q) = %q, want %q", ec.Command, got, "Hello\n")
+		}
+	}
+
+	helpers, _ := filepath.Glob(filepath.Join(tmpDir, "chisme-askpass.*", "*"))
+	if len(helpers) != 1 || filepath.Base(helpers[0]) != "askpass" {
+		t.Fatalf("askpass directory holds %v, want only the helper", helpers)
+	}
+	info, err := os.Stat(helpers[0])
+	if err != nil {
+		t.Fatalf("failed to stat helper: %v", err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("helper mode = %v, want 0700", info.Mode().Perm())
+	}
+	for _, command := range server.Commands() {
+		if strings.Contains(command, "secret") {
+			t.Errorf("command line %q contains the password", command)
+		}
+	}
+
+	if err := runner.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if dirs, _ := filepath.Glob(filepath.Join(tmpDir, "chisme-askpass.*")); len(dirs) != 0 {
+		t.Errorf("askpass directory %v not removed on Close()", dirs)
+	}
+}
+
+func TestSSHCommandRunner_AskPassIncorrectPassword(t *testing.T) {
+	runner, _, _ := startAskPassRunner(t, "wrong")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := runner.RunCommandContext(ctx, ExecCommand{Command: "echo Hello", Elevated: true})
+	if !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("RunCommand() error = %v, want %v", err, ErrIncorrectPassword)
+	}
+}
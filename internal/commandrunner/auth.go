@@ -0,0 +1,250 @@
+package commandrunner
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"net"
+	"os"
+)
+
+// AuthMethodType identifies an ssh authentication method
+type AuthMethodType int
+
+const (
+	// AuthPrivateKey authenticates with a private key
+	AuthPrivateKey AuthMethodType = iota
+	// AuthAgent authenticates with the keys held by an ssh-agent
+	AuthAgent
+	// AuthPassword authenticates with a password
+	AuthPassword
+	// AuthKeyboardInteractive answers the challenges of the server, with the password unless a challenge function is set
+	AuthKeyboardInteractive
+	// AuthCertificate authenticates with a private key and the OpenSSH user certificate issued for it
+	AuthCertificate
+)
+
+func (t AuthMethodType) String() string {
+	switch t {
+	case AuthPrivateKey:
+		return "private key"
+	case AuthAgent:
+		return "agent"
+	case AuthPassword:
+		return "password"
+	case AuthKeyboardInteractive:
+		return "keyboard-interactive"
+	case AuthCertificate:
+		return "certificate"
+	default:
+		return fmt.Sprintf("AuthMethodType(%d)", int(t))
+	}
+}
+
+// AuthMethod is an ssh authentication method, the fields that are used depend on the type
+type AuthMethod struct {
+	Type               AuthMethodType
+	PrivateKey         []byte
+	PrivateKeyPassword string
+	// Certificate is the OpenSSH user certificate in authorized_keys format, as found in id_ed25519-cert.pub
+	Certificate []byte
+	// AgentSocket is the path of the ssh-agent socket, defaults to SSH_AUTH_SOCK
+	AgentSocket string
+	Password    string
+	// Challenge answers keyboard-interactive challenges, when nil every question is answered with Password
+	Challenge ssh.KeyboardInteractiveChallenge
+}
+
+// PrivateKeyAuth returns an AuthMethod authenticating with a private key, the password may be empty
+func PrivateKeyAuth(privateKey []byte, password string) AuthMethod {
+	return AuthMethod{Type: AuthPrivateKey, PrivateKey: privateKey, PrivateKeyPassword: password}
+}
+
+// AgentAuth returns an AuthMethod authenticating with the ssh-agent listening on socket, or on SSH_AUTH_SOCK when empty
+func AgentAuth(socket string) AuthMethod {
+	return AuthMethod{Type: AuthAgent, AgentSocket: socket}
+}
+
+// PasswordAuth returns an AuthMethod authenticating with a password
+func PasswordAuth(password string) AuthMethod {
+	return AuthMethod{Type: AuthPassword, Password: password}
+}
+
+// KeyboardInteractiveAuth returns an AuthMethod answering keyboard-interactive challenges with the challenge function
+func KeyboardInteractiveAuth(challenge ssh.KeyboardInteractiveChallenge) AuthMethod {
+	return AuthMethod{Type: AuthKeyboardInteractive, Challenge: challenge}
+}
+
+// CertificateAuth returns an AuthMethod authenticating with a private key and its OpenSSH user certificate
+func CertificateAuth(privateKey []byte, password string, certificate []byte) AuthMethod {
+	return AuthMethod{Type: AuthCertificate, PrivateKey: privateKey, PrivateKeyPassword: password, Certificate: certificate}
+}
+
+// validateAuthMethod checks that all fields required by the type of the method are present
+func validateAuthMethod(method AuthMethod) error {
+	switch method.Type {
+	case AuthPrivateKey:
+		if len(method.PrivateKey) == 0 {
+			return fmt.Errorf("private key is required")
+		}
+	case AuthAgent:
+		if method.AgentSocket == "" && os.Getenv("SSH_AUTH_SOCK") == "" {
+			return fmt.Errorf("agent socket is required when SSH_AUTH_SOCK is not set")
+		}
+	case AuthPassword:
+		if method.Password == "" {
+			return fmt.Errorf("password is required")
+		}
+	case AuthKeyboardInteractive:
+		if method.Challenge == nil && method.Password == "" {
+			return fmt.Errorf("challenge function or password is required")
+		}
+	case AuthCertificate:
+		if len(method.PrivateKey) == 0 {
+			return fmt.Errorf("private key is required")
+		}
+		if len(method.Certificate) == 0 {
+			return fmt.Errorf("certificate is required")
+		}
+	default:
+		return fmt.Errorf("unknown authentication method: %d", method.Type)
+	}
+	return nil
+}
+
+// configuredAuthMethods returns the authentication methods of the config in order,
+// a private key set directly on the config is tried first
+func configuredAuthMethods(config *SSHConfig) []AuthMethod {
+	if len(config.PrivateKey) == 0 {
+		return config.AuthMethods
+	}
+	return append([]AuthMethod{PrivateKeyAuth(config.PrivateKey, config.PrivateKeyPassword)}, config.AuthMethods...)
+}
+
+// setupAuthMethods converts the authentication methods into ssh auth methods. The ssh client tries every method
+// name only once, so all public key based methods are merged into one that offers their keys in order.
+// The returned function closes the agent connections and must be called once the handshake is done
+func setupAuthMethods(methods []AuthMethod) ([]ssh.AuthMethod, func(), error) {
+	var authMethods []ssh.AuthMethod
+	var signers []func() ([]ssh.Signer, error)
+	var closers []func()
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+
+	for _, method := range methods {
+		switch method.Type {
+		case AuthPrivateKey, AuthCertificate, AuthAgent:
+			if len(signers) == 0 {
+				authMethods = append(authMethods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+					return collectSigners(signers)
+				}))
+			}
+
+			signer, closer, err := setupSigner(method)
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("failed to setup %s authentication: %w", method.Type, err)
+			}
+			if closer != nil {
+				closers = append(closers, closer)
+			}
+			signers = append(signers, signer)
+		case AuthPassword:
+			authMethods = append(authMethods, ssh.Password(method.Password))
+		case AuthKeyboardInteractive:
+			authMethods = append(authMethods, ssh.KeyboardInteractive(keyboardInteractiveChallenge(method)))
+		}
+	}
+
+	return authMethods, closeAll, nil
+}
+
+// setupSigner returns a function providing the signers of a public key based method
+// and a function releasing its resources, if any
+func setupSigner(method AuthMethod) (func() ([]ssh.Signer, error), func(), error) {
+	switch method.Type {
+	case AuthAgent:
+		socket := method.AgentSocket
+		if socket == "" {
+			socket = os.Getenv("SSH_AUTH_SOCK")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to agent: %w", err)
+		}
+		return agent.NewClient(conn).Signers, func() { conn.Close() }, nil
+	case AuthCertificate:
+		signer, err := getCertificateSigner(method.PrivateKey, method.PrivateKeyPassword, method.Certificate)
+		if err != nil {
+			return nil, nil, err
+		}
+		return staticSigners(signer), nil, nil
+	default:
+		signer, err := getPublicKeySignerFromPrivateKey(method.PrivateKey, method.PrivateKeyPassword)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get public key signer from private key: %w", err)
+		}
+		return staticSigners(signer), nil, nil
+	}
+}
+
+// collectSigners returns the signers of all providers in order
+func collectSigners(providers []func() ([]ssh.Signer, error)) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for _, provider := range providers {
+		s, err := provider()
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, s...)
+	}
+	return signers, nil
+}
+
+// staticSigners returns a provider of a fixed signer
+func staticSigners(signer ssh.Signer) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		return []ssh.Signer{signer}, nil
+	}
+}
+
+// getCertificateSigner returns a signer presenting the certificate for the private key
+func getCertificateSigner(privateKey []byte, password string, certificate []byte) (ssh.Signer, error) {
+	signer, err := getPublicKeySignerFromPrivateKey(privateKey, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key signer from private key: %w", err)
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	cert, ok := publicKey.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse certificate: not an OpenSSH certificate")
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate signer: %w", err)
+	}
+	return certSigner, nil
+}
+
+// keyboardInteractiveChallenge returns the challenge function of the method, answering every question
+// with the password when no challenge function is set
+func keyboardInteractiveChallenge(method AuthMethod) ssh.KeyboardInteractiveChallenge {
+	if method.Challenge != nil {
+		return method.Challenge
+	}
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range answers {
+			answers[i] = method.Password
+		}
+		return answers, nil
+	}
+}
@@ -0,0 +1,174 @@
+package commandrunner
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// generateEd25519ClientKey generates an ed25519 client key and returns it PEM encoded together with its public key
+func generateEd25519ClientKey(t *testing.T) ([]byte, ed25519.PrivateKey, ssh.PublicKey) {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate client key: %v", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		t.Fatalf("failed to marshal client key: %v", err)
+	}
+
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("failed to create ssh public key: %v", err)
+	}
+	return pem.EncodeToMemory(block), privateKey, sshPublicKey
+}
+
+// startTestAgent serves an ssh-agent holding the key on a unix socket and returns the socket path
+func startTestAgent(t *testing.T, key ed25519.PrivateKey) string {
+	t.Helper()
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatalf("failed to add key to agent: %v", err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on agent socket: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	return socket
+}
+
+// signUserCertificate issues a user certificate for the principal "test" signed by the authority
+func signUserCertificate(t *testing.T, authority ssh.Signer, key ssh.PublicKey) []byte {
+	t.Helper()
+
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.UserCert,
+		KeyId:           "test",
+		ValidPrincipals: []string{"test"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, authority); err != nil {
+		t.Fatalf("failed to sign certificate: %v", err)
+	}
+	return ssh.MarshalAuthorizedKey(cert)
+}
+
+// runEchoWithAuthMethods connects to the server with the authentication methods and runs an echo command
+func runEchoWithAuthMethods(t *testing.T, server *testSSHServer, methods ...AuthMethod) error {
+	t.Helper()
+
+	runner, err := NewSSHCommandRunner(SSHConfig{
+		Host:        server.Host,
+		Port:        server.Port,
+		User:        "test",
+		AuthMethods: methods,
+	})
+	if err != nil {
+		t.Fatalf("failed to create SSH command runner: %v", err)
+	}
+
+	_, err = runner.RunCommand(ExecCommand{Command: "echo Hello"})
+	return err
+}
+
+func TestAuthMethods(t *testing.T) {
+	authorizedPEM, authorizedKey, authorizedPublicKey := generateEd25519ClientKey(t)
+	unknownPEM, unknownKey, _ := generateEd25519ClientKey(t)
+	userPEM, _, userPublicKey := generateEd25519ClientKey(t)
+	authority := generateHostKey(t)
+
+	server := startTestSSHServerWithOptions(t, testSSHServerOptions{
+		authorizedKey:               authorizedPublicKey,
+		userCA:                      authority.PublicKey(),
+		password:                    "secret",
+		keyboardInteractivePassword: "interactive",
+	})
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		methods []AuthMethod
+		err     bool
+	}{
+		{"private key", []AuthMethod{PrivateKeyAuth(authorizedPEM, "")}, false},
+		{"unknown private key", []AuthMethod{PrivateKeyAuth(unknownPEM, "")}, true},
+		{"agent", []AuthMethod{AgentAuth(startTestAgent(t, authorizedKey))}, false},
+		{"agent with unknown key", []AuthMethod{AgentAuth(startTestAgent(t, unknownKey))}, true},
+		{"password", []AuthMethod{PasswordAuth("secret")}, false},
+		{"wrong password", []AuthMethod{PasswordAuth("wrong")}, true},
+		{"keyboard-interactive with password", []AuthMethod{{Type: AuthKeyboardInteractive, Password: "interactive"}}, false},
+		{"keyboard-interactive with challenge", []AuthMethod{KeyboardInteractiveAuth(
+			func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				return []string{"interactive"}, nil
+			})}, false},
+		{"certificate", []AuthMethod{CertificateAuth(userPEM, "", signUserCertificate(t, authority, userPublicKey))}, false},
+		{"certificate of unknown authority", []AuthMethod{CertificateAuth(userPEM, "", signUserCertificate(t, generateHostKey(t), userPublicKey))}, true},
+		{"falls back to the next method", []AuthMethod{PasswordAuth("wrong"), PrivateKeyAuth(authorizedPEM, "")}, false},
+		{"tries all public keys in order", []AuthMethod{PrivateKeyAuth(unknownPEM, ""), AgentAuth(startTestAgent(t, authorizedKey))}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runEchoWithAuthMethods(t, server, tt.methods...)
+			if (err != nil) != tt.err {
+				t.Errorf("expected err: %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestValidateConfig_AuthMethods(t *testing.T) {
+	base := SSHConfig{Host: "127.0.0.1", Port: 22, User: "test"}
+
+	tests := []struct {
+		name    string
+		methods []AuthMethod
+		err     bool
+	}{
+		{"no methods", nil, true},
+		{"private key", []AuthMethod{PrivateKeyAuth([]byte("key"), "")}, false},
+		{"empty private key", []AuthMethod{PrivateKeyAuth(nil, "")}, true},
+		{"agent socket", []AuthMethod{AgentAuth("/tmp/agent.sock")}, false},
+		{"empty password", []AuthMethod{PasswordAuth("")}, true},
+		{"keyboard-interactive without challenge", []AuthMethod{{Type: AuthKeyboardInteractive}}, true},
+		{"certificate without certificate", []AuthMethod{CertificateAuth([]byte("key"), "", nil)}, true},
+		{"unknown method", []AuthMethod{{Type: AuthMethodType(42)}}, true},
+		{"second method invalid", []AuthMethod{PasswordAuth("secret"), PasswordAuth("")}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := base
+			config.AuthMethods = tt.methods
+			err := validateConfig(config)
+			if (err != nil) != tt.err {
+				t.Errorf("expected err: %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
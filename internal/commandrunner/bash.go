@@ -1,10 +1,10 @@
 package commandrunner
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 )
 
@@ -12,46 +12,63 @@ import (
 type BashCommandRunner struct {
 }
 
-// RunCommand runs a bash command and returns the output as a scanner
-func (b *BashCommandRunner) RunCommand(ec ExecCommand) (*bufio.Scanner, error) {
-	cmd := exec.Command("bash", "-c", ec.Command)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("ec execution failed: %w", err)
-	}
+// Host returns the host the runner runs commands on, which is the local machine
+func (b *BashCommandRunner) Host() string {
+	return localHost
+}
 
-	scanner := bufio.NewScanner(bytes.NewReader(output))
-	return scanner, nil
+// RunCommand runs a bash command and returns its result
+func (b *BashCommandRunner) RunCommand(ec ExecCommand) (*CommandResult, error) {
+	return b.RunCommandContext(context.Background(), ec)
 }
 
-// RunCommandAsync runs a bash command asynchronously and returns a channel with the output lines
-func (b *BashCommandRunner) RunCommandAsync(ec ExecCommand) (<-chan string, <-chan error, error) {
-	output := make(chan string, 10)
-	errorsChan := make(chan error)
+// RunCommandContext runs a bash command and returns its result, the process group of the command
+// is killed when the context is cancelled or the timeout of the command expires.
+// A command exiting with a non-zero status returns its result together with an *ExitError
+func (b *BashCommandRunner) RunCommandContext(ctx context.Context, ec ExecCommand) (*CommandResult, error) {
+	return runLocalCommand(ctx, ec, newBashCommand)
+}
 
-	cmd := exec.Command("bash", "-c", ec.Command)
+// RunCommandAsync runs a bash command asynchronously and returns a channel with the events of the command
+func (b *BashCommandRunner) RunCommandAsync(ec ExecCommand) (<-chan Event, error) {
+	return b.RunCommandAsyncContext(context.Background(), ec)
+}
 
-	stdOut, stdErr, err := setupCmdPipes(cmd)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to setup ec: %w", err)
-	}
+// RunCommandAsyncContext runs a bash command asynchronously and returns a channel with the events of the command,
+// the process group of the command is killed when the context is cancelled or the timeout of the command expires
+func (b *BashCommandRunner) RunCommandAsyncContext(ctx context.Context, ec ExecCommand) (<-chan Event, error) {
+	return runLocalCommandAsync(ctx, ec, newBashCommand)
+}
 
-	err = cmd.Start()
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to start ec: %w", err)
+// newBashCommand creates the process for the command bound to the given context, a command given
+// as Args is executed directly while a Command line is run by bash. A umask is set by a shell that
+// then replaces itself with the command
+func newBashCommand(ctx context.Context, ec ExecCommand) (*exec.Cmd, error) {
+	if err := validateExecCommand(ec); err != nil {
+		return nil, err
+	}
+	if ec.PTY != nil {
+		return nil, ErrPTYUnsupported
 	}
 
-	go func() {
-		defer close(output)
-		defer close(errorsChan)
-		handleCmdOutput(stdOut, stdErr, output, errorsChan)
-
-		if err := cmd.Wait(); err != nil {
-			errorsChan <- fmt.Errorf("ec finished with error: %w", err)
-		}
-	}()
+	var cmd *exec.Cmd
+	switch {
+	case len(ec.Args) > 0 && ec.Umask != 0:
+		script := umaskCommand(ec.Umask) + ` && exec "$@"`
+		cmd = exec.CommandContext(ctx, "sh", append([]string{"-c", script, "sh"}, ec.Args...)...)
+	case len(ec.Args) > 0:
+		cmd = exec.CommandContext(ctx, ec.Args[0], ec.Args[1:]...)
+	default:
+		cmd = exec.CommandContext(ctx, "bash", "-c", shellPrelude(ExecCommand{Umask: ec.Umask})+ec.Command)
+	}
 
-	return output, errorsChan, nil
+	cmd.Dir = ec.Dir
+	cmd.Stdin = ec.Input
+	if len(ec.Env) > 0 {
+		cmd.Env = append(os.Environ(), envAssignments(ec.Env)...)
+	}
+	configureProcessGroup(cmd)
+	return cmd, nil
 }
 
 // setupCmdPipes sets up the command and returns the stdout and stderr pipes
@@ -66,14 +83,3 @@ func setupCmdPipes(cmd *exec.Cmd) (io.ReadCloser, io.ReadCloser, error) {
 	}
 	return stdOut, stdErr, nil
 }
-
-// handleCmdOutput pipes the command output line by line to the output channel
-func handleCmdOutput(stdOut, stdErr io.Reader, output chan string, errorsChan chan error) {
-	scanner := bufio.NewScanner(io.MultiReader(stdOut, stdErr))
-	for scanner.Scan() {
-		output <- scanner.Text()
-	}
-	if err := scanner.Err(); err != nil {
-		errorsChan <- fmt.Errorf("error reading stderr: %w", err)
-	}
-}
@@ -1,18 +1,22 @@
 package commandrunner
 
 import (
+	"context"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestBashCommandRunner_RunCommand_Cat(t *testing.T) {
 	cmdRunner := BashCommandRunner{}
 
-	scanner, err := cmdRunner.RunCommand(ExecCommand{Command: "echo 'Hello, World!'"})
+	result, err := cmdRunner.RunCommand(ExecCommand{Command: "echo 'Hello, World!'"})
 	if err != nil {
 		t.Fatalf("RunCommand() failed, error = %v", err)
 	}
 
+	scanner := result.Scanner()
 	for scanner.Scan() {
 		if scanner.Text() != "Hello, World!" {
 			t.Errorf("RunCommand() = %q expected %q", scanner.Text(), "Hello, World!")
@@ -33,18 +37,47 @@ func TestBashCommandRunner_RunCommand_NoExistingCommand(t *testing.T) {
 	}
 }
 
+func TestBashCommandRunner_RunCommand_Result(t *testing.T) {
+	cmdRunner := BashCommandRunner{}
+
+	result, err := cmdRunner.RunCommand(ExecCommand{Command: "echo out; echo err >&2; exit 3"})
+
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("RunCommand() error = %v, expected an ExitError", err)
+	}
+	if exitErr.Result != result {
+		t.Errorf("RunCommand() expected the ExitError to carry the returned result")
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("RunCommand() exit code = %d, want %d", result.ExitCode, 3)
+	}
+	if got := result.Stdout.String(); got != "out\n" {
+		t.Errorf("RunCommand() stdout = %q, want %q", got, "out\n")
+	}
+	if got := result.Stderr.String(); got != "err\n" {
+		t.Errorf("RunCommand() stderr = %q, want %q", got, "err\n")
+	}
+	if result.Host != localHost {
+		t.Errorf("RunCommand() host = %q, want %q", result.Host, localHost)
+	}
+	if result.StartedAt.IsZero() || result.Duration() < 0 {
+		t.Errorf("RunCommand() expected valid timestamps, got start %v and end %v", result.StartedAt, result.FinishedAt)
+	}
+}
+
 func TestBashCommandRunner_RunCommandAsync(t *testing.T) {
 	cmdRunner := BashCommandRunner{}
 
 	t.Run("EchoCommand", func(t *testing.T) {
-		output, outputError, err := cmdRunner.RunCommandAsync(ExecCommand{Command: "echo 'Hello, World!'"})
+		events, err := cmdRunner.RunCommandAsync(ExecCommand{Command: "echo 'Hello, World!'"})
 		if err != nil {
 			t.Fatalf("RunCommandAsync() failed, error = %v", err)
 		}
 
-		got, err := readFromChannels(t, output, outputError)
-		if err != nil {
-			t.Fatalf("RunCommandAsync() failed, error = %v", err)
+		got, exitCode, err := readEvents(t, events)
+		if err != nil || exitCode != 0 {
+			t.Fatalf("RunCommandAsync() failed, exit code = %d, error = %v", exitCode, err)
 		}
 
 		expected := "Hello, World!"
@@ -54,14 +87,14 @@ func TestBashCommandRunner_RunCommandAsync(t *testing.T) {
 	})
 
 	t.Run("CatCommand", func(t *testing.T) {
-		output, outputError, err := cmdRunner.RunCommandAsync(ExecCommand{Command: "echo 'Hello, World!' | cat"})
+		events, err := cmdRunner.RunCommandAsync(ExecCommand{Command: "echo 'Hello, World!' | cat"})
 		if err != nil {
 			t.Fatalf("RunCommandAsync() failed, error = %v", err)
 		}
 
-		got, err := readFromChannels(t, output, outputError)
-		if err != nil {
-			t.Fatalf("RunCommandAsync() failed, error = %v", err)
+		got, exitCode, err := readEvents(t, events)
+		if err != nil || exitCode != 0 {
+			t.Fatalf("RunCommandAsync() failed, exit code = %d, error = %v", exitCode, err)
 		}
 
 		expected := "Hello, World!"
@@ -71,14 +104,17 @@ func TestBashCommandRunner_RunCommandAsync(t *testing.T) {
 	})
 
 	t.Run("CommandWithError", func(t *testing.T) {
-		output, outputError, err := cmdRunner.RunCommandAsync(ExecCommand{Command: "invalid_command"})
+		events, err := cmdRunner.RunCommandAsync(ExecCommand{Command: "invalid_command"})
 		if err != nil {
 			t.Fatalf("RunCommandAsync() failed, error = %v", err)
 		}
 
-		got, err := readFromChannels(t, output, outputError)
-		if err == nil {
-			t.Fatalf("RunCommandAsync() expected to fail, error is nil")
+		got, exitCode, err := readEvents(t, events)
+		if err != nil {
+			t.Fatalf("RunCommandAsync() failed, error = %v", err)
+		}
+		if exitCode == 0 {
+			t.Fatalf("RunCommandAsync() expected to exit with a non-zero code")
 		}
 
 		if len(got) > 0 && !strings.Contains(got, "command not found") {
@@ -86,3 +122,96 @@ func TestBashCommandRunner_RunCommandAsync(t *testing.T) {
 		}
 	})
 }
+
+func TestBashCommandRunner_RunCommand_Timeout(t *testing.T) {
+	cmdRunner := BashCommandRunner{}
+
+	start := time.Now()
+	_, err := cmdRunner.RunCommand(ExecCommand{Command: "sleep 5 | cat", Timeout: 100 * time.Millisecond})
+	if err == nil {
+		t.Fatalf("RunCommand() expected an error but got nil")
+	}
+
+	var deadlineErr *DeadlineExceededError
+	if !errors.As(err, &deadlineErr) {
+		t.Fatalf("RunCommand() error = %v, expected a DeadlineExceededError", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RunCommand() error = %v, expected to match context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("RunCommand() returned after %s, expected the process group to be killed", elapsed)
+	}
+}
+
+func TestBashCommandRunner_RunCommandAsyncContext_Cancel(t *testing.T) {
+	cmdRunner := BashCommandRunner{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := cmdRunner.RunCommandAsyncContext(ctx, ExecCommand{Command: "echo started; sleep 5"})
+	if err != nil {
+		t.Fatalf("RunCommandAsyncContext() failed, error = %v", err)
+	}
+
+	if event := <-events; event.Type != EventStdout || event.Line != "started" {
+		t.Fatalf("RunCommandAsyncContext() = %v, want stdout line %q", event, "started")
+	}
+	cancel()
+
+	start := time.Now()
+	_, _, err = readEvents(t, events)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunCommandAsyncContext() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("RunCommandAsyncContext() finished after %s, expected the command to be killed", elapsed)
+	}
+}
+
+func TestBashCommandRunner_RunCommandAsync_Events(t *testing.T) {
+	cmdRunner := BashCommandRunner{}
+
+	events, err := cmdRunner.RunCommandAsync(ExecCommand{Command: "echo out; echo err >&2; exit 4"})
+	if err != nil {
+		t.Fatalf("RunCommandAsync() failed, error = %v", err)
+	}
+
+	var got []Event
+	for event := range events {
+		got = append(got, event)
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("RunCommandAsync() emitted %d events, want %d: %v", len(got), 3, got)
+	}
+	lines := map[EventType]string{}
+	for _, event := range got[:2] {
+		lines[event.Type] = event.Line
+	}
+	if lines[EventStdout] != "out" || lines[EventStderr] != "err" {
+		t.Errorf("RunCommandAsync() lines = %v, want stdout %q and stderr %q", lines, "out", "err")
+	}
+
+	last := got[2]
+	if last.Type != EventExit || last.ExitCode != 4 {
+		t.Errorf("RunCommandAsync() last event = %v, want exit with code %d", last, 4)
+	}
+	if last.Host != localHost || last.Time.IsZero() {
+		t.Errorf("RunCommandAsync() expected events to be tagged with host and time, got %v", last)
+	}
+}
+
+func TestBashCommandRunner_RunCommand_Args(t *testing.T) {
+	cmdRunner := BashCommandRunner{}
+
+	result, err := cmdRunner.RunCommand(ExecCommand{Args: []string{"echo", "$HOME; echo injected", "it's"}})
+	if err != nil {
+		t.Fatalf("RunCommand() failed, error = %v", err)
+	}
+	if got, want := result.Stdout.String(), "$HOME; echo injected it's\n"; got != want {
+		t.Errorf("RunCommand() = %q, want %q", got, want)
+	}
+	if got, want := result.Command, `echo '$HOME; echo injected' 'it'\''s'`; got != want {
+		t.Errorf("RunCommand() command = %q, want %q", got, want)
+	}
+}
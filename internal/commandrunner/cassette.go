@@ -0,0 +1,341 @@
+package commandrunner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replayHost is the host reported in the results of the ReplayRunner
+const replayHost = "replay"
+
+// ErrUnexpectedCommand is returned by the ReplayRunner for a command that matches no interaction of its cassette
+var ErrUnexpectedCommand = errors.New("unexpected command")
+
+// Interaction is a command recorded in a cassette together with its outcome
+type Interaction struct {
+	// Command is the command line, or a regular expression matching the whole command line when Regex is set
+	Command  string `json:"command"`
+	Regex    bool   `json:"regex,omitempty"`
+	Elevated bool   `json:"elevated,omitempty"`
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	ExitCode int    `json:"exit_code"`
+	// Error is the message of the error that kept the command from running to completion
+	Error string `json:"error,omitempty"`
+}
+
+// Cassette is a recorded sequence of commands and their outcomes
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette reads a cassette from a JSON file
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	return &cassette, nil
+}
+
+// Save writes the cassette to a JSON file
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// RecordingRunner runs commands with another runner and records them with their outcome in a cassette
+type RecordingRunner struct {
+	runner CommandRunner
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecordingRunner creates a RecordingRunner recording the commands run by runner
+func NewRecordingRunner(runner CommandRunner) *RecordingRunner {
+	return &RecordingRunner{runner: runner}
+}
+
+// Cassette returns a copy of the cassette recorded so far
+func (r *RecordingRunner) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return &Cassette{Interactions: append([]Interaction(nil), r.cassette.Interactions...)}
+}
+
+// Host returns the host of the recorded runner
+func (r *RecordingRunner) Host() string {
+	return hostOf(r.runner)
+}
+
+// Save writes the cassette recorded so far to a JSON file
+func (r *RecordingRunner) Save(path string) error {
+	return r.Cassette().Save(path)
+}
+
+// record appends an interaction to the cassette
+func (r *RecordingRunner) record(interaction Interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+}
+
+// RunCommand runs the command with the wrapped runner and records it
+func (r *RecordingRunner) RunCommand(command ExecCommand) (*CommandResult, error) {
+	return r.RunCommandContext(context.Background(), command)
+}
+
+// RunCommandContext runs the command with the wrapped runner and records it
+func (r *RecordingRunner) RunCommandContext(ctx context.Context, command ExecCommand) (*CommandResult, error) {
+	result, err := r.runner.RunCommandContext(ctx, command)
+
+	interaction := Interaction{Command: command.CommandLine(), Elevated: command.Elevated}
+	var exitErr *ExitError
+	switch {
+	case err == nil || errors.As(err, &exitErr):
+		if result == nil {
+			result = exitErr.Result
+		}
+		interaction.Stdout = result.Stdout.String()
+		interaction.Stderr = result.Stderr.String()
+		interaction.ExitCode = result.ExitCode
+	default:
+		interaction.Error = err.Error()
+	}
+	r.record(interaction)
+
+	return result, err
+}
+
+// RunCommandAsync runs the command asynchronously with the wrapped runner and records it
+func (r *RecordingRunner) RunCommandAsync(command ExecCommand) (<-chan Event, error) {
+	return r.RunCommandAsyncContext(context.Background(), command)
+}
+
+// RunCommandAsyncContext runs the command asynchronously with the wrapped runner, the events are forwarded
+// and the command is recorded once its stream ended
+func (r *RecordingRunner) RunCommandAsyncContext(ctx context.Context, command ExecCommand) (<-chan Event, error) {
+	interaction := Interaction{Command: command.CommandLine(), Elevated: command.Elevated}
+
+	events, err := r.runner.RunCommandAsyncContext(ctx, command)
+	if err != nil {
+		interaction.Error = err.Error()
+		r.record(interaction)
+		return nil, err
+	}
+
+	forwarded := make(chan Event, cap(events))
+	go func() {
+		defer close(forwarded)
+
+		var stdout, stderr strings.Builder
+		for event := range events {
+			switch event.Type {
+			case EventStdout:
+				stdout.WriteString(event.Line + "\n")
+			case EventStderr:
+				stderr.WriteString(event.Line + "\n")
+			case EventExit:
+				interaction.ExitCode = event.ExitCode
+				interaction.Stdout, interaction.Stderr = stdout.String(), stderr.String()
+				r.record(interaction)
+			case EventError:
+				interaction.Error = event.Err.Error()
+				r.record(interaction)
+			}
+			forwarded <- event
+		}
+	}()
+
+	return forwarded, nil
+}
+
+// ReplayRunner answers commands with the interactions of a cassette instead of running them. The commands
+// have to arrive in the order of the cassette unless AnyOrder is set, every interaction is replayed once
+type ReplayRunner struct {
+	// AnyOrder lets a command match any interaction not replayed yet instead of only the next one
+	AnyOrder bool
+
+	interactions []Interaction
+	patterns     []*regexp.Regexp
+
+	mu       sync.Mutex
+	replayed []bool
+}
+
+// NewReplayRunner creates a ReplayRunner replaying the cassette, it fails on an invalid regular expression
+func NewReplayRunner(cassette *Cassette) (*ReplayRunner, error) {
+	r := &ReplayRunner{
+		interactions: cassette.Interactions,
+		patterns:     make([]*regexp.Regexp, len(cassette.Interactions)),
+		replayed:     make([]bool, len(cassette.Interactions)),
+	}
+
+	for i, interaction := range cassette.Interactions {
+		if !interaction.Regex {
+			continue
+		}
+		pattern, err := regexp.Compile("^(?:" + interaction.Command + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid command pattern of interaction %d: %w", i, err)
+		}
+		r.patterns[i] = pattern
+	}
+	return r, nil
+}
+
+// Host returns the host reported in the results of the replay runner
+func (r *ReplayRunner) Host() string {
+	return replayHost
+}
+
+// Remaining returns the interactions that were not replayed yet
+func (r *ReplayRunner) Remaining() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var remaining []Interaction
+	for i, interaction := range r.interactions {
+		if !r.replayed[i] {
+			remaining = append(remaining, interaction)
+		}
+	}
+	return remaining
+}
+
+// matches reports whether the interaction at index i was recorded for the command
+func (r *ReplayRunner) matches(i int, command ExecCommand) bool {
+	interaction := r.interactions[i]
+	if interaction.Elevated != command.Elevated {
+		return false
+	}
+	if r.patterns[i] != nil {
+		return r.patterns[i].MatchString(command.CommandLine())
+	}
+	return interaction.Command == command.CommandLine()
+}
+
+// next marks the interaction recorded for the command as replayed and returns it
+func (r *ReplayRunner) next(command ExecCommand) (Interaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.interactions {
+		if r.replayed[i] {
+			continue
+		}
+		if r.matches(i, command) {
+			r.replayed[i] = true
+			return r.interactions[i], nil
+		}
+		if !r.AnyOrder {
+			return Interaction{}, fmt.Errorf("%w %q, expected %q", ErrUnexpectedCommand, command.CommandLine(), r.interactions[i].Command)
+		}
+	}
+	return Interaction{}, fmt.Errorf("%w %q", ErrUnexpectedCommand, command.CommandLine())
+}
+
+// RunCommand replays the interaction recorded for the command
+func (r *ReplayRunner) RunCommand(command ExecCommand) (*CommandResult, error) {
+	return r.RunCommandContext(context.Background(), command)
+}
+
+// RunCommandContext replays the interaction recorded for the command, or returns the context error
+// when the context is already done
+func (r *ReplayRunner) RunCommandContext(ctx context.Context, command ExecCommand) (*CommandResult, error) {
+	if ctx.Err() != nil {
+		return nil, contextError(ctx, command)
+	}
+	interaction, err := r.next(command)
+	if err != nil {
+		return nil, err
+	}
+	if interaction.Error != "" {
+		return nil, errors.New(interaction.Error)
+	}
+
+	result := &CommandResult{
+		Command:    command.CommandLine(),
+		Host:       replayHost,
+		ExitCode:   interaction.ExitCode,
+		Stdout:     bytes.NewBufferString(interaction.Stdout),
+		Stderr:     bytes.NewBufferString(interaction.Stderr),
+		StartedAt:  time.Now(),
+		FinishedAt: time.Now(),
+	}
+	if interaction.ExitCode != 0 {
+		return result, &ExitError{Result: result}
+	}
+	return result, nil
+}
+
+// RunCommandAsync replays the interaction recorded for the command asynchronously
+func (r *ReplayRunner) RunCommandAsync(command ExecCommand) (<-chan Event, error) {
+	return r.RunCommandAsyncContext(context.Background(), command)
+}
+
+// RunCommandAsyncContext replays the interaction recorded for the command asynchronously, it emits the stdout
+// and stderr lines followed by the recorded error or exit code. The output stops once the context is done
+func (r *ReplayRunner) RunCommandAsyncContext(ctx context.Context, command ExecCommand) (<-chan Event, error) {
+	if ctx.Err() != nil {
+		return nil, contextError(ctx, command)
+	}
+	interaction, err := r.next(command)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := newEventStream(replayHost)
+	go func() {
+		for _, output := range []struct {
+			eventType EventType
+			text      string
+		}{{EventStdout, interaction.Stdout}, {EventStderr, interaction.Stderr}} {
+			for _, line := range outputLines(output.text) {
+				if ctx.Err() != nil {
+					stream.fail(contextError(ctx, command))
+					return
+				}
+				stream.send(Event{Type: output.eventType, Line: line})
+			}
+		}
+
+		if interaction.Error != "" {
+			stream.fail(errors.New(interaction.Error))
+			return
+		}
+		stream.exit(interaction.ExitCode)
+	}()
+
+	return stream.events, nil
+}
+
+// outputLines splits output into its lines, a final newline does not start another line
+func outputLines(output string) []string {
+	if output == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+}
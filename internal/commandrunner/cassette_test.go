@@ -0,0 +1,150 @@
+package commandrunner
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRecordingRunner_Replay(t *testing.T) {
+	recorder := NewRecordingRunner(&BashCommandRunner{})
+
+	if _, err := recorder.RunCommand(ExecCommand{Args: []string{"echo", "Hello"}}); err != nil {
+		t.Fatalf("RunCommand() error = %v", err)
+	}
+	if _, err := recorder.RunCommand(ExecCommand{Command: "echo oops >&2; exit 3"}); err == nil {
+		t.Fatalf("RunCommand() expected an exit error")
+	}
+	events, err := recorder.RunCommandAsync(ExecCommand{Command: "echo one; echo two"})
+	if err != nil {
+		t.Fatalf("RunCommandAsync() error = %v", err)
+	}
+	readEvents(t, events)
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	if err := recorder.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("LoadCassette() error = %v", err)
+	}
+
+	want := []Interaction{
+		{Command: "echo Hello", Stdout: "Hello\n"},
+		{Command: "echo oops >&2; exit 3", Stderr: "oops\n", ExitCode: 3},
+		{Command: "echo one; echo two", Stdout: "one\ntwo\n"},
+	}
+	if !reflect.DeepEqual(cassette.Interactions, want) {
+		t.Fatalf("recorded %+v, want %+v", cassette.Interactions, want)
+	}
+
+	replay, err := NewReplayRunner(cassette)
+	if err != nil {
+		t.Fatalf("NewReplayRunner() error = %v", err)
+	}
+
+	result, err := replay.RunCommand(ExecCommand{Args: []string{"echo", "Hello"}})
+	if err != nil || result.Stdout.String() != "Hello\n" {
+		t.Errorf("RunCommand() = %q, %v, want %q", result.Stdout.String(), err, "Hello\n")
+	}
+
+	var exitErr *ExitError
+	if _, err := replay.RunCommand(ExecCommand{Command: "echo oops >&2; exit 3"}); !errors.As(err, &exitErr) || exitErr.Result.ExitCode != 3 {
+		t.Errorf("RunCommand() error = %v, want exit code 3", err)
+	}
+
+	events, err = replay.RunCommandAsync(ExecCommand{Command: "echo one; echo two"})
+	if err != nil {
+		t.Fatalf("RunCommandAsync() error = %v", err)
+	}
+	if output, exitCode, err := readEvents(t, events); output != "onetwo" || exitCode != 0 || err != nil {
+		t.Errorf("RunCommandAsync() = %q with exit code %d and error %v, want %q", output, exitCode, err, "onetwo")
+	}
+
+	if remaining := replay.Remaining(); len(remaining) != 0 {
+		t.Errorf("Remaining() = %v, want none", remaining)
+	}
+}
+
+func TestReplayRunner_Matching(t *testing.T) {
+	cassette := &Cassette{Interactions: []Interaction{
+		{Command: "apt list", Stdout: "installed"},
+		{Command: `apt install --only-upgrade \S+`, Regex: true, Elevated: true, Stdout: "upgraded"},
+	}}
+
+	tests := []struct {
+		name     string
+		anyOrder bool
+		commands []ExecCommand
+		stdout   []string
+		err      error
+	}{
+		{
+			name:     "in order",
+			commands: []ExecCommand{{Command: "apt list"}, {Command: "apt install --only-upgrade vim", Elevated: true}},
+			stdout:   []string{"installed", "upgraded"},
+		},
+		{
+			name:     "out of order",
+			commands: []ExecCommand{{Command: "apt install --only-upgrade vim", Elevated: true}},
+			err:      ErrUnexpectedCommand,
+		},
+		{
+			name:     "any order",
+			anyOrder: true,
+			commands: []ExecCommand{{Command: "apt install --only-upgrade vim", Elevated: true}, {Command: "apt list"}},
+			stdout:   []string{"upgraded", "installed"},
+		},
+		{
+			name:     "regex matches the whole command",
+			anyOrder: true,
+			commands: []ExecCommand{{Command: "apt install --only-upgrade vim git", Elevated: true}},
+			err:      ErrUnexpectedCommand,
+		},
+		{
+			name:     "elevation differs",
+			commands: []ExecCommand{{Command: "apt list", Elevated: true}},
+			err:      ErrUnexpectedCommand,
+		},
+		{
+			name:     "replayed once",
+			commands: []ExecCommand{{Command: "apt list"}, {Command: "apt list"}},
+			stdout:   []string{"installed"},
+			err:      ErrUnexpectedCommand,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replay, err := NewReplayRunner(cassette)
+			if err != nil {
+				t.Fatalf("NewReplayRunner() error = %v", err)
+			}
+			replay.AnyOrder = tt.anyOrder
+
+			var stdout []string
+			for _, command := range tt.commands {
+				result, err := replay.RunCommand(command)
+				if err != nil {
+					if !errors.Is(err, tt.err) {
+						t.Fatalf("RunCommand(%q) error = %v, want %v", command.Command, err, tt.err)
+					}
+					break
+				}
+				stdout = append(stdout, result.Stdout.String())
+			}
+			if !reflect.DeepEqual(stdout, tt.stdout) {
+				t.Errorf("RunCommand() stdout = %v, want %v", stdout, tt.stdout)
+			}
+		})
+	}
+}
+
+func TestNewReplayRunner_InvalidPattern(t *testing.T) {
+	_, err := NewReplayRunner(&Cassette{Interactions: []Interaction{{Command: "apt (", Regex: true}}})
+	if err == nil {
+		t.Errorf("NewReplayRunner() expected an error for an invalid pattern")
+	}
+}
@@ -1,20 +1,49 @@
 package commandrunner
 
 import (
-	"bufio"
+	"context"
 	"io"
+	"os"
+	"time"
 )
 
 // ExecCommand is a struct that holds the command to be executed and
 // a flag to indicate if the command should be run with elevated privileges
 type ExecCommand struct {
-	Command  string
+	// Command is a shell command line, it is ignored when Args is set
+	Command string
+	// Args is the program and its arguments, it is run without a shell locally and quoted for the remote shell over ssh
+	Args     []string
 	Elevated bool
 	Input    io.Reader
+	// Timeout limits how long the command may run, zero means no limit
+	Timeout time.Duration
+	// Env holds environment variables set for the command on top of the inherited ones, they survive elevation
+	Env map[string]string
+	// Dir is the working directory of the command, the default one of the runner when empty
+	Dir string
+	// Umask is the file mode creation mask of the command, zero keeps the inherited one.
+	// sudo combines it with its own umask for elevated commands
+	Umask os.FileMode
+	// Limits bounds the output of the command kept in memory, all of it is kept when zero
+	Limits OutputLimits
+	// PTY allocates a terminal for the command when set, for commands that prompt like dpkg on changed conffiles
+	PTY *PTY
+}
+
+// CommandLine returns the command as a shell command line, the arguments of Args are quoted
+func (ec ExecCommand) CommandLine() string {
+	if len(ec.Args) > 0 {
+		return ShellJoin(ec.Args)
+	}
+	return ec.Command
 }
 
 // CommandRunner is an interface for running system commands
 type CommandRunner interface {
-	RunCommand(command ExecCommand) (*bufio.Scanner, error)
-	RunCommandAsync(command ExecCommand) (<-chan string, <-chan error, error)
+	RunCommand(command ExecCommand) (*CommandResult, error)
+	RunCommandAsync(command ExecCommand) (<-chan Event, error)
+
+	RunCommandContext(ctx context.Context, command ExecCommand) (*CommandResult, error)
+	RunCommandAsyncContext(ctx context.Context, command ExecCommand) (<-chan Event, error)
 }
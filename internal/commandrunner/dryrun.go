@@ -0,0 +1,297 @@
+package commandrunner
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// dryRunHost is the host reported in the results of commands intercepted by the DryRunRunner
+const dryRunHost = "dry-run"
+
+// DryRunRunner runs read-only commands with another runner and intercepts the others. An intercepted command
+// is logged in the form it would run in and reported as successful without output
+type DryRunRunner struct {
+	// ReadOnly decides whether a command is let through, IsReadOnlyCommand when nil
+	ReadOnly func(ExecCommand) bool
+
+	runner CommandRunner
+	logger *slog.Logger
+}
+
+// NewDryRunRunner creates a DryRunRunner wrapping runner, intercepted commands are logged to logger
+// or to the default logger when it is nil
+func NewDryRunRunner(runner CommandRunner, logger *slog.Logger) *DryRunRunner {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &DryRunRunner{runner: runner, logger: logger}
+}
+
+// Host returns the host of the wrapped runner
+func (d *DryRunRunner) Host() string {
+	return hostOf(d.runner)
+}
+
+// intercepts reports whether the command is intercepted, and logs it if so
+func (d *DryRunRunner) intercepts(ctx context.Context, command ExecCommand) bool {
+	readOnly := d.ReadOnly
+	if readOnly == nil {
+		readOnly = IsReadOnlyCommand
+	}
+	if readOnly(command) {
+		return false
+	}
+
+	d.logger.InfoContext(ctx, "dry run: command not executed", "command", ElevatedCommandLine(command))
+	return true
+}
+
+// RunCommand runs a read-only command, other commands are intercepted
+func (d *DryRunRunner) RunCommand(command ExecCommand) (*CommandResult, error) {
+	return d.RunCommandContext(context.Background(), command)
+}
+
+// RunCommandContext runs a read-only command, other commands are intercepted and return an empty successful result
+func (d *DryRunRunner) RunCommandContext(ctx context.Context, command ExecCommand) (*CommandResult, error) {
+	if !d.intercepts(ctx, command) {
+		return d.runner.RunCommandContext(ctx, command)
+	}
+	if ctx.Err() != nil {
+		return nil, contextError(ctx, command)
+	}
+
+	now := time.Now()
+	return &CommandResult{
+		Command:    command.CommandLine(),
+		Host:       dryRunHost,
+		Stdout:     &bytes.Buffer{},
+		Stderr:     &bytes.Buffer{},
+		StartedAt:  now,
+		FinishedAt: now,
+	}, nil
+}
+
+// RunCommandAsync runs a read-only command asynchronously, other commands are intercepted
+func (d *DryRunRunner) RunCommandAsync(command ExecCommand) (<-chan Event, error) {
+	return d.RunCommandAsyncContext(context.Background(), command)
+}
+
+// RunCommandAsyncContext runs a read-only command asynchronously, other commands are intercepted and
+// their stream only carries an exit event with exit code zero
+func (d *DryRunRunner) RunCommandAsyncContext(ctx context.Context, command ExecCommand) (<-chan Event, error) {
+	if !d.intercepts(ctx, command) {
+		return d.runner.RunCommandAsyncContext(ctx, command)
+	}
+	if ctx.Err() != nil {
+		return nil, contextError(ctx, command)
+	}
+
+	stream := newEventStream(dryRunHost)
+	go stream.exit(0)
+	return stream.events, nil
+}
+
+// ElevatedCommandLine returns the command line as the remote shell runs it, with the working directory,
+// umask and environment of the command applied and prefixed with sudo when the command is elevated
+func ElevatedCommandLine(ec ExecCommand) string {
+	command := ec.CommandLine()
+	if len(ec.Env) > 0 {
+		command = envCommand(envAssignments(ec.Env), ec)
+	}
+	if ec.Elevated {
+		command = "sudo " + command
+	}
+	return shellPrelude(ec) + command
+}
+
+// IsReadOnlyCommand reports whether the command only reads the state of the host, such as listing packages or
+// simulating an upgrade. Command lines that cannot be split without a shell, like pipelines, are not read-only
+func IsReadOnlyCommand(ec ExecCommand) bool {
+	args := ec.Args
+	if len(args) == 0 {
+		var ok bool
+		if args, ok = splitCommandLine(ec.Command); !ok {
+			return false
+		}
+	}
+
+	args = stripSudo(args)
+	if len(args) == 0 {
+		return false
+	}
+
+	program := args[0]
+	if i := strings.LastIndexByte(program, '/'); i >= 0 {
+		program = program[i+1:]
+	}
+	if slices.Contains(readOnlyPrograms, program) {
+		return true
+	}
+	rule, ok := readOnlyRules[program]
+	return ok && rule(args[1:])
+}
+
+// readOnlyPrograms only read the state of the host whatever their arguments are
+var readOnlyPrograms = []string{
+	"apt-cache", "apt-config", "cat", "date", "df", "dpkg-query", "echo", "free", "head", "hostname", "id",
+	"ls", "lsb_release", "printenv", "pwd", "stat", "tail", "test", "true", "uname", "uptime", "wc", "which", "whoami",
+}
+
+// readOnlyRules decide from the arguments whether a package manager only reads the state of the host
+var readOnlyRules = map[string]func(args []string) bool{
+	"apt":     aptReadOnly,
+	"apt-get": aptReadOnly,
+	"dpkg": func(args []string) bool {
+		return len(args) > 0 && slices.Contains([]string{
+			"-l", "--list", "-s", "--status", "-L", "--listfiles", "-S", "--search",
+			"-p", "--print-avail", "--print-architecture", "--get-selections", "--compare-versions",
+		}, args[0])
+	},
+	"rpm": func(args []string) bool {
+		return len(args) > 0 && (strings.HasPrefix(args[0], "-q") || args[0] == "--query")
+	},
+	"dnf":  dnfReadOnly,
+	"dnf5": dnfReadOnly,
+	"yum":  dnfReadOnly,
+	"apk": func(args []string) bool {
+		return hasAnyArg(args, "-s", "--simulate") ||
+			subcommandIn(args, "info", "version", "search", "policy", "list", "dot", "stats")
+	},
+	"pacman": func(args []string) bool {
+		if len(args) == 0 {
+			return false
+		}
+		first := args[0]
+		if strings.HasPrefix(first, "-Q") || first == "--query" {
+			return true
+		}
+		// --print only prints the transaction, but y still refreshes the package database first
+		if (hasAnyArg(args, "--print") || hasShortFlag(args, 'p')) && !hasAnyArg(args, "--refresh") && !hasShortFlag(args, 'y') {
+			return true
+		}
+		// -S only reads with search, info, list and group flags, y and u refresh and upgrade and a bare -S installs
+		return strings.HasPrefix(first, "-S") && len(first) > 2 && strings.Trim(first[2:], "silg") == ""
+	},
+	"zypper": func(args []string) bool {
+		// -D is also the global --reposd-dir, only the long form of the dry run is recognized
+		return hasAnyArg(args, "--dry-run") || subcommandIn(args,
+			"list-updates", "lu", "list-patches", "lp", "patches", "pch", "packages", "pa",
+			"search", "se", "info", "if", "repos", "lr", "patch-check", "pchk")
+	},
+}
+
+// aptReadOnly reports whether apt or apt-get only reads, either by the subcommand or by simulating
+func aptReadOnly(args []string) bool {
+	return hasAnyArg(args, "-s", "--simulate", "--dry-run", "--just-print", "--recon", "--no-act") ||
+		subcommandIn(args, "list", "show", "search", "policy", "depends", "rdepends", "showsrc", "changelog", "check")
+}
+
+// dnfReadOnly reports whether dnf, dnf5 or yum only reads, either by the subcommand or by answering no to every question
+func dnfReadOnly(args []string) bool {
+	return hasAnyArg(args, "--assumeno") ||
+		subcommandIn(args, "list", "info", "check-update", "repoquery", "search", "provides", "repolist", "updateinfo")
+}
+
+// hasAnyArg reports whether one of the flags is among the arguments
+func hasAnyArg(args []string, flags ...string) bool {
+	return slices.ContainsFunc(args, func(arg string) bool {
+		return slices.Contains(flags, arg)
+	})
+}
+
+// hasShortFlag reports whether the flag is among the arguments, alone or grouped with other short flags like -Syu
+func hasShortFlag(args []string, flag rune) bool {
+	return slices.ContainsFunc(args, func(arg string) bool {
+		return len(arg) > 1 && arg[0] == '-' && arg[1] != '-' && strings.ContainsRune(arg[1:], flag)
+	})
+}
+
+// subcommandIn reports whether the first argument that is not an option is one of the subcommands
+func subcommandIn(args []string, subcommands ...string) bool {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			return slices.Contains(subcommands, arg)
+		}
+	}
+	return false
+}
+
+// stripSudo removes a leading sudo and its options without values from the arguments. Options taking a value
+// like -u are left in place so the command is not considered read-only
+func stripSudo(args []string) []string {
+	if len(args) == 0 || args[0] != "sudo" {
+		return args
+	}
+	args = args[1:]
+	for len(args) > 0 && slices.Contains([]string{"-n", "-E", "-H", "-k", "-S", "-A", "--"}, args[0]) {
+		if args[0] == "--" {
+			return args[1:]
+		}
+		args = args[1:]
+	}
+	return args
+}
+
+// splitCommandLine splits a simple command line into its words, removing quotes. It reports false for
+// command lines that need a shell, with operators, redirections, substitutions or expansions
+func splitCommandLine(line string) ([]string, bool) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '$', '`', '\\':
+				return nil, false
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case strings.ContainsRune("|&;<>()$`\n*?[]{}~#", r):
+			return nil, false
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, false
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, true
+}
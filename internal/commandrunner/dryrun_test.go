@@ -0,0 +1,112 @@
+package commandrunner
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestIsReadOnlyCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		ec       ExecCommand
+		readOnly bool
+	}{
+		{"apt list", ExecCommand{Args: []string{"apt", "list", "--upgradable"}}, true},
+		{"apt list command line", ExecCommand{Command: "apt list --installed"}, true},
+		{"apt upgrade", ExecCommand{Command: "apt upgrade -y", Elevated: true}, false},
+		{"apt install", ExecCommand{Args: []string{"apt", "install", "vim"}, Elevated: true}, false},
+		{"apt install simulated", ExecCommand{Args: []string{"apt", "install", "--only-upgrade", "--simulate", "vim"}, Elevated: true}, true},
+		{"apt update", ExecCommand{Command: "apt-get update"}, false},
+		{"apt options before subcommand", ExecCommand{Command: "apt -q list"}, true},
+		{"dpkg-query", ExecCommand{Command: "dpkg-query -W -f='${Package}\\n'"}, true},
+		{"dpkg install", ExecCommand{Command: "dpkg -i vim.deb"}, false},
+		{"dpkg list", ExecCommand{Command: "/usr/bin/dpkg -l"}, true},
+		{"sudo apt list", ExecCommand{Command: "sudo -n apt list"}, true},
+		{"sudo as another user", ExecCommand{Command: "sudo -u postgres apt list"}, false},
+		{"rpm query", ExecCommand{Args: []string{"rpm", "-qa"}}, true},
+		{"rpm erase", ExecCommand{Args: []string{"rpm", "-e", "vim"}}, false},
+		{"dnf check-update", ExecCommand{Args: []string{"dnf", "-q", "check-update"}}, true},
+		{"dnf upgrade assumeno", ExecCommand{Args: []string{"dnf", "upgrade", "--assumeno"}}, true},
+		{"dnf upgrade", ExecCommand{Args: []string{"dnf", "upgrade", "-y"}}, false},
+		{"dnf5 list installed", ExecCommand{Args: []string{"dnf5", "list", "--installed"}}, true},
+		{"dnf5 upgrade", ExecCommand{Args: []string{"dnf5", "upgrade", "-y"}}, false},
+		{"apk version", ExecCommand{Args: []string{"apk", "version", "-l", "<"}}, true},
+		{"apk upgrade simulated", ExecCommand{Args: []string{"apk", "upgrade", "--simulate"}}, true},
+		{"apk add", ExecCommand{Args: []string{"apk", "add", "vim"}}, false},
+		{"pacman query", ExecCommand{Args: []string{"pacman", "-Qu"}}, true},
+		{"pacman search", ExecCommand{Args: []string{"pacman", "-Ss", "vim"}}, true},
+		{"pacman upgrade", ExecCommand{Args: []string{"pacman", "-Syu"}}, false},
+		{"pacman install", ExecCommand{Args: []string{"pacman", "-S", "--noconfirm", "nginx"}}, false},
+		{"pacman sync info", ExecCommand{Args: []string{"pacman", "-Si", "nginx"}}, true},
+		{"pacman print upgrade", ExecCommand{Args: []string{"pacman", "-Sup", "--print", "vim"}}, true},
+		{"pacman print refresh", ExecCommand{Args: []string{"pacman", "-Syu", "-p"}}, false},
+		{"pacman print long refresh", ExecCommand{Args: []string{"pacman", "-Su", "--refresh", "--print"}}, false},
+		{"zypper list updates", ExecCommand{Args: []string{"zypper", "--xmlout", "list-updates"}}, true},
+		{"zypper update", ExecCommand{Args: []string{"zypper", "--non-interactive", "update"}}, false},
+		{"zypper update dry run", ExecCommand{Args: []string{"zypper", "--non-interactive", "update", "--dry-run", "vim"}}, true},
+		{"zypper reposd dir", ExecCommand{Args: []string{"zypper", "-D", "/tmp", "install", "-y", "vim"}}, false},
+		{"pipeline", ExecCommand{Command: "apt list | grep vim"}, false},
+		{"command list", ExecCommand{Command: "apt list; apt upgrade -y"}, false},
+		{"substitution", ExecCommand{Command: "echo $(apt upgrade -y)"}, false},
+		{"redirection", ExecCommand{Command: "echo x > /etc/hosts"}, false},
+		{"quoted operator", ExecCommand{Command: "echo 'a; b'"}, true},
+		{"unknown program", ExecCommand{Command: "reboot"}, false},
+		{"empty", ExecCommand{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsReadOnlyCommand(tt.ec); got != tt.readOnly {
+				t.Errorf("IsReadOnlyCommand() = %v, want %v", got, tt.readOnly)
+			}
+		})
+	}
+}
+
+func TestElevatedCommandLine(t *testing.T) {
+	ec := ExecCommand{
+		Args:     []string{"apt", "upgrade", "-y"},
+		Elevated: true,
+		Env:      map[string]string{"DEBIAN_FRONTEND": "noninteractive"},
+		Dir:      "/tmp",
+	}
+	want := "cd /tmp && sudo env 'DEBIAN_FRONTEND=noninteractive' apt upgrade -y"
+	if got := ElevatedCommandLine(ec); got != want {
+		t.Errorf("ElevatedCommandLine() = %q, want %q", got, want)
+	}
+}
+
+func TestDryRunRunner(t *testing.T) {
+	var logs bytes.Buffer
+	mock := &MockCommandRunner{Output: "vim/noble 9.1 amd64\n"}
+	runner := NewDryRunRunner(mock, slog.New(slog.NewTextHandler(&logs, nil)))
+
+	result, err := runner.RunCommand(ExecCommand{Args: []string{"apt", "list"}})
+	if err != nil || result.Stdout.String() != mock.Output {
+		t.Fatalf("RunCommand() = %q, %v, want the output of the wrapped runner", result.Stdout.String(), err)
+	}
+
+	result, err = runner.RunCommand(ExecCommand{Command: "apt upgrade -y", Elevated: true})
+	if err != nil || result.ExitCode != 0 || result.Stdout.Len() != 0 {
+		t.Fatalf("RunCommand() = %+v, %v, want an empty successful result", result, err)
+	}
+
+	events, err := runner.RunCommandAsync(ExecCommand{Args: []string{"apt", "install", "vim"}, Elevated: true})
+	if err != nil {
+		t.Fatalf("RunCommandAsync() error = %v", err)
+	}
+	if output, exitCode, err := readEvents(t, events); output != "" || exitCode != 0 || err != nil {
+		t.Errorf("RunCommandAsync() = %q with exit code %d and error %v, want no output and exit code 0", output, exitCode, err)
+	}
+
+	if commands := mock.Commands(); len(commands) != 1 || commands[0].CommandLine() != "apt list" {
+		t.Errorf("wrapped runner ran %v, want only apt list", commands)
+	}
+	for _, command := range []string{"sudo apt upgrade -y", "sudo apt install vim"} {
+		if !strings.Contains(logs.String(), command) {
+			t.Errorf("log %q does not contain the intercepted command %q", logs.String(), command)
+		}
+	}
+}
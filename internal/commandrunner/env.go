@@ -0,0 +1,78 @@
+package commandrunner
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// envNamePattern matches the environment variable names a POSIX shell accepts
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateExecCommand checks the environment variable names and the umask of the command
+func validateExecCommand(ec ExecCommand) error {
+	for name := range ec.Env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name: %q", name)
+		}
+	}
+	if ec.Umask > 0777 {
+		return fmt.Errorf("invalid umask: %#o", uint32(ec.Umask))
+	}
+	return nil
+}
+
+// envAssignments returns the environment of the command as NAME=value assignments sorted by name
+func envAssignments(env map[string]string) []string {
+	assignments := make([]string, 0, len(env))
+	for name, value := range env {
+		assignments = append(assignments, name+"="+value)
+	}
+	slices.Sort(assignments)
+	return assignments
+}
+
+// envCommand returns the command line of the command prefixed with env setting the assignments. A command line given
+// as Command may be a compound one like `a && b`, it is run with sh -c so every command of it gets the environment
+func envCommand(assignments []string, ec ExecCommand) string {
+	if len(ec.Args) > 0 {
+		return "env " + ShellJoin(assignments) + " " + ShellJoin(ec.Args)
+	}
+	return "env " + ShellJoin(assignments) + " sh -c " + ShellQuote(ec.Command)
+}
+
+// umaskCommand returns the shell command setting the umask
+func umaskCommand(umask os.FileMode) string {
+	return fmt.Sprintf("umask %04o", uint32(umask))
+}
+
+// shellPrelude returns the shell commands changing to the working directory and setting the umask of the command,
+// joined so the command only runs when they succeed
+func shellPrelude(ec ExecCommand) string {
+	var prelude []string
+	if ec.Dir != "" {
+		prelude = append(prelude, "cd "+ShellQuote(ec.Dir))
+	}
+	if ec.Umask != 0 {
+		prelude = append(prelude, umaskCommand(ec.Umask))
+	}
+	if len(prelude) == 0 {
+		return ""
+	}
+	return strings.Join(prelude, " && ") + " && "
+}
+
+// setenv sets the assignments on the session and reports whether the server accepted all of them,
+// servers only accept the variables listed in AcceptEnv of their sshd_config
+func setenv(session *ssh.Session, assignments []string) bool {
+	for _, assignment := range assignments {
+		name, value, _ := strings.Cut(assignment, "=")
+		if err := session.Setenv(name, value); err != nil {
+			return false
+		}
+	}
+	return true
+}
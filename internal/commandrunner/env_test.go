@@ -0,0 +1,189 @@
+package commandrunner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// envTestScript prints the variable FOO, the working directory and the umask separated by |
+const envTestScript = `printf '%s|%s|%s' "$FOO" "$(pwd)" "$(umask)"`
+
+// writeFakeSudo writes a sudo to dir that runs the command with a reset environment, like env_reset of sudoers
+func writeFakeSudo(t *testing.T, dir string) {
+	t.Helper()
+
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-n|-k|-S) shift ;;
+	-p|-u) shift 2 ;;
+	--) shift; break ;;
+	*) break ;;
+	esac
+done
+exec env -i PATH="$PATH" "$@"
+`
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake sudo: %v", err)
+	}
+}
+
+func TestBashCommandRunner_RunCommand_Env(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to resolve temp dir: %v", err)
+	}
+	want := "bar baz|" + dir + "|0027"
+
+	tests := []struct {
+		name string
+		ec   ExecCommand
+	}{
+		{"command line", ExecCommand{Command: envTestScript}},
+		{"args", ExecCommand{Args: []string{"sh", "-c", envTestScript}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.ec.Env = map[string]string{"FOO": "bar baz"}
+			tt.ec.Dir = dir
+			tt.ec.Umask = 0027
+
+			result, err := (&BashCommandRunner{}).RunCommand(tt.ec)
+			if err != nil {
+				t.Fatalf("RunCommand() error = %v", err)
+			}
+			if got := result.Stdout.String(); got != want {
+				t.Errorf("RunCommand() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestValidateExecCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		ec   ExecCommand
+		err  bool
+	}{
+		{"valid", ExecCommand{Env: map[string]string{"LC_ALL": "C", "_x1": ""}, Umask: 0077}, false},
+		{"name with space", ExecCommand{Env: map[string]string{"A B": "x"}}, true},
+		{"name with injection", ExecCommand{Env: map[string]string{"A=$(reboot) B": "x"}}, true},
+		{"name starting with digit", ExecCommand{Env: map[string]string{"1A": "x"}}, true},
+		{"umask out of range", ExecCommand{Umask: 01000}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateExecCommand(tt.ec)
+			if (err != nil) != tt.err {
+				t.Errorf("expected err: %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSSHCommandRunner_RunCommand_Env(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to resolve temp dir: %v", err)
+	}
+	sudoDir := t.TempDir()
+	writeFakeSudo(t, sudoDir)
+	want := "bar 'baz'|" + dir + "|0027"
+
+	tests := []struct {
+		name      string
+		acceptEnv bool
+		elevated  bool
+		inlineEnv bool
+	}{
+		{name: "setenv", acceptEnv: true},
+		{name: "setenv rejected", inlineEnv: true},
+		{name: "elevated", acceptEnv: true, elevated: true, inlineEnv: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startTestSSHServerWithOptions(t, testSSHServerOptions{shell: true, shellPath: sudoDir, acceptEnv: tt.acceptEnv})
+			defer server.Close()
+			runner := newPoolTestRunner(t, server, 0)
+
+			result, err := runner.RunCommand(ExecCommand{
+				Args:     []string{"sh", "-c", envTestScript},
+				Env:      map[string]string{"FOO": "bar 'baz'"},
+				Dir:      dir,
+				Umask:    0027,
+				Elevated: tt.elevated,
+			})
+			if err != nil {
+				t.Fatalf("RunCommand() error = %v", err)
+			}
+			if got := result.Stdout.String(); got != want {
+				t.Errorf("RunCommand() = %q, want %q", got, want)
+			}
+
+			commands := server.Commands()
+			last := commands[len(commands)-1]
+			if got := strings.Contains(last, "FOO="); got != tt.inlineEnv {
+				t.Errorf("command %q passes the environment inline: %v, want %v", last, got, tt.inlineEnv)
+			}
+		})
+	}
+}
+
+func TestEnvCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		ec   ExecCommand
+		want string
+	}{
+		{"args", ExecCommand{Args: []string{"apt", "list"}}, "env 'FOO=bar baz' apt list"},
+		{"command line", ExecCommand{Command: "apt update"}, "env 'FOO=bar baz' sh -c 'apt update'"},
+		{"compound command line", ExecCommand{Command: `cd /tmp && echo "$FOO"; echo 'done'`}, `env 'FOO=bar baz' sh -c 'cd /tmp && echo "$FOO"; echo '\''done'\'''`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := envCommand([]string{"FOO=bar baz"}, tt.ec); got != tt.want {
+				t.Errorf("envCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSHCommandRunner_RunCommand_EnvCompound(t *testing.T) {
+	sudoDir := t.TempDir()
+	writeFakeSudo(t, sudoDir)
+	want := "bar|bar|bar"
+
+	tests := []struct {
+		name     string
+		elevated bool
+	}{
+		{name: "setenv rejected"},
+		{name: "elevated", elevated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startTestSSHServerWithOptions(t, testSSHServerOptions{shell: true, shellPath: sudoDir})
+			defer server.Close()
+			runner := newPoolTestRunner(t, server, 0)
+
+			result, err := runner.RunCommand(ExecCommand{
+				Command:  `printf '%s' "$FOO" && printf '|%s' "$FOO"; printf '|%s' "$FOO"`,
+				Env:      map[string]string{"FOO": "bar"},
+				Elevated: tt.elevated,
+			})
+			if err != nil {
+				t.Fatalf("RunCommand() error = %v", err)
+			}
+			if got := result.Stdout.String(); got != want {
+				t.Errorf("RunCommand() = %q, want %q", got, want)
+			}
+		})
+	}
+}
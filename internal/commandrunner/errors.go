@@ -0,0 +1,57 @@
+package commandrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DeadlineExceededError is returned when a command is still running once its deadline has passed
+type DeadlineExceededError struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (e *DeadlineExceededError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("command %q did not finish within %s", e.Command, e.Timeout)
+	}
+	return fmt.Sprintf("command %q did not finish before the deadline", e.Command)
+}
+
+// Unwrap allows errors.Is(err, context.DeadlineExceeded) to match
+func (e *DeadlineExceededError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// commandContext derives the context a command runs in by applying the timeout of the command
+func commandContext(ctx context.Context, ec ExecCommand) (context.Context, context.CancelFunc) {
+	if ec.Timeout > 0 {
+		return context.WithTimeout(ctx, ec.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// contextError converts the error of a done command context into the error reported to the caller
+func contextError(ctx context.Context, ec ExecCommand) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &DeadlineExceededError{Command: ec.CommandLine(), Timeout: ec.Timeout}
+	}
+	return ctx.Err()
+}
+
+// NotStartedError is returned when a command failed before it was started on the host, like when the connection
+// or a session could not be opened. Such a command did not run at all and can be run again
+type NotStartedError struct {
+	Err error
+}
+
+func (e *NotStartedError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error that kept the command from starting
+func (e *NotStartedError) Unwrap() error {
+	return e.Err
+}
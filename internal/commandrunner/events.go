@@ -0,0 +1,150 @@
+package commandrunner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of record emitted by an asynchronously running command
+type EventType int
+
+const (
+	// EventStdout carries a line the command wrote to stdout
+	EventStdout EventType = iota
+	// EventStderr carries a line the command wrote to stderr
+	EventStderr
+	// EventExit is the last event of a command that ran to completion and carries its exit code
+	EventExit
+	// EventError is the last event of a command that could not run to completion and carries the error
+	EventError
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventStdout:
+		return "stdout"
+	case EventStderr:
+		return "stderr"
+	case EventExit:
+		return "exit"
+	case EventError:
+		return "error"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event is a record emitted while a command runs asynchronously. Every stream of events ends
+// with exactly one EventExit or EventError, after which the channel is closed
+type Event struct {
+	Type     EventType
+	Line     string
+	ExitCode int
+	Err      error
+	Host     string
+	Time     time.Time
+	// Truncated is set on a line cut at the maximum line length and on the marker replacing the output
+	// dropped past the cap of the command
+	Truncated bool
+}
+
+// eventStream emits the events of a command running on a host
+type eventStream struct {
+	host   string
+	limits OutputLimits
+	events chan Event
+}
+
+// newEventStream creates a stream for a command running on host
+func newEventStream(host string) *eventStream {
+	return &eventStream{
+		host:   host,
+		events: make(chan Event, 10),
+	}
+}
+
+// newLimitedEventStream creates a stream for a command running on host that keeps its output within the limits
+func newLimitedEventStream(host string, limits OutputLimits) *eventStream {
+	stream := newEventStream(host)
+	stream.limits = limits
+	return stream
+}
+
+// send timestamps the event, tags it with the host and emits it
+func (s *eventStream) send(event Event) {
+	event.Host = s.host
+	event.Time = time.Now()
+	s.events <- event
+}
+
+// exit emits the final event of a command that exited with the exit code and closes the stream
+func (s *eventStream) exit(exitCode int) {
+	s.send(Event{Type: EventExit, ExitCode: exitCode})
+	close(s.events)
+}
+
+// fail emits the final event of a command that could not run to completion and closes the stream
+func (s *eventStream) fail(err error) {
+	s.send(Event{Type: EventError, Err: err})
+	close(s.events)
+}
+
+// streamOutput reads stdout and stderr concurrently and emits every line as an event of the matching type,
+// it returns once both readers are exhausted
+func (s *eventStream) streamOutput(stdOut, stdErr io.Reader) error {
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = s.streamLines(stdOut, EventStdout)
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = s.streamLines(stdErr, EventStderr)
+	}()
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamLines emits every line of the reader as an event of the given type, long lines are cut and the lines
+// past the cap are replaced by a single marker. When reading fails the rest of the reader is discarded so the
+// command does not block on a full pipe
+func (s *eventStream) streamLines(r io.Reader, eventType EventType) error {
+	reader := bufio.NewReader(r)
+	var written int64
+	dropped := false
+	for {
+		line, cut, err := readLine(reader, s.limits.maxLineLength())
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			_, _ = io.Copy(io.Discard, r)
+			return fmt.Errorf("error reading %s: %w", eventType, err)
+		}
+
+		if s.limits.MaxBytes > 0 {
+			written += int64(len(line)) + 1
+			if written > s.limits.MaxBytes {
+				if !dropped {
+					dropped = true
+					s.send(Event{Type: eventType, Line: truncationMarker(s.limits.MaxBytes), Truncated: true})
+				}
+				continue
+			}
+		}
+		s.send(Event{Type: eventType, Line: line, Truncated: cut})
+	}
+}
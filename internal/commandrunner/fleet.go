@@ -0,0 +1,229 @@
+package commandrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSkipped is the error of a host the command was not run on because another host failed first
+var ErrSkipped = errors.New("skipped after a failure on another host")
+
+// FleetRunner runs a command on many hosts in parallel, the hosts are known by their names
+type FleetRunner struct {
+	// Parallelism limits how many hosts run the command at the same time, zero runs it on all hosts at once
+	Parallelism int
+	// FailFast cancels the command on the running hosts and skips the remaining ones once a host fails,
+	// otherwise the command runs on every host whatever the others do
+	FailFast bool
+
+	names   []string
+	runners map[string]CommandRunner
+}
+
+// HostResult is the outcome of a command on a host of the fleet, Result is set when the command ran to completion
+// even when it exited with a non-zero status
+type HostResult struct {
+	Name   string
+	Result *CommandResult
+	Err    error
+}
+
+// FleetError is returned when a command failed on some hosts of the fleet
+type FleetError struct {
+	// Errors holds the error of every failed host by name
+	Errors map[string]error
+	Hosts  int
+}
+
+func (e *FleetError) Error() string {
+	names := slices.Sorted(maps.Keys(e.Errors))
+	failures := make([]string, 0, len(names))
+	for _, name := range names {
+		failures = append(failures, fmt.Sprintf("%s: %v", name, e.Errors[name]))
+	}
+	return fmt.Sprintf("command failed on %d of %d hosts: %s", len(names), e.Hosts, strings.Join(failures, "; "))
+}
+
+// Unwrap returns the errors of the failed hosts so errors.Is and errors.As match any of them
+func (e *FleetError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, name := range slices.Sorted(maps.Keys(e.Errors)) {
+		errs = append(errs, e.Errors[name])
+	}
+	return errs
+}
+
+// NewFleetRunner creates a FleetRunner with an SSHCommandRunner for every named config, wrapped with the middlewares
+func NewFleetRunner(configs map[string]SSHConfig, middlewares ...Middleware) (*FleetRunner, error) {
+	runners := make(map[string]CommandRunner, len(configs))
+	for _, name := range slices.Sorted(maps.Keys(configs)) {
+		runner, err := NewSSHCommandRunner(configs[name])
+		if err != nil {
+			closeRunners(runners)
+			return nil, fmt.Errorf("invalid config of host %s: %w", name, err)
+		}
+		runners[name] = Chain(runner, middlewares...)
+	}
+	return NewFleetRunnerFromRunners(runners), nil
+}
+
+// NewFleetRunnerFromRunners creates a FleetRunner running the commands of every named host with its runner
+func NewFleetRunnerFromRunners(runners map[string]CommandRunner) *FleetRunner {
+	return &FleetRunner{
+		names:   slices.Sorted(maps.Keys(runners)),
+		runners: runners,
+	}
+}
+
+// Hosts returns the names of the hosts in the fleet, sorted
+func (f *FleetRunner) Hosts() []string {
+	return slices.Clone(f.names)
+}
+
+// Close closes the connections of the runners of the fleet
+func (f *FleetRunner) Close() error {
+	return closeRunners(f.runners)
+}
+
+// closeRunners closes the runners that hold connections, the ones wrapped by middlewares included
+func closeRunners(runners map[string]CommandRunner) error {
+	var errs []error
+	for _, runner := range runners {
+		for runner != nil {
+			if closer, ok := runner.(io.Closer); ok {
+				errs = append(errs, closer.Close())
+				break
+			}
+			wrapped, ok := runner.(*WrappedRunner)
+			if !ok {
+				break
+			}
+			runner = wrapped.Next
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// failed reports whether the outcome of a command is a failure, which includes a non-zero exit status
+func failed(result *CommandResult, err error) bool {
+	return err != nil || (result != nil && result.ExitCode != 0)
+}
+
+// schedule calls run for every host, at most Parallelism at a time, and waits for them to return. run reports
+// whether the host failed, with FailFast the other hosts are cancelled then. skip is called with the error of
+// the hosts not started once the context is done
+func (f *FleetRunner) schedule(parent context.Context, run func(ctx context.Context, name string) bool, skip func(name string, err error)) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	parallelism := f.Parallelism
+	if parallelism <= 0 {
+		parallelism = len(f.names)
+	}
+	slots := make(chan struct{}, max(parallelism, 1))
+
+	var wg sync.WaitGroup
+	for i, name := range f.names {
+		acquired := false
+		select {
+		case slots <- struct{}{}:
+			if acquired = ctx.Err() == nil; !acquired {
+				<-slots
+			}
+		case <-ctx.Done():
+		}
+		if !acquired {
+			err := ErrSkipped
+			if parent.Err() != nil {
+				err = parent.Err()
+			}
+			for _, name := range f.names[i:] {
+				skip(name, err)
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if run(ctx, name) && f.FailFast {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Run runs the command on every host and returns the results in the order of the host names. A *FleetError
+// is returned along with the results when the command failed or exited with a non-zero status on any host
+func (f *FleetRunner) Run(ctx context.Context, ec ExecCommand) ([]HostResult, error) {
+	results := make(map[string]*HostResult, len(f.names))
+	for _, name := range f.names {
+		results[name] = &HostResult{Name: name}
+	}
+
+	f.schedule(ctx, func(ctx context.Context, name string) bool {
+		result, err := f.runners[name].RunCommandContext(ctx, ec)
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) {
+			result = exitErr.Result
+		}
+		results[name].Result, results[name].Err = result, err
+		return failed(result, err)
+	}, func(name string, err error) {
+		results[name].Err = err
+	})
+
+	ordered := make([]HostResult, 0, len(f.names))
+	fleetErr := &FleetError{Errors: map[string]error{}, Hosts: len(f.names)}
+	for _, name := range f.names {
+		ordered = append(ordered, *results[name])
+		if err := results[name].Err; err != nil {
+			fleetErr.Errors[name] = err
+		}
+	}
+	if len(fleetErr.Errors) > 0 {
+		return ordered, fleetErr
+	}
+	return ordered, nil
+}
+
+// RunAsync runs the command on every host and merges their events into one channel, the Host of every event
+// is the name of its host. Every host ends with exactly one EventExit or EventError, hosts skipped after a
+// failure with an EventError carrying ErrSkipped, and the channel is closed after the last host
+func (f *FleetRunner) RunAsync(ctx context.Context, ec ExecCommand) <-chan Event {
+	merged := make(chan Event, 10*max(len(f.names), 1))
+
+	go func() {
+		defer close(merged)
+
+		f.schedule(ctx, func(ctx context.Context, name string) bool {
+			events, err := f.runners[name].RunCommandAsyncContext(ctx, ec)
+			if err != nil {
+				merged <- Event{Type: EventError, Err: err, Host: name, Time: time.Now()}
+				return true
+			}
+
+			hostFailed := false
+			for event := range events {
+				event.Host = name
+				hostFailed = hostFailed || event.Type == EventError || (event.Type == EventExit && event.ExitCode != 0)
+				merged <- event
+			}
+			return hostFailed
+		}, func(name string, err error) {
+			merged <- Event{Type: EventError, Err: err, Host: name, Time: time.Now()}
+		})
+	}()
+
+	return merged
+}
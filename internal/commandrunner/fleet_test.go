@@ -0,0 +1,163 @@
+package commandrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startTestFleet starts a test SSH server for every name and returns their configs
+func startTestFleet(t *testing.T, names ...string) map[string]SSHConfig {
+	t.Helper()
+
+	configs := make(map[string]SSHConfig, len(names))
+	for _, name := range names {
+		server := startTestSSHServer(t)
+		t.Cleanup(server.Close)
+		configs[name] = SSHConfig{Host: server.Host, Port: server.Port, User: "test", PrivateKey: generateClientPrivateKey(t)}
+	}
+	return configs
+}
+
+func TestFleetRunner_Run(t *testing.T) {
+	configs := startTestFleet(t, "web1", "web2", "db1")
+	down := startTestSSHServer(t)
+	down.Close()
+	configs["down"] = SSHConfig{Host: down.Host, Port: down.Port, User: "test", PrivateKey: generateClientPrivateKey(t)}
+
+	fleet, err := NewFleetRunner(configs)
+	if err != nil {
+		t.Fatalf("NewFleetRunner() error = %v", err)
+	}
+	defer fleet.Close()
+	fleet.Parallelism = 2
+
+	results, err := fleet.Run(context.Background(), ExecCommand{Command: "echo Hello"})
+	var fleetErr *FleetError
+	if !errors.As(err, &fleetErr) || len(fleetErr.Errors) != 1 || fleetErr.Errors["down"] == nil {
+		t.Fatalf("Run() error = %v, want a fleet error for the host down", err)
+	}
+
+	var names []string
+	for _, result := range results {
+		names = append(names, result.Name)
+		if result.Name == "down" {
+			continue
+		}
+		if result.Err != nil || result.Result.Stdout.String() != "Hello\n" {
+			t.Errorf("Run() on %s = %v, %v, want Hello", result.Name, result.Result, result.Err)
+		}
+	}
+	if got := fmt.Sprint(names); got != "[db1 down web1 web2]" {
+		t.Errorf("Run() returned results for %s, want [db1 down web1 web2]", got)
+	}
+}
+
+func TestFleetRunner_RunAsync(t *testing.T) {
+	fleet, err := NewFleetRunner(startTestFleet(t, "web1", "web2"))
+	if err != nil {
+		t.Fatalf("NewFleetRunner() error = %v", err)
+	}
+	defer fleet.Close()
+
+	lines := map[string][]string{}
+	exits := map[string]int{}
+	for event := range fleet.RunAsync(context.Background(), ExecCommand{Command: "echo Hello"}) {
+		switch event.Type {
+		case EventStdout:
+			lines[event.Host] = append(lines[event.Host], event.Line)
+		case EventExit:
+			exits[event.Host]++
+		case EventError:
+			t.Errorf("host %s failed: %v", event.Host, event.Err)
+		}
+	}
+
+	for _, name := range fleet.Hosts() {
+		if fmt.Sprint(lines[name]) != "[Hello]" || exits[name] != 1 {
+			t.Errorf("host %s emitted %v and %d exit events, want [Hello] and one exit event", name, lines[name], exits[name])
+		}
+	}
+}
+
+func TestFleetRunner_FailFast(t *testing.T) {
+	tests := []struct {
+		name     string
+		failFast bool
+		skipped  int
+	}{
+		{name: "fail fast", failFast: true, skipped: 2},
+		{name: "continue on error", failFast: false, skipped: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fleet := NewFleetRunnerFromRunners(map[string]CommandRunner{
+				"a": &MockCommandRunner{ExitCode: 1},
+				"b": &MockCommandRunner{Output: "ok"},
+				"c": &MockCommandRunner{Output: "ok"},
+			})
+			fleet.Parallelism = 1
+			fleet.FailFast = tt.failFast
+
+			results, err := fleet.Run(context.Background(), ExecCommand{Command: "apt update"})
+			if err == nil {
+				t.Fatalf("Run() expected an error")
+			}
+			skipped := 0
+			for _, result := range results {
+				if errors.Is(result.Err, ErrSkipped) {
+					skipped++
+				}
+			}
+			if skipped != tt.skipped {
+				t.Errorf("Run() skipped %d hosts, want %d", skipped, tt.skipped)
+			}
+
+			finals := map[string]int{}
+			skipped = 0
+			for event := range fleet.RunAsync(context.Background(), ExecCommand{Command: "apt update"}) {
+				if event.Type == EventExit || event.Type == EventError {
+					finals[event.Host]++
+				}
+				if errors.Is(event.Err, ErrSkipped) {
+					skipped++
+				}
+			}
+			if len(finals) != 3 || finals["a"] != 1 || finals["b"] != 1 || finals["c"] != 1 {
+				t.Errorf("RunAsync() final events per host = %v, want one for every host", finals)
+			}
+			if skipped != tt.skipped {
+				t.Errorf("RunAsync() skipped %d hosts, want %d", skipped, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestFleetRunner_Parallelism(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	runners := map[string]CommandRunner{}
+	for i := 0; i < 6; i++ {
+		mock := &MockCommandRunner{}
+		runners[fmt.Sprintf("host%d", i)] = &WrappedRunner{Next: mock, Run: func(ctx context.Context, command ExecCommand) (*CommandResult, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for m := maxRunning.Load(); n > m && !maxRunning.CompareAndSwap(m, n); m = maxRunning.Load() {
+			}
+			time.Sleep(20 * time.Millisecond)
+			return mock.RunCommandContext(ctx, command)
+		}}
+	}
+
+	fleet := NewFleetRunnerFromRunners(runners)
+	fleet.Parallelism = 2
+	if _, err := fleet.Run(context.Background(), ExecCommand{Command: "true"}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := maxRunning.Load(); got != 2 {
+		t.Errorf("at most %d hosts ran the command at the same time, want 2", got)
+	}
+}
@@ -0,0 +1,162 @@
+package commandrunner
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"sahand.dev/chisme/internal/persistence"
+	"strings"
+)
+
+// HostKeyPolicy selects how the host key presented by the ssh server is verified
+type HostKeyPolicy int
+
+const (
+	// HostKeyInsecureIgnore accepts any host key, it is only meant for tests and throwaway hosts
+	HostKeyInsecureIgnore HostKeyPolicy = iota
+	// HostKeyKnownHosts only accepts keys listed for the host in a known_hosts file
+	HostKeyKnownHosts
+	// HostKeyTrustOnFirstUse pins the fingerprint of the first key seen for the host and rejects any other key afterwards
+	HostKeyTrustOnFirstUse
+	// HostKeyFingerprint only accepts the key matching an explicit SHA256 fingerprint
+	HostKeyFingerprint
+)
+
+// HostKeyConfig holds the settings of the host key verification, the fields that are used depend on the policy
+type HostKeyConfig struct {
+	Policy HostKeyPolicy
+	// KnownHostsPath is the known_hosts file used by HostKeyKnownHosts, defaults to ~/.ssh/known_hosts
+	KnownHostsPath string
+	// Fingerprint is the SHA256 fingerprint accepted by HostKeyFingerprint, e.g. SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s
+	Fingerprint string
+	// Store keeps the fingerprints pinned by HostKeyTrustOnFirstUse
+	Store persistence.HostKeyStore
+}
+
+// ErrUnknownHostKey is returned by HostKeyKnownHosts when the known_hosts file has no key for the host
+var ErrUnknownHostKey = errors.New("host key is not known")
+
+// HostKeyMismatchError is returned when the server presents a key that differs from the expected one
+type HostKeyMismatchError struct {
+	Host      string
+	Presented string
+	Expected  []string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key mismatch for %s: presented %s, expected %s", e.Host, e.Presented, strings.Join(e.Expected, " or "))
+}
+
+// validateHostKeyConfig checks that the fields required by the policy are present
+func validateHostKeyConfig(config HostKeyConfig) error {
+	switch config.Policy {
+	case HostKeyInsecureIgnore, HostKeyKnownHosts:
+	case HostKeyTrustOnFirstUse:
+		if config.Store == nil {
+			return fmt.Errorf("host key store is required for trust on first use")
+		}
+	case HostKeyFingerprint:
+		if config.Fingerprint == "" {
+			return fmt.Errorf("host key fingerprint is required")
+		}
+	default:
+		return fmt.Errorf("unknown host key policy: %d", config.Policy)
+	}
+	return nil
+}
+
+// hostKeyCallback returns the callback verifying the host key according to the policy
+func hostKeyCallback(config HostKeyConfig) (ssh.HostKeyCallback, error) {
+	switch config.Policy {
+	case HostKeyKnownHosts:
+		return knownHostsCallback(config.KnownHostsPath)
+	case HostKeyTrustOnFirstUse:
+		return trustOnFirstUseCallback(config.Store), nil
+	case HostKeyFingerprint:
+		return fingerprintCallback(normalizeFingerprint(config.Fingerprint)), nil
+	default:
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+}
+
+// knownHostsCallback verifies the host key against the known_hosts file at path
+func knownHostsCallback(path string) (ssh.HostKeyCallback, error) {
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate known_hosts: %w", err)
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		switch {
+		case errors.As(err, &keyErr) && len(keyErr.Want) == 0:
+			return fmt.Errorf("%w: %s is not in %s", ErrUnknownHostKey, hostname, path)
+		case errors.As(err, &keyErr):
+			expected := make([]string, 0, len(keyErr.Want))
+			for _, known := range keyErr.Want {
+				expected = append(expected, ssh.FingerprintSHA256(known.Key))
+			}
+			return &HostKeyMismatchError{Host: hostname, Presented: ssh.FingerprintSHA256(key), Expected: expected}
+		}
+		return err
+	}, nil
+}
+
+// trustOnFirstUseCallback pins the fingerprint of the first key seen for a host in the store
+// and only accepts that key on later connections
+func trustOnFirstUseCallback(store persistence.HostKeyStore) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		host := knownhosts.Normalize(hostname)
+		presented := ssh.FingerprintSHA256(key)
+
+		pinned, err := store.GetFingerprint(host)
+		if err != nil {
+			return fmt.Errorf("failed to get pinned host key: %w", err)
+		}
+
+		if pinned == "" {
+			if err := store.SaveFingerprint(host, presented); err != nil {
+				return fmt.Errorf("failed to pin host key: %w", err)
+			}
+			return nil
+		}
+
+		if pinned != presented {
+			return &HostKeyMismatchError{Host: host, Presented: presented, Expected: []string{pinned}}
+		}
+		return nil
+	}
+}
+
+// fingerprintCallback only accepts the key matching the fingerprint
+func fingerprintCallback(fingerprint string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		presented := ssh.FingerprintSHA256(key)
+		if presented != fingerprint {
+			return &HostKeyMismatchError{Host: hostname, Presented: presented, Expected: []string{fingerprint}}
+		}
+		return nil
+	}
+}
+
+// normalizeFingerprint adds the SHA256: prefix ssh-keygen prints to fingerprints given without it
+func normalizeFingerprint(fingerprint string) string {
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		return fingerprint
+	}
+	return "SHA256:" + fingerprint
+}
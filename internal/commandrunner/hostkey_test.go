@@ -0,0 +1,174 @@
+package commandrunner
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// memoryHostKeyStore is an in-memory HostKeyStore for testing purposes
+type memoryHostKeyStore map[string]string
+
+func (m memoryHostKeyStore) GetFingerprint(host string) (string, error) {
+	return m[host], nil
+}
+
+func (m memoryHostKeyStore) SaveFingerprint(host string, fingerprint string) error {
+	m[host] = fingerprint
+	return nil
+}
+
+// runEchoWithHostKeyConfig connects to the server with the host key config and runs an echo command
+func runEchoWithHostKeyConfig(t *testing.T, server *testSSHServer, hostKey HostKeyConfig) error {
+	t.Helper()
+
+	runner, err := NewSSHCommandRunner(SSHConfig{
+		Host:       server.Host,
+		Port:       server.Port,
+		User:       "test",
+		PrivateKey: generateClientPrivateKey(t),
+		HostKey:    hostKey,
+	})
+	if err != nil {
+		t.Fatalf("failed to create SSH command runner: %v", err)
+	}
+
+	_, err = runner.RunCommand(ExecCommand{Command: "echo Hello"})
+	return err
+}
+
+func TestHostKey_KnownHosts(t *testing.T) {
+	server := startTestSSHServer(t)
+	defer server.Close()
+
+	address := knownhosts.Normalize(fmt.Sprintf("%s:%d", server.Host, server.Port))
+	otherKey := generateHostKey(t).PublicKey()
+
+	tests := []struct {
+		name      string
+		knownHost string
+		check     func(err error) bool
+	}{
+		{
+			name:      "known host key",
+			knownHost: knownhosts.Line([]string{address}, server.HostKey.PublicKey()),
+			check:     func(err error) bool { return err == nil },
+		},
+		{
+			name:      "changed host key",
+			knownHost: knownhosts.Line([]string{address}, otherKey),
+			check: func(err error) bool {
+				var mismatch *HostKeyMismatchError
+				return errors.As(err, &mismatch) &&
+					mismatch.Presented == ssh.FingerprintSHA256(server.HostKey.PublicKey()) &&
+					mismatch.Expected[0] == ssh.FingerprintSHA256(otherKey)
+			},
+		},
+		{
+			name:      "unknown host",
+			knownHost: knownhosts.Line([]string{"other.example.com"}, otherKey),
+			check:     func(err error) bool { return errors.Is(err, ErrUnknownHostKey) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "known_hosts")
+			if err := os.WriteFile(path, []byte(tt.knownHost+"\n"), 0600); err != nil {
+				t.Fatalf("failed to write known_hosts: %v", err)
+			}
+
+			err := runEchoWithHostKeyConfig(t, server, HostKeyConfig{Policy: HostKeyKnownHosts, KnownHostsPath: path})
+			if !tt.check(err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestHostKey_TrustOnFirstUse(t *testing.T) {
+	server := startTestSSHServer(t)
+	defer server.Close()
+
+	store := memoryHostKeyStore{}
+	config := HostKeyConfig{Policy: HostKeyTrustOnFirstUse, Store: store}
+
+	if err := runEchoWithHostKeyConfig(t, server, config); err != nil {
+		t.Fatalf("expected first connection to succeed, got %v", err)
+	}
+
+	host := knownhosts.Normalize(fmt.Sprintf("%s:%d", server.Host, server.Port))
+	if store[host] != ssh.FingerprintSHA256(server.HostKey.PublicKey()) {
+		t.Fatalf("expected the host key of %s to be pinned, got %v", host, store)
+	}
+
+	if err := runEchoWithHostKeyConfig(t, server, config); err != nil {
+		t.Fatalf("expected second connection to succeed, got %v", err)
+	}
+
+	store[host] = ssh.FingerprintSHA256(generateHostKey(t).PublicKey())
+	err := runEchoWithHostKeyConfig(t, server, config)
+
+	var mismatch *HostKeyMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected a HostKeyMismatchError, got %v", err)
+	}
+	if mismatch.Expected[0] != store[host] {
+		t.Errorf("expected fingerprint = %s, want %s", mismatch.Expected[0], store[host])
+	}
+}
+
+func TestHostKey_Fingerprint(t *testing.T) {
+	server := startTestSSHServer(t)
+	defer server.Close()
+
+	fingerprint := ssh.FingerprintSHA256(server.HostKey.PublicKey())
+
+	if err := runEchoWithHostKeyConfig(t, server, HostKeyConfig{Policy: HostKeyFingerprint, Fingerprint: fingerprint}); err != nil {
+		t.Fatalf("expected connection with matching fingerprint to succeed, got %v", err)
+	}
+
+	withoutPrefix := fingerprint[len("SHA256:"):]
+	if err := runEchoWithHostKeyConfig(t, server, HostKeyConfig{Policy: HostKeyFingerprint, Fingerprint: withoutPrefix}); err != nil {
+		t.Fatalf("expected connection with fingerprint without prefix to succeed, got %v", err)
+	}
+
+	other := ssh.FingerprintSHA256(generateHostKey(t).PublicKey())
+	err := runEchoWithHostKeyConfig(t, server, HostKeyConfig{Policy: HostKeyFingerprint, Fingerprint: other})
+
+	var mismatch *HostKeyMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected a HostKeyMismatchError, got %v", err)
+	}
+	if mismatch.Presented != fingerprint || mismatch.Expected[0] != other {
+		t.Errorf("unexpected mismatch error: %v", mismatch)
+	}
+}
+
+func TestValidateHostKeyConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config HostKeyConfig
+		err    bool
+	}{
+		{"insecure", HostKeyConfig{}, false},
+		{"known hosts with default path", HostKeyConfig{Policy: HostKeyKnownHosts}, false},
+		{"trust on first use without store", HostKeyConfig{Policy: HostKeyTrustOnFirstUse}, true},
+		{"trust on first use with store", HostKeyConfig{Policy: HostKeyTrustOnFirstUse, Store: memoryHostKeyStore{}}, false},
+		{"fingerprint without fingerprint", HostKeyConfig{Policy: HostKeyFingerprint}, true},
+		{"unknown policy", HostKeyConfig{Policy: HostKeyPolicy(42)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateHostKeyConfig(tt.config)
+			if (err != nil) != tt.err {
+				t.Errorf("expected err: %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package commandrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"sync"
+)
+
+// defaultTerm is the terminal type of a PTY without one
+const defaultTerm = "xterm-256color"
+
+// ErrPTYUnsupported is returned by runners that cannot allocate a terminal for a command
+var ErrPTYUnsupported = errors.New("terminals are not supported by this runner")
+
+// TerminalSize is the size of a terminal in characters, 24 rows of 80 columns when zero
+type TerminalSize struct {
+	Rows int
+	Cols int
+}
+
+// rowsCols returns the rows and columns of the terminal
+func (t TerminalSize) rowsCols() (int, int) {
+	rows, cols := t.Rows, t.Cols
+	if rows <= 0 {
+		rows = 24
+	}
+	if cols <= 0 {
+		cols = 80
+	}
+	return rows, cols
+}
+
+// PTY is the terminal allocated for a command, the command then writes stdout and stderr to the terminal
+// and both show up on stdout
+type PTY struct {
+	// Term is the terminal type set as TERM, xterm-256color when empty
+	Term string
+	Size TerminalSize
+}
+
+// requestPty allocates the terminal for the command of the session
+func requestPty(session *ssh.Session, pty *PTY) error {
+	term := pty.Term
+	if term == "" {
+		term = defaultTerm
+	}
+	rows, cols := pty.Size.rowsCols()
+	modes := ssh.TerminalModes{ssh.ECHO: 1, ssh.TTY_OP_ISPEED: 38400, ssh.TTY_OP_OSPEED: 38400}
+	if err := session.RequestPty(term, rows, cols, modes); err != nil {
+		return fmt.Errorf("failed to request pty: %w", err)
+	}
+	return nil
+}
+
+// InteractiveSession is a command running with its input and output handed to the caller. Reads return the
+// output of the command, stdout and stderr merged, and writes go to its input
+type InteractiveSession interface {
+	io.ReadWriteCloser
+	// CloseWrite closes the input of the command
+	CloseWrite() error
+	// Resize tells the command the size of its terminal changed
+	Resize(size TerminalSize) error
+	// Wait waits for the command to finish and returns its exit code, the output must be read until EOF
+	Wait() (int, error)
+}
+
+// InteractiveRunner is implemented by runners that can run a command interactively
+type InteractiveRunner interface {
+	RunInteractive(ctx context.Context, ec ExecCommand) (InteractiveSession, error)
+}
+
+// RunInteractive starts the command with a terminal, a default one when the command requests none, and hands
+// its input and output to the caller. The command is killed when the context is cancelled, the timeout of the
+// command expires or the session is closed
+func (s *SSHCommandRunner) RunInteractive(ctx context.Context, ec ExecCommand) (InteractiveSession, error) {
+	if ec.Input != nil {
+		return nil, errors.New("interactive commands read their input from the session")
+	}
+	if ec.PTY == nil {
+		ec.PTY = &PTY{}
+	}
+
+	ctx, cancel := commandContext(ctx, ec)
+	input, inputWriter := io.Pipe()
+	ec.Input = input
+
+	started, err := s.startCommand(ctx, ec)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	output, outputWriter := io.Pipe()
+	interactive := &sshInteractiveSession{
+		started: started,
+		input:   inputWriter,
+		output:  output,
+		done:    make(chan struct{}),
+	}
+	go interactive.run(ctx, cancel, ec, outputWriter)
+	return interactive, nil
+}
+
+// sshInteractiveSession is a command running interactively on a session of an SSHCommandRunner
+type sshInteractiveSession struct {
+	started *startedCommand
+	input   *io.PipeWriter
+	output  *io.PipeReader
+
+	done     chan struct{}
+	exitCode int
+	err      error
+}
+
+// run copies the output of the command until it finished and records its exit status
+func (s *sshInteractiveSession) run(ctx context.Context, cancel context.CancelFunc, ec ExecCommand, output *io.PipeWriter) {
+	defer close(s.done)
+	defer cancel()
+	defer s.started.release()
+
+	session := s.started.session
+	stop := context.AfterFunc(ctx, func() { killSession(session) })
+	defer stop()
+
+	// the output left once the caller closed the session is discarded so the command is not blocked
+	var wg sync.WaitGroup
+	for _, r := range []io.Reader{s.started.stdout, s.started.stderr} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := io.Copy(output, r); err != nil {
+				_, _ = io.Copy(io.Discard, r)
+			}
+		}()
+	}
+	wg.Wait()
+	// the session only returns once nothing is left to copy to the input of the command
+	_ = s.input.Close()
+
+	err := session.Wait()
+	var exitErr *ssh.ExitError
+	switch {
+	case ctx.Err() != nil:
+		s.err = contextError(ctx, ec)
+	case s.started.elevation.result(err) != nil:
+		s.err = fmt.Errorf("failed to run elevated command: %w", s.started.elevation.result(err))
+	case errors.As(err, &exitErr):
+		s.exitCode = exitErr.ExitStatus()
+	case err != nil:
+		s.err = fmt.Errorf("failed to run command: %w", err)
+	}
+	_ = output.CloseWithError(s.err)
+}
+
+// Read reads the output of the command, it returns io.EOF once the command finished
+func (s *sshInteractiveSession) Read(p []byte) (int, error) {
+	return s.output.Read(p)
+}
+
+// Write writes to the input of the command
+func (s *sshInteractiveSession) Write(p []byte) (int, error) {
+	return s.input.Write(p)
+}
+
+// CloseWrite closes the input of the command
+func (s *sshInteractiveSession) CloseWrite() error {
+	return s.input.Close()
+}
+
+// Resize sends the new size of the terminal to the command
+func (s *sshInteractiveSession) Resize(size TerminalSize) error {
+	rows, cols := size.rowsCols()
+	if err := s.started.session.WindowChange(rows, cols); err != nil {
+		return fmt.Errorf("failed to resize terminal: %w", err)
+	}
+	return nil
+}
+
+// Wait waits for the command to finish and returns its exit code
+func (s *sshInteractiveSession) Wait() (int, error) {
+	<-s.done
+	return s.exitCode, s.err
+}
+
+// Close kills the command if it still runs and waits for the session to end
+func (s *sshInteractiveSession) Close() error {
+	killSession(s.started.session)
+	_ = s.input.Close()
+	_ = s.output.Close()
+	<-s.done
+	return nil
+}
@@ -0,0 +1,147 @@
+package commandrunner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+// waitForTerminals waits until the last terminal requests and resizes the server received are the wanted ones,
+// the sudo probes request terminals of their own
+func waitForTerminals(t *testing.T, server *testSSHServer, want ...string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got := server.Terminals()
+		if len(got) >= len(want) && slices.Equal(got[len(got)-len(want):], want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server received terminals %v, want them to end with %v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSSHCommandRunner_RunCommand_PTY(t *testing.T) {
+	server := startTestSSHServer(t)
+	t.Cleanup(server.Close)
+	runner := newPoolTestRunner(t, server, 0)
+
+	result, err := runner.RunCommand(ExecCommand{Command: "echo Hello", PTY: &PTY{Term: "vt100", Size: TerminalSize{Rows: 50, Cols: 132}}})
+	if err != nil {
+		t.Fatalf("RunCommand() error = %v", err)
+	}
+	if got := result.Stdout.String(); got != "Hello\n" {
+		t.Errorf("RunCommand() = %q, want %q", got, "Hello\n")
+	}
+	waitForTerminals(t, server, "vt100 50x132")
+}
+
+func TestSSHCommandRunner_RunInteractive(t *testing.T) {
+	tests := []struct {
+		name     string
+		sudo     testSudoOptions
+		password SecretProvider
+		elevated bool
+	}{
+		{name: "user"},
+		{name: "elevated with password", sudo: testSudoOptions{password: "secret", requireTTY: true}, password: StaticSecret("secret"), elevated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startTestSSHServerWithOptions(t, testSSHServerOptions{sudo: tt.sudo})
+			t.Cleanup(server.Close)
+			runner, err := NewSSHCommandRunner(SSHConfig{
+				Host:         server.Host,
+				Port:         server.Port,
+				User:         "test",
+				PrivateKey:   generateClientPrivateKey(t),
+				SudoPassword: tt.password,
+			})
+			if err != nil {
+				t.Fatalf("NewSSHCommandRunner() error = %v", err)
+			}
+			defer runner.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			session, err := runner.RunInteractive(ctx, ExecCommand{Command: "cat", Elevated: tt.elevated})
+			if err != nil {
+				t.Fatalf("RunInteractive() error = %v", err)
+			}
+			defer session.Close()
+
+			output := bufio.NewReader(session)
+			if _, err := io.WriteString(session, "hello\n"); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if line, err := output.ReadString('\n'); err != nil || line != "hello\n" {
+				t.Fatalf("Read() = %q, %v, want %q", line, err, "hello\n")
+			}
+
+			if err := session.Resize(TerminalSize{Rows: 40, Cols: 120}); err != nil {
+				t.Fatalf("Resize() error = %v", err)
+			}
+			waitForTerminals(t, server, "xterm-256color 24x80", "40x120")
+
+			if err := session.CloseWrite(); err != nil {
+				t.Fatalf("CloseWrite() error = %v", err)
+			}
+			rest, err := io.ReadAll(output)
+			if err != nil || len(rest) > 0 {
+				t.Errorf("Read() after the input was closed = %q, %v, want EOF", rest, err)
+			}
+			if code, err := session.Wait(); code != 0 || err != nil {
+				t.Errorf("Wait() = %d, %v, want 0, nil", code, err)
+			}
+		})
+	}
+}
+
+func TestSSHCommandRunner_RunInteractive_Close(t *testing.T) {
+	server := startTestSSHServer(t)
+	t.Cleanup(server.Close)
+	runner := newPoolTestRunner(t, server, 0)
+
+	session, err := runner.RunInteractive(context.Background(), ExecCommand{Command: "sleep 10"})
+	if err != nil {
+		t.Fatalf("RunInteractive() error = %v", err)
+	}
+
+	start := time.Now()
+	if err := session.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Close() returned after %s, expected the command to be killed", elapsed)
+	}
+	if _, err := session.Wait(); err == nil {
+		t.Errorf("Wait() expected an error for the killed command")
+	}
+}
+
+func TestLocalCommandRunner_RunCommand_PTY(t *testing.T) {
+	for _, runner := range []CommandRunner{&LocalCommandRunner{}, &BashCommandRunner{}} {
+		_, err := runner.RunCommand(ExecCommand{Command: "true", PTY: &PTY{}})
+		if !errors.Is(err, ErrPTYUnsupported) {
+			t.Errorf("%T.RunCommand() error = %v, want %v", runner, err, ErrPTYUnsupported)
+		}
+	}
+	if _, ok := CommandRunner(&LocalCommandRunner{}).(InteractiveRunner); ok {
+		t.Errorf("LocalCommandRunner is not expected to run commands interactively")
+	}
+	if _, ok := CommandRunner(&SSHCommandRunner{}).(InteractiveRunner); !ok {
+		t.Errorf("SSHCommandRunner is expected to run commands interactively")
+	}
+	if _, ok := CommandRunner(NewDryRunRunner(&SSHCommandRunner{}, nil)).(InteractiveRunner); ok {
+		t.Errorf("DryRunRunner is not expected to run commands interactively")
+	}
+}
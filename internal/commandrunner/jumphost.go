@@ -0,0 +1,70 @@
+package commandrunner
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strconv"
+	"time"
+)
+
+// sshConnectTimeout limits how long dialing and the handshake of a single hop may take
+const sshConnectTimeout = 10 * time.Second
+
+// dialFunc opens a connection to addr, either directly or through an ssh connection
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// HopError is returned when connecting through jump hosts fails, Hop is the 1-based position in the chain
+// of the host that could not be reached, the target being the last hop
+type HopError struct {
+	Hop  int
+	Hops int
+	Host string
+	Err  error
+}
+
+func (e *HopError) Error() string {
+	return fmt.Sprintf("failed at hop %d/%d (%s): %v", e.Hop, e.Hops, e.Host, e.Err)
+}
+
+func (e *HopError) Unwrap() error {
+	return e.Err
+}
+
+// connectThroughJumpHosts connects to every jump host in order, each through the previous one, and finally to the target.
+// The jump host connections are closed once the connection to the target is closed
+func connectThroughJumpHosts(ctx context.Context, config *SSHConfig, dial dialFunc) (*ssh.Client, error) {
+	hops := append(append([]SSHConfig{}, config.JumpHosts...), *config)
+	clients := make([]*ssh.Client, 0, len(hops))
+
+	for i := range hops {
+		client, err := dialSSH(ctx, &hops[i], dial)
+		if err != nil {
+			closeClients(clients)
+			return nil, &HopError{Hop: i + 1, Hops: len(hops), Host: sshAddress(&hops[i]), Err: err}
+		}
+		clients = append(clients, client)
+		dial = client.DialContext
+	}
+
+	target := clients[len(clients)-1]
+	go func() {
+		_ = target.Wait()
+		closeClients(clients[:len(clients)-1])
+	}()
+
+	return target, nil
+}
+
+// closeClients closes the clients in reverse order, so every connection is closed before the one it is tunneled through
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
+
+// sshAddress returns the host:port address of the config
+func sshAddress(config *SSHConfig) string {
+	return net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+}
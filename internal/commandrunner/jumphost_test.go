@@ -0,0 +1,196 @@
+package commandrunner
+
+import (
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// jumpHostConfig returns the config of a hop authenticating with the password and pinning the host key of the server
+func jumpHostConfig(server *testSSHServer, password string) SSHConfig {
+	return SSHConfig{
+		Host:        server.Host,
+		Port:        server.Port,
+		User:        "bastion",
+		AuthMethods: []AuthMethod{PasswordAuth(password)},
+		HostKey:     HostKeyConfig{Policy: HostKeyFingerprint, Fingerprint: ssh.FingerprintSHA256(server.HostKey.PublicKey())},
+	}
+}
+
+// closedPort returns a port of 127.0.0.1 nothing listens on
+func closedPort(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen on a port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+func TestSSHCommandRunner_JumpHost(t *testing.T) {
+	bastion := startTestSSHServerWithOptions(t, testSSHServerOptions{password: "bastion-secret"})
+	defer bastion.Close()
+
+	privateKey := generateClientPrivateKey(t)
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("failed to parse client key: %v", err)
+	}
+	target := startTestSSHServerWithOptions(t, testSSHServerOptions{authorizedKey: signer.PublicKey()})
+	defer target.Close()
+
+	runner, err := NewSSHCommandRunner(SSHConfig{
+		Host:       target.Host,
+		Port:       target.Port,
+		User:       "test",
+		PrivateKey: privateKey,
+		HostKey:    HostKeyConfig{Policy: HostKeyFingerprint, Fingerprint: ssh.FingerprintSHA256(target.HostKey.PublicKey())},
+		JumpHosts:  []SSHConfig{jumpHostConfig(bastion, "bastion-secret")},
+	})
+	if err != nil {
+		t.Fatalf("failed to create SSH command runner: %v", err)
+	}
+
+	result, err := runner.RunCommand(ExecCommand{Command: "echo Hello"})
+	if err != nil {
+		t.Fatalf("RunCommand() through jump host failed, error = %v", err)
+	}
+	if got := result.Stdout.String(); got != "Hello\n" {
+		t.Errorf("RunCommand() = %q, want %q", got, "Hello\n")
+	}
+	if bastion.Connections() != 1 || target.Connections() != 1 {
+		t.Errorf("connections = %d to the bastion and %d to the target, want 1 each", bastion.Connections(), target.Connections())
+	}
+
+	if err := runner.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for bastion.OpenConnections() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := bastion.OpenConnections(); got != 0 {
+		t.Errorf("open bastion connections after Close() = %d, want 0", got)
+	}
+}
+
+func TestSSHCommandRunner_JumpHost_Errors(t *testing.T) {
+	bastion := startTestSSHServerWithOptions(t, testSSHServerOptions{password: "bastion-secret"})
+	defer bastion.Close()
+	target := startTestSSHServer(t)
+	defer target.Close()
+
+	unreachable := jumpHostConfig(bastion, "bastion-secret")
+	unreachable.Port = closedPort(t)
+	wrongPassword := jumpHostConfig(bastion, "wrong")
+	otherFingerprint := ssh.FingerprintSHA256(generateHostKey(t).PublicKey())
+
+	tests := []struct {
+		name      string
+		jumpHosts []SSHConfig
+		hostKey   HostKeyConfig
+		// port of the target, the test server when zero
+		port  int
+		hop   string
+		check func(err error) bool
+	}{
+		{
+			name:      "unreachable bastion",
+			jumpHosts: []SSHConfig{unreachable},
+			hop:       "failed at hop 1/2",
+		},
+		{
+			name:      "changed target host key",
+			jumpHosts: []SSHConfig{jumpHostConfig(bastion, "bastion-secret")},
+			hostKey:   HostKeyConfig{Policy: HostKeyFingerprint, Fingerprint: otherFingerprint},
+			hop:       "failed at hop 2/2",
+			check: func(err error) bool {
+				var mismatch *HostKeyMismatchError
+				return errors.As(err, &mismatch)
+			},
+		},
+		{
+			name:      "rejected second bastion",
+			jumpHosts: []SSHConfig{jumpHostConfig(bastion, "bastion-secret"), wrongPassword},
+			hop:       "failed at hop 2/3",
+		},
+		{
+			name:      "unreachable target",
+			jumpHosts: []SSHConfig{jumpHostConfig(bastion, "bastion-secret"), jumpHostConfig(bastion, "bastion-secret")},
+			port:      closedPort(t),
+			hop:       "failed at hop 3/3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := tt.port
+			if port == 0 {
+				port = target.Port
+			}
+
+			runner, err := NewSSHCommandRunner(SSHConfig{
+				Host:       target.Host,
+				Port:       port,
+				User:       "test",
+				PrivateKey: generateClientPrivateKey(t),
+				HostKey:    tt.hostKey,
+				JumpHosts:  tt.jumpHosts,
+			})
+			if err != nil {
+				t.Fatalf("failed to create SSH command runner: %v", err)
+			}
+			defer runner.Close()
+
+			_, err = runner.RunCommand(ExecCommand{Command: "echo Hello"})
+
+			var hopErr *HopError
+			if !errors.As(err, &hopErr) {
+				t.Fatalf("RunCommand() error = %v, expected a HopError", err)
+			}
+			if !strings.Contains(err.Error(), tt.hop) {
+				t.Errorf("RunCommand() error = %q, expected to contain %q", err, tt.hop)
+			}
+			if tt.check != nil && !tt.check(err) {
+				t.Errorf("RunCommand() error = %v, unexpected cause", err)
+			}
+		})
+	}
+}
+
+func TestValidateConfig_JumpHosts(t *testing.T) {
+	base := SSHConfig{Host: "target", Port: 22, User: "u", AuthMethods: []AuthMethod{PasswordAuth("p")}}
+	valid := SSHConfig{Host: "bastion", Port: 22, User: "u", AuthMethods: []AuthMethod{PasswordAuth("p")}}
+
+	nested := valid
+	nested.JumpHosts = []SSHConfig{valid}
+	withoutUser := valid
+	withoutUser.User = ""
+
+	tests := []struct {
+		name      string
+		jumpHosts []SSHConfig
+		err       bool
+	}{
+		{"valid", []SSHConfig{valid, valid}, false},
+		{"nested jump hosts", []SSHConfig{nested}, true},
+		{"jump host without user", []SSHConfig{valid, withoutUser}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := base
+			config.JumpHosts = tt.jumpHosts
+			err := validateConfig(config)
+			if (err != nil) != tt.err {
+				t.Errorf("expected err: %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package commandrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// Shell is the shell a LocalCommandRunner runs command lines with
+type Shell string
+
+const (
+	// ShellSh runs command lines with sh, available on every POSIX system
+	ShellSh Shell = "sh"
+	// ShellBash runs command lines with bash
+	ShellBash Shell = "bash"
+	// ShellNone splits simple command lines into words and executes them directly, without a shell
+	ShellNone Shell = "none"
+)
+
+// RunAs is the program a LocalCommandRunner switches to another user with
+type RunAs string
+
+const (
+	// RunAsSudo switches user with sudo, which must not ask for a password
+	RunAsSudo RunAs = "sudo"
+	// RunAsSetpriv switches user with setpriv, which needs chisme to run as root
+	RunAsSetpriv RunAs = "setpriv"
+)
+
+// ErrShellRequired is returned by a LocalCommandRunner without a shell for commands that need one
+var ErrShellRequired = errors.New("command needs a shell")
+
+// LocalCommandRunner implements CommandRunner for the local machine, executing commands directly.
+// Commands given as Args never go through a shell, command lines are run by the configured shell
+type LocalCommandRunner struct {
+	// Shell runs the command lines and sets the umask, sh when empty
+	Shell Shell
+	// User runs the commands as another user when set, elevated commands run as root whatever the user
+	User string
+	// RunAs is the program switching to the user or to root, sudo when empty
+	RunAs RunAs
+}
+
+// Host returns the host the runner runs commands on, which is the local machine
+func (l *LocalCommandRunner) Host() string {
+	return localHost
+}
+
+// RunCommand runs the command and returns its result
+func (l *LocalCommandRunner) RunCommand(ec ExecCommand) (*CommandResult, error) {
+	return l.RunCommandContext(context.Background(), ec)
+}
+
+// RunCommandContext runs the command and returns its result, the process group of the command
+// is killed when the context is cancelled or the timeout of the command expires.
+// A command exiting with a non-zero status returns its result together with an *ExitError
+func (l *LocalCommandRunner) RunCommandContext(ctx context.Context, ec ExecCommand) (*CommandResult, error) {
+	return runLocalCommand(ctx, ec, l.newCommand)
+}
+
+// RunCommandAsync runs the command asynchronously and returns a channel with the events of the command
+func (l *LocalCommandRunner) RunCommandAsync(ec ExecCommand) (<-chan Event, error) {
+	return l.RunCommandAsyncContext(context.Background(), ec)
+}
+
+// RunCommandAsyncContext runs the command asynchronously and returns a channel with the events of the command,
+// the process group of the command is killed when the context is cancelled or the timeout of the command expires
+func (l *LocalCommandRunner) RunCommandAsyncContext(ctx context.Context, ec ExecCommand) (<-chan Event, error) {
+	return runLocalCommandAsync(ctx, ec, l.newCommand)
+}
+
+// Upload writes src to the local file dst, uploads as another user or elevated run the upload script as that user
+func (l *LocalCommandRunner) Upload(ctx context.Context, src io.Reader, dst string, opts FileOptions) error {
+	if opts.Elevated || l.User != "" {
+		return uploadWith(ctx, l.streamCommand, src, dst, opts)
+	}
+	return uploadLocal(ctx, src, dst, opts)
+}
+
+// Download copies the local file src to dst, downloads as another user or elevated read the file as that user
+func (l *LocalCommandRunner) Download(ctx context.Context, src string, dst io.Writer, opts FileOptions) error {
+	if opts.Elevated || l.User != "" {
+		return downloadWith(ctx, l.streamCommand, src, dst, opts)
+	}
+	return downloadLocal(ctx, src, dst)
+}
+
+// streamCommand runs the command of a transfer with its stdout written to stdout
+func (l *LocalCommandRunner) streamCommand(ctx context.Context, ec ExecCommand, stdout io.Writer) error {
+	return streamLocalCommand(ctx, ec, stdout, l.newCommand)
+}
+
+// shell returns the shell running command lines
+func (l *LocalCommandRunner) shell() Shell {
+	if l.Shell == "" {
+		return ShellSh
+	}
+	return l.Shell
+}
+
+// newCommand creates the process for the command bound to the given context
+func (l *LocalCommandRunner) newCommand(ctx context.Context, ec ExecCommand) (*exec.Cmd, error) {
+	if err := validateExecCommand(ec); err != nil {
+		return nil, err
+	}
+	if ec.PTY != nil {
+		return nil, ErrPTYUnsupported
+	}
+
+	argv, err := l.argv(ec)
+	if err != nil {
+		return nil, err
+	}
+	env := envAssignments(ec.Env)
+	if argv, err = l.switchUser(argv, env, ec.Elevated); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = ec.Dir
+	cmd.Stdin = ec.Input
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	configureProcessGroup(cmd)
+	return cmd, nil
+}
+
+// argv returns the program and arguments running the command. A umask is set by a shell that
+// then replaces itself with the command
+func (l *LocalCommandRunner) argv(ec ExecCommand) ([]string, error) {
+	shell := l.shell()
+	if shell != ShellSh && shell != ShellBash && shell != ShellNone {
+		return nil, fmt.Errorf("unsupported shell: %q", shell)
+	}
+
+	if len(ec.Args) == 0 {
+		if shell != ShellNone {
+			return []string{string(shell), "-c", shellPrelude(ExecCommand{Umask: ec.Umask}) + ec.Command}, nil
+		}
+		words, ok := splitCommandLine(ec.Command)
+		if !ok || len(words) == 0 || envNamePattern.MatchString(envName(words[0])) {
+			return nil, fmt.Errorf("%w: %s", ErrShellRequired, ec.Command)
+		}
+		ec.Args = words
+	}
+
+	if ec.Umask == 0 {
+		return ec.Args, nil
+	}
+	if shell == ShellNone {
+		return nil, fmt.Errorf("%w: setting the umask of %s", ErrShellRequired, ec.CommandLine())
+	}
+	script := umaskCommand(ec.Umask) + ` && exec "$@"`
+	return append([]string{string(shell), "-c", script, string(shell)}, ec.Args...), nil
+}
+
+// envName returns the name of a NAME=value word, empty for other words
+func envName(word string) string {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok {
+		return ""
+	}
+	return name
+}
+
+// switchUser prefixes the command with the program running it as root when elevated, or as the user of the
+// runner. Elevated commands run directly when chisme already runs as root. The environment is passed on
+// with env, sudo would otherwise reset it
+func (l *LocalCommandRunner) switchUser(argv, env []string, elevated bool) ([]string, error) {
+	if (!elevated && l.User == "") || (elevated && os.Geteuid() == 0) {
+		return argv, nil
+	}
+	if len(env) > 0 {
+		argv = append(append([]string{"env"}, env...), argv...)
+	}
+
+	switch l.RunAs {
+	case "", RunAsSudo:
+		if elevated {
+			return append([]string{"sudo", "-n", "--"}, argv...), nil
+		}
+		return append([]string{"sudo", "-n", "-u", l.User, "--"}, argv...), nil
+	case RunAsSetpriv:
+		if elevated || os.Geteuid() != 0 {
+			return nil, errors.New("setpriv can only switch user when running as root")
+		}
+		u, err := user.Lookup(l.User)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up user %s: %w", l.User, err)
+		}
+		return append([]string{"setpriv", "--reuid=" + u.Uid, "--regid=" + u.Gid, "--init-groups", "--"}, argv...), nil
+	}
+	return nil, fmt.Errorf("unsupported run as: %q", l.RunAs)
+}
+
+// runLocalCommand runs the process created by newCmd and returns the result of the command
+func runLocalCommand(ctx context.Context, ec ExecCommand, newCmd func(context.Context, ExecCommand) (*exec.Cmd, error)) (*CommandResult, error) {
+	ctx, cancel := commandContext(ctx, ec)
+	defer cancel()
+
+	cmd, err := newCmd(ctx, ec)
+	if err != nil {
+		return nil, err
+	}
+
+	result := newCommandResult(ec.CommandLine(), localHost)
+	output := newResultOutput(result, ec.Limits)
+	cmd.Stdout = output.stdout
+	cmd.Stderr = output.stderr
+
+	err = cmd.Run()
+	result.FinishedAt = time.Now()
+	if outputErr := output.close(); outputErr != nil {
+		return nil, outputErr
+	}
+	if ctx.Err() != nil {
+		_ = result.RemoveOutputFiles()
+		return nil, contextError(ctx, ec)
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+		return result, &ExitError{Result: result}
+	case err != nil:
+		_ = result.RemoveOutputFiles()
+		return nil, fmt.Errorf("failed to run ec: %w", err)
+	}
+
+	return result, nil
+}
+
+// runLocalCommandAsync starts the process created by newCmd and returns a channel with the events of the command
+func runLocalCommandAsync(ctx context.Context, ec ExecCommand, newCmd func(context.Context, ExecCommand) (*exec.Cmd, error)) (<-chan Event, error) {
+	ctx, cancel := commandContext(ctx, ec)
+	cmd, err := newCmd(ctx, ec)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	stdOut, stdErr, err := setupCmdPipes(cmd)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to setup ec: %w", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start ec: %w", err)
+	}
+
+	stream := newLimitedEventStream(localHost, ec.Limits)
+	go func() {
+		defer cancel()
+		readErr := stream.streamOutput(stdOut, stdErr)
+
+		err := cmd.Wait()
+		var exitErr *exec.ExitError
+		switch {
+		case ctx.Err() != nil:
+			stream.fail(contextError(ctx, ec))
+		case readErr != nil:
+			stream.fail(readErr)
+		case errors.As(err, &exitErr):
+			stream.exit(exitErr.ExitCode())
+		case err != nil:
+			stream.fail(fmt.Errorf("ec finished with error: %w", err))
+		default:
+			stream.exit(0)
+		}
+	}()
+
+	return stream.events, nil
+}
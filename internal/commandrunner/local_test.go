@@ -0,0 +1,213 @@
+package commandrunner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalCommandRunner_RunCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		shell   Shell
+		ec      ExecCommand
+		want    string
+		wantErr error
+	}{
+		{name: "sh pipeline", shell: ShellSh, ec: ExecCommand{Command: "echo hello | tr h j"}, want: "jello\n"},
+		{name: "default shell", ec: ExecCommand{Command: "echo $((1 + 2))"}, want: "3\n"},
+		{name: "bash", shell: ShellBash, ec: ExecCommand{Command: "echo ${BASH_VERSION:+bash}"}, want: "bash\n"},
+		{name: "args", shell: ShellSh, ec: ExecCommand{Args: []string{"echo", "$HOME; echo injected"}}, want: "$HOME; echo injected\n"},
+		{name: "umask", shell: ShellSh, ec: ExecCommand{Args: []string{"sh", "-c", "umask"}, Umask: 0027}, want: "0027\n"},
+		{name: "no shell", shell: ShellNone, ec: ExecCommand{Command: `echo 'hello world' "again"`}, want: "hello world again\n"},
+		{name: "no shell env", shell: ShellNone, ec: ExecCommand{Command: "printenv FOO", Env: map[string]string{"FOO": "bar"}}, want: "bar\n"},
+		{name: "no shell pipeline", shell: ShellNone, ec: ExecCommand{Command: "echo hello | tr h j"}, wantErr: ErrShellRequired},
+		{name: "no shell expansion", shell: ShellNone, ec: ExecCommand{Command: "echo $HOME"}, wantErr: ErrShellRequired},
+		{name: "no shell assignment", shell: ShellNone, ec: ExecCommand{Command: "FOO=bar printenv FOO"}, wantErr: ErrShellRequired},
+		{name: "no shell umask", shell: ShellNone, ec: ExecCommand{Args: []string{"true"}, Umask: 0027}, wantErr: ErrShellRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &LocalCommandRunner{Shell: tt.shell}
+			result, err := runner.RunCommand(tt.ec)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("RunCommand() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RunCommand() error = %v", err)
+			}
+			if got := result.Stdout.String(); got != tt.want {
+				t.Errorf("RunCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalCommandRunner_NoBash(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"sh", "echo", "tr"} {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			t.Skipf("%s not found: %v", name, err)
+		}
+		if err := os.Symlink(path, filepath.Join(dir, name)); err != nil {
+			t.Fatalf("failed to link %s: %v", name, err)
+		}
+	}
+	t.Setenv("PATH", dir)
+
+	result, err := (&LocalCommandRunner{}).RunCommand(ExecCommand{Command: "echo hello | tr h j"})
+	if err != nil {
+		t.Fatalf("RunCommand() error = %v", err)
+	}
+	if got := result.Stdout.String(); got != "jello\n" {
+		t.Errorf("RunCommand() = %q, want %q", got, "jello\n")
+	}
+}
+
+func TestLocalCommandRunner_Cancel_KillsProcessGroup(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+	runner := &LocalCommandRunner{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := runner.RunCommandAsyncContext(ctx, ExecCommand{
+		Command: "(sleep 0.5; touch " + ShellQuote(marker) + ") & echo started; wait",
+	})
+	if err != nil {
+		t.Fatalf("RunCommandAsyncContext() error = %v", err)
+	}
+	if event := <-events; event.Type != EventStdout || event.Line != "started" {
+		t.Fatalf("RunCommandAsyncContext() = %v, want stdout line %q", event, "started")
+	}
+	cancel()
+
+	if _, _, err := readEvents(t, events); !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunCommandAsyncContext() error = %v, want %v", err, context.Canceled)
+	}
+	time.Sleep(time.Second)
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("a child of the cancelled command kept running")
+	}
+}
+
+func TestLocalCommandRunner_SwitchUser(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatalf("failed to look up the current user: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		runner  LocalCommandRunner
+		ec      ExecCommand
+		want    string
+		root    string
+		wantErr bool
+	}{
+		{
+			name:   "not switched",
+			runner: LocalCommandRunner{},
+			ec:     ExecCommand{Args: []string{"apt", "update"}},
+			want:   "apt update",
+		},
+		{
+			name:   "elevated with sudo",
+			runner: LocalCommandRunner{User: "alice"},
+			ec:     ExecCommand{Args: []string{"apt", "update"}, Env: map[string]string{"LC_ALL": "C"}, Elevated: true},
+			want:   "sudo -n -- env LC_ALL=C apt update",
+			root:   "apt update",
+		},
+		{
+			name:   "user with sudo",
+			runner: LocalCommandRunner{User: "alice", RunAs: RunAsSudo},
+			ec:     ExecCommand{Command: "id -u"},
+			want:   "sudo -n -u alice -- sh -c id -u",
+		},
+		{
+			name:    "user with setpriv",
+			runner:  LocalCommandRunner{User: current.Username, RunAs: RunAsSetpriv},
+			ec:      ExecCommand{Args: []string{"id", "-u"}},
+			want:    "setpriv --reuid=" + current.Uid + " --regid=" + current.Gid + " --init-groups -- id -u",
+			wantErr: os.Geteuid() != 0,
+		},
+		{
+			name:    "elevated with setpriv",
+			runner:  LocalCommandRunner{RunAs: RunAsSetpriv},
+			ec:      ExecCommand{Args: []string{"id", "-u"}, Elevated: true},
+			root:    "id -u",
+			wantErr: os.Geteuid() != 0,
+		},
+		{
+			name:    "unknown run as",
+			runner:  LocalCommandRunner{User: "alice", RunAs: "doas"},
+			ec:      ExecCommand{Args: []string{"id", "-u"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := tt.runner.newCommand(context.Background(), tt.ec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			want := tt.want
+			if tt.root != "" && os.Geteuid() == 0 {
+				want = tt.root
+			}
+			if got := strings.Join(cmd.Args, " "); got != want {
+				t.Errorf("newCommand() args = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestLocalCommandRunner_RunCommand_Sudo(t *testing.T) {
+	dir := t.TempDir()
+	writeFakeSudo(t, dir)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	runner := &LocalCommandRunner{User: "nobody"}
+	result, err := runner.RunCommand(ExecCommand{Command: `echo "$FOO"`, Env: map[string]string{"FOO": "bar"}})
+	if err != nil {
+		t.Fatalf("RunCommand() error = %v", err)
+	}
+	if got := result.Stdout.String(); got != "bar\n" {
+		t.Errorf("RunCommand() = %q, want %q", got, "bar\n")
+	}
+}
+
+func TestLocalCommandRunner_RunCommand_Setpriv(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("setpriv needs root")
+	}
+	if _, err := exec.LookPath("setpriv"); err != nil {
+		t.Skip("setpriv not found")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skipf("user nobody not found: %v", err)
+	}
+
+	runner := &LocalCommandRunner{User: "nobody", RunAs: RunAsSetpriv, Shell: ShellNone}
+	result, err := runner.RunCommand(ExecCommand{Command: "id -u"})
+	if err != nil {
+		t.Fatalf("RunCommand() error = %v", err)
+	}
+	if got := strings.TrimSpace(result.Stdout.String()); got != nobody.Uid {
+		t.Errorf("RunCommand() uid = %s, want %s", got, nobody.Uid)
+	}
+}
@@ -0,0 +1,404 @@
+package commandrunner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"hash"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Middleware wraps a CommandRunner to add behavior around the commands it runs
+type Middleware func(next CommandRunner) CommandRunner
+
+// Chain wraps the runner with the middlewares, the first middleware is the outermost one and sees a command first
+func Chain(runner CommandRunner, middlewares ...Middleware) CommandRunner {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		runner = middlewares[i](runner)
+	}
+	return runner
+}
+
+// HostRunner is implemented by runners that run commands on a single host
+type HostRunner interface {
+	Host() string
+}
+
+// hostOf returns the host the runner runs commands on, or an empty string when it does not tell
+func hostOf(runner CommandRunner) string {
+	if r, ok := runner.(HostRunner); ok {
+		return r.Host()
+	}
+	return ""
+}
+
+// WrappedRunner is a CommandRunner replacing the context aware methods of the Next runner, the ones left nil
+// call the Next runner. It is the building block of middlewares
+type WrappedRunner struct {
+	Next     CommandRunner
+	Run      func(ctx context.Context, command ExecCommand) (*CommandResult, error)
+	RunAsync func(ctx context.Context, command ExecCommand) (<-chan Event, error)
+}
+
+// Host returns the host of the Next runner
+func (w *WrappedRunner) Host() string {
+	return hostOf(w.Next)
+}
+
+// RunCommand runs the command with Run
+func (w *WrappedRunner) RunCommand(command ExecCommand) (*CommandResult, error) {
+	return w.RunCommandContext(context.Background(), command)
+}
+
+// RunCommandContext runs the command with Run, or with the Next runner when Run is nil
+func (w *WrappedRunner) RunCommandContext(ctx context.Context, command ExecCommand) (*CommandResult, error) {
+	if w.Run == nil {
+		return w.Next.RunCommandContext(ctx, command)
+	}
+	return w.Run(ctx, command)
+}
+
+// RunCommandAsync runs the command asynchronously with RunAsync
+func (w *WrappedRunner) RunCommandAsync(command ExecCommand) (<-chan Event, error) {
+	return w.RunCommandAsyncContext(context.Background(), command)
+}
+
+// RunCommandAsyncContext runs the command asynchronously with RunAsync, or with the Next runner when RunAsync is nil
+func (w *WrappedRunner) RunCommandAsyncContext(ctx context.Context, command ExecCommand) (<-chan Event, error) {
+	if w.RunAsync == nil {
+		return w.Next.RunCommandAsyncContext(ctx, command)
+	}
+	return w.RunAsync(ctx, command)
+}
+
+// relayEvents forwards the events to the returned channel, an event is dropped when relay returns false.
+// relay sees every event including the final one, in order
+func relayEvents(events <-chan Event, relay func(Event) bool) <-chan Event {
+	relayed := make(chan Event, cap(events))
+	go func() {
+		defer close(relayed)
+		for event := range events {
+			if relay(event) {
+				relayed <- event
+			}
+		}
+	}()
+	return relayed
+}
+
+// Audit logs one record per command with the actor running it, the host, the command line, whether it was
+// elevated, the exit code or error, the duration and SHA-256 digests of the output. The actor defaults to
+// the name of the local user
+func Audit(logger *slog.Logger, actor string) Middleware {
+	if actor == "" {
+		actor = localUserName()
+	}
+
+	return func(next CommandRunner) CommandRunner {
+		host := hostOf(next)
+		logCommand := func(ctx context.Context, command ExecCommand, started time.Time, exitCode int, err error, stdout, stderr *outputDigest) {
+			attrs := []slog.Attr{
+				slog.String("actor", actor),
+				slog.String("host", host),
+				slog.String("command", command.CommandLine()),
+				slog.Bool("elevated", command.Elevated),
+				slog.Int("exit_code", exitCode),
+				slog.Duration("duration", time.Since(started)),
+				slog.Int64("stdout_bytes", stdout.size),
+				slog.String("stdout_sha256", stdout.sum()),
+				slog.Int64("stderr_bytes", stderr.size),
+				slog.String("stderr_sha256", stderr.sum()),
+			}
+			level := slog.LevelInfo
+			if err != nil {
+				level = slog.LevelError
+				attrs = append(attrs, slog.String("error", err.Error()))
+			} else if exitCode != 0 {
+				level = slog.LevelWarn
+			}
+			logger.LogAttrs(ctx, level, "command audit", attrs...)
+		}
+
+		return &WrappedRunner{
+			Next: next,
+			Run: func(ctx context.Context, command ExecCommand) (*CommandResult, error) {
+				started := time.Now()
+				result, err := next.RunCommandContext(ctx, command)
+
+				stdout, stderr := newOutputDigest(), newOutputDigest()
+				exitCode, runErr := 0, err
+				var exitErr *ExitError
+				if errors.As(err, &exitErr) {
+					result, runErr = exitErr.Result, nil
+				}
+				if result != nil {
+					_, _ = stdout.Write(result.Stdout.Bytes())
+					_, _ = stderr.Write(result.Stderr.Bytes())
+					exitCode = result.ExitCode
+				}
+				logCommand(ctx, command, started, exitCode, runErr, stdout, stderr)
+				return result, err
+			},
+			RunAsync: func(ctx context.Context, command ExecCommand) (<-chan Event, error) {
+				started := time.Now()
+				stdout, stderr := newOutputDigest(), newOutputDigest()
+
+				events, err := next.RunCommandAsyncContext(ctx, command)
+				if err != nil {
+					logCommand(ctx, command, started, 0, err, stdout, stderr)
+					return nil, err
+				}
+
+				return relayEvents(events, func(event Event) bool {
+					switch event.Type {
+					case EventStdout:
+						_, _ = io.WriteString(stdout, event.Line+"\n")
+					case EventStderr:
+						_, _ = io.WriteString(stderr, event.Line+"\n")
+					case EventExit:
+						logCommand(ctx, command, started, event.ExitCode, nil, stdout, stderr)
+					case EventError:
+						logCommand(ctx, command, started, 0, event.Err, stdout, stderr)
+					}
+					return true
+				}), nil
+			},
+		}
+	}
+}
+
+// outputDigest counts and hashes the output of a command
+type outputDigest struct {
+	hash hash.Hash
+	size int64
+}
+
+// newOutputDigest creates a digest of empty output
+func newOutputDigest() *outputDigest {
+	return &outputDigest{hash: sha256.New()}
+}
+
+// Write hashes p
+func (d *outputDigest) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.hash.Write(p)
+}
+
+// sum returns the hex encoded digest of the output written so far
+func (d *outputDigest) sum() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// Retry runs a command again when it fails with a transport error, up to attempts times in total. The wait
+// before the next attempt starts at backoff and doubles after every attempt. A connection lost while the command
+// runs may leave it half done, so only read-only commands and commands that failed before starting are retried.
+// Commands with input are not retried since their input was consumed, and asynchronous commands are only retried
+// when they fail to start
+func Retry(attempts int, backoff time.Duration) Middleware {
+	retry := func(ctx context.Context, command ExecCommand, attempt func() error) error {
+		wait := backoff
+		for i := 1; ; i++ {
+			err := attempt()
+			if err == nil || i >= attempts || command.Input != nil || !retryable(command, err) {
+				return err
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+			wait *= 2
+		}
+	}
+
+	return func(next CommandRunner) CommandRunner {
+		return &WrappedRunner{
+			Next: next,
+			Run: func(ctx context.Context, command ExecCommand) (*CommandResult, error) {
+				var result *CommandResult
+				err := retry(ctx, command, func() (err error) {
+					result, err = next.RunCommandContext(ctx, command)
+					return err
+				})
+				return result, err
+			},
+			RunAsync: func(ctx context.Context, command ExecCommand) (<-chan Event, error) {
+				var events <-chan Event
+				err := retry(ctx, command, func() (err error) {
+					events, err = next.RunCommandAsyncContext(ctx, command)
+					return err
+				})
+				return events, err
+			},
+		}
+	}
+}
+
+// retryable reports whether the command failing with err can be run again without running it twice on the host
+func retryable(command ExecCommand, err error) bool {
+	var notStarted *NotStartedError
+	return IsTransportError(err) && (errors.As(err, &notStarted) || IsReadOnlyCommand(command))
+}
+
+// IsTransportError reports whether the error is a failure to reach the host or of the connection to it,
+// as opposed to the command failing or a permanent error like a rejected login
+func IsTransportError(err error) bool {
+	var (
+		exitErr        *ExitError
+		netErr         net.Error
+		exitMissingErr *ssh.ExitMissingError
+	)
+	switch {
+	case err == nil, errors.As(err, &exitErr):
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrRunnerClosed):
+		return false
+	case errors.As(err, &netErr), errors.As(err, &exitMissingErr):
+		return true
+	}
+
+	for _, transportErr := range []error{io.EOF, io.ErrUnexpectedEOF, net.ErrClosed, syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.EPIPE} {
+		if errors.Is(err, transportErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// LimitConcurrency lets at most perHost commands run at the same time on a host, further commands wait for
+// a running one to finish. A limit below 1 is raised to 1. The limit is shared by all runners wrapped by the
+// returned middleware
+func LimitConcurrency(perHost int) Middleware {
+	if perHost < 1 {
+		perHost = 1
+	}
+
+	var (
+		mu     sync.Mutex
+		limits = map[string]chan struct{}{}
+	)
+	limit := func(host string) chan struct{} {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if limits[host] == nil {
+			limits[host] = make(chan struct{}, perHost)
+		}
+		return limits[host]
+	}
+	acquire := func(ctx context.Context, slots chan struct{}, command ExecCommand) error {
+		if ctx.Err() != nil {
+			return contextError(ctx, command)
+		}
+		select {
+		case slots <- struct{}{}:
+			return nil
+		case <-ctx.Done():
+			return contextError(ctx, command)
+		}
+	}
+
+	return func(next CommandRunner) CommandRunner {
+		slots := limit(hostOf(next))
+
+		return &WrappedRunner{
+			Next: next,
+			Run: func(ctx context.Context, command ExecCommand) (*CommandResult, error) {
+				if err := acquire(ctx, slots, command); err != nil {
+					return nil, err
+				}
+				defer func() { <-slots }()
+
+				return next.RunCommandContext(ctx, command)
+			},
+			RunAsync: func(ctx context.Context, command ExecCommand) (<-chan Event, error) {
+				if err := acquire(ctx, slots, command); err != nil {
+					return nil, err
+				}
+
+				events, err := next.RunCommandAsyncContext(ctx, command)
+				if err != nil {
+					<-slots
+					return nil, err
+				}
+				return relayEvents(events, func(event Event) bool {
+					if event.Type == EventExit || event.Type == EventError {
+						<-slots
+					}
+					return true
+				}), nil
+			},
+		}
+	}
+}
+
+// LimitOutput caps the stdout and the stderr of a command at maxBytes each. A command run to completion gets
+// the cap as its MaxBytes limit so the runner drops the output past it while reading, the result of a capped
+// command is marked as truncated. An asynchronous command drops the lines past the cap and reports it on
+// stderr once
+func LimitOutput(maxBytes int) Middleware {
+	return func(next CommandRunner) CommandRunner {
+		return &WrappedRunner{
+			Next: next,
+			Run: func(ctx context.Context, command ExecCommand) (*CommandResult, error) {
+				if command.Limits.MaxBytes <= 0 || command.Limits.MaxBytes > int64(maxBytes) {
+					command.Limits.MaxBytes = int64(maxBytes)
+				}
+				result, err := next.RunCommandContext(ctx, command)
+
+				var exitErr *ExitError
+				if errors.As(err, &exitErr) {
+					result = exitErr.Result
+				}
+				// runners that ignore the limits, like the mock, are capped once they are done
+				if result != nil {
+					for _, output := range []*bytes.Buffer{result.Stdout, result.Stderr} {
+						if output.Len() > maxBytes {
+							output.Truncate(maxBytes)
+							result.Truncated = true
+						}
+					}
+				}
+				return result, err
+			},
+			RunAsync: func(ctx context.Context, command ExecCommand) (<-chan Event, error) {
+				events, err := next.RunCommandAsyncContext(ctx, command)
+				if err != nil {
+					return nil, err
+				}
+
+				sizes := map[EventType]int{}
+				truncated := false
+				relayed := make(chan Event, cap(events))
+				go func() {
+					defer close(relayed)
+					for event := range events {
+						if event.Type == EventStdout || event.Type == EventStderr {
+							sizes[event.Type] += len(event.Line) + 1
+							if sizes[event.Type] > maxBytes {
+								if !truncated {
+									truncated = true
+									relayed <- Event{Type: EventStderr, Line: truncationMarker(int64(maxBytes)), Host: event.Host, Time: event.Time, Truncated: true}
+								}
+								continue
+							}
+						}
+						relayed <- event
+					}
+				}()
+				return relayed, nil
+			},
+		}
+	}
+}
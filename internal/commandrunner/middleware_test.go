@@ -0,0 +1,337 @@
+package commandrunner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next CommandRunner) CommandRunner {
+			return &WrappedRunner{Next: next, Run: func(ctx context.Context, command ExecCommand) (*CommandResult, error) {
+				calls = append(calls, name)
+				return next.RunCommandContext(ctx, command)
+			}}
+		}
+	}
+
+	runner := Chain(&MockCommandRunner{}, trace("outer"), trace("inner"))
+	if _, err := runner.RunCommand(ExecCommand{Command: "true"}); err != nil {
+		t.Fatalf("RunCommand() error = %v", err)
+	}
+	if got := strings.Join(calls, ","); got != "outer,inner" {
+		t.Errorf("middlewares called in order %s, want outer,inner", got)
+	}
+	if got := hostOf(runner); got != mockHost {
+		t.Errorf("Host() = %q, want %q", got, mockHost)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	tests := []struct {
+		name     string
+		mock     *MockCommandRunner
+		async    bool
+		level    string
+		exitCode float64
+		err      string
+	}{
+		{name: "success", mock: &MockCommandRunner{Output: "Hello\n"}, level: "INFO"},
+		{name: "success async", mock: &MockCommandRunner{Output: "Hello\n"}, async: true, level: "INFO"},
+		{name: "exit code", mock: &MockCommandRunner{Output: "Hello\n", ExitCode: 2}, level: "WARN", exitCode: 2},
+		{name: "error", mock: &MockCommandRunner{Err: []error{errors.New("connection lost")}}, level: "ERROR", err: "connection lost"},
+		{name: "error async", mock: &MockCommandRunner{Output: "Hello\n", Err: []error{errors.New("connection lost")}}, async: true, level: "ERROR", err: "connection lost"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			runner := Chain(tt.mock, Audit(slog.New(slog.NewJSONHandler(&logs, nil)), "alice"))
+			command := ExecCommand{Args: []string{"apt", "upgrade", "-y"}, Elevated: true}
+
+			if tt.async {
+				events, err := runner.RunCommandAsync(command)
+				if err != nil {
+					t.Fatalf("RunCommandAsync() error = %v", err)
+				}
+				readEvents(t, events)
+			} else {
+				_, _ = runner.RunCommand(command)
+			}
+
+			var record map[string]any
+			if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+				t.Fatalf("expected one JSON record, got %q: %v", logs.String(), err)
+			}
+			want := map[string]any{
+				"level":     tt.level,
+				"actor":     "alice",
+				"host":      mockHost,
+				"command":   "apt upgrade -y",
+				"elevated":  true,
+				"exit_code": tt.exitCode,
+			}
+			if tt.err != "" {
+				want["error"] = tt.err
+			} else {
+				want["stdout_bytes"] = float64(6)
+				want["stdout_sha256"] = "66a045b452102c59d840ec097d59d9467e13a3f34f6494e539ffd32c1bb35f18"
+			}
+			for key, value := range want {
+				if record[key] != value {
+					t.Errorf("record[%q] = %v, want %v", key, record[key], value)
+				}
+			}
+			if _, ok := record["duration"]; !ok {
+				t.Errorf("record %v has no duration", record)
+			}
+		})
+	}
+}
+
+// failingRunner fails the first failures commands with err and runs the others with the mock
+func failingRunner(failures int, err error) (CommandRunner, *atomic.Int32) {
+	var attempts atomic.Int32
+	mock := &MockCommandRunner{Output: "ok"}
+	return &WrappedRunner{
+		Next: mock,
+		Run: func(ctx context.Context, command ExecCommand) (*CommandResult, error) {
+			if int(attempts.Add(1)) <= failures {
+				return nil, err
+			}
+			return mock.RunCommandContext(ctx, command)
+		},
+		RunAsync: func(ctx context.Context, command ExecCommand) (<-chan Event, error) {
+			if int(attempts.Add(1)) <= failures {
+				return nil, err
+			}
+			return mock.RunCommandAsyncContext(ctx, command)
+		},
+	}, &attempts
+}
+
+func TestRetry(t *testing.T) {
+	transportErr := fmt.Errorf("failed to dial ssh: %w", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED})
+
+	notStartedErr := &NotStartedError{Err: transportErr}
+	droppedErr := fmt.Errorf("failed to run command: %w", io.EOF)
+
+	tests := []struct {
+		name     string
+		command  string
+		failures int
+		err      error
+		input    bool
+		attempts int32
+		wantErr  bool
+	}{
+		{name: "transport error", failures: 2, err: transportErr, attempts: 3},
+		{name: "connection dropped while reading", failures: 1, err: droppedErr, attempts: 2},
+		{name: "connection dropped while upgrading", command: "apt-get upgrade -y", failures: 1, err: droppedErr, attempts: 1, wantErr: true},
+		{name: "upgrade not started", command: "apt-get upgrade -y", failures: 2, err: notStartedErr, attempts: 3},
+		{name: "too many transport errors", failures: 5, err: transportErr, attempts: 3, wantErr: true},
+		{name: "permanent error", failures: 1, err: errors.New("ssh: unable to authenticate"), attempts: 1, wantErr: true},
+		{name: "exit error", failures: 1, err: &ExitError{Result: newCommandResult("false", mockHost)}, attempts: 1, wantErr: true},
+		{name: "input consumed", failures: 1, err: transportErr, input: true, attempts: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		for _, async := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s async=%v", tt.name, async), func(t *testing.T) {
+				next, attempts := failingRunner(tt.failures, tt.err)
+				runner := Chain(next, Retry(3, time.Millisecond))
+
+				command := ExecCommand{Command: "apt list"}
+				if tt.command != "" {
+					command.Command = tt.command
+				}
+				if tt.input {
+					command.Input = strings.NewReader("input")
+				}
+
+				var err error
+				if async {
+					var events <-chan Event
+					if events, err = runner.RunCommandAsync(command); err == nil {
+						readEvents(t, events)
+					}
+				} else {
+					_, err = runner.RunCommand(command)
+				}
+
+				if (err != nil) != tt.wantErr {
+					t.Errorf("RunCommand() error = %v, want error: %v", err, tt.wantErr)
+				}
+				if got := attempts.Load(); got != tt.attempts {
+					t.Errorf("command attempted %d times, want %d", got, tt.attempts)
+				}
+			})
+		}
+	}
+}
+
+func TestLimitConcurrency(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	slow := func(next CommandRunner) CommandRunner {
+		return &WrappedRunner{Next: next, Run: func(ctx context.Context, command ExecCommand) (*CommandResult, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			return next.RunCommandContext(ctx, command)
+		}}
+	}
+
+	limit := LimitConcurrency(2)
+	runners := []CommandRunner{
+		Chain(&MockCommandRunner{}, limit, slow),
+		Chain(&MockCommandRunner{}, limit, slow),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(runner CommandRunner) {
+			defer wg.Done()
+			if _, err := runner.RunCommand(ExecCommand{Command: "true"}); err != nil {
+				t.Errorf("RunCommand() error = %v", err)
+			}
+		}(runners[i%2])
+	}
+	wg.Wait()
+
+	if got := maxRunning.Load(); got != 2 {
+		t.Errorf("at most %d commands ran at the same time on the host, want 2", got)
+	}
+
+	unlimited := Chain(&MockCommandRunner{}, LimitConcurrency(0))
+	done := make(chan error)
+	go func() {
+		_, err := unlimited.RunCommand(ExecCommand{Command: "true"})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("RunCommand() with a limit of 0 error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("RunCommand() with a limit of 0 blocked")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := runners[0].RunCommandContext(ctx, ExecCommand{Command: "true"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("RunCommandContext() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestLimitOutput(t *testing.T) {
+	runner := Chain(&MockCommandRunner{Output: "line1\nline2\nline3\n", Stderr: "err\n"}, LimitOutput(8))
+
+	result, err := runner.RunCommand(ExecCommand{Command: "cat"})
+	if err != nil {
+		t.Fatalf("RunCommand() error = %v", err)
+	}
+	if got := result.Stdout.String(); got != "line1\nli" || !result.Truncated {
+		t.Errorf("RunCommand() stdout = %q, truncated = %v, want %q truncated", got, result.Truncated, "line1\nli")
+	}
+	if got := result.Stderr.String(); got != "err\n" {
+		t.Errorf("RunCommand() stderr = %q, want %q", got, "err\n")
+	}
+
+	events, err := runner.RunCommandAsync(ExecCommand{Command: "cat"})
+	if err != nil {
+		t.Fatalf("RunCommandAsync() error = %v", err)
+	}
+	var lines []string
+	for event := range events {
+		if event.Type == EventStdout || event.Type == EventStderr {
+			lines = append(lines, event.Line)
+		}
+	}
+	want := "line1,[output truncated at 8 bytes],err"
+	if got := strings.Join(lines, ","); got != want {
+		t.Errorf("RunCommandAsync() lines = %s, want %s", got, want)
+	}
+}
+
+func TestLimitOutput_RunnerLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   OutputLimits
+		expected int64
+	}{
+		{name: "no limit", expected: 8},
+		{name: "larger limit", limits: OutputLimits{MaxBytes: 100}, expected: 8},
+		{name: "smaller limit", limits: OutputLimits{MaxBytes: 4}, expected: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &MockCommandRunner{}
+			runner := Chain(mockRunner, LimitOutput(8))
+
+			if _, err := runner.RunCommand(ExecCommand{Command: "cat", Limits: tt.limits}); err != nil {
+				t.Fatalf("RunCommand() error = %v", err)
+			}
+			if got := mockRunner.Commands()[0].Limits.MaxBytes; got != tt.expected {
+				t.Errorf("RunCommand() ran with MaxBytes = %d, want %d", got, tt.expected)
+			}
+		})
+	}
+
+	runner := Chain(&LocalCommandRunner{}, LimitOutput(8))
+	result, err := runner.RunCommand(ExecCommand{Command: "head -c 1000000 /dev/zero"})
+	if err != nil {
+		t.Fatalf("RunCommand() error = %v", err)
+	}
+	if result.Stdout.Len() != 8 || !result.Truncated {
+		t.Errorf("RunCommand() kept %d bytes, truncated = %v, want 8 truncated", result.Stdout.Len(), result.Truncated)
+	}
+}
+
+func TestIsTransportError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"dial", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"hop", &HopError{Hop: 1, Host: "bastion", Err: &net.OpError{Op: "dial", Err: syscall.ECONNRESET}}, true},
+		{"eof", fmt.Errorf("failed to create session: %w", errors.New("EOF")), false},
+		{"wrapped eof", fmt.Errorf("failed to create session: %w", net.ErrClosed), true},
+		{"exit error", &ExitError{Result: newCommandResult("false", mockHost)}, false},
+		{"canceled", context.Canceled, false},
+		{"deadline", &DeadlineExceededError{Command: "sleep 10"}, false},
+		{"closed runner", ErrRunnerClosed, false},
+		{"sudo", ErrIncorrectPassword, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransportError(tt.err); got != tt.want {
+				t.Errorf("IsTransportError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,45 +1,117 @@
 package commandrunner
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"strings"
+	"sync"
+	"time"
 )
 
+// mockHost is the host reported in the results of the MockCommandRunner
+const mockHost = "mock"
+
 // MockCommandRunner is a mock implementation of the CommandRunner interface
 type MockCommandRunner struct {
 	Output      string
+	Stderr      string
+	ExitCode    int
 	Err         []error
 	AskPassPath string
+
+	mu       sync.Mutex
+	commands []ExecCommand
+}
+
+// Commands returns the commands the mock was asked to run, in order
+func (m *MockCommandRunner) Commands() []ExecCommand {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]ExecCommand(nil), m.commands...)
+}
+
+// record remembers a command the mock was asked to run
+func (m *MockCommandRunner) record(command ExecCommand) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.commands = append(m.commands, command)
+}
+
+// Host returns the host reported in the results of the mock
+func (m *MockCommandRunner) Host() string {
+	return mockHost
 }
 
 // RunCommand mocks the execution of a command and returns predefined output and error
-func (m *MockCommandRunner) RunCommand(command ExecCommand) (*bufio.Scanner, error) {
+func (m *MockCommandRunner) RunCommand(command ExecCommand) (*CommandResult, error) {
+	return m.RunCommandContext(context.Background(), command)
+}
+
+// RunCommandContext mocks the execution of a command and returns predefined output and error,
+// or the context error when the context is already done
+func (m *MockCommandRunner) RunCommandContext(ctx context.Context, command ExecCommand) (*CommandResult, error) {
+	m.record(command)
+	if ctx.Err() != nil {
+		return nil, contextError(ctx, command)
+	}
 	if m.Err != nil && len(m.Err) > 0 {
 		return nil, m.Err[0]
 	}
-	return bufio.NewScanner(strings.NewReader(m.Output)), nil
+
+	result := &CommandResult{
+		Command:    command.CommandLine(),
+		Host:       mockHost,
+		ExitCode:   m.ExitCode,
+		Stdout:     bytes.NewBufferString(m.Output),
+		Stderr:     bytes.NewBufferString(m.Stderr),
+		StartedAt:  time.Now(),
+		FinishedAt: time.Now(),
+	}
+	if m.ExitCode != 0 {
+		return result, &ExitError{Result: result}
+	}
+	return result, nil
 }
 
 // RunCommandAsync mocks the execution of a command asynchronously and returns predefined output and error
-func (m *MockCommandRunner) RunCommandAsync(command ExecCommand) (<-chan string, <-chan error, error) {
-	output := make(chan string)
-	outputErrors := make(chan error)
+func (m *MockCommandRunner) RunCommandAsync(command ExecCommand) (<-chan Event, error) {
+	return m.RunCommandAsyncContext(context.Background(), command)
+}
+
+// RunCommandAsyncContext mocks the execution of a command asynchronously, it emits the predefined stdout
+// and stderr lines followed by the first predefined error or the exit code. The output stops once the context is done
+func (m *MockCommandRunner) RunCommandAsyncContext(ctx context.Context, command ExecCommand) (<-chan Event, error) {
+	m.record(command)
+	if ctx.Err() != nil {
+		return nil, contextError(ctx, command)
+	}
 
+	stream := newEventStream(mockHost)
 	go func() {
-		defer close(output)
-		for _, line := range strings.Split(m.Output, "\n") {
-			if len(line) > 0 {
-				output <- line
+		for _, output := range []struct {
+			eventType EventType
+			text      string
+		}{{EventStdout, m.Output}, {EventStderr, m.Stderr}} {
+			for _, line := range strings.Split(output.text, "\n") {
+				if len(line) == 0 {
+					continue
+				}
+				if ctx.Err() != nil {
+					stream.fail(contextError(ctx, command))
+					return
+				}
+				stream.send(Event{Type: output.eventType, Line: line})
 			}
 		}
-	}()
 
-	go func() {
-		defer close(outputErrors)
-		for _, err := range m.Err {
-			outputErrors <- err
+		if len(m.Err) > 0 {
+			stream.fail(m.Err[0])
+			return
 		}
+		stream.exit(m.ExitCode)
 	}()
 
-	return output, outputErrors, nil
+	return stream.events, nil
 }
@@ -0,0 +1,156 @@
+package commandrunner
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// defaultMaxLineLength is the longest line of streamed output kept when the command sets no limit
+const defaultMaxLineLength = 1 << 20
+
+// OutputLimits bounds the output of a command kept in memory, the zero value keeps all of it
+type OutputLimits struct {
+	// MaxLineLength cuts the lines of streamed output longer than this many bytes, 1MiB when zero.
+	// Cut lines are emitted once with Truncated set, the rest of the line is dropped
+	MaxLineLength int
+	// MaxBytes caps the stdout and the stderr of a command at this many bytes each, zero means no cap
+	MaxBytes int64
+	// Spill writes the whole stdout and stderr of a command exceeding MaxBytes to temporary files named in
+	// the result, which still keeps the first MaxBytes in memory. It only applies to RunCommand
+	Spill bool
+	// SpillDir is the directory of the spill files, the default directory for temporary files when empty
+	SpillDir string
+}
+
+// maxLineLength returns the longest line of streamed output kept
+func (l OutputLimits) maxLineLength() int {
+	if l.MaxLineLength <= 0 {
+		return defaultMaxLineLength
+	}
+	return l.MaxLineLength
+}
+
+// truncationMarker returns the line emitted in place of the output dropped past the cap
+func truncationMarker(maxBytes int64) string {
+	return fmt.Sprintf("[output truncated at %d bytes]", maxBytes)
+}
+
+// outputWriter keeps an output stream of a command in a buffer up to the cap of the limits. Past the cap
+// the stream is either dropped or, when spilling, written in full to a temporary file
+type outputWriter struct {
+	name      string
+	buf       *bytes.Buffer
+	limits    OutputLimits
+	written   int64
+	truncated bool
+	spill     *os.File
+	err       error
+}
+
+// Write never fails so the command is not disturbed, a failure to spill is reported by close
+func (w *outputWriter) Write(p []byte) (int, error) {
+	if w.limits.MaxBytes <= 0 {
+		return w.buf.Write(p)
+	}
+
+	overflow := w.written+int64(len(p)) > w.limits.MaxBytes
+	if overflow && w.limits.Spill && w.spill == nil && w.err == nil {
+		w.spill, w.err = os.CreateTemp(w.limits.SpillDir, "chisme-"+w.name+"-*")
+		if w.err == nil {
+			_, w.err = w.spill.Write(w.buf.Bytes())
+		}
+	}
+	if w.spill != nil && w.err == nil {
+		_, w.err = w.spill.Write(p)
+	}
+
+	if keep := w.limits.MaxBytes - w.written; keep > 0 {
+		w.buf.Write(p[:min(keep, int64(len(p)))])
+	}
+	w.written += int64(len(p))
+	w.truncated = w.truncated || overflow
+	return len(p), nil
+}
+
+// close closes the spill file and returns its path, empty when the output was not spilled
+func (w *outputWriter) close() (string, error) {
+	if w.spill == nil {
+		return "", w.err
+	}
+	if err := w.spill.Close(); w.err == nil {
+		w.err = err
+	}
+	if w.err != nil {
+		_ = os.Remove(w.spill.Name())
+		return "", fmt.Errorf("failed to spill %s: %w", w.name, w.err)
+	}
+	return w.spill.Name(), nil
+}
+
+// resultOutput holds the writers of the stdout and the stderr of a command kept in its result
+type resultOutput struct {
+	result *CommandResult
+	stdout *outputWriter
+	stderr *outputWriter
+}
+
+// newResultOutput creates the writers keeping the output of the command in the result within the limits
+func newResultOutput(result *CommandResult, limits OutputLimits) *resultOutput {
+	return &resultOutput{
+		result: result,
+		stdout: &outputWriter{name: "stdout", buf: result.Stdout, limits: limits},
+		stderr: &outputWriter{name: "stderr", buf: result.Stderr, limits: limits},
+	}
+}
+
+// close marks the result as truncated when output was capped and records the spill files in it
+func (o *resultOutput) close() error {
+	var errs []error
+	var err error
+	o.result.StdoutFile, err = o.stdout.close()
+	errs = append(errs, err)
+	o.result.StderrFile, err = o.stderr.close()
+	errs = append(errs, err)
+	o.result.Truncated = o.stdout.truncated || o.stderr.truncated
+
+	if err := errors.Join(errs...); err != nil {
+		_ = o.result.RemoveOutputFiles()
+		return err
+	}
+	return nil
+}
+
+// readLine reads the next line without its end of line, keeping at most maxLength bytes of it and dropping
+// the rest. It reports whether the line was cut and returns io.EOF once the reader is exhausted
+func readLine(r *bufio.Reader, maxLength int) (string, bool, error) {
+	var line []byte
+	read, cut := false, false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		read = read || len(chunk) > 0
+		// two more bytes than kept leave room for the end of line
+		if room := maxLength + 2 - len(line); len(chunk) > room {
+			chunk, cut = chunk[:max(room, 0)], true
+		}
+		line = append(line, chunk...)
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil && (!errors.Is(err, io.EOF) || !read) {
+			return "", false, err
+		}
+		break
+	}
+
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if len(line) > maxLength {
+		line, cut = line[:maxLength], true
+	}
+	return string(line), cut, nil
+}
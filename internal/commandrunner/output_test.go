@@ -0,0 +1,236 @@
+package commandrunner
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		maxLength int
+		want      []string
+		wantCut   []bool
+	}{
+		{name: "lines", input: "one\ntwo\n", maxLength: 10, want: []string{"one", "two"}, wantCut: []bool{false, false}},
+		{name: "no final newline", input: "one\ntwo", maxLength: 10, want: []string{"one", "two"}, wantCut: []bool{false, false}},
+		{name: "empty lines", input: "\n\none\n", maxLength: 10, want: []string{"", "", "one"}, wantCut: []bool{false, false, false}},
+		{name: "crlf", input: "one\r\ntwo\r\n", maxLength: 3, want: []string{"one", "two"}, wantCut: []bool{false, false}},
+		{name: "cut", input: "abcdefgh\nij\n", maxLength: 3, want: []string{"abc", "ij"}, wantCut: []bool{true, false}},
+		{name: "cut without newline", input: "abcdefgh", maxLength: 4, want: []string{"abcd"}, wantCut: []bool{true}},
+		{name: "longer than the buffer", input: strings.Repeat("x", 100000) + "\nend\n", maxLength: 70000, want: []string{strings.Repeat("x", 70000), "end"}, wantCut: []bool{true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReaderSize(strings.NewReader(tt.input), 16)
+			var got []string
+			var cuts []bool
+			for {
+				line, cut, err := readLine(reader, tt.maxLength)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("readLine() error = %v", err)
+				}
+				got, cuts = append(got, line), append(cuts, cut)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("readLine() = %q, want %q", got, tt.want)
+			}
+			for i := range cuts {
+				if i < len(tt.wantCut) && cuts[i] != tt.wantCut[i] {
+					t.Errorf("readLine() line %d cut = %v, want %v", i, cuts[i], tt.wantCut[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLocalCommandRunner_RunCommandAsync_LongLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		limits     OutputLimits
+		wantLength int
+		wantCut    bool
+	}{
+		{name: "default limit", wantLength: 200000},
+		{name: "max line length", limits: OutputLimits{MaxLineLength: 1000}, wantLength: 1000, wantCut: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := (&LocalCommandRunner{}).RunCommandAsync(ExecCommand{
+				Command: "head -c 200000 /dev/zero | tr '\\0' x; echo; echo done",
+				Limits:  tt.limits,
+			})
+			if err != nil {
+				t.Fatalf("RunCommandAsync() error = %v", err)
+			}
+
+			var lines []Event
+			for event := range events {
+				switch event.Type {
+				case EventStdout:
+					lines = append(lines, event)
+				case EventError:
+					t.Fatalf("RunCommandAsync() error = %v", event.Err)
+				}
+			}
+			if len(lines) != 2 || lines[1].Line != "done" {
+				t.Fatalf("RunCommandAsync() emitted %d lines, want the long line and done", len(lines))
+			}
+			if len(lines[0].Line) != tt.wantLength || lines[0].Truncated != tt.wantCut {
+				t.Errorf("RunCommandAsync() long line length = %d, truncated = %v, want %d, %v", len(lines[0].Line), lines[0].Truncated, tt.wantLength, tt.wantCut)
+			}
+		})
+	}
+}
+
+func TestLocalCommandRunner_RunCommandAsync_MaxBytes(t *testing.T) {
+	events, err := (&LocalCommandRunner{}).RunCommandAsync(ExecCommand{
+		Command: "seq 1 1000; echo err >&2",
+		Limits:  OutputLimits{MaxBytes: 8},
+	})
+	if err != nil {
+		t.Fatalf("RunCommandAsync() error = %v", err)
+	}
+
+	var stdout, stderr []string
+	markers := 0
+	for event := range events {
+		switch event.Type {
+		case EventStdout:
+			stdout = append(stdout, event.Line)
+		case EventStderr:
+			stderr = append(stderr, event.Line)
+		case EventError:
+			t.Fatalf("RunCommandAsync() error = %v", event.Err)
+		}
+		if event.Truncated {
+			markers++
+		}
+	}
+
+	want := "1,2,3,4," + truncationMarker(8)
+	if got := strings.Join(stdout, ","); got != want || markers != 1 {
+		t.Errorf("RunCommandAsync() stdout = %s with %d markers, want %s with one marker", got, markers, want)
+	}
+	if got := strings.Join(stderr, ","); got != "err" {
+		t.Errorf("RunCommandAsync() stderr = %s, want err", got)
+	}
+}
+
+func TestLocalCommandRunner_RunCommand_Limits(t *testing.T) {
+	want := ""
+	for i := 1; i <= 1000; i++ {
+		want += strings.Repeat("x", 10) + "\n"
+	}
+	command := "for i in $(seq 1 1000); do echo xxxxxxxxxx; done; echo err >&2"
+
+	tests := []struct {
+		name      string
+		limits    OutputLimits
+		wantKept  string
+		truncated bool
+		spilled   bool
+	}{
+		{name: "no limit", wantKept: want},
+		{name: "max bytes", limits: OutputLimits{MaxBytes: 15}, wantKept: want[:15], truncated: true},
+		{name: "under max bytes", limits: OutputLimits{MaxBytes: 20000}, wantKept: want},
+		{name: "spill", limits: OutputLimits{MaxBytes: 15, Spill: true, SpillDir: t.TempDir()}, wantKept: want[:15], truncated: true, spilled: true},
+		{name: "no spill under max bytes", limits: OutputLimits{MaxBytes: 20000, Spill: true}, wantKept: want},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := (&LocalCommandRunner{}).RunCommand(ExecCommand{Command: command, Limits: tt.limits})
+			if err != nil {
+				t.Fatalf("RunCommand() error = %v", err)
+			}
+			defer result.RemoveOutputFiles()
+
+			if got := result.Stdout.String(); got != tt.wantKept || result.Truncated != tt.truncated {
+				t.Errorf("RunCommand() kept %d bytes, truncated = %v, want %d bytes, truncated = %v", len(got), result.Truncated, len(tt.wantKept), tt.truncated)
+			}
+			if got := result.Stderr.String(); got != "err\n" {
+				t.Errorf("RunCommand() stderr = %q, want %q", got, "err\n")
+			}
+			if (result.StdoutFile != "") != tt.spilled || result.StderrFile != "" {
+				t.Fatalf("RunCommand() spill files = %q, %q, want stdout spilled: %v", result.StdoutFile, result.StderrFile, tt.spilled)
+			}
+			if !tt.spilled {
+				return
+			}
+
+			if filepath.Dir(result.StdoutFile) != tt.limits.SpillDir {
+				t.Errorf("RunCommand() spilled to %s, want a file in %s", result.StdoutFile, tt.limits.SpillDir)
+			}
+			spilled, err := os.ReadFile(result.StdoutFile)
+			if err != nil {
+				t.Fatalf("failed to read spill file: %v", err)
+			}
+			if string(spilled) != want {
+				t.Errorf("spill file holds %d bytes, want the whole %d bytes of stdout", len(spilled), len(want))
+			}
+			file := result.StdoutFile
+			if err := result.RemoveOutputFiles(); err != nil {
+				t.Fatalf("RemoveOutputFiles() error = %v", err)
+			}
+			if _, err := os.Stat(file); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("RemoveOutputFiles() left %s behind", file)
+			}
+		})
+	}
+}
+
+func TestCommandResult_Scanner_LongLine(t *testing.T) {
+	long := strings.Repeat("x", 100*1024)
+	result := newCommandResult("cat", localHost)
+	result.Stdout.WriteString("first\n" + long + "\nlast\n")
+
+	var lines []string
+	scanner := result.Scanner()
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Scanner() error = %v", err)
+	}
+	if len(lines) != 3 || lines[1] != long {
+		t.Errorf("Scanner() read %d lines, want 3 with the long line intact", len(lines))
+	}
+}
+
+func TestSSHCommandRunner_RunCommand_Spill(t *testing.T) {
+	server := startTestSSHServerWithOptions(t, testSSHServerOptions{shell: true, shellPath: t.TempDir()})
+	t.Cleanup(server.Close)
+	runner := newPoolTestRunner(t, server, 0)
+
+	result, err := runner.RunCommand(ExecCommand{
+		Args:   []string{"seq", "1", "10000"},
+		Limits: OutputLimits{MaxBytes: 100, Spill: true, SpillDir: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("RunCommand() error = %v", err)
+	}
+	defer result.RemoveOutputFiles()
+
+	if result.Stdout.Len() != 100 || !result.Truncated || result.StdoutFile == "" {
+		t.Fatalf("RunCommand() kept %d bytes, truncated = %v, spill file %q, want 100 bytes spilled", result.Stdout.Len(), result.Truncated, result.StdoutFile)
+	}
+	spilled, err := os.ReadFile(result.StdoutFile)
+	if err != nil {
+		t.Fatalf("failed to read spill file: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(spilled)), "\n"); len(lines) != 10000 || lines[9999] != "10000" {
+		t.Errorf("spill file holds %d lines, want 10000", len(lines))
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package commandrunner
+
+import "os/exec"
+
+// configureProcessGroup is a no-op on platforms without process groups, cancelling
+// the command context only kills the process itself
+func configureProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,37 @@
+package commandrunner
+
+import "strings"
+
+// shellSafe reports whether the argument only contains characters that need no quoting in a POSIX shell. Words with
+// '=' are quoted, a shell reads an unquoted X=1 in command position as a variable assignment
+func shellSafe(arg string) bool {
+	if arg == "" {
+		return false
+	}
+	for _, r := range arg {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("-_./:+,@%", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ShellQuote quotes the argument for a POSIX shell, arguments without special characters are returned as they are
+func ShellQuote(arg string) string {
+	if shellSafe(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// ShellJoin quotes every argument for a POSIX shell and joins them into a command line
+func ShellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = ShellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,49 @@
+package commandrunner
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"libc6", "libc6"},
+		{"libstdc++6", "libstdc++6"},
+		{"--only-upgrade", "--only-upgrade"},
+		{"/usr/bin/apt", "/usr/bin/apt"},
+		{"X=1", "'X=1'"},
+		{"--format=json", "'--format=json'"},
+		{"", "''"},
+		{"two words", "'two words'"},
+		{"it's", `'it'\''s'`},
+		{"libc6; rm -rf /", "'libc6; rm -rf /'"},
+		{"$(reboot)", "'$(reboot)'"},
+		{"`reboot`", "'`reboot`'"},
+		{"a\nb", "'a\nb'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			if got := ShellQuote(tt.arg); got != tt.want {
+				t.Errorf("ShellQuote(%q) = %q, want %q", tt.arg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShellJoin_RoundTrip(t *testing.T) {
+	args := []string{"printf", "%s|", "X=1", "plain", "two words", "it's", "$HOME", "`id`", "a\"b", "back\\slash", ""}
+
+	output, err := exec.Command("sh", "-c", ShellJoin(args)).Output()
+	if err != nil {
+		t.Fatalf("failed to run joined command: %v", err)
+	}
+
+	want := "X=1|plain|two words|it's|$HOME|`id`|a\"b|back\\slash||"
+	if string(output) != want {
+		t.Errorf("sh -c %s = %q, want %q", ShellJoin(args), output, want)
+	}
+}
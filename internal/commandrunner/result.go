@@ -0,0 +1,89 @@
+package commandrunner
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// localHost is the host reported in the results of commands run on the local machine
+const localHost = "localhost"
+
+// CommandResult holds the outcome of a command that ran to completion
+type CommandResult struct {
+	Command    string
+	Host       string
+	ExitCode   int
+	Stdout     *bytes.Buffer
+	Stderr     *bytes.Buffer
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Truncated is set when output was dropped because it exceeded a limit
+	Truncated bool
+	// StdoutFile and StderrFile hold the whole output of a command that spilled past its limit, Stdout and
+	// Stderr then only keep its beginning. RemoveOutputFiles removes them once they are no longer needed
+	StdoutFile string
+	StderrFile string
+}
+
+// newCommandResult creates an empty result for the command on the host with the start time set to now
+func newCommandResult(command, host string) *CommandResult {
+	return &CommandResult{
+		Command:   command,
+		Host:      host,
+		Stdout:    &bytes.Buffer{},
+		Stderr:    &bytes.Buffer{},
+		StartedAt: time.Now(),
+	}
+}
+
+// Duration returns how long the command ran
+func (r *CommandResult) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// Success reports whether the command exited with status zero
+func (r *CommandResult) Success() bool {
+	return r.ExitCode == 0
+}
+
+// Scanner returns a scanner over the stdout of the command. Its buffer may grow to the size of the whole
+// output so no line is too long to scan, the output is already held in memory
+func (r *CommandResult) Scanner() *bufio.Scanner {
+	scanner := bufio.NewScanner(bytes.NewReader(r.Stdout.Bytes()))
+	scanner.Buffer(nil, max(r.Stdout.Len()+1, bufio.MaxScanTokenSize))
+	return scanner
+}
+
+// RemoveOutputFiles removes the files the output of the command spilled to
+func (r *CommandResult) RemoveOutputFiles() error {
+	var errs []error
+	for _, file := range []*string{&r.StdoutFile, &r.StderrFile} {
+		if *file == "" {
+			continue
+		}
+		if err := os.Remove(*file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		*file = ""
+	}
+	return errors.Join(errs...)
+}
+
+// ExitError is returned when a command ran on the host but exited with a non-zero status,
+// any other error returned by a runner means the command could not be run or completed
+type ExitError struct {
+	Result *CommandResult
+}
+
+func (e *ExitError) Error() string {
+	stderr := strings.TrimSpace(e.Result.Stderr.String())
+	if stderr == "" {
+		return fmt.Sprintf("command execution failed with exit code %d", e.Result.ExitCode)
+	}
+	return fmt.Sprintf("command execution failed with exit code %d: %s", e.Result.ExitCode, stderr)
+}
@@ -1,28 +1,56 @@
 package commandrunner
 
 import (
-	"bufio"
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/ssh"
 	"io"
+	"net"
 	"sync"
 	"time"
 )
 
-// SSHCommandRunner implements CommandRunner for running bash commands over an SSH connection
+// SSHCommandRunner implements CommandRunner for running bash commands over an SSH connection. The connection
+// is kept open and shared by all commands, each command runs in its own session
 type SSHCommandRunner struct {
 	config      SSHConfig
 	AskPassPath string
+
+	mu       sync.Mutex
+	pool     *pooledClient
+	closed   bool
+	sessions chan struct{}
+	// dialing is held by the command connecting to the host
+	dialing chan struct{}
+
+	sudoMu  sync.Mutex
+	sudo    *sudoMode
+	askPass string
 }
 
-// SSHConfig holds the configuration for the sshrunner connection
+// SSHConfig holds the configuration for the sshrunner connection. The authentication methods are tried
+// in order, a PrivateKey set directly on the config is tried before them
 type SSHConfig struct {
 	Host               string
 	Port               int
 	User               string
 	PrivateKey         []byte
 	PrivateKeyPassword string
+	AuthMethods        []AuthMethod
+	HostKey            HostKeyConfig
+	// KeepAliveInterval is the interval between keepalive requests on the connection, defaults to 30 seconds
+	KeepAliveInterval time.Duration
+	// MaxSessions caps the number of commands running concurrently on the connection, defaults to 10
+	MaxSessions int
+	// SudoPassword provides the password of elevated commands, it is only asked for when sudo requires a password
+	SudoPassword SecretProvider
+	// AskPass runs elevated commands with sudo -A and an askpass helper uploaded on first use instead of
+	// answering the sudo prompt, the helper is removed on Close. It requires SudoPassword
+	AskPass bool
+	// JumpHosts are the bastions the connection is tunneled through in order, like OpenSSH ProxyJump.
+	// Every jump host has its own user, authentication and host key verification
+	JumpHosts []SSHConfig
 }
 
 // NewSSHCommandRunner creates a new SSHCommandRunner
@@ -30,8 +58,14 @@ func NewSSHCommandRunner(config SSHConfig) (*SSHCommandRunner, error) {
 	if err := validateConfig(config); err != nil {
 		return nil, err
 	}
+	maxSessions := config.MaxSessions
+	if maxSessions <= 0 {
+		maxSessions = defaultMaxSessions
+	}
 	return &SSHCommandRunner{
-		config: config,
+		config:   config,
+		sessions: make(chan struct{}, maxSessions),
+		dialing:  make(chan struct{}, 1),
 	}, nil
 }
 
@@ -46,110 +80,269 @@ func validateConfig(config SSHConfig) error {
 	if config.User == "" {
 		return fmt.Errorf("user is required")
 	}
-	if len(config.PrivateKey) == 0 {
-		return fmt.Errorf("private key is required")
+	methods := configuredAuthMethods(&config)
+	if len(methods) == 0 {
+		return fmt.Errorf("private key or authentication method is required")
+	}
+	for i, method := range methods {
+		if err := validateAuthMethod(method); err != nil {
+			return fmt.Errorf("invalid authentication method %d (%s): %w", i+1, method.Type, err)
+		}
+	}
+	if err := validateHostKeyConfig(config.HostKey); err != nil {
+		return err
+	}
+	for i, jumpHost := range config.JumpHosts {
+		if len(jumpHost.JumpHosts) > 0 {
+			return fmt.Errorf("invalid jump host %d: nested jump hosts are not supported", i+1)
+		}
+		if err := validateConfig(jumpHost); err != nil {
+			return fmt.Errorf("invalid jump host %d: %w", i+1, err)
+		}
 	}
 	return nil
 }
 
-// RunCommand runs a command over a sshrunner connection and returns the output as a scanner
-func (s *SSHCommandRunner) RunCommand(ec ExecCommand) (*bufio.Scanner, error) {
-	client, err := connectToSSH(&s.config)
+// RunCommand runs a command over a sshrunner connection and returns its result
+func (s *SSHCommandRunner) RunCommand(ec ExecCommand) (*CommandResult, error) {
+	return s.RunCommandContext(context.Background(), ec)
+}
+
+// RunCommandContext runs a command over a sshrunner connection and returns its result, the remote command
+// is killed and the session closed when the context is cancelled or the timeout expires.
+// A command exiting with a non-zero status returns its result together with an *ExitError
+func (s *SSHCommandRunner) RunCommandContext(ctx context.Context, ec ExecCommand) (*CommandResult, error) {
+	ctx, cancel := commandContext(ctx, ec)
+	defer cancel()
+
+	elevation, err := s.prepareElevation(ctx, ec)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to sshrunner: %w", err)
+		if ctx.Err() != nil {
+			return nil, contextError(ctx, ec)
+		}
+		return nil, err
 	}
-	defer client.Close()
 
-	session, err := client.NewSession()
+	session, release, err := s.newSession(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create session: %w", err)
+		if ctx.Err() != nil {
+			return nil, contextError(ctx, ec)
+		}
+		return nil, err
 	}
-	defer session.Close()
+	defer release()
+
+	stop := context.AfterFunc(ctx, func() { killSession(session) })
+	defer stop()
+
+	result := newCommandResult(ec.CommandLine(), s.config.Host)
+	stdOut, stdErr, err := setupSshPipes(session)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyCommandSettings(&ec, elevation, session); err != nil {
+		return nil, err
+	}
+	stdOut, stdErr = elevation.filter(stdOut, stdErr)
+
+	if err := session.Start(ec.Command); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	output := newResultOutput(result, ec.Limits)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(output.stdout, stdOut)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(output.stderr, stdErr)
+	}()
+	wg.Wait()
+
+	err = session.Wait()
+	result.FinishedAt = time.Now()
+	if outputErr := output.close(); outputErr != nil {
+		return nil, outputErr
+	}
+	if ctx.Err() != nil {
+		_ = result.RemoveOutputFiles()
+		return nil, contextError(ctx, ec)
+	}
+	if sudoErr := elevation.result(err); sudoErr != nil {
+		_ = result.RemoveOutputFiles()
+		return nil, fmt.Errorf("failed to run elevated command on %s: %w", s.config.Host, sudoErr)
+	}
+
+	var exitErr *ssh.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+		return result, &ExitError{Result: result}
+	case err != nil:
+		_ = result.RemoveOutputFiles()
+		return nil, fmt.Errorf("failed to run command: %w", err)
+	}
+
+	return result, nil
+}
+
+// RunCommandAsync runs a command over a sshrunner connection asynchronously and returns a channel with the events of the command
+func (s *SSHCommandRunner) RunCommandAsync(ec ExecCommand) (<-chan Event, error) {
+	return s.RunCommandAsyncContext(context.Background(), ec)
+}
 
-	applyCommandSettings(&ec, s.AskPassPath, session)
+// RunCommandAsyncContext runs a command over a sshrunner connection asynchronously and returns a channel with
+// the events of the command, the remote command is killed and the session closed when the context is cancelled or the timeout expires
+func (s *SSHCommandRunner) RunCommandAsyncContext(ctx context.Context, ec ExecCommand) (<-chan Event, error) {
+	ctx, cancel := commandContext(ctx, ec)
 
-	output, err := session.CombinedOutput(ec.Command)
+	started, err := s.startCommand(ctx, ec)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
-	return bufio.NewScanner(bytes.NewReader(output)), nil
+	stream := newLimitedEventStream(s.config.Host, ec.Limits)
+	go runSshCommand(ctx, cancel, started.session, started.release, ec, started.elevation, started.stdout, started.stderr, stream)
+
+	return stream.events, nil
 }
 
-// RunCommandAsync runs a command over a sshrunner connection asynchronously and returns a channel with the output lines
-func (s *SSHCommandRunner) RunCommandAsync(ec ExecCommand) (<-chan string, <-chan error, error) {
-	output := make(chan string, 10)
-	errorsChan := make(chan error)
+// startedCommand is a command started on a session of the runner
+type startedCommand struct {
+	session   *ssh.Session
+	release   func()
+	elevation *sudoElevation
+	stdout    io.Reader
+	stderr    io.Reader
+}
 
-	client, err := connectToSSH(&s.config)
+// startCommand elevates the command if needed and starts it on a new session, release returns the session
+// to the runner once the command finished
+func (s *SSHCommandRunner) startCommand(ctx context.Context, ec ExecCommand) (*startedCommand, error) {
+	elevation, err := s.prepareElevation(ctx, ec)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to connect to sshrunner: %w", err)
+		return nil, err
 	}
+	return s.startElevated(ctx, ec, elevation)
+}
 
-	session, err := client.NewSession()
+// startElevated starts the command on a new session with the elevation prepared for it, nil when it is not elevated
+func (s *SSHCommandRunner) startElevated(ctx context.Context, ec ExecCommand, elevation *sudoElevation) (*startedCommand, error) {
+	session, release, err := s.newSession(ctx)
 	if err != nil {
-		client.Close()
-		return nil, nil, fmt.Errorf("failed to create session: %w", err)
+		return nil, err
 	}
 
 	stdOut, stdErr, err := setupSshPipes(session)
 	if err != nil {
-		closeResources(session, client)
-		return nil, nil, err
+		release()
+		return nil, err
 	}
 
-	applyCommandSettings(&ec, s.AskPassPath, session)
+	if err := applyCommandSettings(&ec, elevation, session); err != nil {
+		release()
+		return nil, err
+	}
+	stdOut, stdErr = elevation.filter(stdOut, stdErr)
 
-	var wg sync.WaitGroup
-	wg.Add(1)
+	if err := session.Start(ec.Command); err != nil {
+		release()
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
 
-	go handleSshOutput(stdOut, stdErr, output, errorsChan, &wg)
-	go runSshCommand(session, ec.Command, output, errorsChan, client, &wg)
+	return &startedCommand{session: session, release: release, elevation: elevation, stdout: stdOut, stderr: stdErr}, nil
+}
 
-	return output, errorsChan, nil
+// connectToSSH connects to an ssh server using the provided configuration, through its jump hosts if any.
+// The dial and handshake are aborted when the context is cancelled
+func connectToSSH(ctx context.Context, config *SSHConfig) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: sshConnectTimeout}
+	if len(config.JumpHosts) > 0 {
+		return connectThroughJumpHosts(ctx, config, dialer.DialContext)
+	}
+	return dialSSH(ctx, config, dialer.DialContext)
 }
 
-// connectToSSH connects to an ssh server using the provided configuration
-func connectToSSH(config *SSHConfig) (*ssh.Client, error) {
-	clientConfig, err := setupSSHConfig(config)
+// dialSSH opens the connection to the host of the config with dial and runs the ssh handshake on it
+func dialSSH(ctx context.Context, config *SSHConfig, dial dialFunc) (*ssh.Client, error) {
+	clientConfig, release, err := setupSSHConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to setup ssh config: %w", err)
 	}
+	defer release()
+
+	addr := sshAddress(config)
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial ssh: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", config.Host, config.Port), clientConfig)
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
 	if err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("failed to dial ssh: %w", err)
 	}
 
-	return client, nil
+	return ssh.NewClient(c, chans, reqs), nil
 }
 
-// setupSSHConfig sets up the ssh client config by reading the private key and parsing it
-func setupSSHConfig(config *SSHConfig) (*ssh.ClientConfig, error) {
-	signer, err := getPublicKeySignerFromPrivateKey(config.PrivateKey, config.PrivateKeyPassword)
+// setupSSHConfig sets up the ssh client config from the authentication methods and the host key verification,
+// the returned function releases the resources held by the authentication methods once the handshake is done
+func setupSSHConfig(config *SSHConfig) (*ssh.ClientConfig, func(), error) {
+	callback, err := hostKeyCallback(config.HostKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get public key signer from private key: %w", err)
+		return nil, nil, fmt.Errorf("failed to setup host key verification: %w", err)
+	}
+
+	authMethods, release, err := setupAuthMethods(configuredAuthMethods(config))
+	if err != nil {
+		return nil, nil, err
 	}
 
 	return &ssh.ClientConfig{
-		User: config.User,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-	}, nil
+		User:            config.User,
+		Auth:            authMethods,
+		HostKeyCallback: callback,
+		Timeout:         sshConnectTimeout,
+	}, release, nil
 }
 
-// applyCommandSettings applies the root elevation and Input settings to the command
-func applyCommandSettings(ec *ExecCommand, askPassPath string, session *ssh.Session) {
-	if ec.Elevated {
-		applyCommandRootElevation(&ec.Command, askPassPath)
+// applyCommandSettings turns the command into the command line run by the remote shell and applies the environment,
+// working directory, umask, root elevation and Input settings, the elevation is nil for commands that are not elevated.
+// The environment is passed inline with env when the server rejects it, and always for elevated commands as sudo resets it
+func applyCommandSettings(ec *ExecCommand, elevation *sudoElevation, session *ssh.Session) error {
+	if err := validateExecCommand(*ec); err != nil {
+		return err
+	}
+
+	command := ec.CommandLine()
+	if env := envAssignments(ec.Env); len(env) > 0 && (elevation != nil || !setenv(session, env)) {
+		command = envCommand(env, *ec)
 	}
+	ec.Command, ec.Args = command, nil
 
-	if ec.Input != nil {
+	if ec.PTY != nil {
+		if err := requestPty(session, ec.PTY); err != nil {
+			return err
+		}
+	}
+	if elevation != nil {
+		if err := elevation.apply(ec, session); err != nil {
+			return err
+		}
+	} else if ec.Input != nil {
 		session.Stdin = ec.Input
 	}
+
+	ec.Command = shellPrelude(*ec) + ec.Command
+	return nil
 }
 
 // getPublicKeySignerFromPrivateKey returns a signer from the provided private key
@@ -185,35 +378,38 @@ func setupSshPipes(session *ssh.Session) (io.Reader, io.Reader, error) {
 	return stdOut, stdErr, nil
 }
 
-// runSshCommand runs the provided command on the session and sends the output to the output channel
-func runSshCommand(session *ssh.Session, command string, output chan<- string, errorsChan chan<- error, client *ssh.Client, wg *sync.WaitGroup) {
-	defer closeResources(session, client)
-	defer close(output)
-	defer close(errorsChan)
-	if err := session.Run(command); err != nil {
-		errorsChan <- fmt.Errorf("failed to run command: %w", err)
-	}
-	wg.Wait()
-}
+// runSshCommand streams the output of the started command on the session and emits its exit status,
+// the session is killed as soon as the context is done
+func runSshCommand(ctx context.Context, cancel context.CancelFunc, session *ssh.Session, release func(), ec ExecCommand, elevation *sudoElevation, stdOut, stdErr io.Reader, stream *eventStream) {
+	defer cancel()
+	defer release()
 
-// handleSshOutput reads from the stdout and stderr pipes and sends the output to the output channel
-func handleSshOutput(stdOut, stdErr io.Reader, output chan<- string, errorsChan chan<- error, wg *sync.WaitGroup) {
-	defer wg.Done()
-	scanner := bufio.NewScanner(io.MultiReader(stdErr, stdOut))
-	for scanner.Scan() {
-		output <- scanner.Text()
-	}
-	if err := scanner.Err(); err != nil {
-		errorsChan <- fmt.Errorf("error reading stderr: %w", err)
+	stop := context.AfterFunc(ctx, func() { killSession(session) })
+	defer stop()
+
+	readErr := stream.streamOutput(stdOut, stdErr)
+
+	err := session.Wait()
+	var exitErr *ssh.ExitError
+	switch {
+	case ctx.Err() != nil:
+		stream.fail(contextError(ctx, ec))
+	case elevation.result(err) != nil:
+		stream.fail(fmt.Errorf("failed to run elevated command on %s: %w", stream.host, elevation.result(err)))
+	case readErr != nil:
+		stream.fail(readErr)
+	case errors.As(err, &exitErr):
+		stream.exit(exitErr.ExitStatus())
+	case err != nil:
+		stream.fail(fmt.Errorf("failed to run command: %w", err))
+	default:
+		stream.exit(0)
 	}
 }
 
-// closeResources closes the session and client
-func closeResources(session *ssh.Session, client *ssh.Client) {
-	if session != nil {
-		session.Close()
-	}
-	if client != nil {
-		client.Close()
-	}
+// killSession sends SIGKILL to the remote command and closes the session, servers that
+// do not support signals still terminate the command once the channel is closed
+func killSession(session *ssh.Session) {
+	_ = session.Signal(ssh.SIGKILL)
+	_ = session.Close()
 }
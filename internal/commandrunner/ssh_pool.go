@@ -0,0 +1,195 @@
+package commandrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"time"
+)
+
+const (
+	// defaultKeepAliveInterval is the interval between keepalive requests when SSHConfig.KeepAliveInterval is not set
+	defaultKeepAliveInterval = 30 * time.Second
+	// defaultMaxSessions matches the MaxSessions default of OpenSSH servers
+	defaultMaxSessions = 10
+)
+
+// ErrRunnerClosed is returned when a command is run on a runner that has been closed
+var ErrRunnerClosed = errors.New("command runner is closed")
+
+// Host returns the host the runner connects to
+func (s *SSHCommandRunner) Host() string {
+	return s.config.Host
+}
+
+// pooledClient is the long-lived connection of an SSHCommandRunner
+type pooledClient struct {
+	client *ssh.Client
+	done   chan struct{}
+}
+
+// Close removes the askpass helper from the host and closes the pooled connection, the runner cannot be used afterwards
+func (s *SSHCommandRunner) Close() error {
+	cleanupErr := s.removeAskPassHelper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return cleanupErr
+	}
+	s.closed = true
+
+	if s.pool == nil {
+		return cleanupErr
+	}
+	err := s.pool.client.Close()
+	s.pool = nil
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return errors.Join(cleanupErr, fmt.Errorf("failed to close ssh connection: %w", err))
+	}
+	return cleanupErr
+}
+
+// getClient returns the pooled client, connecting first when there is no live connection. Only one dial runs at a
+// time, it runs without holding the runner lock so that Close and the commands on the live connection are not
+// blocked by a slow dial
+func (s *SSHCommandRunner) getClient(ctx context.Context) (*pooledClient, error) {
+	select {
+	case s.dialing <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-s.dialing }()
+
+	s.mu.Lock()
+	closed, pool := s.closed, s.pool
+	s.mu.Unlock()
+	if closed {
+		return nil, ErrRunnerClosed
+	}
+	if pool != nil {
+		return pool, nil
+	}
+
+	client, err := connectToSSH(ctx, &s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = client.Close()
+		return nil, ErrRunnerClosed
+	}
+	pool = &pooledClient{client: client, done: make(chan struct{})}
+	s.pool = pool
+	s.mu.Unlock()
+
+	go func() {
+		_ = client.Wait()
+		close(pool.done)
+		s.dropClient(pool)
+	}()
+	go keepAlive(pool, s.keepAliveInterval())
+
+	return pool, nil
+}
+
+// dropClient forgets the pooled client when it is still the current one and closes it,
+// the next command connects again
+func (s *SSHCommandRunner) dropClient(pool *pooledClient) {
+	s.mu.Lock()
+	if s.pool == pool {
+		s.pool = nil
+	}
+	s.mu.Unlock()
+
+	_ = pool.client.Close()
+}
+
+// newSession opens a session on the pooled connection once a session slot is free. A connection that turns out
+// to be dead is replaced once, a session the server refused, like when its MaxSessions is lower than ours, is
+// returned as an error and leaves the connection and its other sessions alone. The returned function closes the
+// session and frees its slot
+func (s *SSHCommandRunner) newSession(ctx context.Context) (*ssh.Session, func(), error) {
+	select {
+	case s.sessions <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	release := func() { <-s.sessions }
+
+	for attempt := 0; ; attempt++ {
+		pool, err := s.getClient(ctx)
+		if err != nil {
+			release()
+			return nil, nil, &NotStartedError{Err: fmt.Errorf("failed to connect to sshrunner: %w", err)}
+		}
+
+		session, err := pool.client.NewSession()
+		if err == nil {
+			return session, func() {
+				session.Close()
+				release()
+			}, nil
+		}
+
+		var rejected *ssh.OpenChannelError
+		if errors.As(err, &rejected) {
+			release()
+			return nil, nil, &NotStartedError{Err: fmt.Errorf("failed to create session: %w", err)}
+		}
+
+		s.dropClient(pool)
+		if attempt > 0 {
+			release()
+			return nil, nil, &NotStartedError{Err: fmt.Errorf("failed to create session: %w", err)}
+		}
+	}
+}
+
+// keepAlive sends keepalive requests on the connection at every interval and closes the connection
+// when the server does not answer within an interval
+func keepAlive(pool *pooledClient, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pool.done:
+			return
+		case <-ticker.C:
+		}
+
+		replied := make(chan error, 1)
+		go func() {
+			_, _, err := pool.client.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+
+		select {
+		case err := <-replied:
+			if err != nil {
+				_ = pool.client.Close()
+				return
+			}
+		case <-time.After(interval):
+			_ = pool.client.Close()
+			return
+		case <-pool.done:
+			return
+		}
+	}
+}
+
+// keepAliveInterval returns the configured keepalive interval or the default
+func (s *SSHCommandRunner) keepAliveInterval() time.Duration {
+	if s.config.KeepAliveInterval > 0 {
+		return s.config.KeepAliveInterval
+	}
+	return defaultKeepAliveInterval
+}
@@ -0,0 +1,166 @@
+package commandrunner
+
+import (
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newPoolTestRunner returns a runner connecting to the server with the max sessions
+func newPoolTestRunner(t *testing.T, server *testSSHServer, maxSessions int) *SSHCommandRunner {
+	t.Helper()
+
+	runner, err := NewSSHCommandRunner(SSHConfig{
+		Host:        server.Host,
+		Port:        server.Port,
+		User:        "test",
+		PrivateKey:  generateClientPrivateKey(t),
+		MaxSessions: maxSessions,
+	})
+	if err != nil {
+		t.Fatalf("failed to create SSH command runner: %v", err)
+	}
+	t.Cleanup(func() { runner.Close() })
+	return runner
+}
+
+func TestSSHCommandRunner_ReusesConnection(t *testing.T) {
+	server := startTestSSHServer(t)
+	defer server.Close()
+	runner := newPoolTestRunner(t, server, 0)
+
+	for i := 0; i < 5; i++ {
+		if _, err := runner.RunCommand(ExecCommand{Command: "echo Hello"}); err != nil {
+			t.Fatalf("RunCommand() failed, error = %v", err)
+		}
+	}
+
+	events, err := runner.RunCommandAsync(ExecCommand{Command: "echo Hello"})
+	if err != nil {
+		t.Fatalf("RunCommandAsync() failed, error = %v", err)
+	}
+	if _, _, err := readEvents(t, events); err != nil {
+		t.Fatalf("RunCommandAsync() error = %v", err)
+	}
+
+	if got := server.Connections(); got != 1 {
+		t.Errorf("connections = %d, want %d", got, 1)
+	}
+}
+
+func TestSSHCommandRunner_Reconnects(t *testing.T) {
+	server := startTestSSHServer(t)
+	defer server.Close()
+	runner := newPoolTestRunner(t, server, 0)
+
+	if _, err := runner.RunCommand(ExecCommand{Command: "echo Hello"}); err != nil {
+		t.Fatalf("RunCommand() failed, error = %v", err)
+	}
+
+	server.DropConnections()
+
+	result, err := runner.RunCommand(ExecCommand{Command: "echo Again"})
+	if err != nil {
+		t.Fatalf("RunCommand() after dropped connection failed, error = %v", err)
+	}
+	if got := result.Stdout.String(); got != "Again\n" {
+		t.Errorf("RunCommand() = %q, want %q", got, "Again\n")
+	}
+	if got := server.Connections(); got != 2 {
+		t.Errorf("connections = %d, want %d", got, 2)
+	}
+}
+
+func TestSSHCommandRunner_MaxSessions(t *testing.T) {
+	server := startTestSSHServer(t)
+	defer server.Close()
+	runner := newPoolTestRunner(t, server, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := runner.RunCommand(ExecCommand{Command: "sleep 0.1"}); err != nil {
+				t.Errorf("RunCommand() failed, error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := server.MaxSessions(); got != 2 {
+		t.Errorf("max concurrent sessions = %d, want %d", got, 2)
+	}
+	if got := server.Connections(); got != 1 {
+		t.Errorf("connections = %d, want %d", got, 1)
+	}
+}
+
+func TestSSHCommandRunner_MaxSessions_Timeout(t *testing.T) {
+	server := startTestSSHServer(t)
+	defer server.Close()
+	runner := newPoolTestRunner(t, server, 1)
+
+	events, err := runner.RunCommandAsync(ExecCommand{Command: "sleep 1"})
+	if err != nil {
+		t.Fatalf("RunCommandAsync() failed, error = %v", err)
+	}
+	defer readEvents(t, events)
+
+	_, err = runner.RunCommand(ExecCommand{Command: "echo Hello", Timeout: 100 * time.Millisecond})
+
+	var deadlineErr *DeadlineExceededError
+	if !errors.As(err, &deadlineErr) {
+		t.Fatalf("RunCommand() error = %v, expected a DeadlineExceededError while waiting for a session", err)
+	}
+}
+
+func TestSSHCommandRunner_SessionRejected(t *testing.T) {
+	server := startTestSSHServerWithOptions(t, testSSHServerOptions{sessionLimit: 1})
+	defer server.Close()
+	runner := newPoolTestRunner(t, server, 2)
+
+	events, err := runner.RunCommandAsync(ExecCommand{Command: "sleep 0.5"})
+	if err != nil {
+		t.Fatalf("RunCommandAsync() failed, error = %v", err)
+	}
+
+	_, err = runner.RunCommand(ExecCommand{Command: "echo Hello"})
+	var rejected *ssh.OpenChannelError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("RunCommand() error = %v, want the session refused by the server", err)
+	}
+
+	if _, _, err := readEvents(t, events); err != nil {
+		t.Errorf("running command error = %v, want it to finish on the shared connection", err)
+	}
+	if got := server.Connections(); got != 1 {
+		t.Errorf("connections = %d, want %d", got, 1)
+	}
+}
+
+func TestSSHCommandRunner_Close(t *testing.T) {
+	server := startTestSSHServer(t)
+	defer server.Close()
+	runner := newPoolTestRunner(t, server, 0)
+
+	if _, err := runner.RunCommand(ExecCommand{Command: "echo Hello"}); err != nil {
+		t.Fatalf("RunCommand() failed, error = %v", err)
+	}
+
+	if err := runner.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := runner.Close(); err != nil {
+		t.Errorf("second Close() error = %v, want nil", err)
+	}
+
+	if _, err := runner.RunCommand(ExecCommand{Command: "echo Hello"}); !errors.Is(err, ErrRunnerClosed) {
+		t.Errorf("RunCommand() after Close() error = %v, want %v", err, ErrRunnerClosed)
+	}
+	if _, err := runner.RunCommandAsync(ExecCommand{Command: "echo Hello"}); !errors.Is(err, ErrRunnerClosed) {
+		t.Errorf("RunCommandAsync() after Close() error = %v, want %v", err, ErrRunnerClosed)
+	}
+}
@@ -1,20 +1,23 @@
 package commandrunner
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/ssh"
 	"strings"
 	"testing"
+	"time"
 )
 
-// startSSHRunner starts a test SSH server on 127.0.0.1:9090 that understand echo command
+// startSSHRunner starts a test SSH server that understand echo command
 // and returns a new SSHCommandRunner configured to connect to the server
 func startSSHRunner(t *testing.T) (*SSHCommandRunner, func()) {
-	stopServer := startTestSSHServer(t)
+	server := startTestSSHServer(t)
 
 	config := SSHConfig{
-		Host:       "127.0.0.1",
-		Port:       9090,
+		Host:       server.Host,
+		Port:       server.Port,
 		User:       "test",
 		PrivateKey: generateClientPrivateKey(t),
 	}
@@ -23,18 +26,19 @@ func startSSHRunner(t *testing.T) (*SSHCommandRunner, func()) {
 	if err != nil {
 		t.Fatalf("failed to create SSH command runner: %v", err)
 	}
-	return runner, stopServer
+	return runner, server.Close
 }
 
 func TestSSHCommandRunner_RunCommand(t *testing.T) {
 	runner, stopServer := startSSHRunner(t)
 	defer stopServer()
 
-	scanner, err := runner.RunCommand(ExecCommand{Command: "echo Hello, World!"})
+	result, err := runner.RunCommand(ExecCommand{Command: "echo Hello, World!"})
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
+	scanner := result.Scanner()
 	for scanner.Scan() {
 		if scanner.Text() != "Hello, World!" {
 			t.Errorf("RunCommand() = %q expected %q", scanner.Text(), "Hello, World!")
@@ -52,16 +56,54 @@ func TestSSHCommandRunner_RunCommand_UnknownCommand(t *testing.T) {
 	}
 }
 
+func TestSSHCommandRunner_RunCommand_Result(t *testing.T) {
+	runner, stopServer := startSSHRunner(t)
+	defer stopServer()
+
+	result, err := runner.RunCommand(ExecCommand{Command: "fail 100 could not get lock"})
+
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("RunCommand() error = %v, expected an ExitError", err)
+	}
+	if result.ExitCode != 100 {
+		t.Errorf("RunCommand() exit code = %d, want %d", result.ExitCode, 100)
+	}
+	if got := result.Stderr.String(); got != "could not get lock\n" {
+		t.Errorf("RunCommand() stderr = %q, want %q", got, "could not get lock\n")
+	}
+	if result.Stdout.Len() != 0 {
+		t.Errorf("RunCommand() stdout = %q, expected to be empty", result.Stdout.String())
+	}
+	if result.Host != "127.0.0.1" {
+		t.Errorf("RunCommand() host = %q, want %q", result.Host, "127.0.0.1")
+	}
+}
+
+func TestSSHCommandRunner_RunCommand_DialError(t *testing.T) {
+	runner, err := NewSSHCommandRunner(SSHConfig{Host: "127.0.0.1", Port: 1, User: "test", PrivateKey: generateClientPrivateKey(t)})
+	if err != nil {
+		t.Fatalf("failed to create SSH command runner: %v", err)
+	}
+
+	_, err = runner.RunCommand(ExecCommand{Command: "echo Hello"})
+	var exitErr *ExitError
+	if err == nil || errors.As(err, &exitErr) {
+		t.Fatalf("RunCommand() error = %v, expected a connection error", err)
+	}
+}
+
 func TestSSHCommandRunner_RunCommand_LargeOutput(t *testing.T) {
 	runner, stopServer := startSSHRunner(t)
 	defer stopServer()
 
 	largeText := strings.Repeat("A", 10000)
-	scanner, err := runner.RunCommand(ExecCommand{Command: fmt.Sprintf("echo %s", largeText)})
+	result, err := runner.RunCommand(ExecCommand{Command: fmt.Sprintf("echo %s", largeText)})
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
+	scanner := result.Scanner()
 	scanner.Scan()
 	output := scanner.Text()
 	if output != largeText {
@@ -74,14 +116,14 @@ func TestSSHCommandRunner_RunCommandAsync(t *testing.T) {
 	defer stopServer()
 
 	t.Run("EchoCommand", func(t *testing.T) {
-		output, outputError, err := runner.RunCommandAsync(ExecCommand{Command: "echo Hello, World!"})
+		events, err := runner.RunCommandAsync(ExecCommand{Command: "echo Hello, World!"})
 		if err != nil {
 			t.Fatalf("RunCommandAsync() failed, error = %v", err)
 		}
 
-		got, err := readFromChannels(t, output, outputError)
-		if err != nil {
-			t.Fatalf("RunCommandAsync() failed, error = %v", err)
+		got, exitCode, err := readEvents(t, events)
+		if err != nil || exitCode != 0 {
+			t.Fatalf("RunCommandAsync() failed, exit code = %d, error = %v", exitCode, err)
 		}
 
 		expected := "Hello, World!"
@@ -91,14 +133,17 @@ func TestSSHCommandRunner_RunCommandAsync(t *testing.T) {
 	})
 
 	t.Run("CommandWithError", func(t *testing.T) {
-		output, outputError, err := runner.RunCommandAsync(ExecCommand{Command: "invalid_command"})
+		events, err := runner.RunCommandAsync(ExecCommand{Command: "invalid_command"})
 		if err != nil {
 			t.Fatalf("RunCommandAsync() failed, error = %v", err)
 		}
 
-		got, err := readFromChannels(t, output, outputError)
-		if err == nil {
-			t.Fatalf("RunCommandAsync() expected to fail, error is nil")
+		got, exitCode, err := readEvents(t, events)
+		if err != nil {
+			t.Fatalf("RunCommandAsync() failed, error = %v", err)
+		}
+		if exitCode == 0 {
+			t.Fatalf("RunCommandAsync() expected to exit with a non-zero code")
 		}
 
 		if len(got) > 0 && !strings.Contains(got, "unknown command") {
@@ -118,13 +163,13 @@ func TestSSHCommandRunner_RunCommand_Concurrent(t *testing.T) {
 
 	for _, cmd := range commands {
 		go func(command string) {
-			scanner, err := runner.RunCommand(ExecCommand{Command: command})
+			result, err := runner.RunCommand(ExecCommand{Command: command})
 			if err != nil {
 				errors <- err
 				return
 			}
 
-			if scanner.Scan() {
+			if scanner := result.Scanner(); scanner.Scan() {
 				results <- scanner.Text()
 			} else {
 				results <- ""
@@ -148,35 +193,47 @@ func TestApplyCommandSettings(t *testing.T) {
 	tests := []struct {
 		name        string
 		ec          ExecCommand
-		askPassPath string
+		elevation   *sudoElevation
 		expectedCmd string
 		expectStdin bool
 	}{
 		{
 			name:        "Elevated command with askpass",
 			ec:          ExecCommand{Command: "ls", Elevated: true},
-			askPassPath: "/path/to/askpass",
+			elevation:   &sudoElevation{askPassPath: "/path/to/askpass"},
 			expectedCmd: "SUDO_ASKPASS=/path/to/askpass sudo -A ls",
 			expectStdin: false,
 		},
 		{
-			name:        "Elevated command with standard Input",
+			name:        "Elevated command without password",
+			ec:          ExecCommand{Command: "ls", Elevated: true},
+			elevation:   &sudoElevation{},
+			expectedCmd: "sudo -n ls",
+			expectStdin: false,
+		},
+		{
+			name:        "Elevated command without password with Input",
 			ec:          ExecCommand{Command: "ls", Elevated: true},
-			askPassPath: "",
-			expectedCmd: "sudo -S ls",
+			elevation:   &sudoElevation{input: strings.NewReader("Input")},
+			expectedCmd: "sudo -n ls",
+			expectStdin: true,
+		},
+		{
+			name:        "Elevated argv command",
+			ec:          ExecCommand{Args: []string{"apt", "install", "libc6; reboot"}, Elevated: true},
+			elevation:   &sudoElevation{},
+			expectedCmd: "sudo -n apt install 'libc6; reboot'",
 			expectStdin: false,
 		},
 		{
 			name:        "Non-elevated command",
 			ec:          ExecCommand{Command: "ls", Elevated: false},
-			askPassPath: "/path/to/askpass",
 			expectedCmd: "ls",
 			expectStdin: false,
 		},
 		{
 			name:        "Command with Input",
 			ec:          ExecCommand{Command: "ls", Elevated: false, Input: strings.NewReader("Input")},
-			askPassPath: "/path/to/askpass",
 			expectedCmd: "ls",
 			expectStdin: true,
 		},
@@ -185,7 +242,9 @@ func TestApplyCommandSettings(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			session := &ssh.Session{}
-			applyCommandSettings(&tt.ec, tt.askPassPath, session)
+			if err := applyCommandSettings(&tt.ec, tt.elevation, session); err != nil {
+				t.Fatalf("applyCommandSettings() error = %v", err)
+			}
 			if tt.ec.Command != tt.expectedCmd {
 				t.Errorf("expected command %s, got %s", tt.expectedCmd, tt.ec.Command)
 			}
@@ -204,3 +263,66 @@ func contains(slice []string, item string) bool {
 	}
 	return false
 }
+
+func TestSSHCommandRunner_RunCommand_Timeout(t *testing.T) {
+	runner, stopServer := startSSHRunner(t)
+	defer stopServer()
+
+	start := time.Now()
+	_, err := runner.RunCommand(ExecCommand{Command: "sleep 5", Timeout: 200 * time.Millisecond})
+
+	var deadlineErr *DeadlineExceededError
+	if !errors.As(err, &deadlineErr) {
+		t.Fatalf("RunCommand() error = %v, expected a DeadlineExceededError", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("RunCommand() returned after %s, expected the session to be closed", elapsed)
+	}
+}
+
+func TestSSHCommandRunner_RunCommandAsyncContext_Cancel(t *testing.T) {
+	runner, stopServer := startSSHRunner(t)
+	defer stopServer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := runner.RunCommandAsyncContext(ctx, ExecCommand{Command: "sleep 5"})
+	if err != nil {
+		t.Fatalf("RunCommandAsyncContext() failed, error = %v", err)
+	}
+
+	start := time.Now()
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	_, _, err = readEvents(t, events)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunCommandAsyncContext() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("RunCommandAsyncContext() finished after %s, expected the session to be closed", elapsed)
+	}
+}
+
+func TestSSHCommandRunner_RunCommandAsync_Events(t *testing.T) {
+	runner, stopServer := startSSHRunner(t)
+	defer stopServer()
+
+	events, err := runner.RunCommandAsync(ExecCommand{Command: "fail 2 boom"})
+	if err != nil {
+		t.Fatalf("RunCommandAsync() failed, error = %v", err)
+	}
+
+	var got []Event
+	for event := range events {
+		got = append(got, event)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("RunCommandAsync() emitted %d events, want %d: %v", len(got), 2, got)
+	}
+	if got[0].Type != EventStderr || got[0].Line != "boom" {
+		t.Errorf("RunCommandAsync() first event = %v, want stderr line %q", got[0], "boom")
+	}
+	if got[1].Type != EventExit || got[1].ExitCode != 2 || got[1].Host != "127.0.0.1" {
+		t.Errorf("RunCommandAsync() last event = %v, want exit with code %d on %s", got[1], 2, "127.0.0.1")
+	}
+}
@@ -0,0 +1,223 @@
+package commandrunner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// ErrTransferNeedsTerminal is returned by elevated transfers on hosts where sudo only runs with a terminal,
+// the line discipline of the terminal would alter the transferred bytes
+var ErrTransferNeedsTerminal = errors.New("elevated file transfers are not supported when sudo requires a terminal")
+
+// Upload writes src to the file dst on the host. The file is streamed over SFTP, elevated uploads stream it to
+// the upload script run by sudo instead
+func (s *SSHCommandRunner) Upload(ctx context.Context, src io.Reader, dst string, opts FileOptions) error {
+	if opts.Elevated {
+		return uploadWith(ctx, s.streamCommand, src, dst, opts)
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if err := s.sftpUpload(ctx, src, dst, opts); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", dst, err)
+	}
+	return nil
+}
+
+// Download copies the file src on the host to dst. The file is streamed over SFTP, elevated downloads stream
+// the output of cat run by sudo instead
+func (s *SSHCommandRunner) Download(ctx context.Context, src string, dst io.Writer, opts FileOptions) error {
+	if opts.Elevated {
+		return downloadWith(ctx, s.streamCommand, src, dst, opts)
+	}
+	if err := s.sftpDownload(ctx, src, dst); err != nil {
+		return fmt.Errorf("failed to download %s: %w", src, err)
+	}
+	return nil
+}
+
+// sftpClient starts the SFTP subsystem on a new session, the returned function closes the client and frees
+// the session slot. The client is closed when the context is done
+func (s *SSHCommandRunner) sftpClient(ctx context.Context) (*sftp.Client, func(), error) {
+	session, release, err := s.newSession(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("failed to get stdin pipe: %w", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("failed to get stdout pipe: %w", err)
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		release()
+		return nil, nil, fmt.Errorf("failed to start sftp: %w", err)
+	}
+
+	client, err := sftp.NewClientPipe(stdout, stdin)
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("failed to start sftp: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { _ = client.Close() })
+	return client, func() {
+		stop()
+		_ = client.Close()
+		release()
+	}, nil
+}
+
+// sftpUpload writes src to a temporary file next to dst, sets its mode and ownership and renames it over dst
+func (s *SSHCommandRunner) sftpUpload(ctx context.Context, src io.Reader, dst string, opts FileOptions) error {
+	client, closeClient, err := s.sftpClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	temp, err := tempUploadPath(dst)
+	if err != nil {
+		return err
+	}
+	file, err := client.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			_ = client.Remove(temp)
+		}
+	}()
+
+	_, err = io.Copy(file, readerContext{ctx: ctx, r: src})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = client.Chmod(temp, opts.mode())
+	}
+	if ownership := opts.ownership(); err == nil && ownership != "" {
+		// SFTP only takes numeric ids, chown resolves the names on the host
+		_, err = s.RunCommandContext(ctx, ExecCommand{Args: []string{"chown", "--", ownership, temp}})
+	}
+	if err == nil {
+		err = client.PosixRename(temp, dst)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	renamed = true
+	return nil
+}
+
+// sftpDownload copies the file src to dst
+func (s *SSHCommandRunner) sftpDownload(ctx context.Context, src string, dst io.Writer) error {
+	client, closeClient, err := s.sftpClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	file, err := client.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(dst, readerContext{ctx: ctx, r: file}); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// tempUploadPath returns a random name for the temporary file of an upload in the directory of dst
+func tempUploadPath(dst string) (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return path.Join(path.Dir(dst), "."+path.Base(dst)+".chisme."+hex.EncodeToString(b)), nil
+}
+
+// streamCommand runs the command of a transfer with its stdout written to stdout as it arrives. Elevated
+// commands are refused when sudo needs a terminal
+func (s *SSHCommandRunner) streamCommand(ctx context.Context, ec ExecCommand, stdout io.Writer) error {
+	ctx, cancel := commandContext(ctx, ec)
+	defer cancel()
+
+	elevation, err := s.prepareElevation(ctx, ec)
+	if err != nil {
+		return err
+	}
+	if elevation != nil && elevation.mode.tty {
+		return fmt.Errorf("%w on %s", ErrTransferNeedsTerminal, s.config.Host)
+	}
+
+	started, err := s.startElevated(ctx, ec, elevation)
+	if err != nil {
+		return err
+	}
+	defer started.release()
+
+	stop := context.AfterFunc(ctx, func() { killSession(started.session) })
+	defer stop()
+
+	result := newCommandResult(ec.CommandLine(), s.config.Host)
+	output := newResultOutput(result, OutputLimits{MaxBytes: transferStderrLimit})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(output.stderr, started.stderr)
+	}()
+
+	_, copyErr := io.Copy(stdout, started.stdout)
+	if copyErr != nil {
+		killSession(started.session)
+		_, _ = io.Copy(io.Discard, started.stdout)
+	}
+	wg.Wait()
+
+	err = started.session.Wait()
+	result.FinishedAt = time.Now()
+	_ = output.close()
+
+	var exitErr *ssh.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return contextError(ctx, ec)
+	case elevation.result(err) != nil:
+		return fmt.Errorf("failed to run elevated command on %s: %w", s.config.Host, elevation.result(err))
+	case copyErr != nil:
+		return copyErr
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+		return &ExitError{Result: result}
+	case err != nil:
+		return fmt.Errorf("failed to run command: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,483 @@
+package commandrunner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxProxyJumpDepth limits how deep ProxyJump hosts may refer to other ProxyJump hosts
+const maxProxyJumpDepth = 8
+
+// defaultIdentityFiles are the keys ssh tries when no IdentityFile is configured for a host
+var defaultIdentityFiles = []string{"~/.ssh/id_ed25519", "~/.ssh/id_ecdsa", "~/.ssh/id_rsa"}
+
+// SSHConfigFile is a parsed OpenSSH client config file, like ~/.ssh/config. Only Host blocks are supported,
+// Match blocks are skipped
+type SSHConfigFile struct {
+	entries []sshConfigEntry
+}
+
+// sshConfigEntry is a keyword of the config file and the Host patterns it applies to, nil patterns apply to every host
+type sshConfigEntry struct {
+	patterns []string
+	keyword  string
+	args     []string
+}
+
+// LoadSSHConfig resolves the host alias with ~/.ssh/config, a missing file resolves every alias to the ssh defaults
+func LoadSSHConfig(alias string) (SSHConfig, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return SSHConfig{}, fmt.Errorf("failed to locate ssh config: %w", err)
+	}
+
+	file, err := ParseSSHConfigFile(filepath.Join(home, ".ssh", "config"))
+	if errors.Is(err, fs.ErrNotExist) {
+		file = &SSHConfigFile{}
+	} else if err != nil {
+		return SSHConfig{}, err
+	}
+
+	return file.Resolve(alias)
+}
+
+// ParseSSHConfigFile parses the ssh config file at path, relative Include paths are resolved against its directory
+func ParseSSHConfigFile(path string) (*SSHConfigFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ssh config: %w", err)
+	}
+	defer f.Close()
+
+	config := &SSHConfigFile{}
+	if err := config.parse(f, filepath.Dir(path), nil, 0); err != nil {
+		return nil, fmt.Errorf("failed to parse ssh config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// ParseSSHConfig parses an ssh config, relative Include paths are resolved against ~/.ssh
+func ParseSSHConfig(r io.Reader) (*SSHConfigFile, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate ssh config: %w", err)
+	}
+
+	config := &SSHConfigFile{}
+	if err := config.parse(r, filepath.Join(home, ".ssh"), nil, 0); err != nil {
+		return nil, fmt.Errorf("failed to parse ssh config: %w", err)
+	}
+	return config, nil
+}
+
+// parse appends the entries of r, starting with the Host patterns of the including file like OpenSSH does
+func (c *SSHConfigFile) parse(r io.Reader, dir string, patterns []string, depth int) error {
+	if depth > 16 {
+		return fmt.Errorf("too many nested includes")
+	}
+
+	skip := false
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		keyword, args, err := parseSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			if len(args) == 0 {
+				return fmt.Errorf("line %d: Host requires at least one pattern", lineNumber)
+			}
+			patterns, skip = args, false
+		case "match":
+			skip = true
+		case "include":
+			if skip {
+				continue
+			}
+			for _, arg := range args {
+				if err := c.include(arg, dir, patterns, depth); err != nil {
+					return fmt.Errorf("line %d: %w", lineNumber, err)
+				}
+			}
+		default:
+			if !skip {
+				c.entries = append(c.entries, sshConfigEntry{patterns: patterns, keyword: keyword, args: args})
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// include parses the files matching the glob pattern
+func (c *SSHConfigFile) include(pattern, dir string, patterns []string, depth int) error {
+	pattern = expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+	}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open included ssh config: %w", err)
+		}
+		err = c.parse(f, dir, patterns, depth+1)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// parseSSHConfigLine splits a line into its lower-cased keyword and its arguments, the keyword is empty for
+// blank lines and comments. The keyword may be separated from the arguments by whitespace or an equal sign
+func parseSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	keyword := strings.ToLower(line[:end])
+
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+
+	args, err := splitSSHConfigArgs(rest)
+	if err != nil {
+		return "", nil, err
+	}
+	return keyword, args, nil
+}
+
+// splitSSHConfigArgs splits the arguments at whitespace, double quoted arguments may contain whitespace
+func splitSSHConfigArgs(s string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg, quoted := false, false
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case !quoted && r == '#' && !inArg:
+			return args, nil
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// Get returns the first value of the keyword for the host, as ssh uses the first value it obtains
+func (c *SSHConfigFile) Get(host, keyword string) string {
+	return strings.Join(c.firstArgs(host, keyword), " ")
+}
+
+// firstArgs returns the arguments of the first entry of the keyword for the host
+func (c *SSHConfigFile) firstArgs(host, keyword string) []string {
+	keyword = strings.ToLower(keyword)
+	for _, entry := range c.entries {
+		if entry.keyword == keyword && matchHostPatterns(entry.patterns, host) {
+			return entry.args
+		}
+	}
+	return nil
+}
+
+// GetAll returns every value of the keyword for the host in order, for keywords like IdentityFile that accumulate
+func (c *SSHConfigFile) GetAll(host, keyword string) []string {
+	keyword = strings.ToLower(keyword)
+
+	var values []string
+	for _, entry := range c.entries {
+		if entry.keyword == keyword && matchHostPatterns(entry.patterns, host) {
+			values = append(values, strings.Join(entry.args, " "))
+		}
+	}
+	return values
+}
+
+// matchHostPatterns reports whether the host matches one of the patterns and none of the negated ones
+func matchHostPatterns(patterns []string, host string) bool {
+	if patterns == nil {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	matched := false
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if matchWildcard(negated, host) {
+				return false
+			}
+			continue
+		}
+		if matchWildcard(pattern, host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchWildcard matches s against a pattern where * matches any sequence and ? any single character
+func matchWildcard(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := 0; i <= len(s); i++ {
+				if matchWildcard(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// Resolve builds the SSHConfig of the host alias from HostName, Port, User, IdentityFile, ProxyJump,
+// UserKnownHostsFile and ServerAliveInterval. Identity files that do not exist are skipped, those that need a
+// passphrase are skipped with a warning, the ssh-agent at SSH_AUTH_SOCK or IdentityAgent is tried after them
+func (c *SSHConfigFile) Resolve(alias string) (SSHConfig, error) {
+	return c.resolve(alias, "", 0, 0)
+}
+
+// resolve resolves the alias, a non-empty user and non-zero port given by a ProxyJump spec take precedence
+func (c *SSHConfigFile) resolve(alias, userName string, port int, depth int) (SSHConfig, error) {
+	if depth > maxProxyJumpDepth {
+		return SSHConfig{}, fmt.Errorf("too many nested ProxyJump hosts for %s", alias)
+	}
+
+	hostName := alias
+	if value := c.Get(alias, "HostName"); value != "" {
+		hostName = strings.ReplaceAll(value, "%h", alias)
+	}
+
+	if port == 0 {
+		port = 22
+		if value := c.Get(alias, "Port"); value != "" {
+			p, err := strconv.Atoi(value)
+			if err != nil {
+				return SSHConfig{}, fmt.Errorf("invalid Port for %s: %w", alias, err)
+			}
+			port = p
+		}
+	}
+
+	if userName == "" {
+		userName = c.Get(alias, "User")
+	}
+	if userName == "" {
+		userName = localUserName()
+	}
+
+	tokens := strings.NewReplacer("%%", "%", "%h", hostName, "%r", userName, "%u", localUserName(), "%d", homeDir())
+
+	config := SSHConfig{
+		Host:    hostName,
+		Port:    port,
+		User:    userName,
+		HostKey: HostKeyConfig{Policy: HostKeyKnownHosts},
+	}
+
+	authMethods, err := c.identityAuthMethods(alias, tokens)
+	if err != nil {
+		return SSHConfig{}, err
+	}
+	config.AuthMethods = authMethods
+
+	if files := c.firstArgs(alias, "UserKnownHostsFile"); len(files) > 0 {
+		config.HostKey.KnownHostsPath = expandHome(tokens.Replace(files[0]))
+		if files[0] == "none" {
+			config.HostKey = noKnownHostsConfig(c.Get(alias, "StrictHostKeyChecking"))
+		}
+	}
+
+	if value := c.Get(alias, "ServerAliveInterval"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return SSHConfig{}, fmt.Errorf("invalid ServerAliveInterval for %s: %w", alias, err)
+		}
+		config.KeepAliveInterval = time.Duration(seconds) * time.Second
+	}
+
+	if value := c.Get(alias, "ProxyJump"); value != "" && value != "none" {
+		for _, spec := range strings.Split(value, ",") {
+			jumpAlias, jumpUser, jumpPort, err := parseProxyJumpSpec(spec)
+			if err != nil {
+				return SSHConfig{}, fmt.Errorf("invalid ProxyJump for %s: %w", alias, err)
+			}
+
+			jumpHost, err := c.resolve(jumpAlias, jumpUser, jumpPort, depth+1)
+			if err != nil {
+				return SSHConfig{}, err
+			}
+
+			config.JumpHosts = append(config.JumpHosts, jumpHost.JumpHosts...)
+			jumpHost.JumpHosts = nil
+			config.JumpHosts = append(config.JumpHosts, jumpHost)
+		}
+	}
+
+	return config, nil
+}
+
+// noKnownHostsConfig returns the host key verification of a host with UserKnownHostsFile none. Like ssh, no key is
+// known so every key is rejected, unless StrictHostKeyChecking no or accept-new lets unknown keys through
+func noKnownHostsConfig(strictHostKeyChecking string) HostKeyConfig {
+	switch strings.ToLower(strictHostKeyChecking) {
+	case "no", "off", "accept-new":
+		return HostKeyConfig{Policy: HostKeyInsecureIgnore}
+	}
+	return HostKeyConfig{Policy: HostKeyKnownHosts, KnownHostsPath: os.DevNull}
+}
+
+// identityAuthMethods returns the authentication methods of the identity files of the host, followed by the agent.
+// The certificate next to an identity file, named like id_ed25519-cert.pub, is presented with it
+func (c *SSHConfigFile) identityAuthMethods(alias string, tokens *strings.Replacer) ([]AuthMethod, error) {
+	paths := c.GetAll(alias, "IdentityFile")
+	if len(paths) == 0 {
+		paths = defaultIdentityFiles
+	}
+
+	var methods []AuthMethod
+	for _, path := range paths {
+		path = expandHome(tokens.Replace(path))
+
+		privateKey, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity file: %w", err)
+		}
+
+		if _, err := ssh.ParseRawPrivateKey(privateKey); err != nil {
+			var passphraseErr *ssh.PassphraseMissingError
+			if errors.As(err, &passphraseErr) {
+				slog.Warn("skipping identity file protected by a passphrase", "host", alias, "path", path)
+				continue
+			}
+			return nil, fmt.Errorf("failed to parse identity file %s: %w", path, err)
+		}
+
+		if certificate, err := os.ReadFile(path + "-cert.pub"); err == nil {
+			methods = append(methods, CertificateAuth(privateKey, "", certificate))
+			continue
+		}
+		methods = append(methods, PrivateKeyAuth(privateKey, ""))
+	}
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if value := c.Get(alias, "IdentityAgent"); value != "" {
+		socket = value
+		if value == "SSH_AUTH_SOCK" {
+			socket = os.Getenv("SSH_AUTH_SOCK")
+		}
+	}
+	if socket != "" && socket != "none" {
+		methods = append(methods, AgentAuth(expandHome(tokens.Replace(socket))))
+	}
+
+	return methods, nil
+}
+
+// parseProxyJumpSpec parses a ProxyJump host given as [user@]host[:port] or ssh://[user@]host[:port]
+func parseProxyJumpSpec(spec string) (string, string, int, error) {
+	spec = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+	if spec == "" {
+		return "", "", 0, fmt.Errorf("empty jump host")
+	}
+
+	var userName string
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		userName, spec = spec[:i], spec[i+1:]
+	}
+
+	host, port := spec, 0
+	if i := strings.LastIndex(spec, ":"); i >= 0 && !strings.HasSuffix(spec, "]") {
+		p, err := strconv.Atoi(spec[i+1:])
+		if err != nil {
+			return "", "", 0, fmt.Errorf("invalid port in %q: %w", spec, err)
+		}
+		host, port = spec[:i], p
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	return host, userName, port, nil
+}
+
+// expandHome replaces a leading ~ with the home directory
+func expandHome(path string) string {
+	if path == "~" {
+		return homeDir()
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(homeDir(), rest)
+	}
+	return path
+}
+
+// homeDir returns the home directory of the current user, or an empty string when it is unknown
+func homeDir() string {
+	home, _ := os.UserHomeDir()
+	return home
+}
+
+// localUserName returns the name of the current user, which ssh uses when no User is configured
+func localUserName() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,421 @@
+package commandrunner
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupSSHHome points HOME to a temporary directory with an empty .ssh directory and disables the agent
+func setupSSHHome(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if err := os.Mkdir(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatalf("failed to create .ssh directory: %v", err)
+	}
+	return home
+}
+
+// writeSSHFile writes content to the file relative to the .ssh directory of home
+func writeSSHFile(t *testing.T, home, name string, content []byte) string {
+	t.Helper()
+
+	path := filepath.Join(home, ".ssh", name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+const testSSHConfig = `
+# global options apply to every host
+ServerAliveInterval 15
+
+Host web
+    HostName 10.0.0.10
+    User deploy
+    Port 2222
+    IdentityFile ~/.ssh/web_key
+
+Host db-* !db-legacy
+    HostName %h.internal.example.com
+    User=postgres
+    ProxyJump bastion
+
+Host bastion
+    HostName bastion.example.com
+    User jump
+    UserKnownHostsFile ~/.ssh/known_hosts_bastion
+
+Host "quoted host"
+    HostName quoted.example.com
+
+Match host web
+    User ignored
+
+Host *
+    User fallback
+    Port 22
+    IdentityFile ~/.ssh/shared_key
+`
+
+func TestSSHConfigFile_Get(t *testing.T) {
+	setupSSHHome(t)
+
+	config, err := ParseSSHConfig(strings.NewReader(testSSHConfig))
+	if err != nil {
+		t.Fatalf("ParseSSHConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		host    string
+		keyword string
+		want    string
+	}{
+		{"web", "HostName", "10.0.0.10"},
+		{"web", "user", "deploy"},
+		{"web", "Port", "2222"},
+		{"web", "ServerAliveInterval", "15"},
+		{"db-main", "User", "postgres"},
+		{"db-main", "HostName", "%h.internal.example.com"},
+		{"db-legacy", "User", "fallback"},
+		{"db-legacy", "HostName", ""},
+		{"quoted host", "HostName", "quoted.example.com"},
+		{"other", "User", "fallback"},
+		{"other", "ProxyJump", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host+"/"+tt.keyword, func(t *testing.T) {
+			if got := config.Get(tt.host, tt.keyword); got != tt.want {
+				t.Errorf("Get(%q, %q) = %q, want %q", tt.host, tt.keyword, got, tt.want)
+			}
+		})
+	}
+
+	wantIdentities := []string{"~/.ssh/web_key", "~/.ssh/shared_key"}
+	if got := config.GetAll("web", "IdentityFile"); !reflect.DeepEqual(got, wantIdentities) {
+		t.Errorf("GetAll(web, IdentityFile) = %v, want %v", got, wantIdentities)
+	}
+}
+
+func TestSSHConfigFile_Resolve(t *testing.T) {
+	home := setupSSHHome(t)
+	webKey := generateClientPrivateKey(t)
+	sharedKey := generateClientPrivateKey(t)
+	writeSSHFile(t, home, "web_key", webKey)
+	writeSSHFile(t, home, "shared_key", sharedKey)
+
+	config, err := ParseSSHConfig(strings.NewReader(testSSHConfig))
+	if err != nil {
+		t.Fatalf("ParseSSHConfig() error = %v", err)
+	}
+
+	web, err := config.Resolve("web")
+	if err != nil {
+		t.Fatalf("Resolve(web) error = %v", err)
+	}
+	if web.Host != "10.0.0.10" || web.Port != 2222 || web.User != "deploy" {
+		t.Errorf("Resolve(web) = %s@%s:%d, want deploy@10.0.0.10:2222", web.User, web.Host, web.Port)
+	}
+	if web.KeepAliveInterval != 15*time.Second {
+		t.Errorf("Resolve(web) keepalive = %s, want %s", web.KeepAliveInterval, 15*time.Second)
+	}
+	if len(web.AuthMethods) != 2 || string(web.AuthMethods[0].PrivateKey) != string(webKey) || string(web.AuthMethods[1].PrivateKey) != string(sharedKey) {
+		t.Errorf("Resolve(web) auth methods = %v, want the web key followed by the shared key", web.AuthMethods)
+	}
+	if web.HostKey.Policy != HostKeyKnownHosts || web.HostKey.KnownHostsPath != "" {
+		t.Errorf("Resolve(web) host key = %+v, want the default known_hosts", web.HostKey)
+	}
+
+	db, err := config.Resolve("db-main")
+	if err != nil {
+		t.Fatalf("Resolve(db-main) error = %v", err)
+	}
+	if db.Host != "db-main.internal.example.com" || db.User != "postgres" || db.Port != 22 {
+		t.Errorf("Resolve(db-main) = %s@%s:%d, want postgres@db-main.internal.example.com:22", db.User, db.Host, db.Port)
+	}
+	if len(db.JumpHosts) != 1 {
+		t.Fatalf("Resolve(db-main) jump hosts = %d, want 1", len(db.JumpHosts))
+	}
+	bastion := db.JumpHosts[0]
+	if bastion.Host != "bastion.example.com" || bastion.User != "jump" {
+		t.Errorf("Resolve(db-main) jump host = %s@%s, want jump@bastion.example.com", bastion.User, bastion.Host)
+	}
+	if want := filepath.Join(home, ".ssh", "known_hosts_bastion"); bastion.HostKey.KnownHostsPath != want {
+		t.Errorf("Resolve(db-main) jump host known_hosts = %q, want %q", bastion.HostKey.KnownHostsPath, want)
+	}
+}
+
+func TestSSHConfigFile_Resolve_ProxyJumpChain(t *testing.T) {
+	setupSSHHome(t)
+
+	config, err := ParseSSHConfig(strings.NewReader(`
+Host target
+    ProxyJump admin@second:2200
+Host second
+    ProxyJump first
+Host first
+    HostName first.example.com
+Host loop
+    ProxyJump loop
+Host *
+    User u
+`))
+	if err != nil {
+		t.Fatalf("ParseSSHConfig() error = %v", err)
+	}
+
+	target, err := config.Resolve("target")
+	if err != nil {
+		t.Fatalf("Resolve(target) error = %v", err)
+	}
+
+	var hops []string
+	for _, jumpHost := range target.JumpHosts {
+		hops = append(hops, jumpHost.User+"@"+sshAddress(&jumpHost))
+	}
+	want := []string{"u@first.example.com:22", "admin@second:2200"}
+	if !reflect.DeepEqual(hops, want) {
+		t.Errorf("Resolve(target) jump hosts = %v, want %v", hops, want)
+	}
+
+	if _, err := config.Resolve("loop"); err == nil {
+		t.Errorf("Resolve(loop) expected an error for the ProxyJump loop")
+	}
+}
+
+func TestSSHConfigFile_Resolve_Identities(t *testing.T) {
+	home := setupSSHHome(t)
+
+	privateKey, _, _ := generateEd25519ClientKey(t)
+	certificate := []byte("ssh-ed25519-cert-v01@openssh.com AAAA")
+	writeSSHFile(t, home, "id_ed25519", privateKey)
+	writeSSHFile(t, home, "id_ed25519-cert.pub", certificate)
+	t.Setenv("SSH_AUTH_SOCK", "/tmp/agent.sock")
+
+	config, err := ParseSSHConfig(strings.NewReader("Host noagent\n    IdentityAgent none\n"))
+	if err != nil {
+		t.Fatalf("ParseSSHConfig() error = %v", err)
+	}
+
+	resolved, err := config.Resolve("host")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(resolved.AuthMethods) != 2 {
+		t.Fatalf("Resolve() auth methods = %v, want the default key with its certificate and the agent", resolved.AuthMethods)
+	}
+	if resolved.AuthMethods[0].Type != AuthCertificate || string(resolved.AuthMethods[0].Certificate) != string(certificate) {
+		t.Errorf("Resolve() first auth method = %v, want the certificate", resolved.AuthMethods[0].Type)
+	}
+	if resolved.AuthMethods[1].Type != AuthAgent || resolved.AuthMethods[1].AgentSocket != "/tmp/agent.sock" {
+		t.Errorf("Resolve() second auth method = %+v, want the agent", resolved.AuthMethods[1])
+	}
+
+	noAgent, err := config.Resolve("noagent")
+	if err != nil {
+		t.Fatalf("Resolve(noagent) error = %v", err)
+	}
+	if len(noAgent.AuthMethods) != 1 {
+		t.Errorf("Resolve(noagent) auth methods = %d, want 1", len(noAgent.AuthMethods))
+	}
+}
+
+func TestSSHConfigFile_Resolve_NoKnownHostsFile(t *testing.T) {
+	setupSSHHome(t)
+
+	config, err := ParseSSHConfig(strings.NewReader(`
+Host strict
+    UserKnownHostsFile none
+Host lax
+    UserKnownHostsFile none
+    StrictHostKeyChecking no
+`))
+	if err != nil {
+		t.Fatalf("ParseSSHConfig() error = %v", err)
+	}
+
+	strict, err := config.Resolve("strict")
+	if err != nil {
+		t.Fatalf("Resolve(strict) error = %v", err)
+	}
+	if strict.HostKey.Policy != HostKeyKnownHosts || strict.HostKey.KnownHostsPath != os.DevNull {
+		t.Errorf("Resolve(strict) host key = %+v, want no known host", strict.HostKey)
+	}
+	callback, err := hostKeyCallback(strict.HostKey)
+	if err != nil {
+		t.Fatalf("hostKeyCallback() error = %v", err)
+	}
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 22}
+	if err := callback("strict:22", remote, generateHostKey(t).PublicKey()); !errors.Is(err, ErrUnknownHostKey) {
+		t.Errorf("host key callback error = %v, want %v", err, ErrUnknownHostKey)
+	}
+
+	lax, err := config.Resolve("lax")
+	if err != nil {
+		t.Fatalf("Resolve(lax) error = %v", err)
+	}
+	if lax.HostKey.Policy != HostKeyInsecureIgnore {
+		t.Errorf("Resolve(lax) host key = %+v, want any key accepted", lax.HostKey)
+	}
+}
+
+func TestSSHConfigFile_Resolve_PassphraseIdentity(t *testing.T) {
+	home := setupSSHHome(t)
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(privateKey, "", []byte("secret"))
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	writeSSHFile(t, home, "id_ed25519", pem.EncodeToMemory(block))
+
+	var logged bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logged, nil)))
+
+	config, err := ParseSSHConfig(strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("ParseSSHConfig() error = %v", err)
+	}
+	resolved, err := config.Resolve("host")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(resolved.AuthMethods) != 0 {
+		t.Errorf("Resolve() auth methods = %v, want the key protected by a passphrase skipped", resolved.AuthMethods)
+	}
+	if !strings.Contains(logged.String(), "id_ed25519") {
+		t.Errorf("log = %q, want a warning naming the skipped identity file", logged.String())
+	}
+}
+
+func TestParseSSHConfigFile_Include(t *testing.T) {
+	home := setupSSHHome(t)
+	writeSSHFile(t, home, "config.d/work", []byte("Host work\n    HostName work.example.com\n"))
+	path := writeSSHFile(t, home, "config", []byte("Include config.d/*\nHost *\n    User me\n"))
+
+	config, err := ParseSSHConfigFile(path)
+	if err != nil {
+		t.Fatalf("ParseSSHConfigFile() error = %v", err)
+	}
+
+	if got := config.Get("work", "HostName"); got != "work.example.com" {
+		t.Errorf("Get(work, HostName) = %q, want %q", got, "work.example.com")
+	}
+	if got := config.Get("work", "User"); got != "me" {
+		t.Errorf("Get(work, User) = %q, want %q", got, "me")
+	}
+}
+
+func TestParseSSHConfig_Errors(t *testing.T) {
+	setupSSHHome(t)
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"host without pattern", "Host\n"},
+		{"unterminated quote", "Host a\n    HostName \"a.example.com\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSSHConfig(strings.NewReader(tt.config)); err == nil {
+				t.Errorf("ParseSSHConfig() expected an error")
+			}
+		})
+	}
+}
+
+func TestMatchHostPatterns(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		host     string
+		want     bool
+	}{
+		{[]string{"*"}, "anything", true},
+		{[]string{"web-?"}, "web-1", true},
+		{[]string{"web-?"}, "web-10", false},
+		{[]string{"*.example.com"}, "a.b.example.com", true},
+		{[]string{"*.example.com", "!legacy.example.com"}, "legacy.example.com", false},
+		{[]string{"!legacy"}, "other", false},
+		{[]string{"WEB"}, "web", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.patterns, " ")+"/"+tt.host, func(t *testing.T) {
+			if got := matchHostPatterns(tt.patterns, tt.host); got != tt.want {
+				t.Errorf("matchHostPatterns(%v, %q) = %v, want %v", tt.patterns, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSSHConfig_RunCommand(t *testing.T) {
+	home := setupSSHHome(t)
+	writeSSHFile(t, home, "id_rsa", generateClientPrivateKey(t))
+
+	bastion := startTestSSHServer(t)
+	defer bastion.Close()
+	target := startTestSSHServer(t)
+	defer target.Close()
+
+	writeSSHFile(t, home, "config", []byte(strings.Join([]string{
+		"Host target",
+		"    HostName " + target.Host,
+		"    Port " + strconv.Itoa(target.Port),
+		"    ProxyJump bastion",
+		"Host bastion",
+		"    HostName " + bastion.Host,
+		"    Port " + strconv.Itoa(bastion.Port),
+		"Host *",
+		"    User test",
+	}, "\n")))
+
+	config, err := LoadSSHConfig("target")
+	if err != nil {
+		t.Fatalf("LoadSSHConfig() error = %v", err)
+	}
+	config.HostKey = HostKeyConfig{}
+	config.JumpHosts[0].HostKey = HostKeyConfig{}
+
+	runner, err := NewSSHCommandRunner(config)
+	if err != nil {
+		t.Fatalf("NewSSHCommandRunner() error = %v", err)
+	}
+	defer runner.Close()
+
+	result, err := runner.RunCommand(ExecCommand{Command: "echo Hello"})
+	if err != nil {
+		t.Fatalf("RunCommand() error = %v", err)
+	}
+	if got := result.Stdout.String(); got != "Hello\n" {
+		t.Errorf("RunCommand() = %q, want %q", got, "Hello\n")
+	}
+	if bastion.Connections() != 1 || target.Connections() != 1 {
+		t.Errorf("connections = %d to the bastion and %d to the target, want 1 each", bastion.Connections(), target.Connections())
+	}
+}
@@ -0,0 +1,404 @@
+package commandrunner
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"strings"
+	"sync"
+)
+
+// SecretProvider provides the password sudo asks for on a host
+type SecretProvider interface {
+	Secret(ctx context.Context, host string) (string, error)
+}
+
+// SecretProviderFunc adapts a function to a SecretProvider
+type SecretProviderFunc func(ctx context.Context, host string) (string, error)
+
+// Secret calls f
+func (f SecretProviderFunc) Secret(ctx context.Context, host string) (string, error) {
+	return f(ctx, host)
+}
+
+// StaticSecret returns a SecretProvider providing the same secret for every host
+func StaticSecret(secret string) SecretProvider {
+	return SecretProviderFunc(func(context.Context, string) (string, error) {
+		return secret, nil
+	})
+}
+
+var (
+	// ErrIncorrectPassword is returned when sudo rejects the provided password
+	ErrIncorrectPassword = errors.New("sudo: incorrect password")
+	// ErrNotInSudoers is returned when the user may not run the command with sudo
+	ErrNotInSudoers = errors.New("sudo: user is not allowed to run the command")
+	// ErrSudoPasswordRequired is returned when sudo asks for a password but none can be provided
+	ErrSudoPasswordRequired = errors.New("sudo: a password is required")
+)
+
+// sudoMessages are the messages sudo prints when it refuses to run a command, with their errors
+var sudoMessages = []struct {
+	message string
+	err     error
+}{
+	{"is not in the sudoers file", ErrNotInSudoers},
+	{"is not allowed to execute", ErrNotInSudoers},
+	{"is not allowed to run sudo", ErrNotInSudoers},
+	{"incorrect password attempt", ErrIncorrectPassword},
+	{"a password is required", ErrSudoPasswordRequired},
+}
+
+// sudoOutputHead is how much of the output is kept to look for sudo messages, sudo prints them before the command runs
+const sudoOutputHead = 4096
+
+// sudoMode is how sudo behaves for the user on a host
+type sudoMode struct {
+	// password is set when sudo asks for a password, it is not for NOPASSWD users
+	password bool
+	// tty is set when sudo only runs with a terminal, as with `Defaults requiretty`
+	tty bool
+}
+
+// sudoElevation runs a command with sudo. It answers the password prompt, removes the prompt from the output
+// and recognizes the messages of sudo refusing to run the command
+type sudoElevation struct {
+	askPassPath string
+	mode        sudoMode
+	password    string
+	prompt      string
+
+	input io.Reader
+	stdin io.WriteCloser
+	kill  func()
+
+	mu      sync.Mutex
+	prompts int
+	head    bytes.Buffer
+	err     error
+}
+
+// classifySudoOutput returns the error of the sudo message found in the output, if any
+func classifySudoOutput(output string) error {
+	for _, m := range sudoMessages {
+		if strings.Contains(output, m.message) {
+			return m.err
+		}
+	}
+	return nil
+}
+
+// newSudoPrompt returns a random prompt that the output of a command cannot be mistaken for
+func newSudoPrompt() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("[chisme-sudo-%s] password:", hex.EncodeToString(b))
+}
+
+// prepareElevation returns the elevation of the command, or nil when it is not elevated.
+// The sudo mode of the host is probed before the first elevated command
+func (s *SSHCommandRunner) prepareElevation(ctx context.Context, ec ExecCommand) (*sudoElevation, error) {
+	if !ec.Elevated {
+		return nil, nil
+	}
+	if s.AskPassPath != "" {
+		return &sudoElevation{askPassPath: s.AskPassPath, input: ec.Input}, nil
+	}
+	// the password sent through stdin for the provisioned askpass helper would be echoed by a terminal
+	if s.config.AskPass && ec.PTY == nil {
+		password, err := s.sudoPassword(ctx)
+		if err != nil {
+			return nil, err
+		}
+		helper, err := s.askPassHelperPath(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &sudoElevation{askPassPath: helper, password: password, input: ec.Input}, nil
+	}
+
+	mode, err := s.sudoMode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// with a terminal sudo prompts on it, which is stdout
+	mode.tty = mode.tty || ec.PTY != nil
+	elevation := &sudoElevation{mode: mode, input: ec.Input}
+	if !mode.password {
+		return elevation, nil
+	}
+
+	password, err := s.sudoPassword(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	elevation.password = password
+	elevation.prompt = newSudoPrompt()
+	return elevation, nil
+}
+
+// sudoPassword returns the sudo password of the host from the configured provider
+func (s *SSHCommandRunner) sudoPassword(ctx context.Context) (string, error) {
+	if s.config.SudoPassword == nil {
+		return "", fmt.Errorf("%w for %s on %s, no sudo password provider is configured", ErrSudoPasswordRequired, s.config.User, s.config.Host)
+	}
+	password, err := s.config.SudoPassword.Secret(ctx, s.config.Host)
+	if err != nil {
+		return "", fmt.Errorf("failed to get sudo password: %w", err)
+	}
+	return password, nil
+}
+
+// sudoMode returns how sudo behaves on the host, it is probed once and cached
+func (s *SSHCommandRunner) sudoMode(ctx context.Context) (sudoMode, error) {
+	s.sudoMu.Lock()
+	defer s.sudoMu.Unlock()
+
+	if s.sudo != nil {
+		return *s.sudo, nil
+	}
+
+	mode, err := s.probeSudo(ctx)
+	if err != nil {
+		return sudoMode{}, err
+	}
+	s.sudo = &mode
+	return mode, nil
+}
+
+// probeSudo finds out with `sudo -n true` whether sudo asks for a password, retrying with a terminal
+// when sudo refuses to run without one
+func (s *SSHCommandRunner) probeSudo(ctx context.Context) (sudoMode, error) {
+	for _, tty := range []bool{false, true} {
+		output, exitCode, err := s.runProbe(ctx, "sudo -n true", tty)
+		if err != nil {
+			return sudoMode{}, fmt.Errorf("failed to probe sudo: %w", err)
+		}
+
+		switch {
+		case exitCode == 0:
+			return sudoMode{tty: tty}, nil
+		case strings.Contains(output, "a password is required"):
+			return sudoMode{password: true, tty: tty}, nil
+		case !tty && strings.Contains(output, "must have a tty"):
+			continue
+		}
+
+		if err := classifySudoOutput(output); err != nil {
+			return sudoMode{}, fmt.Errorf("%w for %s on %s", err, s.config.User, s.config.Host)
+		}
+		return sudoMode{}, fmt.Errorf("failed to probe sudo, exit code %d: %s", exitCode, strings.TrimSpace(output))
+	}
+	return sudoMode{}, fmt.Errorf("failed to probe sudo: a terminal is required")
+}
+
+// runProbe runs the command in a new session, with a terminal if tty is set, and returns its combined output and exit code
+func (s *SSHCommandRunner) runProbe(ctx context.Context, command string, tty bool) (string, int, error) {
+	session, release, err := s.newSession(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	defer release()
+
+	stop := context.AfterFunc(ctx, func() { killSession(session) })
+	defer stop()
+
+	if tty {
+		if err := requestSudoPty(session); err != nil {
+			return "", 0, err
+		}
+	}
+
+	output, err := session.CombinedOutput(command)
+	if ctx.Err() != nil {
+		return "", 0, ctx.Err()
+	}
+
+	var exitErr *ssh.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return string(output), exitErr.ExitStatus(), nil
+	case err != nil:
+		return "", 0, err
+	}
+	return string(output), 0, nil
+}
+
+// requestSudoPty requests a terminal for sudo, echo is disabled so the password does not show up in the output
+func requestSudoPty(session *ssh.Session) error {
+	if err := session.RequestPty("xterm", 40, 80, ssh.TerminalModes{ssh.ECHO: 0}); err != nil {
+		return fmt.Errorf("failed to request pty: %w", err)
+	}
+	return nil
+}
+
+// apply rewrites the command to run with sudo and prepares the session, the password is written
+// to stdin once sudo prompts for it and the input of the command follows
+func (e *sudoElevation) apply(ec *ExecCommand, session *ssh.Session) error {
+	applyCommandRootElevation(&ec.Command, e)
+
+	if e.mode.tty && ec.PTY == nil {
+		if err := requestSudoPty(session); err != nil {
+			return err
+		}
+	}
+
+	if e.askPassPath != "" && e.password != "" {
+		input := e.input
+		if input == nil {
+			input = strings.NewReader("")
+		}
+		session.Stdin = io.MultiReader(strings.NewReader(e.password+"\n"), input)
+		return nil
+	}
+	if e.prompt == "" {
+		if e.input != nil {
+			session.Stdin = e.input
+		}
+		return nil
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stdin pipe: %w", err)
+	}
+	e.stdin = stdin
+	e.kill = func() { killSession(session) }
+	return nil
+}
+
+// filter wraps the output sudo writes its prompt and messages to, which is stdout when running with a terminal.
+// With askpass there is no prompt and the output is only recorded
+func (e *sudoElevation) filter(stdOut, stdErr io.Reader) (io.Reader, io.Reader) {
+	if e == nil {
+		return stdOut, stdErr
+	}
+	if e.mode.tty {
+		return &sudoOutputFilter{r: stdOut, elevation: e}, stdErr
+	}
+	return stdOut, &sudoOutputFilter{r: stdErr, elevation: e}
+}
+
+// onPrompt answers the first prompt with the password and forwards the input of the command afterwards,
+// sudo prompting again means the password was rejected
+func (e *sudoElevation) onPrompt() {
+	e.mu.Lock()
+	e.prompts++
+	first := e.prompts == 1
+	if !first && e.err == nil {
+		e.err = ErrIncorrectPassword
+	}
+	e.mu.Unlock()
+
+	if !first {
+		e.kill()
+		return
+	}
+
+	_, _ = io.WriteString(e.stdin, e.password+"\n")
+	go func() {
+		if e.input != nil {
+			_, _ = io.Copy(e.stdin, e.input)
+		}
+		_ = e.stdin.Close()
+	}()
+}
+
+// record keeps the start of the output to look for sudo messages once the command finished
+func (e *sudoElevation) record(p []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if free := sudoOutputHead - e.head.Len(); free > 0 {
+		e.head.Write(p[:min(free, len(p))])
+	}
+}
+
+// result returns the sudo error of the finished command, if sudo refused to run it
+func (e *sudoElevation) result(waitErr error) error {
+	if e == nil {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.err != nil {
+		return e.err
+	}
+	if waitErr == nil {
+		return nil
+	}
+	return classifySudoOutput(e.head.String())
+}
+
+// sudoOutputFilter removes the sudo prompt from the output and reports every prompt it removed
+type sudoOutputFilter struct {
+	r         io.Reader
+	elevation *sudoElevation
+
+	buf     []byte
+	pending []byte
+	err     error
+}
+
+// Read returns the output without prompts, a partial prompt at the end of the data read so far
+// is held back until it is known whether it completes
+func (f *sudoOutputFilter) Read(p []byte) (int, error) {
+	chunk := make([]byte, 4096)
+	for len(f.pending) == 0 {
+		if f.err != nil {
+			if len(f.buf) == 0 {
+				return 0, f.err
+			}
+			f.pending, f.buf = f.buf, nil
+			break
+		}
+
+		n, err := f.r.Read(chunk)
+		f.buf = append(f.buf, chunk[:n]...)
+		f.err = err
+		f.scan()
+	}
+
+	n := copy(p, f.pending)
+	f.elevation.record(f.pending[:n])
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// scan moves the data before the prompts to pending and reports the prompts
+func (f *sudoOutputFilter) scan() {
+	prompt := []byte(f.elevation.prompt)
+	if len(prompt) == 0 {
+		f.pending, f.buf = append(f.pending, f.buf...), nil
+		return
+	}
+
+	for {
+		i := bytes.Index(f.buf, prompt)
+		if i < 0 {
+			break
+		}
+		f.pending = append(f.pending, f.buf[:i]...)
+		f.buf = f.buf[i+len(prompt):]
+		f.elevation.onPrompt()
+	}
+
+	keep := 0
+	for n := min(len(prompt)-1, len(f.buf)); n > 0; n-- {
+		if bytes.HasSuffix(f.buf, prompt[:n]) {
+			keep = n
+			break
+		}
+	}
+	f.pending = append(f.pending, f.buf[:len(f.buf)-keep]...)
+	f.buf = append([]byte(nil), f.buf[len(f.buf)-keep:]...)
+}
@@ -0,0 +1,230 @@
+package commandrunner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// startSudoRunner starts a test SSH server with the sudo options and returns a runner providing the sudo password
+func startSudoRunner(t *testing.T, sudo testSudoOptions, password SecretProvider) *SSHCommandRunner {
+	t.Helper()
+
+	server := startTestSSHServerWithOptions(t, testSSHServerOptions{sudo: sudo})
+	t.Cleanup(server.Close)
+
+	runner, err := NewSSHCommandRunner(SSHConfig{
+		Host:         server.Host,
+		Port:         server.Port,
+		User:         "test",
+		PrivateKey:   generateClientPrivateKey(t),
+		SudoPassword: password,
+	})
+	if err != nil {
+		t.Fatalf("failed to create SSH command runner: %v", err)
+	}
+	t.Cleanup(func() { runner.Close() })
+	return runner
+}
+
+func TestSSHCommandRunner_Sudo(t *testing.T) {
+	tests := []struct {
+		name     string
+		sudo     testSudoOptions
+		password SecretProvider
+		ec       ExecCommand
+		stdout   string
+		err      error
+	}{
+		{
+			name:   "no password",
+			ec:     ExecCommand{Command: "echo Hello", Elevated: true},
+			stdout: "Hello\n",
+		},
+		{
+			name:     "password",
+			sudo:     testSudoOptions{password: "secret"},
+			password: StaticSecret("secret"),
+			ec:       ExecCommand{Command: "echo Hello", Elevated: true},
+			stdout:   "Hello\n",
+		},
+		{
+			name:     "password followed by input",
+			sudo:     testSudoOptions{password: "secret"},
+			password: StaticSecret("secret"),
+			ec:       ExecCommand{Command: "cat", Elevated: true, Input: strings.NewReader("data\n")},
+			stdout:   "data\n",
+		},
+		{
+			name:     "password with tty",
+			sudo:     testSudoOptions{password: "secret", requireTTY: true},
+			password: StaticSecret("secret"),
+			ec:       ExecCommand{Command: "echo Hello", Elevated: true},
+			stdout:   "Hello\n",
+		},
+		{
+			name:     "incorrect password",
+			sudo:     testSudoOptions{password: "secret"},
+			password: StaticSecret("wrong"),
+			ec:       ExecCommand{Command: "echo Hello", Elevated: true},
+			err:      ErrIncorrectPassword,
+		},
+		{
+			name:     "not in sudoers",
+			sudo:     testSudoOptions{password: "secret", notInSudoers: true},
+			password: StaticSecret("secret"),
+			ec:       ExecCommand{Command: "echo Hello", Elevated: true},
+			err:      ErrNotInSudoers,
+		},
+		{
+			name: "password without provider",
+			sudo: testSudoOptions{password: "secret"},
+			ec:   ExecCommand{Command: "echo Hello", Elevated: true},
+			err:  ErrSudoPasswordRequired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := startSudoRunner(t, tt.sudo, tt.password)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			result, err := runner.RunCommandContext(ctx, tt.ec)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("RunCommand() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RunCommand() error = %v", err)
+			}
+			if got := result.Stdout.String(); got != tt.stdout {
+				t.Errorf("RunCommand() stdout = %q, want %q", got, tt.stdout)
+			}
+			if got := result.Stderr.String(); got != "" {
+				t.Errorf("RunCommand() stderr = %q, expected the prompt to be removed", got)
+			}
+		})
+	}
+}
+
+func TestSSHCommandRunner_SudoAsync(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		output   string
+		err      error
+	}{
+		{name: "correct password", password: "secret", output: "Hello"},
+		{name: "incorrect password", password: "wrong", err: ErrIncorrectPassword},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := startSudoRunner(t, testSudoOptions{password: "secret"}, StaticSecret(tt.password))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			events, err := runner.RunCommandAsyncContext(ctx, ExecCommand{Command: "echo Hello", Elevated: true})
+			if err != nil {
+				t.Fatalf("RunCommandAsync() error = %v", err)
+			}
+
+			output, exitCode, err := readEvents(t, events)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("RunCommandAsync() error = %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && (output != tt.output || exitCode != 0) {
+				t.Errorf("RunCommandAsync() = %q with exit code %d, want %q with exit code 0", output, exitCode, tt.output)
+			}
+		})
+	}
+}
+
+func TestSSHCommandRunner_SudoPasswordProvider(t *testing.T) {
+	var hosts []string
+	provider := SecretProviderFunc(func(ctx context.Context, host string) (string, error) {
+		hosts = append(hosts, host)
+		return "secret", nil
+	})
+	runner := startSudoRunner(t, testSudoOptions{password: "secret"}, provider)
+
+	if _, err := runner.RunCommand(ExecCommand{Command: "echo Hello"}); err != nil {
+		t.Fatalf("RunCommand() error = %v", err)
+	}
+	if len(hosts) != 0 {
+		t.Fatalf("password was provided for a command that is not elevated")
+	}
+
+	if _, err := runner.RunCommand(ExecCommand{Command: "echo Hello", Elevated: true}); err != nil {
+		t.Fatalf("RunCommand() error = %v", err)
+	}
+	if len(hosts) != 1 || hosts[0] != "127.0.0.1" {
+		t.Errorf("password provided for %v, want [127.0.0.1]", hosts)
+	}
+
+	failing := SecretProviderFunc(func(ctx context.Context, host string) (string, error) {
+		return "", errors.New("vault sealed")
+	})
+	runner = startSudoRunner(t, testSudoOptions{password: "secret"}, failing)
+	if _, err := runner.RunCommand(ExecCommand{Command: "echo Hello", Elevated: true}); err == nil || !strings.Contains(err.Error(), "vault sealed") {
+		t.Errorf("RunCommand() error = %v, expected the error of the provider", err)
+	}
+}
+
+// nopWriteCloser records what is written to it
+type nopWriteCloser struct {
+	bytes.Buffer
+}
+
+func (w *nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestSudoOutputFilter(t *testing.T) {
+	const prompt = "[chisme-sudo-1] password:"
+
+	tests := []struct {
+		name    string
+		output  string
+		want    string
+		prompts int
+	}{
+		{"prompt only", prompt, "", 1},
+		{"prompt followed by output", prompt + "error\n", "error\n", 1},
+		{"output without prompt", "[chisme-sudo-2] password: no\n", "[chisme-sudo-2] password: no\n", 0},
+		{"partial prompt at the end", "out [chisme-sudo", "out [chisme-sudo", 0},
+		{"prompt twice", prompt + "Sorry, try again.\n" + prompt, "Sorry, try again.\n", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdin := &nopWriteCloser{}
+			elevation := &sudoElevation{prompt: prompt, password: "secret", stdin: stdin, kill: func() {}}
+			filter := &sudoOutputFilter{r: iotest.OneByteReader(strings.NewReader(tt.output)), elevation: elevation}
+
+			got, err := io.ReadAll(filter)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("filtered output = %q, want %q", got, tt.want)
+			}
+			if elevation.prompts != tt.prompts {
+				t.Errorf("prompts = %d, want %d", elevation.prompts, tt.prompts)
+			}
+			if tt.prompts == 2 && !errors.Is(elevation.result(nil), ErrIncorrectPassword) {
+				t.Errorf("result() = %v, want %v", elevation.result(nil), ErrIncorrectPassword)
+			}
+		})
+	}
+}
@@ -1,121 +1,592 @@
 package commandrunner
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	"io"
 	"log"
 	"net"
+	"os"
+	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
-// readFromChannels reads from the output and outputError channels and returns the output as string and error
-func readFromChannels(t *testing.T, output <-chan string, outputError <-chan error) (string, error) {
+// readEvents reads all events of a command and returns the stdout and stderr lines as string,
+// the exit code and the error of the final event
+func readEvents(t *testing.T, events <-chan Event) (string, int, error) {
 	t.Helper()
 
 	var got strings.Builder
-
-	done := make(chan struct{})
-	go func() {
-		for line := range output {
-			got.WriteString(line)
+	var exitCode int
+	var err error
+	for event := range events {
+		switch event.Type {
+		case EventStdout, EventStderr:
+			got.WriteString(event.Line)
+		case EventExit:
+			exitCode = event.ExitCode
+		case EventError:
+			err = event.Err
 		}
-		close(done)
-	}()
+	}
 
-	var err error
-	select {
-	case err = <-outputError:
-	case <-done:
+	return got.String(), exitCode, err
+}
+
+// testSSHServer is a simple SSH server listening on a random port of 127.0.0.1 for testing purposes
+type testSSHServer struct {
+	Host     string
+	Port     int
+	HostKey  ssh.Signer
+	listener net.Listener
+
+	// connections counts the accepted connections that completed the handshake
+	connections atomic.Int32
+	// activeSessions and maxSessions track the commands running concurrently
+	activeSessions atomic.Int32
+	maxSessions    atomic.Int32
+	// openChannels counts the session channels currently open
+	openChannels atomic.Int32
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	commands []string
+	// terminals records the terminal requests of the sessions as "term rowsxcols" and resizes as "rowsxcols"
+	terminals []string
+
+	opts testSSHServerOptions
+}
+
+// Close stops accepting connections
+func (s *testSSHServer) Close() {
+	s.listener.Close()
+}
+
+// DropConnections closes all open connections without stopping the server
+func (s *testSSHServer) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
 	}
+}
 
-	return got.String(), err
+// Connections returns the number of connections that completed the handshake
+func (s *testSSHServer) Connections() int {
+	return int(s.connections.Load())
 }
 
-// startTestSSHServer starts a simple SSH server for testing purposes on 127.0.0.1:9090
-func startTestSSHServer(t *testing.T) func() {
-	t.Helper()
+// Commands returns the command lines the server was asked to execute, in order
+func (s *testSSHServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		t.Fatalf("failed to generate private key: %v", err)
+	return append([]string(nil), s.commands...)
+}
+
+// Terminals returns the terminal requests and resizes the server received, in order
+func (s *testSSHServer) Terminals() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.terminals...)
+}
+
+// recordTerminal remembers a terminal request or resize
+func (s *testSSHServer) recordTerminal(terminal string) {
+	s.mu.Lock()
+	s.terminals = append(s.terminals, terminal)
+	s.mu.Unlock()
+}
+
+// OpenConnections returns the number of connections that are currently open
+func (s *testSSHServer) OpenConnections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+// MaxSessions returns the highest number of commands that were running at the same time
+func (s *testSSHServer) MaxSessions() int {
+	return int(s.maxSessions.Load())
+}
+
+// trackConn remembers an open connection until it is closed
+func (s *testSSHServer) trackConn(conn net.Conn) func() {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
 	}
+}
 
-	private, err := ssh.NewSignerFromKey(privateKey)
-	if err != nil {
-		t.Fatalf("failed to create signer from private key: %v", err)
+// startSession counts a running command and returns the function that ends it
+func (s *testSSHServer) startSession() func() {
+	active := s.activeSessions.Add(1)
+	for {
+		highest := s.maxSessions.Load()
+		if active <= highest || s.maxSessions.CompareAndSwap(highest, active) {
+			break
+		}
 	}
+	return func() { s.activeSessions.Add(-1) }
+}
 
-	config := &ssh.ServerConfig{
-		NoClientAuth: true,
+// testSSHServerOptions configures the test SSH server, clients are not authenticated unless
+// an authorized key, a user certificate authority or a password is set
+type testSSHServerOptions struct {
+	// hostKey is the key presented by the server, a new key is generated when nil
+	hostKey ssh.Signer
+	// authorizedKey is the public key accepted for public key authentication
+	authorizedKey ssh.PublicKey
+	// userCA is the authority whose user certificates are accepted for the principal "test"
+	userCA ssh.PublicKey
+	// password is accepted by password authentication
+	password string
+	// keyboardInteractivePassword is the expected answer to the keyboard-interactive password question
+	keyboardInteractivePassword string
+	// sudo configures how the sudo command of the server behaves
+	sudo testSudoOptions
+	// shell runs the commands with bash instead of understanding the few commands of runTestCommand
+	shell bool
+	// shellPath is put in front of PATH of the commands run with bash
+	shellPath string
+	// acceptEnv accepts the environment variables sent by the client, like AcceptEnv of sshd
+	acceptEnv bool
+	// sessionLimit rejects session channels beyond this number open on the server, like MaxSessions of sshd
+	sessionLimit int32
+}
+
+// startTestSSHServer starts a test SSH server with a newly generated host key that does not authenticate clients
+func startTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+
+	return startTestSSHServerWithOptions(t, testSSHServerOptions{})
+}
+
+// startTestSSHServerWithOptions starts a test SSH server configured by the options
+func startTestSSHServerWithOptions(t *testing.T, opts testSSHServerOptions) *testSSHServer {
+	t.Helper()
+
+	hostKey := opts.hostKey
+	if hostKey == nil {
+		hostKey = generateHostKey(t)
 	}
-	config.AddHostKey(private)
 
-	// setting up sshrunner server on port 9090
-	listener, err := net.Listen("tcp", "127.0.0.1:9090")
+	config := testServerAuthConfig(opts)
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatalf("failed to listen on a port: %v", err)
 	}
 
+	addr := listener.Addr().(*net.TCPAddr)
+	server := &testSSHServer{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		HostKey:  hostKey,
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+		opts:     opts,
+	}
+
 	go func() {
 		for {
 			nConn, err := listener.Accept()
 			if err != nil {
-				//log.Printf("failed to accept incoming connection: %v", err)
-				continue
+				return
 			}
 
 			go func(nConn net.Conn) {
+				untrack := server.trackConn(nConn)
+				defer untrack()
+
 				_, chans, reqs, err := ssh.NewServerConn(nConn, config)
 				if err != nil {
 					log.Printf("failed to handshake: %v", err)
 					return
 				}
+				server.connections.Add(1)
 
 				// Discard all global out-of-band Requests
 				go ssh.DiscardRequests(reqs)
 
 				for newChannel := range chans {
+					if newChannel.ChannelType() == "direct-tcpip" {
+						go handleTestDirectTCPIP(newChannel)
+						continue
+					}
 					if newChannel.ChannelType() != "session" {
 						newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
 						continue
 					}
 
+					if limit := server.opts.sessionLimit; limit > 0 && server.openChannels.Load() >= limit {
+						newChannel.Reject(ssh.ResourceShortage, "no more sessions")
+						continue
+					}
+
 					channel, requests, err := newChannel.Accept()
 					if err != nil {
 						log.Printf("could not accept channel: %v", err)
 						continue
 					}
 
+					server.openChannels.Add(1)
 					go func() {
-						for req := range requests {
-							if req.Type == "exec" {
-								cmd := string(req.Payload[4:])
-								if strings.Contains(cmd, "echo") {
-									req.Reply(true, nil)
-									io.WriteString(channel, strings.TrimLeft(cmd, "echo ")+"\n")
-									channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
-								} else {
-									req.Reply(true, nil)
-									io.WriteString(channel, "unknown command\n")
-									channel.SendRequest("exit-status", false, []byte{0, 0, 0, 1})
-								}
-								channel.Close()
-							}
-						}
+						defer server.openChannels.Add(-1)
+						server.handleSessionRequests(channel, requests)
 					}()
 				}
 			}(nConn)
 		}
 	}()
 
-	return func() {
-		listener.Close()
+	return server
+}
+
+// testServerAuthConfig returns the server config authenticating clients as configured by the options
+func testServerAuthConfig(opts testSSHServerOptions) *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		NoClientAuth: opts.authorizedKey == nil && opts.userCA == nil && opts.password == "" && opts.keyboardInteractivePassword == "",
+	}
+
+	if opts.authorizedKey != nil || opts.userCA != nil {
+		checker := &ssh.CertChecker{
+			IsUserAuthority: func(auth ssh.PublicKey) bool {
+				return opts.userCA != nil && bytes.Equal(auth.Marshal(), opts.userCA.Marshal())
+			},
+			UserKeyFallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+				if opts.authorizedKey != nil && bytes.Equal(key.Marshal(), opts.authorizedKey.Marshal()) {
+					return nil, nil
+				}
+				return nil, fmt.Errorf("unknown public key for %q", conn.User())
+			},
+		}
+		config.PublicKeyCallback = checker.Authenticate
+	}
+
+	if opts.password != "" {
+		config.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) == opts.password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %q", conn.User())
+		}
+	}
+
+	if opts.keyboardInteractivePassword != "" {
+		config.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client(conn.User(), "", []string{"Password: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) == 1 && answers[0] == opts.keyboardInteractivePassword {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("keyboard-interactive rejected for %q", conn.User())
+		}
+	}
+
+	return config
+}
+
+// handleSessionRequests serves the requests of a session channel, the commands are run by runTestCommand
+func (s *testSSHServer) handleSessionRequests(channel ssh.Channel, requests <-chan *ssh.Request) {
+	interrupted := make(chan struct{})
+	var once sync.Once
+	interrupt := func() { once.Do(func() { close(interrupted) }) }
+	defer interrupt()
+
+	tty := false
+	var env []string
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			tty = true
+			var pty struct {
+				Term             string
+				Cols, Rows, W, H uint32
+				Modes            string
+			}
+			if ssh.Unmarshal(req.Payload, &pty) == nil {
+				s.recordTerminal(fmt.Sprintf("%s %dx%d", pty.Term, pty.Rows, pty.Cols))
+			}
+			req.Reply(true, nil)
+		case "window-change":
+			var size struct{ Cols, Rows, W, H uint32 }
+			if ssh.Unmarshal(req.Payload, &size) == nil {
+				s.recordTerminal(fmt.Sprintf("%dx%d", size.Rows, size.Cols))
+			}
+		case "env":
+			var variable struct{ Name, Value string }
+			accepted := s.opts.acceptEnv && ssh.Unmarshal(req.Payload, &variable) == nil
+			if accepted {
+				env = append(env, variable.Name+"="+variable.Value)
+			}
+			req.Reply(accepted, nil)
+		case "exec":
+			cmd := string(req.Payload[4:])
+			req.Reply(true, nil)
+			s.mu.Lock()
+			s.commands = append(s.commands, cmd)
+			s.mu.Unlock()
+			if s.opts.shell {
+				go s.runShell(cmd, channel, env, interrupted)
+				continue
+			}
+			go func(tty bool) {
+				defer channel.Close()
+				defer s.startSession()()
+
+				stderr := channel.Stderr()
+				if tty {
+					stderr = channel
+				}
+				if code, finished := s.runTestCommand(cmd, channel, bufio.NewReader(channel), stderr, tty, interrupted); finished {
+					channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{code}))
+				}
+			}(tty)
+		case "subsystem":
+			var subsystem struct{ Name string }
+			ok := ssh.Unmarshal(req.Payload, &subsystem) == nil && subsystem.Name == "sftp"
+			req.Reply(ok, nil)
+			if ok {
+				go s.serveSFTP(channel)
+			}
+		case "signal":
+			interrupt()
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// serveSFTP serves the sftp subsystem on the channel with the files of the local machine
+func (s *testSSHServer) serveSFTP(channel ssh.Channel) {
+	defer channel.Close()
+	defer s.startSession()()
+
+	server, err := sftp.NewServer(channel)
+	if err != nil {
+		log.Printf("failed to start sftp server: %v", err)
+		return
+	}
+	_ = server.Serve()
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+}
+
+// runShell runs the command with bash in the environment sent by the client, the command is killed when interrupted
+func (s *testSSHServer) runShell(command string, channel ssh.Channel, env []string, interrupted <-chan struct{}) {
+	defer channel.Close()
+	defer s.startSession()()
+
+	cmd := exec.Command("bash", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	if s.opts.shellPath != "" {
+		cmd.Env = append(cmd.Env, "PATH="+s.opts.shellPath+string(os.PathListSeparator)+os.Getenv("PATH"))
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = channel, channel, channel.Stderr()
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Start(); err != nil {
+		io.WriteString(channel.Stderr(), err.Error()+"\n")
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{127}))
+		return
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupted:
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	cmd.Wait()
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(cmd.ProcessState.ExitCode())}))
+}
+
+// runTestCommand runs a command of the test server and returns its exit code, finished is false when the command
+// was interrupted. It understands `true`, `cat`, `echo`, `fail <code> <stderr>`, `sleep <seconds>`, which blocks
+// until the duration passed or the client signals or closes the session, and `sudo`
+func (s *testSSHServer) runTestCommand(cmd string, stdout io.Writer, stdin *bufio.Reader, stderr io.Writer, tty bool, interrupted <-chan struct{}) (uint32, bool) {
+	switch {
+	case strings.HasPrefix(cmd, "sudo "):
+		return s.runTestSudo(strings.TrimPrefix(cmd, "sudo "), stdout, stdin, stderr, tty, interrupted)
+	case cmd == "true":
+		return 0, true
+	case cmd == "cat":
+		io.Copy(stdout, stdin)
+		return 0, true
+	case strings.Contains(cmd, "echo"):
+		io.WriteString(stdout, strings.TrimLeft(cmd, "echo ")+"\n")
+		return 0, true
+	case strings.HasPrefix(cmd, "fail "):
+		fields := strings.SplitN(cmd, " ", 3)
+		code, _ := strconv.Atoi(fields[1])
+		if len(fields) == 3 {
+			io.WriteString(stderr, fields[2]+"\n")
+		}
+		return uint32(code), true
+	case strings.HasPrefix(cmd, "sleep "):
+		seconds, _ := strconv.ParseFloat(strings.TrimPrefix(cmd, "sleep "), 64)
+		select {
+		case <-time.After(time.Duration(seconds * float64(time.Second))):
+			return 0, true
+		case <-interrupted:
+			return 0, false
+		}
+	default:
+		io.WriteString(stdout, "unknown command\n")
+		return 1, true
+	}
+}
+
+// testSudoOptions configures the sudo of the test server, the zero value lets the user run any command without password
+type testSudoOptions struct {
+	// password is asked for when set
+	password string
+	// requireTTY refuses to run without a terminal, like `Defaults requiretty`
+	requireTTY bool
+	// notInSudoers refuses to run any command once the password was given
+	notInSudoers bool
+}
+
+// runTestSudo behaves like sudo with the -n, -k, -S and -p options, the password is read from stdin
+func (s *testSSHServer) runTestSudo(cmd string, stdout io.Writer, stdin *bufio.Reader, stderr io.Writer, tty bool, interrupted <-chan struct{}) (uint32, bool) {
+	nonInteractive := false
+	prompt := "[sudo] password for test: "
+	for parsing := true; parsing; {
+		switch {
+		case strings.HasPrefix(cmd, "-n "):
+			nonInteractive, cmd = true, strings.TrimPrefix(cmd, "-n ")
+		case strings.HasPrefix(cmd, "-k "), strings.HasPrefix(cmd, "-S "):
+			cmd = cmd[3:]
+		case strings.HasPrefix(cmd, "-p '"):
+			end := strings.Index(cmd, "' ")
+			prompt, cmd = cmd[len("-p '"):end], cmd[end+2:]
+		default:
+			parsing = false
+		}
+	}
+
+	opts := s.opts.sudo
+	if opts.requireTTY && !tty {
+		io.WriteString(stderr, "sudo: sorry, you must have a tty to run sudo\n")
+		return 1, true
+	}
+
+	if opts.password != "" {
+		if nonInteractive {
+			io.WriteString(stderr, "sudo: a password is required\n")
+			return 1, true
+		}
+		if !readTestSudoPassword(opts.password, prompt, stdin, stderr) {
+			return 1, true
+		}
+	}
+
+	if opts.notInSudoers {
+		io.WriteString(stderr, "test is not in the sudoers file.  This incident will be reported.\n")
+		return 1, true
+	}
+	return s.runTestCommand(cmd, stdout, stdin, stderr, tty, interrupted)
+}
+
+// readTestSudoPassword prompts for the password up to three times like sudo and reports whether it was given
+func readTestSudoPassword(password, prompt string, stdin *bufio.Reader, stderr io.Writer) bool {
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			io.WriteString(stderr, "Sorry, try again.\n")
+		}
+		io.WriteString(stderr, prompt)
+
+		line, err := stdin.ReadString('\n')
+		if err != nil {
+			io.WriteString(stderr, "sudo: no password was provided\n")
+			return false
+		}
+		if strings.TrimSuffix(line, "\n") == password {
+			return true
+		}
+	}
+	io.WriteString(stderr, "sudo: 3 incorrect password attempts\n")
+	return false
+}
+
+// handleTestDirectTCPIP forwards a direct-tcpip channel to the requested address, as a jump host does
+func handleTestDirectTCPIP(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "invalid direct-tcpip payload")
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+	io.Copy(channel, conn)
+	channel.Close()
+}
+
+// generateHostKey generates an ed25519 host key
+func generateHostKey(t *testing.T) ssh.Signer {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatalf("failed to create signer from host key: %v", err)
 	}
+	return signer
 }
 
 // generateClientPrivateKey generates a private key for the client
@@ -0,0 +1,280 @@
+package commandrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultFileMode is the mode of uploaded files when none is set
+const defaultFileMode os.FileMode = 0644
+
+// ownerPattern matches the user and group names and ids accepted for uploaded files
+var ownerPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*\$?$`)
+
+// FileOptions are the settings of a file transfer
+type FileOptions struct {
+	// Mode is the permission of an uploaded file, 0644 when zero
+	Mode os.FileMode
+	// Owner and Group are set on an uploaded file when not empty, by name or id
+	Owner string
+	Group string
+	// Elevated reads or writes the file with sudo
+	Elevated bool
+}
+
+// FileTransfer copies files to and from the host of a runner. An upload is written to a temporary file next
+// to the destination, which is renamed over the destination once complete so readers never see a partial file
+type FileTransfer interface {
+	Upload(ctx context.Context, src io.Reader, dst string, opts FileOptions) error
+	Download(ctx context.Context, src string, dst io.Writer, opts FileOptions) error
+}
+
+// validate checks the owner, group and mode of the options
+func (o FileOptions) validate() error {
+	for _, name := range []string{o.Owner, o.Group} {
+		if name != "" && !ownerPattern.MatchString(name) {
+			return fmt.Errorf("invalid owner or group: %q", name)
+		}
+	}
+	if o.Mode&^os.ModePerm != 0 {
+		return fmt.Errorf("invalid file mode: %v", o.Mode)
+	}
+	return nil
+}
+
+// mode returns the mode of an uploaded file
+func (o FileOptions) mode() os.FileMode {
+	if o.Mode == 0 {
+		return defaultFileMode
+	}
+	return o.Mode
+}
+
+// ownership returns the argument of chown for the owner and group, empty when neither is set
+func (o FileOptions) ownership() string {
+	switch {
+	case o.Owner != "" && o.Group != "":
+		return o.Owner + ":" + o.Group
+	case o.Group != "":
+		return ":" + o.Group
+	}
+	return o.Owner
+}
+
+// uploadScript returns the shell script writing its stdin to dst through a temporary file in the same directory
+func uploadScript(dst string, opts FileOptions) string {
+	temp := path.Join(path.Dir(dst), "."+path.Base(dst)+".chisme.XXXXXX")
+
+	script := []string{
+		"set -e",
+		"tmp=$(mktemp " + ShellQuote(temp) + ")",
+		`trap 'rm -f "$tmp"' EXIT`,
+		`cat > "$tmp"`,
+		fmt.Sprintf(`chmod %04o "$tmp"`, uint32(opts.mode())),
+	}
+	if ownership := opts.ownership(); ownership != "" {
+		script = append(script, "chown "+ShellQuote(ownership)+` "$tmp"`)
+	}
+	script = append(script, `mv -f "$tmp" `+ShellQuote(dst), "trap - EXIT")
+	return strings.Join(script, "\n")
+}
+
+// transferStderrLimit bounds the error output of a transfer command kept in memory
+const transferStderrLimit = 64 << 10
+
+// streamFunc runs the command of a transfer and writes its stdout to stdout as it is produced, so that a transfer
+// never holds a whole file in memory. A command exiting with a non-zero status returns an *ExitError
+type streamFunc func(ctx context.Context, ec ExecCommand, stdout io.Writer) error
+
+// uploadWith uploads the file by running the upload script with stream, the content is streamed to its input
+func uploadWith(ctx context.Context, stream streamFunc, src io.Reader, dst string, opts FileOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	ec := ExecCommand{Args: []string{"sh", "-c", uploadScript(dst, opts)}, Input: src, Elevated: opts.Elevated}
+	if err := stream(ctx, ec, io.Discard); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", dst, err)
+	}
+	return nil
+}
+
+// downloadWith downloads the file by running cat with stream, the content is streamed to dst
+func downloadWith(ctx context.Context, stream streamFunc, src string, dst io.Writer, opts FileOptions) error {
+	if err := stream(ctx, ExecCommand{Args: []string{"cat", "--", src}, Elevated: opts.Elevated}, dst); err != nil {
+		return fmt.Errorf("failed to download %s: %w", src, err)
+	}
+	return nil
+}
+
+// Upload writes src to the local file dst, elevated uploads run the upload script with sudo
+func (b *BashCommandRunner) Upload(ctx context.Context, src io.Reader, dst string, opts FileOptions) error {
+	if opts.Elevated {
+		return uploadWith(ctx, streamWithSudo, src, dst, opts)
+	}
+	return uploadLocal(ctx, src, dst, opts)
+}
+
+// uploadLocal writes src to the local file dst through a temporary file in the same directory
+func uploadLocal(ctx context.Context, src io.Reader, dst string, opts FileOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	uid, gid, err := lookupOwnership(opts.Owner, opts.Group)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", dst, err)
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".chisme.*")
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", dst, err)
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = io.Copy(temp, readerContext{ctx: ctx, r: src})
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), opts.mode())
+	}
+	if err == nil && (uid >= 0 || gid >= 0) {
+		err = os.Chown(temp.Name(), uid, gid)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), dst)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", dst, err)
+	}
+	return nil
+}
+
+// Download copies the local file src to dst, elevated downloads read the file with sudo
+func (b *BashCommandRunner) Download(ctx context.Context, src string, dst io.Writer, opts FileOptions) error {
+	if opts.Elevated {
+		return downloadWith(ctx, streamWithSudo, src, dst, opts)
+	}
+	return downloadLocal(ctx, src, dst)
+}
+
+// downloadLocal copies the local file src to dst
+func downloadLocal(ctx context.Context, src string, dst io.Writer) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", src, err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(dst, readerContext{ctx: ctx, r: file}); err != nil {
+		return fmt.Errorf("failed to download %s: %w", src, err)
+	}
+	return nil
+}
+
+// streamWithSudo runs the elevated commands of the BashCommandRunner with sudo, which must not ask for a password
+func streamWithSudo(ctx context.Context, ec ExecCommand, stdout io.Writer) error {
+	if ec.Elevated && len(ec.Args) > 0 {
+		ec.Args = append([]string{"sudo", "-n", "--"}, ec.Args...)
+		ec.Elevated = false
+	}
+	return streamLocalCommand(ctx, ec, stdout, newBashCommand)
+}
+
+// streamLocalCommand runs the process created by newCmd with its stdout written to stdout
+func streamLocalCommand(ctx context.Context, ec ExecCommand, stdout io.Writer, newCmd func(context.Context, ExecCommand) (*exec.Cmd, error)) error {
+	ctx, cancel := commandContext(ctx, ec)
+	defer cancel()
+
+	cmd, err := newCmd(ctx, ec)
+	if err != nil {
+		return err
+	}
+
+	result := newCommandResult(ec.CommandLine(), localHost)
+	output := newResultOutput(result, OutputLimits{MaxBytes: transferStderrLimit})
+	cmd.Stdout = stdout
+	cmd.Stderr = output.stderr
+
+	err = cmd.Run()
+	result.FinishedAt = time.Now()
+	_ = output.close()
+	if ctx.Err() != nil {
+		return contextError(ctx, ec)
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+		return &ExitError{Result: result}
+	case err != nil:
+		return fmt.Errorf("failed to run command: %w", err)
+	}
+	return nil
+}
+
+// lookupOwnership returns the ids of the owner and group, -1 for the ones that are not set
+func lookupOwnership(owner, group string) (int, int, error) {
+	uid, err := lookupID(owner, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	gid, err := lookupID(group, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return uid, gid, nil
+}
+
+// lookupID returns the id of a user or group given by name or id, -1 when name is empty
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// readerContext stops reading once the context is done
+type readerContext struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r readerContext) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
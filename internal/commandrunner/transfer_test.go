@@ -0,0 +1,127 @@
+package commandrunner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkUploadedFile checks the content and mode of an uploaded file and that no temporary file is left behind
+func checkUploadedFile(t *testing.T, path, content string, mode os.FileMode) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read uploaded file: %v", err)
+	}
+	if string(data) != content {
+		t.Errorf("uploaded content = %q, want %q", data, content)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat uploaded file: %v", err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("uploaded mode = %v, want %v", info.Mode().Perm(), mode)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the uploaded file", len(entries))
+	}
+}
+
+func TestFileTransfer(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatalf("failed to get current user: %v", err)
+	}
+	sudoDir := t.TempDir()
+	writeFakeSudo(t, sudoDir)
+	server := startTestSSHServerWithOptions(t, testSSHServerOptions{shell: true, shellPath: sudoDir})
+	t.Cleanup(server.Close)
+
+	transfers := map[string]FileTransfer{
+		"ssh":  newPoolTestRunner(t, server, 0),
+		"bash": &BashCommandRunner{},
+	}
+
+	tests := []struct {
+		name string
+		opts FileOptions
+		mode os.FileMode
+	}{
+		{name: "default mode", mode: 0644},
+		{name: "mode and owner", opts: FileOptions{Mode: 0600, Owner: current.Username, Group: current.Gid}, mode: 0600},
+		{name: "elevated", opts: FileOptions{Mode: 0640, Elevated: true}, mode: 0640},
+	}
+
+	for transferName, transfer := range transfers {
+		for _, tt := range tests {
+			t.Run(transferName+" "+tt.name, func(t *testing.T) {
+				if transferName == "bash" && tt.opts.Elevated {
+					t.Setenv("PATH", sudoDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+				}
+				path := filepath.Join(t.TempDir(), "preferences")
+				if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+					t.Fatalf("failed to write file: %v", err)
+				}
+				// bytes a terminal would translate or act on must arrive unchanged
+				content := "Package: *\r\nPin: release a=noble-security\nPin-Priority: 900\n\x00\x03\x04\x1a\xff"
+
+				if err := transfer.Upload(context.Background(), strings.NewReader(content), path, tt.opts); err != nil {
+					t.Fatalf("Upload() error = %v", err)
+				}
+				checkUploadedFile(t, path, content, tt.mode)
+
+				var downloaded bytes.Buffer
+				if err := transfer.Download(context.Background(), path, &downloaded, FileOptions{Elevated: tt.opts.Elevated}); err != nil {
+					t.Fatalf("Download() error = %v", err)
+				}
+				if downloaded.String() != content {
+					t.Errorf("Download() = %q, want %q", downloaded.String(), content)
+				}
+			})
+		}
+
+		t.Run(transferName+" errors", func(t *testing.T) {
+			dir := t.TempDir()
+			if err := transfer.Upload(context.Background(), strings.NewReader("x"), filepath.Join(dir, "missing", "file"), FileOptions{}); err == nil {
+				t.Errorf("Upload() to a missing directory expected an error")
+			}
+			if err := transfer.Upload(context.Background(), strings.NewReader("x"), filepath.Join(dir, "file"), FileOptions{Owner: "root; reboot"}); err == nil {
+				t.Errorf("Upload() with an invalid owner expected an error")
+			}
+			if err := transfer.Download(context.Background(), filepath.Join(dir, "missing"), &bytes.Buffer{}, FileOptions{}); err == nil {
+				t.Errorf("Download() of a missing file expected an error")
+			}
+		})
+	}
+}
+
+func TestSSHCommandRunner_ElevatedTransferNeedsTerminal(t *testing.T) {
+	server := startTestSSHServerWithOptions(t, testSSHServerOptions{sudo: testSudoOptions{requireTTY: true}})
+	t.Cleanup(server.Close)
+	runner := newPoolTestRunner(t, server, 0)
+
+	err := runner.Upload(context.Background(), strings.NewReader("x"), "/etc/apt/preferences.d/chisme", FileOptions{Elevated: true})
+	if !errors.Is(err, ErrTransferNeedsTerminal) {
+		t.Errorf("Upload() error = %v, want %v", err, ErrTransferNeedsTerminal)
+	}
+	err = runner.Download(context.Background(), "/var/run/reboot-required", &bytes.Buffer{}, FileOptions{Elevated: true})
+	if !errors.Is(err, ErrTransferNeedsTerminal) {
+		t.Errorf("Download() error = %v, want %v", err, ErrTransferNeedsTerminal)
+	}
+	for _, command := range server.Commands() {
+		if command != "sudo -n true" {
+			t.Errorf("server ran %q, want only the sudo probe", command)
+		}
+	}
+}
@@ -2,12 +2,19 @@ package commandrunner
 
 import "fmt"
 
-// applyCommandRootElevation applies the root elevation to the command by adding sudo
-func applyCommandRootElevation(command *string, askPassPath string) {
+// applyCommandRootElevation applies the root elevation to the command by adding sudo. The askpass program
+// is used when set, it gets the password through a one-shot pipe when the runner provisioned it. Otherwise
+// sudo reads the password from stdin after prompting with the prompt of the elevation, it never prompts
+// for NOPASSWD users
+func applyCommandRootElevation(command *string, elevation *sudoElevation) {
 	switch {
-	case askPassPath != "":
-		*command = fmt.Sprintf("SUDO_ASKPASS=%s sudo -A %s", askPassPath, *command)
+	case elevation.askPassPath != "" && elevation.password != "":
+		*command = askPassCommand(elevation.askPassPath, *command)
+	case elevation.askPassPath != "":
+		*command = fmt.Sprintf("SUDO_ASKPASS=%s sudo -A %s", elevation.askPassPath, *command)
+	case elevation.prompt != "":
+		*command = fmt.Sprintf("sudo -k -S -p '%s' %s", elevation.prompt, *command)
 	default:
-		*command = fmt.Sprintf("sudo -S %s", *command)
+		*command = fmt.Sprintf("sudo -n %s", *command)
 	}
 }
@@ -1,34 +1,40 @@
 package commandrunner
 
 import (
-	"fmt"
 	"testing"
 )
 
-func TestApplyCommandRootElevation_ShouldAddSudoWithStdinInput(t *testing.T) {
-	runner := MockCommandRunner{}
-
-	command := "apt install"
-	expected := "sudo -S apt install"
-
-	applyCommandRootElevation(&command, runner.AskPassPath)
-
-	if command != expected {
-		t.Errorf("expected %q, got %q", expected, command)
+func TestApplyCommandRootElevation(t *testing.T) {
+	tests := []struct {
+		name      string
+		elevation *sudoElevation
+		expected  string
+	}{
+		{
+			name:      "askpass",
+			elevation: &sudoElevation{askPassPath: "/path/to/askpass"},
+			expected:  "SUDO_ASKPASS=/path/to/askpass sudo -A apt install",
+		},
+		{
+			name:      "password from stdin",
+			elevation: &sudoElevation{mode: sudoMode{password: true}, prompt: "[chisme-sudo-1] password:"},
+			expected:  "sudo -k -S -p '[chisme-sudo-1] password:' apt install",
+		},
+		{
+			name:      "no password",
+			elevation: &sudoElevation{},
+			expected:  "sudo -n apt install",
+		},
 	}
-}
-
-func TestApplyCommandRootElevation_ShouldAddSudoWithDashAskPass(t *testing.T) {
-	runner := MockCommandRunner{
-		AskPassPath: "/path/to/askpass",
-	}
-
-	command := "apt install"
-	expected := fmt.Sprintf("SUDO_ASKPASS=%s sudo -A %s", runner.AskPassPath, command)
 
-	applyCommandRootElevation(&command, runner.AskPassPath)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command := "apt install"
+			applyCommandRootElevation(&command, tt.elevation)
 
-	if command != expected {
-		t.Errorf("expected %q, got %q", expected, command)
+			if command != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, command)
+			}
+		})
 	}
 }
@@ -0,0 +1,62 @@
+package apk
+
+import (
+	"fmt"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+)
+
+// Apk is a struct that represents the apk package manager of Alpine Linux
+type Apk struct {
+	CLI           string
+	CommandRunner commandrunner.CommandRunner
+}
+
+// GetPackages lists all installed packages and returns them as a slice of Package structs, the version of
+// a package is the candidate version when an upgrade is available and the installed version otherwise
+func (a *Apk) GetPackages() ([]*models.Package, error) {
+	packages, err := a.run([]string{a.CLI, "info", "-vv"}, parseInfoLine)
+	if err != nil {
+		return nil, err
+	}
+
+	outdated, err := a.run([]string{a.CLI, "version", "-l", "<"}, parseVersionLine)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make(map[string]string, len(outdated))
+	for _, pkg := range outdated {
+		candidates[pkg.Name] = pkg.Version
+	}
+
+	for _, pkg := range packages {
+		if candidate, ok := candidates[pkg.Name]; ok {
+			pkg.Version = candidate
+		}
+	}
+	return packages, nil
+}
+
+// GetUpgradablePackages lists the packages an upgrade would change and returns them as a slice of Package structs,
+// simulating the upgrade leaves out the packages held back by pinned repositories or version constraints
+func (a *Apk) GetUpgradablePackages() ([]*models.Package, error) {
+	return a.run([]string{a.CLI, "upgrade", "--simulate"}, parseUpgradeLine)
+}
+
+// run runs the read-only command given as arguments and parses each line of its output with parseFunc
+func (a *Apk) run(args []string, parseFunc func(string) (*models.Package, error)) ([]*models.Package, error) {
+	command := commandrunner.ExecCommand{Args: args}
+
+	result, err := a.CommandRunner.RunCommand(command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute command: %s, err: %w", command.CommandLine(), classifyError(err))
+	}
+
+	packages, err := packagemanager.ParseOutputCommand(result.Scanner(), parseFunc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse output: %w", err)
+	}
+
+	return packages, nil
+}
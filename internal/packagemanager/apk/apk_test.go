@@ -0,0 +1,137 @@
+package apk
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+	"slices"
+	"testing"
+)
+
+// fakeApk is a stand-in for apk printing the fixtures, it finds them in the directory given as FIXTURES
+const fakeApk = `#!/bin/sh
+case "$*" in
+"info -vv") cat "$FIXTURES/info-vv.txt" ;;
+"version -l <") cat "$FIXTURES/version.txt" ;;
+"upgrade --simulate") cat "$FIXTURES/upgrade-simulate.txt" ;;
+*) echo "ERROR: unexpected arguments: $*" >&2; exit 1 ;;
+esac
+`
+
+func TestApk_BashCommandRunner(t *testing.T) {
+	fixtures, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatalf("failed to resolve fixtures: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "apk"), []byte(fakeApk), 0o755); err != nil {
+		t.Fatalf("failed to write fake apk: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FIXTURES", fixtures)
+
+	apk := &Apk{CLI: "apk", CommandRunner: &commandrunner.BashCommandRunner{}}
+
+	packages, err := apk.GetPackages()
+	if err != nil {
+		t.Fatalf("GetPackages() failed: %v", err)
+	}
+	if len(packages) != 6 {
+		t.Fatalf("GetPackages() returned %d packages, want 6", len(packages))
+	}
+	// the candidate versions of apk version replace the installed ones
+	busybox := &models.Package{Name: "busybox", InstalledVersion: "1.36.1-r29", Version: "1.36.1-r31", Installed: true}
+	if !packages[1].Equals(busybox) {
+		t.Errorf("Package at index 1 is = %v, expected = %v", packages[1], busybox)
+	}
+	baselayout := &models.Package{Name: "alpine-baselayout", InstalledVersion: "3.6.5-r0", Version: "3.6.5-r0", Installed: true}
+	if !packages[0].Equals(baselayout) {
+		t.Errorf("Package at index 0 is = %v, expected = %v", packages[0], baselayout)
+	}
+
+	upgradable, err := apk.GetUpgradablePackages()
+	if err != nil {
+		t.Fatalf("GetUpgradablePackages() failed: %v", err)
+	}
+	if len(upgradable) != 3 {
+		t.Errorf("GetUpgradablePackages() returned %d packages, want 3", len(upgradable))
+	}
+}
+
+func TestApk_LockedError(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{
+		ExitCode: 99,
+		Stderr:   "ERROR: Unable to lock database: temporary error (try again later)",
+	}
+	apk := &Apk{CLI: "apk", CommandRunner: mockRunner}
+
+	_, err := apk.GetUpgradablePackages()
+	if !errors.Is(err, packagemanager.ErrLocked) {
+		t.Fatalf("GetUpgradablePackages() error = %v, want %v", err, packagemanager.ErrLocked)
+	}
+}
+
+func TestApk_UpdateArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		run    func(a *Apk, output chan<- string) error
+		expect []string
+	}{
+		{
+			name:   "refresh",
+			run:    func(a *Apk, output chan<- string) error { return a.Refresh(output) },
+			expect: []string{"apk", "update"},
+		},
+		{
+			name: "update package",
+			run: func(a *Apk, output chan<- string) error {
+				return a.UpdatePackage(&models.Package{Name: "musl"}, output)
+			},
+			expect: []string{"apk", "upgrade", "musl"},
+		},
+		{
+			name:   "update all packages",
+			run:    func(a *Apk, output chan<- string) error { return a.UpdateAllPackages(output) },
+			expect: []string{"apk", "upgrade"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &commandrunner.MockCommandRunner{Output: "OK: 8 MiB in 15 packages"}
+			apk := &Apk{CLI: "apk", CommandRunner: mockRunner}
+
+			output := make(chan string)
+			go func() {
+				for range output {
+				}
+			}()
+
+			if err := tt.run(apk, output); err != nil {
+				t.Fatalf("%s failed: %v", tt.name, err)
+			}
+			commands := mockRunner.Commands()
+			if len(commands) != 1 || !slices.Equal(commands[0].Args, tt.expect) || !commands[0].Elevated {
+				t.Errorf("commands = %v, want the elevated command %v", commands, tt.expect)
+			}
+		})
+	}
+}
+
+func TestApk_InvalidName(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{}
+	apk := &Apk{CLI: "apk", CommandRunner: mockRunner}
+
+	if _, err := apk.UpdatePackageSimulation(&models.Package{Name: "--allow-untrusted"}); !errors.Is(err, packagemanager.ErrInvalidPackageName) {
+		t.Errorf("UpdatePackageSimulation() error = %v, want %v", err, packagemanager.ErrInvalidPackageName)
+	}
+	if err := apk.UpdatePackage(&models.Package{Name: "-s"}, make(chan string)); !errors.Is(err, packagemanager.ErrInvalidPackageName) {
+		t.Errorf("UpdatePackage() error = %v, want %v", err, packagemanager.ErrInvalidPackageName)
+	}
+	if commands := mockRunner.Commands(); len(commands) != 0 {
+		t.Errorf("commands = %v, want none for invalid names", commands)
+	}
+}
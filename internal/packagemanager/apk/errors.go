@@ -0,0 +1,6 @@
+package apk
+
+import "sahand.dev/chisme/internal/packagemanager"
+
+// classifyError recognizes the message apk prints when it fails to lock the package database
+var classifyError = packagemanager.LockClassifier(packagemanager.OutputContains("Unable to lock database"))
@@ -0,0 +1,112 @@
+package apk
+
+import (
+	"fmt"
+	"regexp"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+	"strings"
+)
+
+// upgradeLinePattern matches the lines of apk upgrade naming a package it upgrades, like
+// (1/2) Upgrading musl (1.2.5-r0 -> 1.2.5-r1)
+var upgradeLinePattern = regexp.MustCompile(`^\(\d+/\d+\) Upgrading (\S+) \((\S+) -> (\S+)\)$`)
+
+// releasePattern matches the release suffix that ends every version of an Alpine package, like -r0
+var releasePattern = regexp.MustCompile(`-r\d+$`)
+
+// parseInfoLine parses a line of output from `apk info -vv`, the package with its version followed by its
+// description, into a Package struct
+func parseInfoLine(line string) (*models.Package, error) {
+	if shouldSkipLine(line) {
+		return nil, packagemanager.SkippingLineError
+	}
+
+	fields := strings.Fields(line)
+	name, version, err := splitNameVersion(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Package{
+		Name:             name,
+		Version:          version,
+		InstalledVersion: version,
+		Installed:        true,
+	}, nil
+}
+
+// parseVersionLine parses a line of output from `apk version -l '<'`, the installed package with its version
+// followed by the relation and the candidate version, into a Package struct
+func parseVersionLine(line string) (*models.Package, error) {
+	if shouldSkipLine(line) || strings.HasPrefix(line, "Installed:") {
+		return nil, packagemanager.SkippingLineError
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("unexpected number of fields in line: %s", line)
+	}
+	if fields[1] != "<" {
+		return nil, packagemanager.SkippingLineError
+	}
+
+	name, installedVersion, err := splitNameVersion(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Package{
+		Name:             name,
+		Version:          fields[2],
+		InstalledVersion: installedVersion,
+		Installed:        true,
+	}, nil
+}
+
+// parseUpgradeLine parses a line of output from `apk upgrade --simulate` into a Package struct, only the
+// packages being upgraded are kept, those installed or removed along with them are skipped
+func parseUpgradeLine(line string) (*models.Package, error) {
+	match := upgradeLinePattern.FindStringSubmatch(strings.TrimSpace(line))
+	if match == nil {
+		return nil, packagemanager.SkippingLineError
+	}
+
+	return &models.Package{
+		Name:             match[1],
+		Version:          match[3],
+		InstalledVersion: match[2],
+		Installed:        true,
+	}, nil
+}
+
+// shouldSkipLine checks if the line should be skipped based on its content and prefix
+func shouldSkipLine(line string) bool {
+	if strings.TrimSpace(line) == "" {
+		return true
+	}
+
+	for _, prefix := range []string{"WARNING", "ERROR", "fetch "} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitNameVersion splits a package as apk prints it, in the format name-version-rN. The name may contain
+// hyphens, the version is what follows the last hyphen before the release
+func splitNameVersion(field string) (string, string, error) {
+	release := releasePattern.FindStringIndex(field)
+	if release == nil {
+		return "", "", fmt.Errorf("failed to extract package version from field: %s", field)
+	}
+
+	i := strings.LastIndexByte(field[:release[0]], '-')
+	if i <= 0 || i+1 == release[0] {
+		return "", "", fmt.Errorf("failed to extract package name from field: %s", field)
+	}
+
+	return field[:i], field[i+1:], nil
+}
@@ -0,0 +1,296 @@
+package apk
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+	"testing"
+)
+
+func TestParseFixtures(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixture  string
+		parser   func(string) (*models.Package, error)
+		expected []*models.Package
+	}{
+		{
+			name:    "apk info -vv",
+			fixture: "testdata/info-vv.txt",
+			parser:  parseInfoLine,
+			expected: []*models.Package{
+				{Name: "alpine-baselayout", InstalledVersion: "3.6.5-r0", Version: "3.6.5-r0", Installed: true},
+				{Name: "busybox", InstalledVersion: "1.36.1-r29", Version: "1.36.1-r29", Installed: true},
+				{Name: "ca-certificates-bundle", InstalledVersion: "20240705-r0", Version: "20240705-r0", Installed: true},
+				{Name: "libcrypto3", InstalledVersion: "3.3.1-r3", Version: "3.3.1-r3", Installed: true},
+				{Name: "musl", InstalledVersion: "1.2.5-r0", Version: "1.2.5-r0", Installed: true},
+				{Name: "py3-setuptools", InstalledVersion: "70.3.0-r0", Version: "70.3.0-r0", Installed: true},
+			},
+		},
+		{
+			name:    "apk version -l '<'",
+			fixture: "testdata/version.txt",
+			parser:  parseVersionLine,
+			expected: []*models.Package{
+				{Name: "busybox", InstalledVersion: "1.36.1-r29", Version: "1.36.1-r31", Installed: true},
+				{Name: "libcrypto3", InstalledVersion: "3.3.1-r3", Version: "3.3.2-r0", Installed: true},
+				{Name: "musl", InstalledVersion: "1.2.5-r0", Version: "1.2.5-r1", Installed: true},
+			},
+		},
+		{
+			name:    "apk upgrade --simulate",
+			fixture: "testdata/upgrade-simulate.txt",
+			parser:  parseUpgradeLine,
+			expected: []*models.Package{
+				{Name: "musl", InstalledVersion: "1.2.5-r0", Version: "1.2.5-r1", Installed: true},
+				{Name: "busybox", InstalledVersion: "1.36.1-r29", Version: "1.36.1-r31", Installed: true},
+				{Name: "libcrypto3", InstalledVersion: "3.3.1-r3", Version: "3.3.2-r0", Installed: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture, err := os.Open(tt.fixture)
+			if err != nil {
+				t.Fatalf("failed to open fixture: %v", err)
+			}
+			defer fixture.Close()
+
+			packages, err := packagemanager.ParseOutputCommand(bufio.NewScanner(fixture), tt.parser)
+			if err != nil {
+				t.Fatalf("ParseOutputCommand() error = %v", err)
+			}
+			if len(packages) != len(tt.expected) {
+				t.Fatalf("ParseOutputCommand() returned %d packages, want %d", len(packages), len(tt.expected))
+			}
+			for i := range packages {
+				if !packages[i].Equals(tt.expected[i]) {
+					t.Errorf("Package at index %d is = %v, expected = %v", i, packages[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseInfoLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected *models.Package
+		err      bool
+	}{
+		{
+			name:     "package with description",
+			line:     "musl-1.2.5-r0 - the musl c library (libc) implementation",
+			expected: &models.Package{Name: "musl", InstalledVersion: "1.2.5-r0", Version: "1.2.5-r0", Installed: true},
+		},
+		{
+			name:     "hyphenated name and description",
+			line:     "ca-certificates-bundle-20240705-r0 - Pre generated bundle of Mozilla certificates - ca-bundle",
+			expected: &models.Package{Name: "ca-certificates-bundle", InstalledVersion: "20240705-r0", Version: "20240705-r0", Installed: true},
+		},
+		{
+			name:     "version with a suffix",
+			line:     "gcc-13.2.1_git20240309-r0 - The GNU Compiler Collection",
+			expected: &models.Package{Name: "gcc", InstalledVersion: "13.2.1_git20240309-r0", Version: "13.2.1_git20240309-r0", Installed: true},
+		},
+		{
+			name:     "no description",
+			line:     "busybox-1.36.1-r29",
+			expected: &models.Package{Name: "busybox", InstalledVersion: "1.36.1-r29", Version: "1.36.1-r29", Installed: true},
+		},
+		{
+			name: "warning",
+			line: "WARNING: opening /var/cache/apk: No such file or directory",
+			err:  true,
+		},
+		{
+			name: "missing release",
+			line: "musl-1.2.5 - the musl c library (libc) implementation",
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseInfoLine(tt.line)
+			if (err != nil) != tt.err {
+				t.Errorf("parseInfoLine() error = %v, want error: %v", err, tt.err)
+				return
+			}
+			if !result.Equals(tt.expected) {
+				t.Errorf("parseInfoLine() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseVersionLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected *models.Package
+		err      error
+	}{
+		{
+			name:     "outdated package",
+			line:     "musl-1.2.5-r0                           < 1.2.5-r1",
+			expected: &models.Package{Name: "musl", InstalledVersion: "1.2.5-r0", Version: "1.2.5-r1", Installed: true},
+		},
+		{
+			name: "header",
+			line: "Installed:                                Available:",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name: "up to date package",
+			line: "musl-1.2.5-r1 = 1.2.5-r1",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name: "newer than the repository",
+			line: "musl-1.2.5-r2 > 1.2.5-r1",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name:     "hyphenated name",
+			line:     "ca-certificates-bundle-20240705-r0   < 20240910-r0",
+			expected: &models.Package{Name: "ca-certificates-bundle", InstalledVersion: "20240705-r0", Version: "20240910-r0", Installed: true},
+		},
+		{
+			name: "warning",
+			line: "WARNING: opening from cache https://dl-cdn.alpinelinux.org/alpine/v3.20/main: No such file or directory",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name: "invalid format",
+			line: "musl-1.2.5-r0 <",
+			err:  fmt.Errorf("unexpected number of fields in line: musl-1.2.5-r0 <"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseVersionLine(tt.line)
+			if (err != nil && tt.err == nil) || (err == nil && tt.err != nil) || (err != nil && tt.err != nil && err.Error() != tt.err.Error()) {
+				t.Errorf("parseVersionLine() error = %v, want %v", err, tt.err)
+				return
+			}
+			if !result.Equals(tt.expected) {
+				t.Errorf("parseVersionLine() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseUpgradeLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected *models.Package
+		err      error
+	}{
+		{
+			name:     "upgrade",
+			line:     "(1/2) Upgrading musl (1.2.5-r0 -> 1.2.5-r1)",
+			expected: &models.Package{Name: "musl", InstalledVersion: "1.2.5-r0", Version: "1.2.5-r1", Installed: true},
+		},
+		{
+			name: "new dependency",
+			line: "(2/2) Installing libssl3 (3.3.2-r0)",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name: "summary",
+			line: "OK: 8 MiB in 15 packages",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name:     "indented upgrade",
+			line:     "  (3/12) Upgrading busybox (1.36.1-r29 -> 1.36.1-r31)",
+			expected: &models.Package{Name: "busybox", InstalledVersion: "1.36.1-r29", Version: "1.36.1-r31", Installed: true},
+		},
+		{
+			name: "downgrade",
+			line: "(1/1) Downgrading musl (1.2.5-r1 -> 1.2.5-r0)",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name: "replacement",
+			line: "(1/1) Replacing busybox-binsh (1.36.1-r29 -> 1.36.1-r31)",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name: "trigger",
+			line: "Executing busybox-1.36.1-r31.trigger",
+			err:  packagemanager.SkippingLineError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseUpgradeLine(tt.line)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("parseUpgradeLine() error = %v, want %v", err, tt.err)
+				return
+			}
+			if !result.Equals(tt.expected) {
+				t.Errorf("parseUpgradeLine() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestShouldSkipLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected bool
+	}{
+		{"", true},
+		{"WARNING: Ignoring https://dl-cdn.alpinelinux.org/alpine/v3.20/main: No such file or directory", true},
+		{"fetch https://dl-cdn.alpinelinux.org/alpine/v3.20/main/x86_64/APKINDEX.tar.gz", true},
+		{"musl-1.2.5-r0 - the musl c library (libc) implementation", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			actual := shouldSkipLine(tt.line)
+			if actual != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSplitNameVersion(t *testing.T) {
+	tests := []struct {
+		field   string
+		name    string
+		version string
+		err     bool
+	}{
+		{"musl-1.2.5-r0", "musl", "1.2.5-r0", false},
+		{"ca-certificates-bundle-20240705-r0", "ca-certificates-bundle", "20240705-r0", false},
+		{"gcc-13.2.1_git20240309-r0", "gcc", "13.2.1_git20240309-r0", false},
+		{"musl-1.2.5", "", "", true},
+		{"1.2.5-r0", "", "", true},
+		{"musl--r0", "", "", true},
+		{"", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("field=%s", tt.field), func(t *testing.T) {
+			name, version, err := splitNameVersion(tt.field)
+			if (err != nil) != tt.err {
+				t.Errorf("expected err: %v, got: %v", tt.err, err)
+			}
+			if name != tt.name || version != tt.version {
+				t.Errorf("expected: %s %s, got: %s %s", tt.name, tt.version, name, version)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package apk
+
+import (
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+)
+
+// UpdatePackageSimulation simulates updating a package and returns a channel to read the output and stderr combined
+func (a *Apk) UpdatePackageSimulation(pkg *models.Package) (<-chan string, error) {
+	if err := packagemanager.ValidatePackageName(packagemanager.AlpinePackageName, pkg.Name); err != nil {
+		return nil, err
+	}
+	command := commandrunner.ExecCommand{Args: []string{a.CLI, "upgrade", "--simulate", pkg.Name}, Elevated: true}
+
+	return packagemanager.StreamOutput(a.CommandRunner, command)
+}
+
+// UpdatePackage updates a package and forwards the output to the output channel, in case the command fails
+// the error is returned
+func (a *Apk) UpdatePackage(pkg *models.Package, output chan<- string) error {
+	if err := packagemanager.ValidatePackageName(packagemanager.AlpinePackageName, pkg.Name); err != nil {
+		return err
+	}
+
+	return a.exec([]string{a.CLI, "upgrade", pkg.Name}, output)
+}
+
+// UpdateAllPackages updates all packages and forwards the output to the output channel, in case the command fails
+// the error is returned
+func (a *Apk) UpdateAllPackages(output chan<- string) error {
+	return a.exec([]string{a.CLI, "upgrade"}, output)
+}
+
+// Refresh downloads the indexes of the repositories
+func (a *Apk) Refresh(output chan<- string) error {
+	return a.exec([]string{a.CLI, "update"}, output)
+}
+
+// exec runs the command given as arguments and forwards its stdout and stderr lines to the output channel, it returns
+// as soon as the command finished while the remaining lines keep being delivered until the output channel is closed
+func (a *Apk) exec(args []string, output chan<- string) error {
+	command := commandrunner.ExecCommand{Args: args, Elevated: true}
+
+	return packagemanager.Exec(a.CommandRunner, command, output, classifyError)
+}
@@ -6,40 +6,75 @@ import (
 	"sahand.dev/chisme/internal/persistence/models"
 )
 
+// aptEnv keeps apt from prompting and its output parseable independent of the locale of the host
+var aptEnv = map[string]string{
+	"DEBIAN_FRONTEND":  "noninteractive",
+	"LC_ALL":           "C",
+	"NEEDRESTART_MODE": "a",
+}
+
+// dpkgQueryFormat prints the name, the version and the status of each package known to dpkg
+const dpkgQueryFormat = `${Package} ${Version} ${Status}\n`
+
 // Apt is a struct that represents the apt packagemanager manager
 type Apt struct {
 	CLI           string
 	CommandRunner commandrunner.CommandRunner
 }
 
-// GetPackages lists all packages and returns them as a slice of Package structs
+// GetPackages lists all packages and returns them as a slice of Package structs. apt-get cannot list packages,
+// on hosts without apt only the installed packages are listed
 func (a *Apt) GetPackages() ([]*models.Package, error) {
-	command := fmt.Sprintf("%s list", a.CLI)
+	if a.CLI == "apt-get" {
+		return a.getInstalledPackages()
+	}
 
-	scanner, err := a.CommandRunner.RunCommand(commandrunner.ExecCommand{Command: command})
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute command: %s, err: %w", command, err)
+	return a.query(commandrunner.ExecCommand{Args: []string{a.CLI, "list"}, Env: aptEnv}, parseLineToPackage)
+}
+
+// GetUpgradablePackages lists all upgradeable packages and returns them as a slice of Package structs, with
+// apt-get they are the packages a simulated dist-upgrade would upgrade
+func (a *Apt) GetUpgradablePackages() ([]*models.Package, error) {
+	if a.CLI == "apt-get" {
+		return a.query(commandrunner.ExecCommand{Args: []string{a.CLI, "--simulate", "dist-upgrade"}, Env: aptEnv}, parseSimulatedUpgradeLine)
 	}
 
-	packages, err := parseOutputCommand(scanner, parseLineToPackage)
+	return a.query(commandrunner.ExecCommand{Args: []string{a.CLI, "list", "--upgradable"}, Env: aptEnv}, parseLineToPackage)
+}
+
+// getInstalledPackages lists the installed packages with dpkg-query, the version of a package is the candidate
+// version when apt-get would upgrade it and the installed version otherwise
+func (a *Apt) getInstalledPackages() ([]*models.Package, error) {
+	installed, err := a.query(commandrunner.ExecCommand{Args: []string{"dpkg-query", "-W", "-f", dpkgQueryFormat}, Env: aptEnv}, parseDpkgQueryLine)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse output: %w", err)
+		return nil, err
 	}
 
-	return packages, nil
+	upgradable, err := a.GetUpgradablePackages()
+	if err != nil {
+		return nil, err
+	}
+	candidates := make(map[string]string, len(upgradable))
+	for _, pkg := range upgradable {
+		candidates[pkg.Name] = pkg.Version
+	}
 
+	for _, pkg := range installed {
+		if candidate, ok := candidates[pkg.Name]; ok {
+			pkg.Version = candidate
+		}
+	}
+	return installed, nil
 }
 
-// GetUpgradablePackages lists all upgradeable packages and returns them as a slice of Package structs
-func (a *Apt) GetUpgradablePackages() ([]*models.Package, error) {
-	command := fmt.Sprintf("%s list --upgradable", a.CLI)
-
-	scanner, err := a.CommandRunner.RunCommand(commandrunner.ExecCommand{Command: command})
+// query runs the read-only command and parses each line of its output with the parser
+func (a *Apt) query(command commandrunner.ExecCommand, parseFunc func(string) (*models.Package, error)) ([]*models.Package, error) {
+	result, err := a.CommandRunner.RunCommand(command)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute command: %s, err: %w", command, err)
+		return nil, fmt.Errorf("failed to execute command: %s, err: %w", command.CommandLine(), classifyError(err))
 	}
 
-	packages, err := parseOutputCommand(scanner, parseLineToPackage)
+	packages, err := parseOutputCommand(result.Scanner(), parseFunc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse output: %w", err)
 	}
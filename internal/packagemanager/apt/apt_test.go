@@ -3,6 +3,7 @@ package apt
 import (
 	"errors"
 	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
 	"sahand.dev/chisme/internal/persistence/models"
 	"strings"
 	"testing"
@@ -66,6 +67,45 @@ libc6-dev/now 2.27-3ubuntu1.2 amd64 [upgradable from: 2.27-3ubuntu1.1]
 	}
 }
 
+func TestApt_AptGetGetPackages(t *testing.T) {
+	replay, err := commandrunner.NewReplayRunner(&commandrunner.Cassette{Interactions: []commandrunner.Interaction{
+		{
+			Command: "dpkg-query -W -f .*",
+			Regex:   true,
+			Stdout:  "curl 7.88.1-10+deb12u6 install ok installed\nexim4-base 4.96-15+deb12u5 deinstall ok config-files\nlibc6 2.36-9+deb12u7 install ok installed\n",
+		},
+		{
+			Command: "apt-get --simulate dist-upgrade",
+			Stdout:  "NOTE: This is only a simulation!\nInst libc6 [2.36-9+deb12u7] (2.36-9+deb12u8 Debian:12.7/stable [amd64]) []\nConf libc6 (2.36-9+deb12u8 Debian:12.7/stable [amd64])\n",
+		},
+	}})
+	if err != nil {
+		t.Fatalf("NewReplayRunner() error = %v", err)
+	}
+	apt := &Apt{CLI: "apt-get", CommandRunner: replay}
+
+	packages, err := apt.GetPackages()
+	if err != nil {
+		t.Fatalf("GetPackages() failed: %v", err)
+	}
+
+	expected := []*models.Package{
+		{Name: "curl", InstalledVersion: "7.88.1-10+deb12u6", Version: "7.88.1-10+deb12u6", Installed: true},
+		{Name: "libc6", InstalledVersion: "2.36-9+deb12u7", Version: "2.36-9+deb12u8", Installed: true},
+	}
+	if len(packages) != len(expected) {
+		t.Fatalf("GetPackages() returned %d packages, want %d", len(packages), len(expected))
+	}
+	for i := range packages {
+		if !packages[i].Equals(expected[i]) {
+			t.Errorf("Package at index %d is = %v, expected = %v", i, packages[i], expected[i])
+		}
+	}
+	if remaining := replay.Remaining(); len(remaining) != 0 {
+		t.Errorf("commands not run: %v", remaining)
+	}
+}
+
 func TestApt_CommandRunnerError(t *testing.T) {
 	mockRunner := &commandrunner.MockCommandRunner{Err: []error{errors.New("command failed")}}
 	apt := &Apt{
@@ -115,3 +155,90 @@ func TestPackageVersionMismatch(t *testing.T) {
 		}
 	}
 }
+
+func TestApt_LockedError(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{
+		ExitCode: 100,
+		Stderr:   "E: Could not get lock /var/lib/dpkg/lock-frontend. It is held by process 1234 (apt)",
+	}
+	apt := &Apt{
+		CLI:           "apt",
+		CommandRunner: mockRunner,
+	}
+
+	_, err := apt.GetUpgradablePackages()
+	if !errors.Is(err, packagemanager.ErrLocked) {
+		t.Fatalf("GetUpgradablePackages() error = %v, want %v", err, packagemanager.ErrLocked)
+	}
+
+	var exitErr *commandrunner.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Result.ExitCode != 100 {
+		t.Fatalf("GetUpgradablePackages() error = %v, expected to wrap the exit error with code 100", err)
+	}
+}
+
+func TestApt_ExitErrorIsNotLocked(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{ExitCode: 100, Stderr: "E: Unable to locate package foo"}
+	apt := &Apt{
+		CLI:           "apt",
+		CommandRunner: mockRunner,
+	}
+
+	_, err := apt.GetPackages()
+	if err == nil || errors.Is(err, packagemanager.ErrLocked) {
+		t.Fatalf("GetPackages() error = %v, expected an error that is not %v", err, packagemanager.ErrLocked)
+	}
+}
+
+func TestApt_ReplayUbuntuSession(t *testing.T) {
+	cassette, err := commandrunner.LoadCassette("testdata/ubuntu-noble.json")
+	if err != nil {
+		t.Fatalf("LoadCassette() error = %v", err)
+	}
+	replay, err := commandrunner.NewReplayRunner(cassette)
+	if err != nil {
+		t.Fatalf("NewReplayRunner() error = %v", err)
+	}
+	apt := &Apt{CLI: "apt", CommandRunner: replay}
+
+	packages, err := apt.GetPackages()
+	if err != nil {
+		t.Fatalf("GetPackages() failed: %v", err)
+	}
+	if len(packages) != 3 {
+		t.Fatalf("GetPackages() returned %d packages, want 3", len(packages))
+	}
+
+	upgradable, err := apt.GetUpgradablePackages()
+	if err != nil {
+		t.Fatalf("GetUpgradablePackages() failed: %v", err)
+	}
+	expected := []*models.Package{
+		{Name: "curl", InstalledVersion: "8.5.0-2ubuntu10.4", Version: "8.5.0-2ubuntu10.6", Installed: true},
+		{Name: "libcurl4t64", InstalledVersion: "8.5.0-2ubuntu10.4", Version: "8.5.0-2ubuntu10.6", Installed: true},
+	}
+	if len(upgradable) != len(expected) {
+		t.Fatalf("GetUpgradablePackages() returned %d packages, want %d", len(upgradable), len(expected))
+	}
+	for i := range upgradable {
+		if !upgradable[i].Equals(expected[i]) {
+			t.Errorf("Package at index %d is = %v, expected = %v", i, upgradable[i], expected[i])
+		}
+	}
+
+	output, err := apt.UpdatePackageSimulation(upgradable[0])
+	if err != nil {
+		t.Fatalf("UpdatePackageSimulation() failed: %v", err)
+	}
+	simulation := ""
+	for line := range output {
+		simulation += line + "\n"
+	}
+	if !strings.Contains(simulation, "Inst curl [8.5.0-2ubuntu10.4]") {
+		t.Errorf("UpdatePackageSimulation() output = %q, want it to contain the upgrade of curl", simulation)
+	}
+
+	if remaining := replay.Remaining(); len(remaining) != 0 {
+		t.Errorf("commands not run: %v", remaining)
+	}
+}
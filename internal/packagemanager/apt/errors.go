@@ -0,0 +1,10 @@
+package apt
+
+import "sahand.dev/chisme/internal/packagemanager"
+
+// classifyError recognizes the messages apt and dpkg print when they fail to acquire their locks
+var classifyError = packagemanager.LockClassifier(packagemanager.OutputContains(
+	"Could not get lock",
+	"Unable to acquire the dpkg frontend lock",
+	"Unable to lock directory",
+))
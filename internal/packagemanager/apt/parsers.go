@@ -4,31 +4,17 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"sahand.dev/chisme/internal/packagemanager"
 	"sahand.dev/chisme/internal/persistence/models"
 	"strings"
 )
 
-var SkippingLineError = errors.New("skipping line")
+// SkippingLineError is returned for lines that carry no package
+var SkippingLineError = packagemanager.SkippingLineError
 
 // parseOutputCommand accepts a scanner and a function to parse each line of the output of a command
 func parseOutputCommand[T any](scanner *bufio.Scanner, parseFunc func(string) (T, error)) ([]T, error) {
-	var output []T
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		parsed, err := parseFunc(line)
-		if err != nil {
-			if errors.Is(err, SkippingLineError) {
-				continue
-			}
-			return nil, fmt.Errorf("failed to parse line: %w", err)
-		}
-
-		output = append(output, parsed)
-	}
-
-	return output, nil
+	return packagemanager.ParseOutputCommand(scanner, parseFunc)
 }
 
 // parseLineToUpgradablePackage parses a line of output from `apt list --upgradable` into a Package struct
@@ -72,6 +58,48 @@ func parseLineToPackage(line string) (*models.Package, error) {
 	}, nil
 }
 
+// parseDpkgQueryLine parses a line of output from `dpkg-query -W` printed with dpkgQueryFormat into a Package
+// struct, packages that are removed or only have their configuration files left are skipped
+func parseDpkgQueryLine(line string) (*models.Package, error) {
+	const expectedFields = 5
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[len(fields)-1] != "installed" {
+		return nil, SkippingLineError
+	}
+	if len(fields) != expectedFields {
+		return nil, fmt.Errorf("unexpected number of fields in line: %s", line)
+	}
+
+	return &models.Package{
+		Name:             fields[0],
+		Version:          fields[1],
+		InstalledVersion: fields[1],
+		Installed:        true,
+	}, nil
+}
+
+// parseSimulatedUpgradeLine parses an Inst line of `apt-get --simulate dist-upgrade` into a Package struct, the
+// line is in the format `Inst pkgname[:arch] [installed] (candidate release [arch])`. Packages newly installed by
+// the upgrade have no installed version and are skipped along with the other lines
+func parseSimulatedUpgradeLine(line string) (*models.Package, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "Inst" || len(fields) > 2 && !strings.HasPrefix(fields[2], "[") {
+		return nil, SkippingLineError
+	}
+	if len(fields) < 4 || !strings.HasSuffix(fields[2], "]") || !strings.HasPrefix(fields[3], "(") {
+		return nil, fmt.Errorf("unexpected format of line: %s", line)
+	}
+
+	name, _, _ := strings.Cut(fields[1], ":")
+	return &models.Package{
+		Name:             name,
+		Version:          strings.TrimPrefix(fields[3], "("),
+		InstalledVersion: strings.TrimSuffix(strings.TrimPrefix(fields[2], "["), "]"),
+		Installed:        true,
+	}, nil
+}
+
 // shouldSkipLine checks if the line should be skipped based on it's size and prefix
 func shouldSkipLine(line string) bool {
 	if len(line) == 0 {
@@ -183,6 +183,117 @@ func TestParseLineToPackage(t *testing.T) {
 	}
 }
 
+func TestParseDpkgQueryLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected *models.Package
+		err      error
+	}{
+		{
+			name:     "installed package",
+			line:     "libc6 2.36-9+deb12u7 install ok installed",
+			expected: &models.Package{Name: "libc6", InstalledVersion: "2.36-9+deb12u7", Version: "2.36-9+deb12u7", Installed: true},
+		},
+		{
+			name:     "held package",
+			line:     "linux-image-amd64 6.1.106-3 hold ok installed",
+			expected: &models.Package{Name: "linux-image-amd64", InstalledVersion: "6.1.106-3", Version: "6.1.106-3", Installed: true},
+		},
+		{
+			name: "configuration files left",
+			line: "exim4-base 4.96-15+deb12u5 deinstall ok config-files",
+			err:  SkippingLineError,
+		},
+		{
+			name: "purged package without a version",
+			line: "libfoo1  purge ok not-installed",
+			err:  SkippingLineError,
+		},
+		{
+			name: "empty line",
+			line: "",
+			err:  SkippingLineError,
+		},
+		{
+			name: "invalid format",
+			line: "libc6 install ok installed",
+			err:  fmt.Errorf("unexpected number of fields in line: libc6 install ok installed"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseDpkgQueryLine(tt.line)
+			if (err != nil && tt.err == nil) || (err == nil && tt.err != nil) || (err != nil && tt.err != nil && err.Error() != tt.err.Error()) {
+				t.Errorf("parseDpkgQueryLine() error = %v, want %v", err, tt.err)
+				return
+			}
+			if !result.Equals(tt.expected) {
+				t.Errorf("parseDpkgQueryLine() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseSimulatedUpgradeLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected *models.Package
+		err      error
+	}{
+		{
+			name:     "upgrade",
+			line:     "Inst libc6 [2.36-9+deb12u7] (2.36-9+deb12u8 Debian:12.7/stable [amd64]) []",
+			expected: &models.Package{Name: "libc6", InstalledVersion: "2.36-9+deb12u7", Version: "2.36-9+deb12u8", Installed: true},
+		},
+		{
+			name:     "foreign architecture",
+			line:     "Inst libssl3:i386 [3.0.13-1~deb12u1] (3.0.14-1~deb12u2 Debian-Security:12/stable-security [i386])",
+			expected: &models.Package{Name: "libssl3", InstalledVersion: "3.0.13-1~deb12u1", Version: "3.0.14-1~deb12u2", Installed: true},
+		},
+		{
+			name: "new package",
+			line: "Inst linux-image-6.1.0-26-amd64 (6.1.112-1 Debian-Security:12/stable-security [amd64])",
+			err:  SkippingLineError,
+		},
+		{
+			name: "configure",
+			line: "Conf libc6 (2.36-9+deb12u8 Debian:12.7/stable [amd64])",
+			err:  SkippingLineError,
+		},
+		{
+			name: "simulation note",
+			line: "NOTE: This is only a simulation!",
+			err:  SkippingLineError,
+		},
+		{
+			name: "empty line",
+			line: "",
+			err:  SkippingLineError,
+		},
+		{
+			name: "invalid format",
+			line: "Inst libc6 [2.36-9+deb12u7]",
+			err:  fmt.Errorf("unexpected format of line: Inst libc6 [2.36-9+deb12u7]"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseSimulatedUpgradeLine(tt.line)
+			if (err != nil && tt.err == nil) || (err == nil && tt.err != nil) || (err != nil && tt.err != nil && err.Error() != tt.err.Error()) {
+				t.Errorf("parseSimulatedUpgradeLine() error = %v, want %v", err, tt.err)
+				return
+			}
+			if !result.Equals(tt.expected) {
+				t.Errorf("parseSimulatedUpgradeLine() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
 func TestShouldSkipLine(t *testing.T) {
 	tests := []struct {
 		line     string
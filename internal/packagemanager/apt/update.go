@@ -1,84 +1,45 @@
 package apt
 
 import (
-	"fmt"
-	"log"
 	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
 	"sahand.dev/chisme/internal/persistence/models"
 )
 
 // UpdatePackageSimulation simulates updating a package and returns a channel to read the output and stderr combined
 func (a *Apt) UpdatePackageSimulation(pkg *models.Package) (<-chan string, error) {
-	command := fmt.Sprintf("%s install --only-upgrade --simulate %s", a.CLI, pkg.Name)
-
-	output, outputErrors, err := a.CommandRunner.RunCommandAsync(commandrunner.ExecCommand{Command: command, Elevated: true})
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute command: %s, err: %w", command, err)
+	if err := packagemanager.ValidatePackageName(packagemanager.DebianPackageName, pkg.Name); err != nil {
+		return nil, err
 	}
+	command := commandrunner.ExecCommand{Args: []string{a.CLI, "install", "--only-upgrade", "--simulate", pkg.Name}, Env: aptEnv, Elevated: true}
 
-	go func() {
-		for err := range outputErrors {
-			log.Println(err)
-		}
-	}()
-
-	return output, nil
+	return packagemanager.StreamOutput(a.CommandRunner, command)
 }
 
 // UpdatePackage updates a package and returns a channel to read the output and also listens
 // on stderr and in case of error it will terminate the process and return the error
 func (a *Apt) UpdatePackage(pkg *models.Package, output chan<- string) error {
-	command := fmt.Sprintf("%s install --only-upgrade --simulate %s", a.CLI, pkg.Name)
+	if err := packagemanager.ValidatePackageName(packagemanager.DebianPackageName, pkg.Name); err != nil {
+		return err
+	}
 
-	return a.exec(command, output)
+	return a.exec([]string{a.CLI, "install", "--only-upgrade", "--simulate", pkg.Name}, output)
 }
 
 // UpdateAllPackages updates all packages and returns a channel to read the output and also listens
 // on stderr and in case of error it will terminate the process and return the error
 func (a *Apt) UpdateAllPackages(output chan<- string) error {
-	command := fmt.Sprintf("%s upgrade -y", a.CLI)
-
-	return a.exec(command, output)
+	return a.exec([]string{a.CLI, "upgrade", "-y"}, output)
 }
 
 func (a *Apt) Refresh(output chan<- string) error {
-	command := fmt.Sprintf("%s update", a.CLI)
-
-	return a.exec(command, output)
+	return a.exec([]string{a.CLI, "update"}, output)
 }
 
-// exec runs the specified command and manages stdout and stderr
-func (a *Apt) exec(command string, output chan<- string) error {
-	stdOutput, outputErrors, err := a.CommandRunner.RunCommandAsync(commandrunner.ExecCommand{Command: command, Elevated: true})
-	if err != nil {
-		return fmt.Errorf("failed to execute command: %s, err: %w", command, err)
-	}
-
-	done := make(chan struct{})
-	errorChan := make(chan error, 1)
-
-	go func() {
-		defer close(done)
-		for line := range stdOutput {
-			output <- line
-		}
-		close(output)
-	}()
+// exec runs the command given as arguments and forwards its stdout and stderr lines to the output channel, it returns
+// as soon as the command finished while the remaining lines keep being delivered until the output channel is closed
+func (a *Apt) exec(args []string, output chan<- string) error {
+	command := commandrunner.ExecCommand{Args: args, Env: aptEnv, Elevated: true}
 
-	go func() {
-		for err := range outputErrors {
-			if err != nil {
-				errorChan <- err
-				return
-			}
-		}
-		errorChan <- nil
-	}()
-
-	select {
-	case <-done:
-		return <-errorChan
-	case err := <-errorChan:
-		return err
-	}
+	return packagemanager.Exec(a.CommandRunner, command, output, classifyError)
 }
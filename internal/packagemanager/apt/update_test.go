@@ -3,7 +3,9 @@ package apt
 import (
 	"errors"
 	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
 	"sahand.dev/chisme/internal/persistence/models"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -210,3 +212,71 @@ func TestUpdatePackage_LongRunning(t *testing.T) {
 		}
 	}
 }
+
+func TestRefresh_Locked(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{
+		Output:   "Reading package lists...",
+		Stderr:   "E: Could not get lock /var/lib/apt/lists/lock. It is held by process 4321 (apt-get)",
+		ExitCode: 100,
+	}
+
+	aptManager := &Apt{
+		CommandRunner: mockRunner,
+		CLI:           "apt",
+	}
+
+	output := make(chan string)
+	err := aptManager.Refresh(output)
+	if !errors.Is(err, packagemanager.ErrLocked) {
+		t.Fatalf("expected error %v, got %v", packagemanager.ErrLocked, err)
+	}
+
+	var lines []string
+	for line := range output {
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 2 {
+		t.Fatalf("expected stdout and stderr lines to be forwarded, got %v", lines)
+	}
+}
+
+func TestUpdatePackage_InvalidName(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{}
+	aptManager := &Apt{CommandRunner: mockRunner, CLI: "apt"}
+	pkg := &models.Package{Name: "libc6; reboot"}
+
+	if err := aptManager.UpdatePackage(pkg, make(chan string)); !errors.Is(err, packagemanager.ErrInvalidPackageName) {
+		t.Errorf("UpdatePackage() error = %v, want %v", err, packagemanager.ErrInvalidPackageName)
+	}
+	if _, err := aptManager.UpdatePackageSimulation(pkg); !errors.Is(err, packagemanager.ErrInvalidPackageName) {
+		t.Errorf("UpdatePackageSimulation() error = %v, want %v", err, packagemanager.ErrInvalidPackageName)
+	}
+	if commands := mockRunner.Commands(); len(commands) != 0 {
+		t.Errorf("expected no command to reach the runner, got %v", commands)
+	}
+}
+
+func TestUpdatePackage_Args(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{}
+	aptManager := &Apt{CommandRunner: mockRunner, CLI: "apt"}
+
+	output := make(chan string)
+	if err := aptManager.UpdatePackage(&models.Package{Name: "libstdc++6"}, output); err != nil {
+		t.Fatalf("UpdatePackage() error = %v", err)
+	}
+	for range output {
+	}
+
+	commands := mockRunner.Commands()
+	if len(commands) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(commands))
+	}
+	want := []string{"apt", "install", "--only-upgrade", "--simulate", "libstdc++6"}
+	if !slices.Equal(commands[0].Args, want) || !commands[0].Elevated {
+		t.Errorf("UpdatePackage() ran %v (elevated: %v), want %v elevated", commands[0].Args, commands[0].Elevated, want)
+	}
+	if got := commands[0].Env["DEBIAN_FRONTEND"]; got != "noninteractive" {
+		t.Errorf("UpdatePackage() DEBIAN_FRONTEND = %q, want %q", got, "noninteractive")
+	}
+}
@@ -0,0 +1,167 @@
+package detect
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/packagemanager/apk"
+	"sahand.dev/chisme/internal/packagemanager/apt"
+	"sahand.dev/chisme/internal/packagemanager/dnf"
+	"sahand.dev/chisme/internal/packagemanager/pacman"
+	"sahand.dev/chisme/internal/packagemanager/zypper"
+	"strings"
+)
+
+// ErrNoPackageManager is returned when none of the supported package managers is installed on the host
+var ErrNoPackageManager = errors.New("no supported package manager found")
+
+// binaries are the package managers looked for, in the order they are preferred when the distribution is unknown
+var binaries = []string{"apt", "apt-get", "dnf", "yum", "apk", "pacman", "zypper"}
+
+// binaryDirs are the directories the package managers are installed to
+var binaryDirs = []string{"/usr/bin", "/usr/sbin", "/bin", "/sbin"}
+
+// families map the distributions, by their ID or ID_LIKE, to the package managers they use in order of preference
+var families = []struct {
+	ids      []string
+	binaries []string
+}{
+	{ids: []string{"debian", "ubuntu"}, binaries: []string{"apt", "apt-get"}},
+	{ids: []string{"rhel", "fedora", "centos", "rocky", "almalinux", "ol", "amzn"}, binaries: []string{"dnf", "yum"}},
+	{ids: []string{"alpine"}, binaries: []string{"apk"}},
+	{ids: []string{"arch"}, binaries: []string{"pacman"}},
+	{ids: []string{"suse", "opensuse", "sles"}, binaries: []string{"zypper"}},
+}
+
+// Host is what was detected about a host, the facts about its operating system and its package manager
+type Host struct {
+	OS OSRelease
+	// PackageManagerName is the CLI the package manager runs, like apt, dnf5 or yum
+	PackageManagerName string
+	PackageManager     packagemanager.PackageManger
+}
+
+// Detect reads the os-release file of the host and looks for the binaries of the supported package managers,
+// it returns the package manager of the distribution configured with the runner. When the distribution is
+// unknown the first package manager found is used
+func Detect(runner commandrunner.CommandRunner) (*Host, error) {
+	osRelease, err := readOSRelease(runner)
+	if err != nil {
+		return nil, err
+	}
+
+	installed, err := findBinaries(runner)
+	if err != nil {
+		return nil, err
+	}
+
+	binary, err := choosePackageManager(osRelease, installed)
+	if err != nil {
+		return nil, err
+	}
+
+	packageManager, name, err := newPackageManager(binary, runner)
+	if err != nil {
+		return nil, err
+	}
+	return &Host{
+		OS:                 osRelease,
+		PackageManagerName: name,
+		PackageManager:     packageManager,
+	}, nil
+}
+
+// readOSRelease reads the first os-release file found on the host
+func readOSRelease(runner commandrunner.CommandRunner) (OSRelease, error) {
+	var errs []error
+	for _, osReleasePath := range osReleasePaths {
+		command := commandrunner.ExecCommand{Args: []string{"cat", osReleasePath}}
+
+		result, err := runner.RunCommand(command)
+		var exitErr *commandrunner.ExitError
+		if errors.As(err, &exitErr) {
+			errs = append(errs, err)
+			continue
+		}
+		if err != nil {
+			return OSRelease{}, fmt.Errorf("failed to execute command: %s, err: %w", command.CommandLine(), err)
+		}
+		return parseOSRelease(result.Stdout.String()), nil
+	}
+	return OSRelease{}, fmt.Errorf("failed to read os-release: %w", errors.Join(errs...))
+}
+
+// findBinaries returns the names of the package managers installed on the host. ls lists the paths that exist
+// and fails for the others, which is expected
+func findBinaries(runner commandrunner.CommandRunner) (map[string]bool, error) {
+	args := []string{"ls", "-1", "-d"}
+	for _, dir := range binaryDirs {
+		for _, binary := range binaries {
+			args = append(args, path.Join(dir, binary))
+		}
+	}
+	command := commandrunner.ExecCommand{Args: args}
+
+	result, err := runner.RunCommand(command)
+	var exitErr *commandrunner.ExitError
+	if errors.As(err, &exitErr) {
+		result, err = exitErr.Result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute command: %s, err: %w", command.CommandLine(), err)
+	}
+
+	installed := make(map[string]bool)
+	for _, line := range strings.Split(result.Stdout.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			installed[path.Base(line)] = true
+		}
+	}
+	return installed, nil
+}
+
+// choosePackageManager returns the installed package manager of the distribution, or the first one installed
+// when the distribution is unknown or its package manager is missing
+func choosePackageManager(osRelease OSRelease, installed map[string]bool) (string, error) {
+	for _, family := range families {
+		if !osRelease.is(family.ids...) {
+			continue
+		}
+		for _, binary := range family.binaries {
+			if installed[binary] {
+				return binary, nil
+			}
+		}
+	}
+
+	for _, binary := range binaries {
+		if installed[binary] {
+			return binary, nil
+		}
+	}
+	return "", fmt.Errorf("%w on %s", ErrNoPackageManager, osRelease.ID)
+}
+
+// newPackageManager returns the package manager for the binary running its commands with the runner, along with
+// the CLI it runs. On the rpm based distributions dnf.NewDnf asks the host whether that is dnf5, dnf or yum
+func newPackageManager(binary string, runner commandrunner.CommandRunner) (packagemanager.PackageManger, string, error) {
+	switch binary {
+	case "apt", "apt-get":
+		return &apt.Apt{CLI: binary, CommandRunner: runner}, binary, nil
+	case "dnf", "yum":
+		packageManager, err := dnf.NewDnf(runner)
+		if err != nil {
+			return nil, "", err
+		}
+		return packageManager, packageManager.CLI, nil
+	case "apk":
+		return &apk.Apk{CLI: binary, CommandRunner: runner}, binary, nil
+	case "pacman":
+		return &pacman.Pacman{CLI: binary, CommandRunner: runner}, binary, nil
+	case "zypper":
+		return &zypper.Zypper{CLI: binary, CommandRunner: runner}, binary, nil
+	}
+	return nil, "", fmt.Errorf("%w: %s", ErrNoPackageManager, binary)
+}
@@ -0,0 +1,217 @@
+package detect
+
+import (
+	"errors"
+	"os"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager/apk"
+	"sahand.dev/chisme/internal/packagemanager/apt"
+	"sahand.dev/chisme/internal/packagemanager/dnf"
+	"sahand.dev/chisme/internal/packagemanager/pacman"
+	"sahand.dev/chisme/internal/packagemanager/zypper"
+	"testing"
+)
+
+// detectionCassette records the commands detection runs on a host with the os-release fixture and the binaries
+func detectionCassette(t *testing.T, fixture string, binaries ...string) *commandrunner.Cassette {
+	t.Helper()
+
+	osRelease := commandrunner.Interaction{Command: "cat /etc/os-release", Stderr: "cat: /etc/os-release: No such file or directory", ExitCode: 1}
+	if fixture != "" {
+		content, err := os.ReadFile("testdata/" + fixture)
+		if err != nil {
+			t.Fatalf("failed to read fixture: %v", err)
+		}
+		osRelease = commandrunner.Interaction{Command: "cat /etc/os-release", Stdout: string(content)}
+	}
+
+	ls := commandrunner.Interaction{Command: "ls -1 -d .*", Regex: true, ExitCode: 2}
+	for _, binary := range binaries {
+		ls.Stdout += binary + "\n"
+	}
+	return &commandrunner.Cassette{Interactions: []commandrunner.Interaction{osRelease, ls}}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixture  string
+		binaries []string
+		// backend are the commands the constructor of the package manager runs after detection
+		backend  []commandrunner.Interaction
+		expected string
+		check    func(t *testing.T, host *Host)
+	}{
+		{
+			name:     "ubuntu",
+			fixture:  "ubuntu-24.04",
+			binaries: []string{"/usr/bin/apt", "/usr/bin/apt-get"},
+			expected: "apt",
+			check: func(t *testing.T, host *Host) {
+				if pm, ok := host.PackageManager.(*apt.Apt); !ok || pm.CLI != "apt" {
+					t.Errorf("Detect() package manager = %#v, want apt", host.PackageManager)
+				}
+			},
+		},
+		{
+			name:     "rocky",
+			fixture:  "rocky-9",
+			binaries: []string{"/usr/bin/dnf", "/usr/bin/yum"},
+			backend:  []commandrunner.Interaction{{Command: "rpm -q --queryformat '%{NAME}\\n' --whatprovides /usr/bin/dnf", Stdout: "dnf\n"}},
+			expected: "dnf",
+			check: func(t *testing.T, host *Host) {
+				if pm, ok := host.PackageManager.(*dnf.Dnf); !ok || pm.CLI != "dnf" {
+					t.Errorf("Detect() package manager = %#v, want dnf", host.PackageManager)
+				}
+			},
+		},
+		{
+			name:     "centos 7 falls back to yum",
+			fixture:  "centos-7",
+			binaries: []string{"/usr/bin/yum"},
+			backend:  []commandrunner.Interaction{{Command: "rpm -q --queryformat '%{NAME}\\n' --whatprovides /usr/bin/dnf", Stdout: "no package provides /usr/bin/dnf\n", ExitCode: 1}},
+			expected: "yum",
+			check: func(t *testing.T, host *Host) {
+				if pm, ok := host.PackageManager.(*dnf.Dnf); !ok || pm.CLI != "yum" {
+					t.Errorf("Detect() package manager = %#v, want yum", host.PackageManager)
+				}
+			},
+		},
+		{
+			name:     "fedora 41 uses dnf5",
+			fixture:  "fedora-41",
+			binaries: []string{"/usr/bin/dnf", "/usr/bin/dnf5"},
+			backend:  []commandrunner.Interaction{{Command: "rpm -q --queryformat '%{NAME}\\n' --whatprovides /usr/bin/dnf", Stdout: "dnf5\n"}},
+			expected: "dnf5",
+			check: func(t *testing.T, host *Host) {
+				if pm, ok := host.PackageManager.(*dnf.Dnf); !ok || pm.CLI != "dnf5" {
+					t.Errorf("Detect() package manager = %#v, want dnf5", host.PackageManager)
+				}
+			},
+		},
+		{
+			name:     "alpine",
+			fixture:  "alpine-3.20",
+			binaries: []string{"/sbin/apk"},
+			expected: "apk",
+			check: func(t *testing.T, host *Host) {
+				if _, ok := host.PackageManager.(*apk.Apk); !ok {
+					t.Errorf("Detect() package manager = %#v, want apk", host.PackageManager)
+				}
+			},
+		},
+		{
+			name:     "arch",
+			fixture:  "arch",
+			binaries: []string{"/usr/bin/pacman"},
+			expected: "pacman",
+			check: func(t *testing.T, host *Host) {
+				if _, ok := host.PackageManager.(*pacman.Pacman); !ok {
+					t.Errorf("Detect() package manager = %#v, want pacman", host.PackageManager)
+				}
+			},
+		},
+		{
+			name:     "sles",
+			fixture:  "sles-15",
+			binaries: []string{"/usr/bin/zypper", "/bin/zypper"},
+			expected: "zypper",
+			check: func(t *testing.T, host *Host) {
+				if _, ok := host.PackageManager.(*zypper.Zypper); !ok {
+					t.Errorf("Detect() package manager = %#v, want zypper", host.PackageManager)
+				}
+			},
+		},
+		{
+			name:     "distribution package manager preferred over others",
+			fixture:  "debian-12",
+			binaries: []string{"/usr/bin/apt", "/usr/bin/apt-get", "/usr/bin/dnf"},
+			expected: "apt",
+		},
+		{
+			name:     "unknown distribution",
+			fixture:  "",
+			binaries: []string{"/sbin/apk", "/usr/bin/zypper"},
+			expected: "apk",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cassette := detectionCassette(t, tt.fixture, tt.binaries...)
+			if tt.fixture == "" {
+				cassette.Interactions = append(cassette.Interactions[:1], append([]commandrunner.Interaction{
+					{Command: "cat /usr/lib/os-release", Stdout: "ID=mystery\n"},
+				}, cassette.Interactions[1:]...)...)
+			}
+			cassette.Interactions = append(cassette.Interactions, tt.backend...)
+			replay, err := commandrunner.NewReplayRunner(cassette)
+			if err != nil {
+				t.Fatalf("NewReplayRunner() error = %v", err)
+			}
+
+			host, err := Detect(replay)
+			if err != nil {
+				t.Fatalf("Detect() error = %v", err)
+			}
+			if host.PackageManagerName != tt.expected {
+				t.Errorf("Detect() package manager name = %s, want %s", host.PackageManagerName, tt.expected)
+			}
+			if tt.check != nil {
+				tt.check(t, host)
+			}
+			if remaining := replay.Remaining(); len(remaining) != 0 {
+				t.Errorf("commands not run: %v", remaining)
+			}
+		})
+	}
+}
+
+func TestDetect_OSFacts(t *testing.T) {
+	replay, err := commandrunner.NewReplayRunner(detectionCassette(t, "ubuntu-24.04", "/usr/bin/apt"))
+	if err != nil {
+		t.Fatalf("NewReplayRunner() error = %v", err)
+	}
+
+	host, err := Detect(replay)
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+	if host.OS.ID != "ubuntu" || host.OS.VersionID != "24.04" || host.OS.Codename != "noble" {
+		t.Errorf("Detect() OS = %+v, want ubuntu 24.04 noble", host.OS)
+	}
+}
+
+func TestDetect_NoPackageManager(t *testing.T) {
+	replay, err := commandrunner.NewReplayRunner(detectionCassette(t, "ubuntu-24.04"))
+	if err != nil {
+		t.Fatalf("NewReplayRunner() error = %v", err)
+	}
+
+	if _, err := Detect(replay); !errors.Is(err, ErrNoPackageManager) {
+		t.Errorf("Detect() error = %v, want %v", err, ErrNoPackageManager)
+	}
+}
+
+func TestDetect_AptGetOnly(t *testing.T) {
+	replay, err := commandrunner.NewReplayRunner(detectionCassette(t, "debian-12", "/usr/bin/apt-get"))
+	if err != nil {
+		t.Fatalf("NewReplayRunner() error = %v", err)
+	}
+
+	host, err := Detect(replay)
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+	if pm, ok := host.PackageManager.(*apt.Apt); !ok || pm.CLI != "apt-get" || host.PackageManagerName != "apt-get" {
+		t.Errorf("Detect() package manager = %#v, want apt-get", host.PackageManager)
+	}
+}
+
+func TestDetect_RunnerError(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{Err: []error{errors.New("connection lost")}}
+
+	if _, err := Detect(mockRunner); err == nil {
+		t.Errorf("Detect() expected an error when the runner fails")
+	}
+}
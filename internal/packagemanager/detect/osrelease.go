@@ -0,0 +1,88 @@
+package detect
+
+import (
+	"bufio"
+	"strings"
+)
+
+// osReleasePaths are the locations of the os-release file, /usr/lib/os-release is the fallback when /etc has none
+var osReleasePaths = []string{"/etc/os-release", "/usr/lib/os-release"}
+
+// OSRelease holds the facts about the operating system of a host read from its os-release file
+type OSRelease struct {
+	// ID identifies the distribution, like ubuntu, rocky or alpine
+	ID string
+	// IDLike lists the distributions this one is derived from, like debian for ubuntu
+	IDLike []string
+	// VersionID is the version of the distribution, like 24.04, absent on rolling releases like Arch
+	VersionID string
+	// Codename is the release codename, like noble, absent on most distributions outside the Debian family
+	Codename   string
+	PrettyName string
+}
+
+// parseOSRelease parses the KEY=value assignments of an os-release file, values may be quoted like in a shell
+func parseOSRelease(content string) OSRelease {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		values[key] = unquote(value)
+	}
+
+	codename := values["VERSION_CODENAME"]
+	if codename == "" {
+		codename = values["UBUNTU_CODENAME"]
+	}
+	return OSRelease{
+		ID:         strings.ToLower(values["ID"]),
+		IDLike:     strings.Fields(strings.ToLower(values["ID_LIKE"])),
+		VersionID:  values["VERSION_ID"],
+		Codename:   codename,
+		PrettyName: values["PRETTY_NAME"],
+	}
+}
+
+// unquote removes the quotes around a value and the backslashes escaping characters inside double quotes
+func unquote(value string) string {
+	if len(value) < 2 {
+		return value
+	}
+	switch {
+	case value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1]
+	case value[0] == '"' && value[len(value)-1] == '"':
+		value = value[1 : len(value)-1]
+		var unquoted strings.Builder
+		for i := 0; i < len(value); i++ {
+			if value[i] == '\\' && i+1 < len(value) && strings.IndexByte("\"\\$`", value[i+1]) >= 0 {
+				i++
+			}
+			unquoted.WriteByte(value[i])
+		}
+		return unquoted.String()
+	}
+	return value
+}
+
+// is reports whether the distribution is one of the ids or derived from one of them
+func (o OSRelease) is(ids ...string) bool {
+	for _, id := range ids {
+		if o.ID == id {
+			return true
+		}
+		for _, like := range o.IDLike {
+			if like == id {
+				return true
+			}
+		}
+	}
+	return false
+}
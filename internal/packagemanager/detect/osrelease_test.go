@@ -0,0 +1,60 @@
+package detect
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		expected OSRelease
+	}{
+		{"ubuntu-24.04", OSRelease{ID: "ubuntu", IDLike: []string{"debian"}, VersionID: "24.04", Codename: "noble", PrettyName: "Ubuntu 24.04.1 LTS"}},
+		{"debian-12", OSRelease{ID: "debian", VersionID: "12", Codename: "bookworm", PrettyName: "Debian GNU/Linux 12 (bookworm)"}},
+		{"rocky-9", OSRelease{ID: "rocky", IDLike: []string{"rhel", "centos", "fedora"}, VersionID: "9.4", PrettyName: "Rocky Linux 9.4 (Blue Onyx)"}},
+		{"centos-7", OSRelease{ID: "centos", IDLike: []string{"rhel", "fedora"}, VersionID: "7", PrettyName: "CentOS Linux 7 (Core)"}},
+		{"alpine-3.20", OSRelease{ID: "alpine", VersionID: "3.20.3", PrettyName: "Alpine Linux v3.20"}},
+		{"arch", OSRelease{ID: "arch", PrettyName: "Arch Linux"}},
+		{"sles-15", OSRelease{ID: "sles", IDLike: []string{"suse"}, VersionID: "15.6", PrettyName: "SUSE Linux Enterprise Server 15 SP6"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			content, err := os.ReadFile("testdata/" + tt.fixture)
+			if err != nil {
+				t.Fatalf("failed to read fixture: %v", err)
+			}
+
+			got := parseOSRelease(string(content))
+			if got.ID != tt.expected.ID || !slices.Equal(got.IDLike, tt.expected.IDLike) || got.VersionID != tt.expected.VersionID ||
+				got.Codename != tt.expected.Codename || got.PrettyName != tt.expected.PrettyName {
+				t.Errorf("parseOSRelease() = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{`noble`, "noble"},
+		{`"Rocky Linux"`, "Rocky Linux"},
+		{`'Arch Linux'`, "Arch Linux"},
+		{`"say \"hi\" for \$5"`, `say "hi" for $5`},
+		{`'no \"escapes\"'`, `no \"escapes\"`},
+		{`"`, `"`},
+		{``, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := unquote(tt.value); got != tt.expected {
+				t.Errorf("unquote(%s) = %q, want %q", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
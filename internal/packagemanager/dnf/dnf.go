@@ -0,0 +1,125 @@
+package dnf
+
+import (
+	"errors"
+	"fmt"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/persistence/models"
+	"slices"
+	"strings"
+)
+
+// checkUpdateAvailable is the exit code of check-update when updates are available
+const checkUpdateAvailable = 100
+
+// dnfEnv keeps the output of dnf and yum parseable independent of the locale of the host
+var dnfEnv = map[string]string{
+	"LC_ALL": "C",
+}
+
+// Dnf is a struct that represents the dnf package manager, dnf5 on recent Fedora or yum on hosts that predate dnf like EL7
+type Dnf struct {
+	CLI           string
+	CommandRunner commandrunner.CommandRunner
+}
+
+// NewDnf returns the package manager of the host, dnf5 or dnf when it is installed and yum otherwise
+func NewDnf(runner commandrunner.CommandRunner) (*Dnf, error) {
+	cli, err := detectCLI(runner)
+	if err != nil {
+		return nil, err
+	}
+	return &Dnf{CLI: cli, CommandRunner: runner}, nil
+}
+
+// detectCLI asks rpm which package provides the dnf binary, dnf5 provides it from Fedora 41 on. Hosts where
+// nothing provides it fall back to yum
+func detectCLI(runner commandrunner.CommandRunner) (string, error) {
+	command := commandrunner.ExecCommand{Args: []string{"rpm", "-q", "--queryformat", `%{NAME}\n`, "--whatprovides", "/usr/bin/dnf"}, Env: dnfEnv}
+
+	result, err := runner.RunCommand(command)
+	var exitErr *commandrunner.ExitError
+	switch {
+	case err == nil:
+		if slices.Contains(strings.Fields(result.Stdout.String()), "dnf5") {
+			return "dnf5", nil
+		}
+		return "dnf", nil
+	case errors.As(err, &exitErr):
+		return "yum", nil
+	default:
+		return "", fmt.Errorf("failed to execute command: %s, err: %w", command.CommandLine(), err)
+	}
+}
+
+// GetPackages lists all installed packages and returns them as a slice of Package structs
+func (d *Dnf) GetPackages() ([]*models.Package, error) {
+	installed, err := d.listInstalled()
+	if err != nil {
+		return nil, err
+	}
+
+	packages := make([]*models.Package, 0, len(installed))
+	for _, pkg := range installed {
+		packages = append(packages, pkg.toPackage(pkg.version))
+	}
+	return packages, nil
+}
+
+// GetUpgradablePackages lists all upgradeable packages and returns them as a slice of Package structs,
+// the installed versions are looked up in the list of installed packages
+func (d *Dnf) GetUpgradablePackages() ([]*models.Package, error) {
+	command := commandrunner.ExecCommand{Args: []string{d.CLI, "check-update"}, Env: dnfEnv}
+
+	result, err := d.CommandRunner.RunCommand(command)
+	var exitErr *commandrunner.ExitError
+	if errors.As(err, &exitErr) && exitErr.Result.ExitCode == checkUpdateAvailable {
+		result, err = exitErr.Result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute command: %s, err: %w", command.CommandLine(), classifyError(err))
+	}
+
+	updates, err := parseListOutput(result.Stdout.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse output: %w", err)
+	}
+	if len(updates) == 0 {
+		return nil, nil
+	}
+
+	installed, err := d.listInstalled()
+	if err != nil {
+		return nil, err
+	}
+	installedVersions := make(map[string]string, len(installed))
+	for _, pkg := range installed {
+		installedVersions[pkg.key()] = pkg.version
+	}
+
+	packages := make([]*models.Package, 0, len(updates))
+	for _, pkg := range updates {
+		packages = append(packages, pkg.toPackage(installedVersions[pkg.key()]))
+	}
+	return packages, nil
+}
+
+// listInstalled lists the installed packages with their architectures, dnf5 only takes the installed filter as an option
+func (d *Dnf) listInstalled() ([]*listedPackage, error) {
+	args := []string{d.CLI, "list", "installed"}
+	if d.CLI == "dnf5" {
+		args = []string{d.CLI, "list", "--installed"}
+	}
+	command := commandrunner.ExecCommand{Args: args, Env: dnfEnv}
+
+	result, err := d.CommandRunner.RunCommand(command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute command: %s, err: %w", command.CommandLine(), classifyError(err))
+	}
+
+	packages, err := parseListOutput(result.Stdout.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse output: %w", err)
+	}
+	return packages, nil
+}
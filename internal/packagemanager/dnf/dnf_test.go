@@ -0,0 +1,257 @@
+package dnf
+
+import (
+	"errors"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// replaySession creates the package manager of the host recorded in the cassette
+func replaySession(t *testing.T, path string) (*Dnf, *commandrunner.ReplayRunner) {
+	t.Helper()
+
+	cassette, err := commandrunner.LoadCassette(path)
+	if err != nil {
+		t.Fatalf("LoadCassette() error = %v", err)
+	}
+	replay, err := commandrunner.NewReplayRunner(cassette)
+	if err != nil {
+		t.Fatalf("NewReplayRunner() error = %v", err)
+	}
+	dnf, err := NewDnf(replay)
+	if err != nil {
+		t.Fatalf("NewDnf() error = %v", err)
+	}
+	return dnf, replay
+}
+
+// readAll collects the lines of the output channel
+func readAll(output <-chan string) string {
+	all := ""
+	for line := range output {
+		all += line + "\n"
+	}
+	return all
+}
+
+func TestDnf_ReplayFedora41Session(t *testing.T) {
+	dnf, replay := replaySession(t, "testdata/fedora-41.json")
+	if dnf.CLI != "dnf5" {
+		t.Fatalf("NewDnf() CLI = %s, want dnf5", dnf.CLI)
+	}
+
+	packages, err := dnf.GetPackages()
+	if err != nil {
+		t.Fatalf("GetPackages() failed: %v", err)
+	}
+	if len(packages) != 3 {
+		t.Fatalf("GetPackages() returned %d packages, want 3", len(packages))
+	}
+
+	upgradable, err := dnf.GetUpgradablePackages()
+	if err != nil {
+		t.Fatalf("GetUpgradablePackages() failed: %v", err)
+	}
+	expected := []*models.Package{
+		{Name: "curl", InstalledVersion: "8.9.1-2.fc41", Version: "8.9.1-3.fc41", Installed: true},
+		{Name: "libcurl", InstalledVersion: "8.9.1-2.fc41", Version: "8.9.1-3.fc41", Installed: true},
+	}
+	if len(upgradable) != len(expected) {
+		t.Fatalf("GetUpgradablePackages() returned %d packages, want %d", len(upgradable), len(expected))
+	}
+	for i := range upgradable {
+		if !upgradable[i].Equals(expected[i]) {
+			t.Errorf("Package at index %d is = %v, expected = %v", i, upgradable[i], expected[i])
+		}
+	}
+
+	output, err := dnf.UpdatePackageSimulation(upgradable[0])
+	if err != nil {
+		t.Fatalf("UpdatePackageSimulation() failed: %v", err)
+	}
+	if simulation := readAll(output); !strings.Contains(simulation, " curl                     x86_64 8.9.1-3.fc41") {
+		t.Errorf("UpdatePackageSimulation() output = %q, want it to contain the upgrade of curl", simulation)
+	}
+
+	if remaining := replay.Remaining(); len(remaining) != 0 {
+		t.Errorf("commands not run: %v", remaining)
+	}
+}
+
+func TestNewDnf(t *testing.T) {
+	tests := []struct {
+		name     string
+		provides commandrunner.Interaction
+		expected string
+	}{
+		{name: "dnf", provides: commandrunner.Interaction{Stdout: "dnf\n"}, expected: "dnf"},
+		{name: "dnf5", provides: commandrunner.Interaction{Stdout: "dnf5\n"}, expected: "dnf5"},
+		{name: "dnf5 next to dnf4", provides: commandrunner.Interaction{Stdout: "dnf\ndnf5\n"}, expected: "dnf5"},
+		{name: "yum", provides: commandrunner.Interaction{Stdout: "no package provides /usr/bin/dnf\n", ExitCode: 1}, expected: "yum"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.provides.Command = `rpm -q --queryformat '%{NAME}\n' --whatprovides /usr/bin/dnf`
+			replay, err := commandrunner.NewReplayRunner(&commandrunner.Cassette{Interactions: []commandrunner.Interaction{tt.provides}})
+			if err != nil {
+				t.Fatalf("NewReplayRunner() error = %v", err)
+			}
+
+			dnf, err := NewDnf(replay)
+			if err != nil {
+				t.Fatalf("NewDnf() error = %v", err)
+			}
+			if dnf.CLI != tt.expected {
+				t.Errorf("NewDnf() CLI = %s, want %s", dnf.CLI, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDnf_ListInstalledArgs(t *testing.T) {
+	tests := []struct {
+		cli      string
+		expected []string
+	}{
+		{cli: "dnf", expected: []string{"dnf", "list", "installed"}},
+		{cli: "yum", expected: []string{"yum", "list", "installed"}},
+		{cli: "dnf5", expected: []string{"dnf5", "list", "--installed"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cli, func(t *testing.T) {
+			mockRunner := &commandrunner.MockCommandRunner{}
+			if _, err := (&Dnf{CLI: tt.cli, CommandRunner: mockRunner}).GetPackages(); err != nil {
+				t.Fatalf("GetPackages() error = %v", err)
+			}
+			if commands := mockRunner.Commands(); len(commands) != 1 || !slices.Equal(commands[0].Args, tt.expected) {
+				t.Errorf("GetPackages() ran %v, want %v", commands, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewDnf_Error(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{Err: []error{errors.New("connection lost")}}
+
+	if _, err := NewDnf(mockRunner); err == nil {
+		t.Fatalf("NewDnf() expected an error when rpm cannot be run")
+	}
+}
+
+func TestDnf_GetUpgradablePackages_ExitCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode int
+		output   string
+		err      bool
+	}{
+		{name: "up to date", exitCode: 0, output: "Last metadata expiration check: 0:00:01 ago on Sat 17 Oct 2026.\n"},
+		{name: "failure", exitCode: 1, output: "Error: Failed to download metadata for repo 'baseos'", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &commandrunner.MockCommandRunner{Output: tt.output, ExitCode: tt.exitCode}
+			dnf := &Dnf{CLI: "dnf", CommandRunner: mockRunner}
+
+			packages, err := dnf.GetUpgradablePackages()
+			if (err != nil) != tt.err {
+				t.Fatalf("GetUpgradablePackages() error = %v, want error: %v", err, tt.err)
+			}
+			if len(packages) != 0 {
+				t.Errorf("GetUpgradablePackages() = %v, want no packages", packages)
+			}
+			if commands := mockRunner.Commands(); len(commands) != 1 {
+				t.Errorf("GetUpgradablePackages() ran %d commands, want only check-update", len(commands))
+			}
+		})
+	}
+}
+
+func TestDnf_LockedError(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{
+		ExitCode: 1,
+		Stderr:   "Error: Failed to obtain rpm transaction lock. Another transaction is in progress.",
+	}
+	dnf := &Dnf{CLI: "dnf", CommandRunner: mockRunner}
+
+	output := make(chan string)
+	go func() {
+		for range output {
+		}
+	}()
+	err := dnf.UpdateAllPackages(output)
+	if !errors.Is(err, packagemanager.ErrLocked) {
+		t.Fatalf("UpdateAllPackages() error = %v, want %v", err, packagemanager.ErrLocked)
+	}
+}
+
+func TestDnf_UpdateArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		run    func(d *Dnf, output chan<- string) error
+		expect []string
+	}{
+		{
+			name:   "refresh",
+			run:    func(d *Dnf, output chan<- string) error { return d.Refresh(output) },
+			expect: []string{"yum", "makecache"},
+		},
+		{
+			name: "update package",
+			run: func(d *Dnf, output chan<- string) error {
+				return d.UpdatePackage(&models.Package{Name: "curl"}, output)
+			},
+			expect: []string{"yum", "upgrade", "-y", "curl"},
+		},
+		{
+			name:   "update all packages",
+			run:    func(d *Dnf, output chan<- string) error { return d.UpdateAllPackages(output) },
+			expect: []string{"yum", "upgrade", "-y"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &commandrunner.MockCommandRunner{Output: "Complete!"}
+			dnf := &Dnf{CLI: "yum", CommandRunner: mockRunner}
+
+			output := make(chan string)
+			done := make(chan string)
+			go func() { done <- readAll(output) }()
+
+			if err := tt.run(dnf, output); err != nil {
+				t.Fatalf("%s failed: %v", tt.name, err)
+			}
+			if got := <-done; got != "Complete!\n" {
+				t.Errorf("output = %q, want %q", got, "Complete!\n")
+			}
+
+			commands := mockRunner.Commands()
+			if len(commands) != 1 || !slices.Equal(commands[0].Args, tt.expect) || !commands[0].Elevated {
+				t.Errorf("commands = %v, want the elevated command %v", commands, tt.expect)
+			}
+		})
+	}
+}
+
+func TestDnf_InvalidName(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{}
+	dnf := &Dnf{CLI: "dnf", CommandRunner: mockRunner}
+
+	if _, err := dnf.UpdatePackageSimulation(&models.Package{Name: "--nogpgcheck"}); !errors.Is(err, packagemanager.ErrInvalidPackageName) {
+		t.Errorf("UpdatePackageSimulation() error = %v, want %v", err, packagemanager.ErrInvalidPackageName)
+	}
+	if err := dnf.UpdatePackage(&models.Package{Name: "-y"}, make(chan string)); !errors.Is(err, packagemanager.ErrInvalidPackageName) {
+		t.Errorf("UpdatePackage() error = %v, want %v", err, packagemanager.ErrInvalidPackageName)
+	}
+	if commands := mockRunner.Commands(); len(commands) != 0 {
+		t.Errorf("commands = %v, want none for invalid names", commands)
+	}
+}
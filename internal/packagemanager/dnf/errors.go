@@ -0,0 +1,11 @@
+package dnf
+
+import "sahand.dev/chisme/internal/packagemanager"
+
+// classifyError recognizes the messages dnf, yum and rpm print when they fail to acquire their locks
+var classifyError = packagemanager.LockClassifier(packagemanager.OutputContains(
+	"Failed to obtain rpm transaction lock",
+	"can't create transaction lock",
+	"Existing lock /var/run/yum.pid",
+	"another copy is running as pid",
+))
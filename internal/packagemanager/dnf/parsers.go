@@ -0,0 +1,143 @@
+package dnf
+
+import (
+	"bufio"
+	"fmt"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+	"strings"
+)
+
+// listFields is the number of fields of a package line, name.arch, version and repository
+const listFields = 3
+
+// skipPrefixes start the lines of dnf, dnf5 and yum that carry no package
+var skipPrefixes = []string{
+	"Installed Packages",
+	"Installed packages",
+	"Available Packages",
+	"Available upgrades",
+	"Upgraded Packages",
+	"Last metadata expiration check",
+	"Loaded plugins",
+	"Loading mirror speeds",
+	"Updating and loading repositories",
+	"Repositories loaded",
+	"Security:",
+	" * ",
+}
+
+// obsoletingHeader starts the section of check-update listing packages obsoleted by others, which are not updates
+const obsoletingHeader = "Obsoleting Packages"
+
+// listedPackage is a package line of dnf list or check-update
+type listedPackage struct {
+	name    string
+	arch    string
+	version string
+}
+
+// key returns the name and the architecture identifying the package among the installed ones
+func (p *listedPackage) key() string {
+	return p.name + "." + p.arch
+}
+
+// toPackage returns the package as installed in installedVersion, an empty version when it is not installed
+func (p *listedPackage) toPackage(installedVersion string) *models.Package {
+	return &models.Package{
+		Name:             p.name,
+		Version:          p.version,
+		InstalledVersion: installedVersion,
+		Installed:        installedVersion != "",
+	}
+}
+
+// parseListOutput parses the output of dnf list and check-update into packages, the lines dnf wrapped are
+// joined and the obsoleting packages are left out
+func parseListOutput(output string) ([]*listedPackage, error) {
+	output = unwrapLines(cutObsoleting(output))
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(nil, max(len(output)+1, bufio.MaxScanTokenSize))
+	return packagemanager.ParseOutputCommand(scanner, parseLineToPackage)
+}
+
+// cutObsoleting removes the obsoleting packages section from the output of check-update
+func cutObsoleting(output string) string {
+	if strings.HasPrefix(output, obsoletingHeader) {
+		return ""
+	}
+	if i := strings.Index(output, "\n"+obsoletingHeader); i >= 0 {
+		return output[:i+1]
+	}
+	return output
+}
+
+// unwrapLines joins the lines dnf wrapped to fit the terminal, a package name too long for its column is
+// followed by a line indented with whitespace holding the remaining fields
+func unwrapLines(output string) string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		last := len(lines) - 1
+		if last >= 0 && strings.TrimSpace(line) != "" && startsWithSpace(line) && isWrapped(lines[last]) {
+			lines[last] += " " + strings.TrimSpace(line)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// startsWithSpace reports whether the line is indented
+func startsWithSpace(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// isWrapped reports whether the line is a package line missing the fields dnf moved to the next line
+func isWrapped(line string) bool {
+	fields := strings.Fields(line)
+	return len(fields) > 0 && len(fields) < listFields && !startsWithSpace(line) && !shouldSkipLine(line)
+}
+
+// parseLineToPackage parses a line of output from `dnf list installed` or `dnf check-update` into a listedPackage
+func parseLineToPackage(line string) (*listedPackage, error) {
+	if shouldSkipLine(line) {
+		return nil, packagemanager.SkippingLineError
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != listFields {
+		return nil, fmt.Errorf("unexpected number of fields in line: %s", line)
+	}
+
+	name, arch, err := splitNameArch(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &listedPackage{name: name, arch: arch, version: fields[1]}, nil
+}
+
+// shouldSkipLine checks if the line should be skipped based on its content and prefix
+func shouldSkipLine(line string) bool {
+	return strings.TrimSpace(line) == "" || hasAnyPrefix(line, skipPrefixes)
+}
+
+// splitNameArch splits the first field of a package line, which is in the format name.arch. The name itself
+// may contain periods, the architecture never does
+func splitNameArch(field string) (string, string, error) {
+	i := strings.LastIndexByte(field, '.')
+	if i <= 0 || i == len(field)-1 {
+		return "", "", fmt.Errorf("failed to extract package name and architecture from field: %s", field)
+	}
+	return field[:i], field[i+1:], nil
+}
+
+// hasAnyPrefix reports whether the line starts with one of the prefixes
+func hasAnyPrefix(line string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
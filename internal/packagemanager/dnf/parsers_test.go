@@ -0,0 +1,300 @@
+package dnf
+
+import (
+	"errors"
+	"fmt"
+	"sahand.dev/chisme/internal/packagemanager"
+	"testing"
+)
+
+func TestParseListOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected []listedPackage
+		err      bool
+	}{
+		{
+			name: "dnf list installed",
+			output: `Installed Packages
+NetworkManager.x86_64                  1:1.46.0-8.el9_4                @baseos
+bash.x86_64                            5.1.8-9.el9                     @anaconda
+python3.11.x86_64                      3.11.7-1.el9_4                  @appstream
+`,
+			expected: []listedPackage{
+				{name: "NetworkManager", arch: "x86_64", version: "1:1.46.0-8.el9_4"},
+				{name: "bash", arch: "x86_64", version: "5.1.8-9.el9"},
+				{name: "python3.11", arch: "x86_64", version: "3.11.7-1.el9_4"},
+			},
+		},
+		{
+			name: "wrapped package name",
+			output: `Installed Packages
+python3-setuptools-wheel.noarch
+                                       53.0.0-12.el9                   @anaconda
+xz-libs.x86_64                         5.2.5-8.el9_0                   @anaconda
+`,
+			expected: []listedPackage{
+				{name: "python3-setuptools-wheel", arch: "noarch", version: "53.0.0-12.el9"},
+				{name: "xz-libs", arch: "x86_64", version: "5.2.5-8.el9_0"},
+			},
+		},
+		{
+			name: "dnf check-update with obsoleting packages",
+			output: `Last metadata expiration check: 0:12:08 ago on Sat 17 Oct 2026 09:12:00 AM UTC.
+
+curl.x86_64                     7.76.1-29.el9_4.1                 baseos
+kernel.x86_64                   5.14.0-427.40.1.el9_4             baseos
+Obsoleting Packages
+grub2-tools.x86_64              1:2.06-82.el9_4                   baseos
+    grub2-tools.x86_64          1:2.06-77.el9                     @anaconda
+`,
+			expected: []listedPackage{
+				{name: "curl", arch: "x86_64", version: "7.76.1-29.el9_4.1"},
+				{name: "kernel", arch: "x86_64", version: "5.14.0-427.40.1.el9_4"},
+			},
+		},
+		{
+			name: "yum check-update",
+			output: `Loaded plugins: fastestmirror
+Loading mirror speeds from cached hostfile
+ * base: mirror.centos.org
+ * updates: mirror.centos.org
+
+curl.x86_64                        7.29.0-59.el7_9.2                     updates
+`,
+			expected: []listedPackage{
+				{name: "curl", arch: "x86_64", version: "7.29.0-59.el7_9.2"},
+			},
+		},
+		{
+			name: "dnf5 list installed",
+			output: `Updating and loading repositories:
+Repositories loaded.
+Installed packages
+bash.x86_64                  5.2.26-4.fc41                  anaconda
+`,
+			expected: []listedPackage{
+				{name: "bash", arch: "x86_64", version: "5.2.26-4.fc41"},
+			},
+		},
+		{
+			name: "yum list installed",
+			output: `Loaded plugins: fastestmirror
+Loading mirror speeds from cached hostfile
+Installed Packages
+bash.x86_64                       4.2.46-35.el7_9                       @updates
+curl.x86_64                       7.29.0-59.el7                         @anaconda
+`,
+			expected: []listedPackage{
+				{name: "bash", arch: "x86_64", version: "4.2.46-35.el7_9"},
+				{name: "curl", arch: "x86_64", version: "7.29.0-59.el7"},
+			},
+		},
+		{
+			name: "dnf5 check-update",
+			output: `Updating and loading repositories:
+Repositories loaded.
+curl.x86_64                     8.9.1-3.fc41                   updates
+libcurl.x86_64                  8.9.1-3.fc41                   updates
+`,
+			expected: []listedPackage{
+				{name: "curl", arch: "x86_64", version: "8.9.1-3.fc41"},
+				{name: "libcurl", arch: "x86_64", version: "8.9.1-3.fc41"},
+			},
+		},
+		{
+			name: "security notice",
+			output: `Security: kernel-core-5.14.0-427.40.1.el9_4.x86_64 is an installed security update
+curl.x86_64                     7.76.1-29.el9_4.1                 baseos
+`,
+			expected: []listedPackage{
+				{name: "curl", arch: "x86_64", version: "7.76.1-29.el9_4.1"},
+			},
+		},
+		{
+			name:   "empty output",
+			output: "",
+		},
+		{
+			name:   "unexpected line",
+			output: "curl.x86_64 7.76.1-29.el9_4.1\n",
+			err:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseListOutput(tt.output)
+			if (err != nil) != tt.err {
+				t.Fatalf("parseListOutput() error = %v, want error: %v", err, tt.err)
+			}
+			if len(result) != len(tt.expected) {
+				t.Fatalf("parseListOutput() returned %d packages, want %d", len(result), len(tt.expected))
+			}
+			for i := range result {
+				if *result[i] != tt.expected[i] {
+					t.Errorf("parseListOutput() package at index %d = %v, want %v", i, *result[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseLineToPackage(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected *listedPackage
+		err      error
+	}{
+		{
+			name:     "installed package",
+			line:     "bash.x86_64                            5.1.8-9.el9                     @anaconda",
+			expected: &listedPackage{name: "bash", arch: "x86_64", version: "5.1.8-9.el9"},
+		},
+		{
+			name:     "package with epoch",
+			line:     "NetworkManager.x86_64 1:1.46.0-8.el9_4 @baseos",
+			expected: &listedPackage{name: "NetworkManager", arch: "x86_64", version: "1:1.46.0-8.el9_4"},
+		},
+		{
+			name:     "update",
+			line:     "curl.x86_64 7.76.1-29.el9_4.1 baseos",
+			expected: &listedPackage{name: "curl", arch: "x86_64", version: "7.76.1-29.el9_4.1"},
+		},
+		{
+			name: "empty line",
+			line: "",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name: "header",
+			line: "Installed Packages",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name: "invalid format",
+			line: "invalid formatLine",
+			err:  fmt.Errorf("unexpected number of fields in line: invalid formatLine"),
+		},
+		{
+			name: "missing architecture",
+			line: "bash 5.1.8-9.el9 @anaconda",
+			err:  fmt.Errorf("failed to extract package name and architecture from field: bash"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseLineToPackage(tt.line)
+			if (err != nil && tt.err == nil) || (err == nil && tt.err != nil) || (err != nil && tt.err != nil && err.Error() != tt.err.Error()) {
+				t.Errorf("parseLineToPackage() error = %v, want %v", err, tt.err)
+				return
+			}
+			if errors.Is(tt.err, packagemanager.SkippingLineError) && !errors.Is(err, packagemanager.SkippingLineError) {
+				t.Errorf("parseLineToPackage() error = %v, want %v", err, tt.err)
+			}
+			if (result == nil) != (tt.expected == nil) || (result != nil && *result != *tt.expected) {
+				t.Errorf("parseLineToPackage() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestShouldSkipLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected bool
+	}{
+		{"", true},
+		{"   ", true},
+		{"Installed Packages", true},
+		{"Last metadata expiration check: 0:00:01 ago", true},
+		{"Loaded plugins: fastestmirror", true},
+		{" * base: mirror.centos.org", true},
+		{"bash.x86_64 5.1.8-9.el9 @anaconda", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			actual := shouldSkipLine(tt.line)
+			if actual != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSplitNameArch(t *testing.T) {
+	tests := []struct {
+		field string
+		name  string
+		arch  string
+		err   bool
+	}{
+		{"bash.x86_64", "bash", "x86_64", false},
+		{"python3.11.noarch", "python3.11", "noarch", false},
+		{"glibc.i686", "glibc", "i686", false},
+		{"bash", "", "", true},
+		{".x86_64", "", "", true},
+		{"bash.", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("field=%s", tt.field), func(t *testing.T) {
+			name, arch, err := splitNameArch(tt.field)
+			if (err != nil) != tt.err {
+				t.Errorf("expected err: %v, got: %v", tt.err, err)
+			}
+			if name != tt.name || arch != tt.arch {
+				t.Errorf("expected: %s %s, got: %s %s", tt.name, tt.arch, name, arch)
+			}
+		})
+	}
+}
+
+func TestSimulationError(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		expected string
+		failed   bool
+	}{
+		{
+			name:  "declined transaction",
+			lines: []string{"Upgrading:", " curl x86_64 7.76.1-29.el9_4.1 baseos 294 k", "Operation aborted."},
+		},
+		{
+			name:  "yum declined transaction",
+			lines: []string{"Updating:", " curl x86_64 7.29.0-59.el7_9.2 updates 271 k", "Exiting on user command"},
+		},
+		{
+			name:  "dnf5 declined transaction",
+			lines: []string{"Upgrading:", " curl x86_64 8.9.1-3.fc41 updates 453.4 KiB", "Operation aborted by the user."},
+		},
+		{
+			name:  "up to date",
+			lines: []string{"Dependencies resolved.", "Nothing to do.", "Complete!"},
+		},
+		{
+			name:     "unknown package",
+			lines:    []string{"No match for argument: nosuchpkg", "Error: No packages marked for upgrade."},
+			expected: "No packages marked for upgrade.",
+			failed:   true,
+		},
+		{
+			name:  "no output",
+			lines: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, failed := simulationError(tt.lines)
+			if msg != tt.expected || failed != tt.failed {
+				t.Errorf("simulationError() = %q, %v, want %q, %v", msg, failed, tt.expected, tt.failed)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package dnf
+
+import (
+	"log"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+	"strings"
+)
+
+// assumeNoExitCode is the exit code of dnf and yum after answering no to the transaction of a simulation
+const assumeNoExitCode = 1
+
+// abortedMessages end the output of a simulation whose transaction was resolved and then declined by --assumeno
+var abortedMessages = []string{"Operation aborted", "Exiting on user command"}
+
+// nothingMessages end the output of a simulation when the package is already up to date
+var nothingMessages = []string{"Nothing to do", "No packages marked for update"}
+
+// UpdatePackageSimulation simulates updating a package and returns a channel to read the output and stderr combined,
+// the transaction is resolved and then declined. A simulation that failed to resolve it is logged
+func (d *Dnf) UpdatePackageSimulation(pkg *models.Package) (<-chan string, error) {
+	if err := packagemanager.ValidatePackageName(packagemanager.RPMPackageName, pkg.Name); err != nil {
+		return nil, err
+	}
+	command := commandrunner.ExecCommand{Args: []string{d.CLI, "upgrade", "--assumeno", pkg.Name}, Env: dnfEnv, Elevated: true}
+
+	lines, err := packagemanager.StreamOutput(d.CommandRunner, command, assumeNoExitCode)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(chan string)
+	go func() {
+		defer close(output)
+		var seen []string
+		for line := range lines {
+			seen = append(seen, line)
+			output <- line
+		}
+		if msg, failed := simulationError(seen); failed {
+			log.Printf("simulation of %s failed: %s", command.CommandLine(), msg)
+		}
+	}()
+
+	return output, nil
+}
+
+// simulationError parses the output of an upgrade with --assumeno, which exits with 1 whether the transaction
+// was declined or failed. It returns the error dnf reported when the simulation did not get to the transaction
+func simulationError(lines []string) (string, bool) {
+	var errorLine string
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if hasAnyPrefix(line, abortedMessages) || hasAnyPrefix(line, nothingMessages) {
+			return "", false
+		}
+		if errorLine == "" && strings.HasPrefix(line, "Error:") {
+			errorLine = strings.TrimSpace(strings.TrimPrefix(line, "Error:"))
+		}
+	}
+	return errorLine, errorLine != ""
+}
+
+// UpdatePackage updates a package and forwards the output to the output channel, in case the command fails
+// the error is returned
+func (d *Dnf) UpdatePackage(pkg *models.Package, output chan<- string) error {
+	if err := packagemanager.ValidatePackageName(packagemanager.RPMPackageName, pkg.Name); err != nil {
+		return err
+	}
+
+	return d.exec([]string{d.CLI, "upgrade", "-y", pkg.Name}, output)
+}
+
+// UpdateAllPackages updates all packages and forwards the output to the output channel, in case the command fails
+// the error is returned
+func (d *Dnf) UpdateAllPackages(output chan<- string) error {
+	return d.exec([]string{d.CLI, "upgrade", "-y"}, output)
+}
+
+// Refresh downloads the metadata of the enabled repositories
+func (d *Dnf) Refresh(output chan<- string) error {
+	return d.exec([]string{d.CLI, "makecache"}, output)
+}
+
+// exec runs the command given as arguments and forwards its stdout and stderr lines to the output channel, it returns
+// as soon as the command finished while the remaining lines keep being delivered until the output channel is closed
+func (d *Dnf) exec(args []string, output chan<- string) error {
+	command := commandrunner.ExecCommand{Args: args, Env: dnfEnv, Elevated: true}
+
+	return packagemanager.Exec(d.CommandRunner, command, output, classifyError)
+}
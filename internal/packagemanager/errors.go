@@ -0,0 +1,38 @@
+package packagemanager
+
+import (
+	"errors"
+	"fmt"
+	"sahand.dev/chisme/internal/commandrunner"
+	"strings"
+)
+
+// ErrLocked is returned when the package manager exited because another process holds its lock
+var ErrLocked = errors.New("package manager is locked by another process")
+
+// LockClassifier returns the classify function of a package manager, it wraps the ExitError of a command in
+// ErrLocked when locked recognizes a failure caused by the lock of the package manager. Other errors are returned
+// unchanged
+func LockClassifier(locked func(result *commandrunner.CommandResult) bool) func(error) error {
+	return func(err error) error {
+		var exitErr *commandrunner.ExitError
+		if errors.As(err, &exitErr) && locked(exitErr.Result) {
+			return fmt.Errorf("%w: %w", ErrLocked, err)
+		}
+		return err
+	}
+}
+
+// OutputContains returns a check for LockClassifier recognizing the results whose stderr or stdout contains one
+// of the messages
+func OutputContains(messages ...string) func(result *commandrunner.CommandResult) bool {
+	return func(result *commandrunner.CommandResult) bool {
+		output := result.Stderr.String() + result.Stdout.String()
+		for _, msg := range messages {
+			if strings.Contains(output, msg) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,108 @@
+package packagemanager
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sahand.dev/chisme/internal/commandrunner"
+	"slices"
+)
+
+// StreamOutput runs the command asynchronously and returns a channel with its stdout and stderr lines combined,
+// the channel is closed once the command finished. A failure of the command is only logged, exit codes among
+// expectedCodes are not, like those of simulations that answer no
+func StreamOutput(runner commandrunner.CommandRunner, command commandrunner.ExecCommand, expectedCodes ...int) (<-chan string, error) {
+	events, err := runner.RunCommandAsync(command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute command: %s, err: %w", command.CommandLine(), err)
+	}
+
+	output := make(chan string)
+	go func() {
+		defer close(output)
+		for event := range events {
+			switch event.Type {
+			case commandrunner.EventStdout, commandrunner.EventStderr:
+				output <- event.Line
+			case commandrunner.EventExit:
+				if event.ExitCode != 0 && !slices.Contains(expectedCodes, event.ExitCode) {
+					log.Printf("command %s exited with code %d", command.CommandLine(), event.ExitCode)
+				}
+			case commandrunner.EventError:
+				log.Println(event.Err)
+			}
+		}
+	}()
+
+	return output, nil
+}
+
+// Exec runs the command and forwards its stdout and stderr lines to the output channel, it returns as soon as the
+// command finished while the remaining lines keep being delivered until the output channel is closed. The error
+// of a command exiting with a non-zero status is passed through classify, which may tell known causes apart or
+// return nil for exit codes that report success
+func Exec(runner commandrunner.CommandRunner, command commandrunner.ExecCommand, output chan<- string, classify func(error) error) error {
+	events, err := runner.RunCommandAsync(command)
+	if err != nil {
+		return fmt.Errorf("failed to execute command: %s, err: %w", command.CommandLine(), err)
+	}
+
+	errorChan := make(chan error, 1)
+	go forwardEvents(command.CommandLine(), events, output, classify, errorChan)
+
+	return <-errorChan
+}
+
+// forwardEvents queues the lines of the events so the command is never blocked by a slow reader of output,
+// and reports the outcome of the command on errorChan once the final event arrived
+func forwardEvents(command string, events <-chan commandrunner.Event, output chan<- string, classify func(error) error, errorChan chan<- error) {
+	defer close(output)
+
+	result := &commandrunner.CommandResult{Command: command, Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}}
+	reported := false
+	report := func(err error) {
+		if !reported {
+			reported = true
+			errorChan <- err
+		}
+	}
+
+	var queue []string
+	for events != nil || len(queue) > 0 {
+		var send chan<- string
+		var next string
+		if len(queue) > 0 {
+			send, next = output, queue[0]
+		}
+
+		select {
+		case send <- next:
+			queue = queue[1:]
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				report(nil)
+				continue
+			}
+			switch event.Type {
+			case commandrunner.EventStdout:
+				queue = append(queue, event.Line)
+			case commandrunner.EventStderr:
+				result.Stderr.WriteString(event.Line + "\n")
+				queue = append(queue, event.Line)
+			case commandrunner.EventExit:
+				result.Host, result.ExitCode, result.FinishedAt = event.Host, event.ExitCode, event.Time
+				var err error
+				if event.ExitCode != 0 {
+					err = &commandrunner.ExitError{Result: result}
+					if classify != nil {
+						err = classify(err)
+					}
+				}
+				report(err)
+			case commandrunner.EventError:
+				report(event.Err)
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package packagemanager
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrInvalidPackageName is returned for package names that do not follow the naming rules of the package manager
+var ErrInvalidPackageName = errors.New("invalid package name")
+
+var (
+	// DebianPackageName matches Debian package names, made of lower case letters, digits, plus and minus signs and
+	// periods, at least two characters long and starting with an alphanumeric character. An architecture qualifier
+	// like :amd64 may follow
+	DebianPackageName = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+(:[a-z0-9][a-z0-9-]*)?$`)
+	// RPMPackageName matches RPM package names as dnf and zypper take them, made of letters, digits, underscores, plus
+	// and minus signs and periods and starting with an alphanumeric character or an underscore. An architecture
+	// like .x86_64 is part of the name then
+	RPMPackageName = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_+.-]*$`)
+	// AlpinePackageName matches Alpine package names, made of letters, digits, underscores, plus and minus signs and
+	// periods and starting with an alphanumeric character
+	AlpinePackageName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_+.-]*$`)
+	// ArchPackageName matches Arch package names, made of lower case letters, digits, at signs, underscores, plus and
+	// minus signs and periods and not starting with a hyphen or a period
+	ArchPackageName = regexp.MustCompile(`^[a-z0-9@_+][a-z0-9@_+.-]*$`)
+)
+
+// ValidatePackageName checks the name against the naming rules of a package manager given by its pattern. None of
+// the patterns accepts a leading hyphen so a valid name is never taken for an option
+func ValidatePackageName(pattern *regexp.Regexp, name string) error {
+	if !pattern.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidPackageName, name)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package packagemanager
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestValidatePackageName(t *testing.T) {
+	tests := []struct {
+		format  string
+		pattern *regexp.Regexp
+		name    string
+		err     bool
+	}{
+		{"apt", DebianPackageName, "libc6", false},
+		{"apt", DebianPackageName, "g++", false},
+		{"apt", DebianPackageName, "libstdc++6", false},
+		{"apt", DebianPackageName, "python3.12", false},
+		{"apt", DebianPackageName, "libc6:amd64", false},
+		{"apt", DebianPackageName, "0ad", false},
+		{"apt", DebianPackageName, "a", true},
+		{"apt", DebianPackageName, "", true},
+		{"apt", DebianPackageName, "-simulate", true},
+		{"apt", DebianPackageName, "--force-yes", true},
+		{"apt", DebianPackageName, "Libc6", true},
+		{"apt", DebianPackageName, "libc6; rm -rf /", true},
+		{"apt", DebianPackageName, "libc6 curl", true},
+		{"apt", DebianPackageName, "$(reboot)", true},
+		{"apt", DebianPackageName, "libc6:", true},
+		{"apt", DebianPackageName, "libc6:amd64:i386", true},
+		{"apt", DebianPackageName, "pkg_name", true},
+		{"apk", AlpinePackageName, "musl", false},
+		{"apk", AlpinePackageName, "py3-setuptools", false},
+		{"apk", AlpinePackageName, "g++", false},
+		{"apk", AlpinePackageName, "ca-certificates-bundle", false},
+		{"apk", AlpinePackageName, "perl-io-socket-ssl", false},
+		{"apk", AlpinePackageName, "", true},
+		{"apk", AlpinePackageName, "-s", true},
+		{"apk", AlpinePackageName, "--allow-untrusted", true},
+		{"apk", AlpinePackageName, "_x", true},
+		{"apk", AlpinePackageName, "musl; rm -rf /", true},
+		{"apk", AlpinePackageName, "musl curl", true},
+		{"apk", AlpinePackageName, "$(reboot)", true},
+		{"apk", AlpinePackageName, "musl>1.2", true},
+		{"dnf", RPMPackageName, "bash", false},
+		{"dnf", RPMPackageName, "NetworkManager", false},
+		{"dnf", RPMPackageName, "gcc-c++", false},
+		{"dnf", RPMPackageName, "python3.11", false},
+		{"dnf", RPMPackageName, "perl-Data-Dumper", false},
+		{"dnf", RPMPackageName, "glibc.i686", false},
+		{"dnf", RPMPackageName, "_x", false},
+		{"dnf", RPMPackageName, "", true},
+		{"dnf", RPMPackageName, "-y", true},
+		{"dnf", RPMPackageName, "--assumeyes", true},
+		{"dnf", RPMPackageName, "bash; rm -rf /", true},
+		{"dnf", RPMPackageName, "bash curl", true},
+		{"dnf", RPMPackageName, "$(reboot)", true},
+		{"dnf", RPMPackageName, "bash*", true},
+		{"pacman", ArchPackageName, "linux", false},
+		{"pacman", ArchPackageName, "python-setuptools", false},
+		{"pacman", ArchPackageName, "lib32-glibc", false},
+		{"pacman", ArchPackageName, "gtk2+extra", false},
+		{"pacman", ArchPackageName, "libc++", false},
+		{"pacman", ArchPackageName, "", true},
+		{"pacman", ArchPackageName, "-Sy", true},
+		{"pacman", ArchPackageName, "--noconfirm", true},
+		{"pacman", ArchPackageName, ".hidden", true},
+		{"pacman", ArchPackageName, "Linux", true},
+		{"pacman", ArchPackageName, "linux; rm -rf /", true},
+		{"pacman", ArchPackageName, "linux curl", true},
+		{"pacman", ArchPackageName, "$(reboot)", true},
+		{"zypper", RPMPackageName, "bash", false},
+		{"zypper", RPMPackageName, "NetworkManager", false},
+		{"zypper", RPMPackageName, "gcc-c++", false},
+		{"zypper", RPMPackageName, "python3.11", false},
+		{"zypper", RPMPackageName, "libzypp", false},
+		{"zypper", RPMPackageName, "glibc.i686", false},
+		{"zypper", RPMPackageName, "_x", false},
+		{"zypper", RPMPackageName, "", true},
+		{"zypper", RPMPackageName, "-y", true},
+		{"zypper", RPMPackageName, "--no-confirm", true},
+		{"zypper", RPMPackageName, "bash; rm -rf /", true},
+		{"zypper", RPMPackageName, "bash curl", true},
+		{"zypper", RPMPackageName, "$(reboot)", true},
+		{"zypper", RPMPackageName, "bash>5", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format+" "+tt.name, func(t *testing.T) {
+			err := ValidatePackageName(tt.pattern, tt.name)
+			if (err != nil) != tt.err {
+				t.Errorf("ValidatePackageName(%q) error = %v, want error: %v", tt.name, err, tt.err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidPackageName) {
+				t.Errorf("ValidatePackageName(%q) error = %v, want %v", tt.name, err, ErrInvalidPackageName)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+package pacman
+
+import "sahand.dev/chisme/internal/packagemanager"
+
+// classifyError recognizes the message pacman prints when its database lock file exists
+var classifyError = packagemanager.LockClassifier(packagemanager.OutputContains("unable to lock database"))
@@ -0,0 +1,73 @@
+package pacman
+
+import (
+	"errors"
+	"fmt"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+)
+
+// pacmanEnv keeps the output of pacman parseable independent of the locale of the host
+var pacmanEnv = map[string]string{
+	"LC_ALL": "C",
+}
+
+// Pacman is a struct that represents the pacman package manager of Arch Linux
+type Pacman struct {
+	CLI           string
+	CommandRunner commandrunner.CommandRunner
+}
+
+// GetPackages lists all installed packages and returns them as a slice of Package structs, the version of
+// a package is the candidate version when an upgrade is available and the installed version otherwise
+func (p *Pacman) GetPackages() ([]*models.Package, error) {
+	packages, err := p.query([]string{p.CLI, "-Q"}, parseQueryLine)
+	if err != nil {
+		return nil, err
+	}
+
+	upgradable, err := p.GetUpgradablePackages()
+	if err != nil {
+		return nil, err
+	}
+	candidates := make(map[string]string, len(upgradable))
+	for _, pkg := range upgradable {
+		candidates[pkg.Name] = pkg.Version
+	}
+
+	for _, pkg := range packages {
+		if candidate, ok := candidates[pkg.Name]; ok {
+			pkg.Version = candidate
+		}
+	}
+	return packages, nil
+}
+
+// GetUpgradablePackages lists all upgradeable packages known to the synced databases and returns them as a slice
+// of Package structs, the packages held back by IgnorePkg are left out
+func (p *Pacman) GetUpgradablePackages() ([]*models.Package, error) {
+	return p.query([]string{p.CLI, "-Qu"}, parseUpgradeLine)
+}
+
+// query runs the query given as arguments and parses each line of its output with parseFunc. A query that
+// matches no package exits with 1 without printing anything, which is an empty result
+func (p *Pacman) query(args []string, parseFunc func(string) (*models.Package, error)) ([]*models.Package, error) {
+	command := commandrunner.ExecCommand{Args: args, Env: pacmanEnv}
+
+	result, err := p.CommandRunner.RunCommand(command)
+	var exitErr *commandrunner.ExitError
+	if errors.As(err, &exitErr) && exitErr.Result.ExitCode == 1 && exitErr.Result.Stdout.Len() == 0 && exitErr.Result.Stderr.Len() == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute command: %s, err: %w", command.CommandLine(), classifyError(err))
+	}
+
+	packages, err := packagemanager.ParseOutputCommand(result.Scanner(), parseFunc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse output: %w", err)
+	}
+
+	return packages, nil
+}
@@ -0,0 +1,163 @@
+package pacman
+
+import (
+	"errors"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+	"slices"
+	"testing"
+)
+
+func TestPacman_GetPackages(t *testing.T) {
+	installed := "bash 5.2.032-1\nlinux 6.10.9.arch1-1\n"
+	tests := []struct {
+		name     string
+		upgrades commandrunner.Interaction
+		expected []*models.Package
+	}{
+		{
+			name:     "candidate version",
+			upgrades: commandrunner.Interaction{Stdout: "linux 6.10.9.arch1-1 -> 6.10.10.arch1-1\n"},
+			expected: []*models.Package{
+				{Name: "bash", InstalledVersion: "5.2.032-1", Version: "5.2.032-1", Installed: true},
+				{Name: "linux", InstalledVersion: "6.10.9.arch1-1", Version: "6.10.10.arch1-1", Installed: true},
+			},
+		},
+		{
+			name:     "ignored upgrade",
+			upgrades: commandrunner.Interaction{Stdout: "linux 6.10.9.arch1-1 -> 6.10.10.arch1-1 [ignored]\n"},
+			expected: []*models.Package{
+				{Name: "bash", InstalledVersion: "5.2.032-1", Version: "5.2.032-1", Installed: true},
+				{Name: "linux", InstalledVersion: "6.10.9.arch1-1", Version: "6.10.9.arch1-1", Installed: true},
+			},
+		},
+		{
+			name:     "no upgrades",
+			upgrades: commandrunner.Interaction{ExitCode: 1},
+			expected: []*models.Package{
+				{Name: "bash", InstalledVersion: "5.2.032-1", Version: "5.2.032-1", Installed: true},
+				{Name: "linux", InstalledVersion: "6.10.9.arch1-1", Version: "6.10.9.arch1-1", Installed: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.upgrades.Command = "pacman -Qu"
+			replay, err := commandrunner.NewReplayRunner(&commandrunner.Cassette{Interactions: []commandrunner.Interaction{
+				{Command: "pacman -Q", Stdout: installed},
+				tt.upgrades,
+			}})
+			if err != nil {
+				t.Fatalf("NewReplayRunner() error = %v", err)
+			}
+
+			packages, err := (&Pacman{CLI: "pacman", CommandRunner: replay}).GetPackages()
+			if err != nil {
+				t.Fatalf("GetPackages() failed: %v", err)
+			}
+			if len(packages) != len(tt.expected) {
+				t.Fatalf("GetPackages() returned %d packages, want %d", len(packages), len(tt.expected))
+			}
+			for i := range packages {
+				if !packages[i].Equals(tt.expected[i]) {
+					t.Errorf("Package at index %d is = %v, expected = %v", i, packages[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPacman_GetUpgradablePackages_NoUpdates(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{ExitCode: 1}
+	pacman := &Pacman{CLI: "pacman", CommandRunner: mockRunner}
+
+	packages, err := pacman.GetUpgradablePackages()
+	if err != nil {
+		t.Fatalf("GetUpgradablePackages() failed: %v", err)
+	}
+	if len(packages) != 0 {
+		t.Errorf("GetUpgradablePackages() = %v, want no packages", packages)
+	}
+}
+
+func TestPacman_LockedError(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{
+		ExitCode: 1,
+		Stderr:   "error: failed to init transaction (unable to lock database)\nerror: could not lock database: File exists",
+	}
+	pacman := &Pacman{CLI: "pacman", CommandRunner: mockRunner}
+
+	output := make(chan string)
+	go func() {
+		for range output {
+		}
+	}()
+	err := pacman.UpdateAllPackages(output)
+	if !errors.Is(err, packagemanager.ErrLocked) {
+		t.Fatalf("UpdateAllPackages() error = %v, want %v", err, packagemanager.ErrLocked)
+	}
+}
+
+func TestPacman_UpdateArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		run    func(p *Pacman, output chan<- string) error
+		expect []string
+	}{
+		{
+			name:   "refresh",
+			run:    func(p *Pacman, output chan<- string) error { return p.Refresh(output) },
+			expect: []string{"pacman", "-Sy"},
+		},
+		{
+			name: "update package",
+			run: func(p *Pacman, output chan<- string) error {
+				return p.UpdatePackage(&models.Package{Name: "linux"}, output)
+			},
+			expect: []string{"pacman", "-Su", "--needed", "--noconfirm", "linux"},
+		},
+		{
+			name:   "update all packages",
+			run:    func(p *Pacman, output chan<- string) error { return p.UpdateAllPackages(output) },
+			expect: []string{"pacman", "-Su", "--noconfirm"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &commandrunner.MockCommandRunner{Output: ":: Starting full system upgrade..."}
+			pacman := &Pacman{CLI: "pacman", CommandRunner: mockRunner}
+
+			output := make(chan string)
+			go func() {
+				for range output {
+				}
+			}()
+
+			if err := tt.run(pacman, output); err != nil {
+				t.Fatalf("%s failed: %v", tt.name, err)
+			}
+			commands := mockRunner.Commands()
+			if len(commands) != 1 || !slices.Equal(commands[0].Args, tt.expect) || !commands[0].Elevated {
+				t.Errorf("commands = %v, want the elevated command %v", commands, tt.expect)
+			}
+		})
+	}
+}
+
+func TestPacman_InvalidName(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{}
+	pacman := &Pacman{CLI: "pacman", CommandRunner: mockRunner}
+
+	if _, err := pacman.UpdatePackageSimulation(&models.Package{Name: "--noconfirm"}); !errors.Is(err, packagemanager.ErrInvalidPackageName) {
+		t.Errorf("UpdatePackageSimulation() error = %v, want %v", err, packagemanager.ErrInvalidPackageName)
+	}
+	if err := pacman.UpdatePackage(&models.Package{Name: "-Syu"}, make(chan string)); !errors.Is(err, packagemanager.ErrInvalidPackageName) {
+		t.Errorf("UpdatePackage() error = %v, want %v", err, packagemanager.ErrInvalidPackageName)
+	}
+	if commands := mockRunner.Commands(); len(commands) != 0 {
+		t.Errorf("commands = %v, want none for invalid names", commands)
+	}
+}
@@ -0,0 +1,92 @@
+package pacman
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+	"strings"
+)
+
+// parseQueryLine parses a line of output from `pacman -Q`, the name and the installed version, into a Package struct
+func parseQueryLine(line string) (*models.Package, error) {
+	if shouldSkipLine(line) {
+		return nil, packagemanager.SkippingLineError
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("unexpected number of fields in line: %s", line)
+	}
+
+	return &models.Package{
+		Name:             fields[0],
+		Version:          fields[1],
+		InstalledVersion: fields[1],
+		Installed:        true,
+	}, nil
+}
+
+// parseUpgradeLine parses a line of output from `pacman -Qu`, like linux 6.10.9.arch1-1 -> 6.10.10.arch1-1,
+// into a Package struct. Packages marked as [ignored] are skipped
+func parseUpgradeLine(line string) (*models.Package, error) {
+	if shouldSkipLine(line) || strings.HasSuffix(line, "[ignored]") {
+		return nil, packagemanager.SkippingLineError
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 4 || fields[2] != "->" {
+		return nil, fmt.Errorf("unexpected number of fields in line: %s", line)
+	}
+
+	return &models.Package{
+		Name:             fields[0],
+		Version:          fields[3],
+		InstalledVersion: fields[1],
+		Installed:        true,
+	}, nil
+}
+
+// parsePrintLine parses a line of output from `pacman -Sup --print`, the URL of a package file to download like
+// https://geo.mirror.pkgbuild.com/core/os/x86_64/linux-6.10.10.arch1-1-x86_64.pkg.tar.zst, into a Package struct
+// with the version the package would be upgraded to
+func parsePrintLine(line string) (*models.Package, error) {
+	if shouldSkipLine(line) || !strings.Contains(line, "://") {
+		return nil, packagemanager.SkippingLineError
+	}
+
+	file, err := url.PathUnescape(path.Base(strings.TrimSpace(line)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unescape package file from line: %s", line)
+	}
+	i := strings.Index(file, ".pkg.tar")
+	if i < 0 {
+		return nil, fmt.Errorf("failed to extract package file from line: %s", line)
+	}
+
+	// the file is named name-version-release-arch, the name may contain hyphens
+	parts := strings.Split(file[:i], "-")
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("failed to extract package name and version from file: %s", file)
+	}
+	name := strings.Join(parts[:len(parts)-3], "-")
+	version := parts[len(parts)-3] + "-" + parts[len(parts)-2]
+
+	return &models.Package{Name: name, Version: version}, nil
+}
+
+// shouldSkipLine checks if the line should be skipped based on its content and prefix
+func shouldSkipLine(line string) bool {
+	if strings.TrimSpace(line) == "" {
+		return true
+	}
+
+	for _, prefix := range []string{"warning:", ":: "} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
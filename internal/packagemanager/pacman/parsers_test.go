@@ -0,0 +1,241 @@
+package pacman
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+	"testing"
+)
+
+func TestParseFixtures(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixture  string
+		parser   func(string) (*models.Package, error)
+		expected []*models.Package
+	}{
+		{
+			name:    "pacman -Q",
+			fixture: "testdata/query.txt",
+			parser:  parseQueryLine,
+			expected: []*models.Package{
+				{Name: "bash", InstalledVersion: "5.2.032-1", Version: "5.2.032-1", Installed: true},
+				{Name: "ca-certificates-mozilla", InstalledVersion: "3.104-1", Version: "3.104-1", Installed: true},
+				{Name: "glibc", InstalledVersion: "2.40+r16+gaa533d58ff-2", Version: "2.40+r16+gaa533d58ff-2", Installed: true},
+				{Name: "lib32-glibc", InstalledVersion: "2.40+r16+gaa533d58ff-2", Version: "2.40+r16+gaa533d58ff-2", Installed: true},
+				{Name: "linux", InstalledVersion: "6.10.9.arch1-1", Version: "6.10.9.arch1-1", Installed: true},
+				{Name: "python-setuptools", InstalledVersion: "1:69.5.1-1", Version: "1:69.5.1-1", Installed: true},
+			},
+		},
+		{
+			name:    "pacman -Qu",
+			fixture: "testdata/query-upgrades.txt",
+			parser:  parseUpgradeLine,
+			expected: []*models.Package{
+				{Name: "glibc", InstalledVersion: "2.40+r16+gaa533d58ff-2", Version: "2.40+r16+gaa533d58ff-3", Installed: true},
+				{Name: "linux", InstalledVersion: "6.10.9.arch1-1", Version: "6.10.10.arch1-1", Installed: true},
+				{Name: "python-setuptools", InstalledVersion: "1:69.5.1-1", Version: "1:74.1.2-1", Installed: true},
+			},
+		},
+		{
+			name:    "pacman -Sup --print",
+			fixture: "testdata/sync-print.txt",
+			parser:  parsePrintLine,
+			expected: []*models.Package{
+				{Name: "glibc", Version: "2.40+r16+gaa533d58ff-3"},
+				{Name: "linux", Version: "6.10.10.arch1-1"},
+				{Name: "python-setuptools", Version: "1:74.1.2-1"},
+				{Name: "ca-certificates-mozilla", Version: "3.104-1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture, err := os.Open(tt.fixture)
+			if err != nil {
+				t.Fatalf("failed to open fixture: %v", err)
+			}
+			defer fixture.Close()
+
+			packages, err := packagemanager.ParseOutputCommand(bufio.NewScanner(fixture), tt.parser)
+			if err != nil {
+				t.Fatalf("ParseOutputCommand() error = %v", err)
+			}
+			if len(packages) != len(tt.expected) {
+				t.Fatalf("ParseOutputCommand() returned %d packages, want %d", len(packages), len(tt.expected))
+			}
+			for i := range packages {
+				if !packages[i].Equals(tt.expected[i]) {
+					t.Errorf("Package at index %d is = %v, expected = %v", i, packages[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseQueryLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected *models.Package
+		err      error
+	}{
+		{
+			name:     "installed package",
+			line:     "bash 5.2.032-1",
+			expected: &models.Package{Name: "bash", InstalledVersion: "5.2.032-1", Version: "5.2.032-1", Installed: true},
+		},
+		{
+			name:     "epoch",
+			line:     "python-setuptools 1:69.5.1-1",
+			expected: &models.Package{Name: "python-setuptools", InstalledVersion: "1:69.5.1-1", Version: "1:69.5.1-1", Installed: true},
+		},
+		{
+			name:     "name with a plus sign",
+			line:     "gtk2+extra 2.24.33-1",
+			expected: &models.Package{Name: "gtk2+extra", InstalledVersion: "2.24.33-1", Version: "2.24.33-1", Installed: true},
+		},
+		{
+			name: "warning",
+			line: "warning: database file for 'core' does not exist (use '-Sy' to download)",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name: "empty line",
+			line: "",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name: "invalid format",
+			line: "bash",
+			err:  fmt.Errorf("unexpected number of fields in line: bash"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseQueryLine(tt.line)
+			if (err != nil && tt.err == nil) || (err == nil && tt.err != nil) || (err != nil && tt.err != nil && err.Error() != tt.err.Error()) {
+				t.Errorf("parseQueryLine() error = %v, want %v", err, tt.err)
+				return
+			}
+			if !result.Equals(tt.expected) {
+				t.Errorf("parseQueryLine() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseUpgradeLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected *models.Package
+		err      error
+	}{
+		{
+			name:     "upgrade",
+			line:     "linux 6.10.9.arch1-1 -> 6.10.10.arch1-1",
+			expected: &models.Package{Name: "linux", InstalledVersion: "6.10.9.arch1-1", Version: "6.10.10.arch1-1", Installed: true},
+		},
+		{
+			name: "ignored upgrade",
+			line: "linux 6.10.9.arch1-1 -> 6.10.10.arch1-1 [ignored]",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name:     "epoch change",
+			line:     "python-setuptools 69.5.1-1 -> 1:74.1.2-1",
+			expected: &models.Package{Name: "python-setuptools", InstalledVersion: "69.5.1-1", Version: "1:74.1.2-1", Installed: true},
+		},
+		{
+			name: "header",
+			line: ":: Synchronizing package databases...",
+			err:  packagemanager.SkippingLineError,
+		},
+		{
+			name: "invalid format",
+			line: "linux 6.10.9.arch1-1 6.10.10.arch1-1 x",
+			err:  fmt.Errorf("unexpected number of fields in line: linux 6.10.9.arch1-1 6.10.10.arch1-1 x"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseUpgradeLine(tt.line)
+			if (err != nil && tt.err == nil) || (err == nil && tt.err != nil) || (err != nil && tt.err != nil && err.Error() != tt.err.Error()) {
+				t.Errorf("parseUpgradeLine() error = %v, want %v", err, tt.err)
+				return
+			}
+			if !result.Equals(tt.expected) {
+				t.Errorf("parseUpgradeLine() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParsePrintLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected *models.Package
+		err      bool
+	}{
+		{
+			name:     "package url",
+			line:     "https://geo.mirror.pkgbuild.com/core/os/x86_64/linux-6.10.10.arch1-1-x86_64.pkg.tar.zst",
+			expected: &models.Package{Name: "linux", Version: "6.10.10.arch1-1"},
+		},
+		{
+			name:     "escaped epoch",
+			line:     "https://geo.mirror.pkgbuild.com/extra/os/x86_64/python-setuptools-1%3A74.1.2-1-any.pkg.tar.zst",
+			expected: &models.Package{Name: "python-setuptools", Version: "1:74.1.2-1"},
+		},
+		{
+			name:     "hyphenated name",
+			line:     "https://geo.mirror.pkgbuild.com/multilib/os/x86_64/lib32-glibc-2.40%2Br16%2Bgaa533d58ff-3-x86_64.pkg.tar.zst",
+			expected: &models.Package{Name: "lib32-glibc", Version: "2.40+r16+gaa533d58ff-3"},
+		},
+		{
+			name:     "package cache",
+			line:     "file:///var/cache/pacman/pkg/bash-5.2.032-1-x86_64.pkg.tar.zst",
+			expected: &models.Package{Name: "bash", Version: "5.2.032-1"},
+		},
+		{
+			name: "not a url",
+			line: ":: Starting full system upgrade...",
+		},
+		{
+			name: "not a package file",
+			line: "https://geo.mirror.pkgbuild.com/core/os/x86_64/core.db",
+			err:  true,
+		},
+		{
+			name: "missing architecture",
+			line: "https://geo.mirror.pkgbuild.com/core/os/x86_64/linux-6.10.10.pkg.tar.zst",
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parsePrintLine(tt.line)
+			if tt.expected == nil && !tt.err {
+				if !errors.Is(err, packagemanager.SkippingLineError) {
+					t.Errorf("parsePrintLine() error = %v, want %v", err, packagemanager.SkippingLineError)
+				}
+				return
+			}
+			if (err != nil) != tt.err {
+				t.Fatalf("parsePrintLine() error = %v, want error: %v", err, tt.err)
+			}
+			if !result.Equals(tt.expected) {
+				t.Errorf("parsePrintLine() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
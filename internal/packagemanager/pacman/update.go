@@ -0,0 +1,64 @@
+package pacman
+
+import (
+	"fmt"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+)
+
+// UpdatePackageSimulation simulates updating a package together with a system upgrade, as Arch does not support
+// partial upgrades, and returns a channel to read the packages that would be downloaded, one per line
+func (p *Pacman) UpdatePackageSimulation(pkg *models.Package) (<-chan string, error) {
+	if err := packagemanager.ValidatePackageName(packagemanager.ArchPackageName, pkg.Name); err != nil {
+		return nil, err
+	}
+	command := commandrunner.ExecCommand{Args: []string{p.CLI, "-Sup", "--print", pkg.Name}, Env: pacmanEnv, Elevated: true}
+
+	lines, err := packagemanager.StreamOutput(p.CommandRunner, command)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(chan string)
+	go func() {
+		defer close(output)
+		for line := range lines {
+			if parsed, err := parsePrintLine(line); err == nil {
+				line = fmt.Sprintf("%s %s (%s)", parsed.Name, parsed.Version, line)
+			}
+			output <- line
+		}
+	}()
+
+	return output, nil
+}
+
+// UpdatePackage updates a package together with a system upgrade and forwards the output to the output channel,
+// in case the command fails the error is returned
+func (p *Pacman) UpdatePackage(pkg *models.Package, output chan<- string) error {
+	if err := packagemanager.ValidatePackageName(packagemanager.ArchPackageName, pkg.Name); err != nil {
+		return err
+	}
+
+	return p.exec([]string{p.CLI, "-Su", "--needed", "--noconfirm", pkg.Name}, output)
+}
+
+// UpdateAllPackages updates all packages and forwards the output to the output channel, in case the command fails
+// the error is returned
+func (p *Pacman) UpdateAllPackages(output chan<- string) error {
+	return p.exec([]string{p.CLI, "-Su", "--noconfirm"}, output)
+}
+
+// Refresh synchronizes the package databases
+func (p *Pacman) Refresh(output chan<- string) error {
+	return p.exec([]string{p.CLI, "-Sy"}, output)
+}
+
+// exec runs the command given as arguments and forwards its stdout and stderr lines to the output channel, it returns
+// as soon as the command finished while the remaining lines keep being delivered until the output channel is closed
+func (p *Pacman) exec(args []string, output chan<- string) error {
+	command := commandrunner.ExecCommand{Args: args, Env: pacmanEnv, Elevated: true}
+
+	return packagemanager.Exec(p.CommandRunner, command, output, classifyError)
+}
@@ -0,0 +1,36 @@
+package packagemanager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+)
+
+// SkippingLineError is returned by line parsers for lines that carry no package, like headers and warnings
+var SkippingLineError = errors.New("skipping line")
+
+// ParseOutputCommand accepts a scanner and a function to parse each line of the output of a command,
+// the lines the function skips with SkippingLineError are left out. An error reading the output, like a line
+// longer than the buffer of the scanner, is returned instead of a partial list
+func ParseOutputCommand[T any](scanner *bufio.Scanner, parseFunc func(string) (T, error)) ([]T, error) {
+	var output []T
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		parsed, err := parseFunc(line)
+		if err != nil {
+			if errors.Is(err, SkippingLineError) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to parse line: %w", err)
+		}
+
+		output = append(output, parsed)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read output: %w", err)
+	}
+
+	return output, nil
+}
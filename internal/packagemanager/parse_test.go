@@ -0,0 +1,46 @@
+package packagemanager
+
+import (
+	"bufio"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseOutputCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		result []string
+		err    bool
+	}{
+		{name: "lines", output: "line1\nline2", result: []string{"line1", "line2"}},
+		{name: "skipped lines", output: "# header\nline1\n# footer", result: []string{"line1"}},
+		{name: "empty output", output: "", result: nil},
+		{name: "failed line", output: "line1\nbroken", err: true},
+		{name: "line too long for the scanner", output: "line1\n" + strings.Repeat("x", 100*1024), err: true},
+	}
+
+	parser := func(line string) (string, error) {
+		switch {
+		case strings.HasPrefix(line, "#"):
+			return "", SkippingLineError
+		case line == "broken":
+			return "", errors.New("parser err")
+		}
+		return line, nil
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseOutputCommand(bufio.NewScanner(strings.NewReader(tt.output)), parser)
+			if (err != nil) != tt.err {
+				t.Fatalf("ParseOutputCommand() error = %v, want error: %v", err, tt.err)
+			}
+			if !slices.Equal(result, tt.result) {
+				t.Errorf("ParseOutputCommand() = %v, want %v", result, tt.result)
+			}
+		})
+	}
+}
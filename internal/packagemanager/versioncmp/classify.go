@@ -0,0 +1,95 @@
+package versioncmp
+
+import (
+	"regexp"
+	"sahand.dev/chisme/internal/persistence/models"
+	"slices"
+	"strings"
+)
+
+// UpgradeKind labels what changed between the installed and the candidate version of a package
+type UpgradeKind string
+
+const (
+	// UpgradeNone is the kind of versions that do not differ
+	UpgradeNone UpgradeKind = "none"
+	// UpgradeEpoch is the kind of upgrades changing the epoch, which resets the ordering of versions
+	UpgradeEpoch UpgradeKind = "epoch"
+	// UpgradeMajor is the kind of upgrades changing the first number of the upstream version
+	UpgradeMajor UpgradeKind = "major"
+	// UpgradeMinor is the kind of upgrades changing the second number of the upstream version
+	UpgradeMinor UpgradeKind = "minor"
+	// UpgradePatch is the kind of upgrades changing any other part of the upstream version
+	UpgradePatch UpgradeKind = "patch"
+	// UpgradeRevision is the kind of upgrades only changing the packaging revision, like the Debian revision,
+	// the RPM release, the Alpine -rN or the pacman pkgrel
+	UpgradeRevision UpgradeKind = "revision"
+)
+
+// numberPattern matches the numbers of an upstream version
+var numberPattern = regexp.MustCompile(`\d+`)
+
+// Classify labels the upgrade from the installed to the candidate version. Whatever the format, both versions are
+// split the Debian way into [epoch:]upstream[-revision], at the first colon and the last hyphen, and the most
+// significant part that differs gives the kind. The RPM release, the Alpine -rN and the pacman pkgrel all follow
+// the last hyphen so they are taken as the revision
+func Classify(installed, candidate string) UpgradeKind {
+	if installed == candidate {
+		return UpgradeNone
+	}
+
+	epochA, upstreamA, revisionA := splitVersion(installed)
+	epochB, upstreamB, revisionB := splitVersion(candidate)
+	if compareNumbers(epochA, epochB) != 0 {
+		return UpgradeEpoch
+	}
+
+	if upstreamA != upstreamB {
+		numbersA := numberPattern.FindAllString(upstreamA, -1)
+		numbersB := numberPattern.FindAllString(upstreamB, -1)
+		for i, kind := range []UpgradeKind{UpgradeMajor, UpgradeMinor} {
+			if compareNumbers(numberAt(numbersA, i), numberAt(numbersB, i)) != 0 {
+				return kind
+			}
+		}
+		return UpgradePatch
+	}
+
+	if revisionA != revisionB {
+		return UpgradeRevision
+	}
+	return UpgradeNone
+}
+
+// numberAt returns the number at index i, an empty string standing for 0 when the version has fewer numbers
+func numberAt(numbers []string, i int) string {
+	if i < len(numbers) {
+		return numbers[i]
+	}
+	return ""
+}
+
+// ParseUpgradeKinds parses a comma separated list of upgrade kinds like revision,patch
+func ParseUpgradeKinds(list string) ([]UpgradeKind, bool) {
+	var kinds []UpgradeKind
+	for _, name := range strings.Split(list, ",") {
+		kind := UpgradeKind(strings.TrimSpace(name))
+		if !slices.Contains([]UpgradeKind{UpgradeEpoch, UpgradeMajor, UpgradeMinor, UpgradePatch, UpgradeRevision}, kind) {
+			return nil, false
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, true
+}
+
+// FilterUpgrades returns the packages whose upgrade is of one of the kinds, like only revision bumps for
+// low-risk automatic updates
+func FilterUpgrades(packages []*models.Package, kinds ...UpgradeKind) []*models.Package {
+	var filtered []*models.Package
+	for _, pkg := range packages {
+		if slices.Contains(kinds, Classify(pkg.InstalledVersion, pkg.Version)) {
+			filtered = append(filtered, pkg)
+		}
+	}
+	return filtered
+}
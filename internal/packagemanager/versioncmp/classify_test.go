@@ -0,0 +1,103 @@
+package versioncmp
+
+import (
+	"sahand.dev/chisme/internal/persistence/models"
+	"slices"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		installed string
+		candidate string
+		expected  UpgradeKind
+	}{
+		{"2.27-3ubuntu1.1", "2.27-3ubuntu1.2", UpgradeRevision},
+		{"8.5.0-2ubuntu10.4", "8.5.0-2ubuntu10.6", UpgradeRevision},
+		{"7.76.1-29.el9_4", "7.76.1-29.el9_4.1", UpgradeRevision},
+		{"1.36.1-r29", "1.36.1-r31", UpgradeRevision},
+		{"6.10.9.arch1-1", "6.10.10.arch1-1", UpgradePatch},
+		{"3.3.1-r3", "3.3.2-r0", UpgradePatch},
+		{"1.2.3+dfsg-1", "1.2.3+dfsg1-1", UpgradePatch},
+		{"1.0a-1", "1.0b-1", UpgradePatch},
+		{"3.11.7-1.el9_4", "3.12.0-1.el9", UpgradeMinor},
+		{"1.2", "1.2.1", UpgradePatch},
+		{"1", "1.1", UpgradeMinor},
+		{"5.14.0-427.40.1.el9_4", "6.0.0-1.el9", UpgradeMajor},
+		{"20240705-r0", "20240901-r0", UpgradeMajor},
+		{"1:69.5.1-1", "1:74.1.2-1", UpgradeMajor},
+		{"69.5.1-1", "1:69.5.1-1", UpgradeEpoch},
+		{"1:2.0.1-1", "1:2.0.1-2", UpgradeRevision},
+		{"1:1.2.3-1", "1:1.2.4-1", UpgradePatch},
+		{"1:2.0.1-1", "2:1.0-1", UpgradeEpoch},
+		{"1.0~rc1-1.fc41", "1.0-1.fc41", UpgradePatch},
+		{"1.0-1.fc41", "1.0^git20240301-1.fc41", UpgradePatch},
+		{"1.0^git20240101-1.fc41", "1.0^git20240301-1.fc41", UpgradePatch},
+		{"1.9-1.fc41", "2.0~rc1-1.fc41", UpgradeMajor},
+		{"2.0~rc1-1.fc41", "2.0~rc1-2.fc41", UpgradeRevision},
+		{"0:1.0-1", "1.0-1", UpgradeNone},
+		{"1.0-1", "1.0-1", UpgradeNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.installed+" "+tt.candidate, func(t *testing.T) {
+			if got := Classify(tt.installed, tt.candidate); got != tt.expected {
+				t.Errorf("Classify(%q, %q) = %s, want %s", tt.installed, tt.candidate, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseUpgradeKinds(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected []UpgradeKind
+		ok       bool
+	}{
+		{"revision", []UpgradeKind{UpgradeRevision}, true},
+		{"revision, patch", []UpgradeKind{UpgradeRevision, UpgradePatch}, true},
+		{"major,minor,epoch", []UpgradeKind{UpgradeMajor, UpgradeMinor, UpgradeEpoch}, true},
+		{"none", nil, false},
+		{"revision,bogus", nil, false},
+		{"", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			kinds, ok := ParseUpgradeKinds(tt.list)
+			if ok != tt.ok || !slices.Equal(kinds, tt.expected) {
+				t.Errorf("ParseUpgradeKinds(%q) = %v, %v, want %v, %v", tt.list, kinds, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFilterUpgrades(t *testing.T) {
+	packages := []*models.Package{
+		{Name: "curl", InstalledVersion: "8.5.0-2ubuntu10.4", Version: "8.5.0-2ubuntu10.6", Installed: true},
+		{Name: "linux", InstalledVersion: "6.10.9.arch1-1", Version: "6.10.10.arch1-1", Installed: true},
+		{Name: "python3", InstalledVersion: "3.11.7-1", Version: "3.12.0-1", Installed: true},
+	}
+
+	tests := []struct {
+		name     string
+		kinds    []UpgradeKind
+		expected []string
+	}{
+		{name: "only revision bumps", kinds: []UpgradeKind{UpgradeRevision}, expected: []string{"curl"}},
+		{name: "revision and patch", kinds: []UpgradeKind{UpgradeRevision, UpgradePatch}, expected: []string{"curl", "linux"}},
+		{name: "no kinds", kinds: nil, expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			for _, pkg := range FilterUpgrades(packages, tt.kinds...) {
+				names = append(names, pkg.Name)
+			}
+			if !slices.Equal(names, tt.expected) {
+				t.Errorf("FilterUpgrades() = %v, want %v", names, tt.expected)
+			}
+		})
+	}
+}
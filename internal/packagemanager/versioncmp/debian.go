@@ -0,0 +1,111 @@
+package versioncmp
+
+import (
+	"strings"
+)
+
+// CompareDebian compares two Debian versions like dpkg --compare-versions, it returns -1 when a is older than b,
+// 0 when both are equal and 1 when a is newer. Versions are [epoch:]upstream[-revision] and a tilde sorts before
+// anything, even the end of the version, so 1.0~rc1 is older than 1.0
+func CompareDebian(a, b string) int {
+	epochA, upstreamA, revisionA := splitVersion(a)
+	epochB, upstreamB, revisionB := splitVersion(b)
+
+	if c := compareNumbers(epochA, epochB); c != 0 {
+		return c
+	}
+	if c := compareDebianPart(upstreamA, upstreamB); c != 0 {
+		return c
+	}
+	return compareDebianPart(revisionA, revisionB)
+}
+
+// splitVersion splits a version into its epoch, upstream version and revision, the epoch is 0 when absent and the
+// revision empty. The epoch ends at the first colon and the revision starts after the last hyphen
+func splitVersion(version string) (string, string, string) {
+	epoch := "0"
+	if i := strings.IndexByte(version, ':'); i >= 0 {
+		epoch, version = version[:i], version[i+1:]
+	}
+	revision := ""
+	if i := strings.LastIndexByte(version, '-'); i >= 0 {
+		version, revision = version[:i], version[i+1:]
+	}
+	return epoch, version, revision
+}
+
+// compareDebianPart compares upstream versions or revisions the way dpkg does, alternating between non-digit
+// parts compared character by character and digit parts compared numerically
+func compareDebianPart(a, b string) int {
+	for a != "" || b != "" {
+		for (a != "" && !isDigit(a[0])) || (b != "" && !isDigit(b[0])) {
+			orderA, orderB := debianOrder(a), debianOrder(b)
+			if orderA != orderB {
+				return sign(orderA - orderB)
+			}
+			a, b = a[1:], b[1:]
+		}
+
+		var digitsA, digitsB string
+		digitsA, a = leadingDigits(a)
+		digitsB, b = leadingDigits(b)
+		if c := compareNumbers(digitsA, digitsB); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// debianOrder returns the weight of the first character of s: a tilde sorts before the end of s, which sorts
+// before letters, which sort before all other characters
+func debianOrder(s string) int {
+	switch {
+	case s == "" || isDigit(s[0]):
+		return 0
+	case s[0] == '~':
+		return -1
+	case isAlpha(s[0]):
+		return int(s[0])
+	default:
+		return int(s[0]) + 256
+	}
+}
+
+// leadingDigits splits s into its leading digits and the rest
+func leadingDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareNumbers compares two strings of digits numerically whatever their length, an empty string is 0
+func compareNumbers(a, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return sign(len(a) - len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+// isDigit reports whether c is an ASCII digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isAlpha reports whether c is an ASCII letter
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// sign returns -1, 0 or 1 for a negative, zero or positive n
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
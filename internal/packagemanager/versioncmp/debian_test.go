@@ -0,0 +1,74 @@
+package versioncmp
+
+import (
+	"testing"
+)
+
+func TestCompareDebian(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0-0", 0},
+		{"0:1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.2", "1.10", -1},
+		{"1.10", "1.9", 1},
+		{"1.001", "1.1", 0},
+		{"1:1.0", "2.0", 1},
+		{"2:8.1.2269-1ubuntu5.22", "2:8.1.2269-1ubuntu5.23", -1},
+		{"2.27-3ubuntu1.1", "2.27-3ubuntu1.2", -1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~", "1.0", -1},
+		{"1.0", "1.0a", -1},
+		{"1.0a", "1.0+", -1},
+		{"1.0+dfsg", "1.0", 1},
+		{"1.0.0", "1.0", 1},
+		{"1.0-1", "1.0-1~bpo1", 1},
+		{"1.0-1ubuntu1", "1.0-1", 1},
+		{"1.5.5+dfsg2-2build1", "1.5.5+dfsg2-2", 1},
+		{"8.5.0-2ubuntu10.4", "8.5.0-2ubuntu10.6", -1},
+		{"1.0-beta-1", "1.0-beta-2", -1},
+		{"a", "b", -1},
+		{"", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if got := CompareDebian(tt.a, tt.b); got != tt.expected {
+				t.Errorf("CompareDebian(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.expected)
+			}
+			if got := CompareDebian(tt.b, tt.a); got != -tt.expected {
+				t.Errorf("CompareDebian(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.expected)
+			}
+		})
+	}
+}
+
+func TestSplitVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		epoch    string
+		upstream string
+		revision string
+	}{
+		{"1.0", "0", "1.0", ""},
+		{"1.0-1", "0", "1.0", "1"},
+		{"2:8.1.2269-1ubuntu5.23", "2", "8.1.2269", "1ubuntu5.23"},
+		{"1.0-beta-1", "0", "1.0-beta", "1"},
+		{"1:74.1.2-1", "1", "74.1.2", "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			epoch, upstream, revision := splitVersion(tt.version)
+			if epoch != tt.epoch || upstream != tt.upstream || revision != tt.revision {
+				t.Errorf("splitVersion(%q) = %q, %q, %q, want %q, %q, %q", tt.version, epoch, upstream, revision, tt.epoch, tt.upstream, tt.revision)
+			}
+		})
+	}
+}
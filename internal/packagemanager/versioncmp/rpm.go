@@ -0,0 +1,117 @@
+package versioncmp
+
+import (
+	"strings"
+)
+
+// CompareRPM compares two RPM versions like rpm does, it returns -1 when a is older than b, 0 when both are equal
+// and 1 when a is newer. Versions are [epoch:]version[-release], a missing epoch is 0 and the releases are only
+// compared when both versions have one
+func CompareRPM(a, b string) int {
+	epochA, versionA, releaseA := splitVersion(a)
+	epochB, versionB, releaseB := splitVersion(b)
+
+	if c := compareNumbers(epochA, epochB); c != 0 {
+		return c
+	}
+	if c := RPMVerCmp(versionA, versionB); c != 0 {
+		return c
+	}
+	if releaseA == "" || releaseB == "" {
+		return 0
+	}
+	return RPMVerCmp(releaseA, releaseB)
+}
+
+// RPMVerCmp compares two versions or releases like rpmvercmp. They are split into segments of digits and of
+// letters, separators are ignored. Numeric segments are newer than alphabetic ones, a tilde sorts before
+// anything and a caret sorts after the end of a version but before anything else
+func RPMVerCmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	for a != "" || b != "" {
+		a, b = trimSeparators(a), trimSeparators(b)
+
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			switch {
+			case a == "":
+				return -1
+			case b == "":
+				return 1
+			case !strings.HasPrefix(a, "^"):
+				return 1
+			case !strings.HasPrefix(b, "^"):
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if a == "" || b == "" {
+			break
+		}
+
+		var segmentA, segmentB string
+		numeric := isDigit(a[0])
+		if numeric {
+			segmentA, a = leadingDigits(a)
+			segmentB, b = leadingDigits(b)
+		} else {
+			segmentA, a = leadingLetters(a)
+			segmentB, b = leadingLetters(b)
+		}
+
+		// segments of different types, a numeric segment is newer
+		if segmentB == "" {
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+
+		if numeric {
+			if c := compareNumbers(segmentA, segmentB); c != 0 {
+				return c
+			}
+		} else if c := strings.Compare(segmentA, segmentB); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	}
+	return 1
+}
+
+// trimSeparators removes the leading characters that are neither alphanumeric nor a tilde or a caret
+func trimSeparators(s string) string {
+	return strings.TrimLeftFunc(s, func(r rune) bool {
+		return r > 127 || (!isDigit(byte(r)) && !isAlpha(byte(r)) && r != '~' && r != '^')
+	})
+}
+
+// leadingLetters splits s into its leading letters and the rest
+func leadingLetters(s string) (string, string) {
+	i := 0
+	for i < len(s) && isAlpha(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,85 @@
+package versioncmp
+
+import (
+	"testing"
+)
+
+func TestRPMVerCmp(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0.1", "2.0.1", 0},
+		{"2.0", "2.0.1", -1},
+		{"2.0.1a", "2.0.1", 1},
+		{"5.5p1", "5.5p10", -1},
+		{"10xyz", "10.1xyz", -1},
+		{"xyz10", "xyz10.1", -1},
+		{"xyz.4", "8", -1},
+		{"xyz.4", "2", -1},
+		{"5.5p2", "5.6p1", -1},
+		{"5.6p1", "6.5p1", -1},
+		{"6.0.rc1", "6.0", 1},
+		{"10b2", "10a1", 1},
+		{"1.0aa", "1.0a", 1},
+		{"10.0001", "10.1", 0},
+		{"10.0001", "10.0039", -1},
+		{"4.999.9", "5.0", -1},
+		{"20101121", "20101122", -1},
+		{"2_0", "2_0", 0},
+		{"2.0", "2_0", 0},
+		{"a", "a", 0},
+		{"a+", "a_", 0},
+		{"+a", "_a", 0},
+		{"+", "_", 0},
+		{"1.0~rc1", "1.0~rc1", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~rc1~git123", "1.0~rc1", -1},
+		{"1.0^", "1.0^", 0},
+		{"1.0^", "1.0", 1},
+		{"1.0^git1", "1.0^git2", -1},
+		{"1.0^git1", "1.01", -1},
+		{"1.0^20160101", "1.0.1", -1},
+		{"1.0~rc1^git1", "1.0~rc1", 1},
+		{"1.0^git1~pre", "1.0^git1", -1},
+		{"el9_4", "el9_4.1", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if got := RPMVerCmp(tt.a, tt.b); got != tt.expected {
+				t.Errorf("RPMVerCmp(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.expected)
+			}
+			if got := RPMVerCmp(tt.b, tt.a); got != -tt.expected {
+				t.Errorf("RPMVerCmp(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompareRPM(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"7.76.1-29.el9_4", "7.76.1-29.el9_4.1", -1},
+		{"1:1.46.0-8.el9_4", "1.47.0-1.el9", 1},
+		{"0:1.0-1", "1.0-1", 0},
+		{"1.0", "1.0-5", 0},
+		{"5.14.0-427.40.1.el9_4", "5.14.0-427.37.1.el9_4", 1},
+		{"7.29.0-59.el7", "7.29.0-59.el7_9.2", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if got := CompareRPM(tt.a, tt.b); got != tt.expected {
+				t.Errorf("CompareRPM(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package zypper
+
+import (
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
+)
+
+// lockedExitCode is the exit code of zypper when another application holds the zypp lock
+const lockedExitCode = 7
+
+// classifyError recognizes the exit code of zypper when it is locked
+var classifyError = packagemanager.LockClassifier(func(result *commandrunner.CommandResult) bool {
+	return result.ExitCode == lockedExitCode
+})
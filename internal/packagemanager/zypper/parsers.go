@@ -0,0 +1,101 @@
+package zypper
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// zypperStream is the document zypper prints with --xmlout, the messages of the command come along with the
+// result of list-updates or search
+type zypperStream struct {
+	XMLName   xml.Name         `xml:"stream"`
+	Messages  []zypperMessage  `xml:"message"`
+	Updates   []zypperUpdate   `xml:"update-status>update-list>update"`
+	Solvables []zypperSolvable `xml:"search-result>solvable-list>solvable"`
+}
+
+// zypperMessage is a message of zypper, like the progress of loading the repositories or an error
+type zypperMessage struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// zypperUpdate is an update of list-updates, EditionOld is only reported by recent versions of zypper
+type zypperUpdate struct {
+	Kind       string `xml:"kind,attr"`
+	Name       string `xml:"name,attr"`
+	Edition    string `xml:"edition,attr"`
+	EditionOld string `xml:"edition-old,attr"`
+	Arch       string `xml:"arch,attr"`
+}
+
+// key returns the name and the architecture identifying the package among the installed ones
+func (u zypperUpdate) key() string {
+	return u.Name + "." + u.Arch
+}
+
+// zypperSolvable is a result of search, the edition and the architecture are only reported with --details
+type zypperSolvable struct {
+	Status     string `xml:"status,attr"`
+	Name       string `xml:"name,attr"`
+	Kind       string `xml:"kind,attr"`
+	Edition    string `xml:"edition,attr"`
+	Arch       string `xml:"arch,attr"`
+	Repository string `xml:"repository,attr"`
+}
+
+// key returns the name and the architecture identifying the package among the installed ones
+func (s zypperSolvable) key() string {
+	return s.Name + "." + s.Arch
+}
+
+// parseStream decodes the XML output of zypper, a stream reporting errors is returned as an error
+func parseStream(output []byte) (*zypperStream, error) {
+	var stream zypperStream
+	if err := xml.Unmarshal(output, &stream); err != nil {
+		return nil, fmt.Errorf("failed to decode zypper xml: %w", err)
+	}
+
+	if errs := stream.errors(); len(errs) > 0 {
+		return nil, fmt.Errorf("zypper reported errors: %s", strings.Join(errs, "; "))
+	}
+	return &stream, nil
+}
+
+// errors returns the error messages of the stream
+func (s *zypperStream) errors() []string {
+	var errs []string
+	for _, message := range s.Messages {
+		if message.Type == "error" {
+			errs = append(errs, strings.TrimSpace(message.Text))
+		}
+	}
+	return errs
+}
+
+// packageUpdates returns the updates of packages, leaving out patches and other kinds of updates
+func (s *zypperStream) packageUpdates() []zypperUpdate {
+	var updates []zypperUpdate
+	for _, update := range s.Updates {
+		if update.Kind == "" || update.Kind == "package" {
+			updates = append(updates, update)
+		}
+	}
+	return updates
+}
+
+// installedPackages returns the installed packages found by search, each package once even when several
+// repositories provide the installed version
+func (s *zypperStream) installedPackages() []zypperSolvable {
+	var installed []zypperSolvable
+	seen := make(map[string]bool)
+	for _, solvable := range s.Solvables {
+		if solvable.Status != "installed" || (solvable.Kind != "" && solvable.Kind != "package") || seen[solvable.key()] {
+			continue
+		}
+		seen[solvable.key()] = true
+		installed = append(installed, solvable)
+	}
+	return installed
+}
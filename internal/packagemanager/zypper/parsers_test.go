@@ -0,0 +1,122 @@
+package zypper
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestParseStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		fixture   string
+		output    string
+		updates   []zypperUpdate
+		installed []zypperSolvable
+		err       bool
+	}{
+		{
+			name:    "list-updates",
+			fixture: "testdata/list-updates.xml",
+			updates: []zypperUpdate{
+				{Kind: "package", Name: "curl", Edition: "8.6.0-150600.4.6.1", EditionOld: "8.6.0-150600.4.3.1", Arch: "x86_64"},
+				{Kind: "package", Name: "libcurl4", Edition: "8.6.0-150600.4.6.1", EditionOld: "8.6.0-150600.4.3.1", Arch: "x86_64"},
+			},
+		},
+		{
+			name:    "list-updates without old editions and with a patch",
+			fixture: "testdata/list-updates-legacy.xml",
+			updates: []zypperUpdate{
+				{Kind: "package", Name: "curl", Edition: "7.60.0-11.49.1", Arch: "x86_64"},
+			},
+		},
+		{
+			name:    "search installed",
+			fixture: "testdata/search-installed.xml",
+			installed: []zypperSolvable{
+				{Status: "installed", Name: "bash", Kind: "package", Edition: "4.4-150400.27.3.2", Arch: "x86_64", Repository: "(System Packages)"},
+				{Status: "installed", Name: "curl", Kind: "package", Edition: "8.6.0-150600.4.3.1", Arch: "x86_64", Repository: "(System Packages)"},
+				{Status: "installed", Name: "libcurl4", Kind: "package", Edition: "8.6.0-150600.4.3.1", Arch: "x86_64", Repository: "(System Packages)"},
+			},
+		},
+		{
+			name: "patches and products among the updates",
+			output: `<?xml version='1.0'?><stream><update-status version="0.6"><update-list>
+<update kind="patch" name="SUSE-SLE-Module-Basesystem-15-SP6-2024-3204" edition="1" arch="noarch"/>
+<update kind="product" name="SLES" edition="15.6-0" arch="x86_64"/>
+<update name="curl" edition="8.6.0-150600.4.6.1" arch="x86_64"/>
+</update-list></update-status></stream>`,
+			updates: []zypperUpdate{
+				{Name: "curl", Edition: "8.6.0-150600.4.6.1", Arch: "x86_64"},
+			},
+		},
+		{
+			name: "installed from several repositories and for several architectures",
+			output: `<?xml version='1.0'?><stream><search-result version="0.0"><solvable-list>
+<solvable status="installed" name="glibc" kind="package" edition="2.38-150600.14.5.1" arch="x86_64" repository="(System Packages)"/>
+<solvable status="installed" name="glibc" kind="package" edition="2.38-150600.14.5.1" arch="x86_64" repository="SLE-Module-Basesystem15-SP6-Updates"/>
+<solvable status="installed" name="glibc" kind="package" edition="2.38-150600.14.5.1" arch="i586" repository="(System Packages)"/>
+<solvable status="other-version" name="glibc" kind="package" edition="2.38-150600.14.8.2" arch="x86_64" repository="SLE-Module-Basesystem15-SP6-Updates"/>
+<solvable status="installed" name="sles-release" kind="product" edition="15.6-0" arch="x86_64" repository="(System Packages)"/>
+</solvable-list></search-result></stream>`,
+			installed: []zypperSolvable{
+				{Status: "installed", Name: "glibc", Kind: "package", Edition: "2.38-150600.14.5.1", Arch: "x86_64", Repository: "(System Packages)"},
+				{Status: "installed", Name: "glibc", Kind: "package", Edition: "2.38-150600.14.5.1", Arch: "i586", Repository: "(System Packages)"},
+			},
+		},
+		{
+			name:   "warning message",
+			output: `<?xml version='1.0'?><stream><message type="warning">Repository 'SLES15-SP6-Updates' is out-of-date.</message><update-status version="0.6"><update-list></update-list></update-status></stream>`,
+		},
+		{
+			name:   "no updates",
+			output: `<?xml version='1.0'?><stream><update-status version="0.6"><update-list></update-list></update-status></stream>`,
+		},
+		{
+			name:   "error message",
+			output: `<?xml version='1.0'?><stream><message type="error">Repository 'SLES15-SP6-Updates' is invalid.</message></stream>`,
+			err:    true,
+		},
+		{
+			name:   "not xml",
+			output: "Loading repository data...\n",
+			err:    true,
+		},
+		{
+			name:   "unexpected document",
+			output: `<?xml version='1.0'?><result></result>`,
+			err:    true,
+		},
+		{
+			name:   "empty output",
+			output: "",
+			err:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := []byte(tt.output)
+			if tt.fixture != "" {
+				var err error
+				if output, err = os.ReadFile(tt.fixture); err != nil {
+					t.Fatalf("failed to read fixture: %v", err)
+				}
+			}
+
+			stream, err := parseStream(output)
+			if (err != nil) != tt.err {
+				t.Fatalf("parseStream() error = %v, want error: %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if updates := stream.packageUpdates(); !slices.Equal(updates, tt.updates) {
+				t.Errorf("packageUpdates() = %v, want %v", updates, tt.updates)
+			}
+			if installed := stream.installedPackages(); !slices.Equal(installed, tt.installed) {
+				t.Errorf("installedPackages() = %v, want %v", installed, tt.installed)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package zypper
+
+import (
+	"errors"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+	"slices"
+)
+
+// successExitCodes are the exit codes of a successful update that report a reboot or a restart of services is needed
+var successExitCodes = []int{102, 103}
+
+// UpdatePackageSimulation simulates updating a package and returns a channel to read the output and stderr combined
+func (z *Zypper) UpdatePackageSimulation(pkg *models.Package) (<-chan string, error) {
+	if err := packagemanager.ValidatePackageName(packagemanager.RPMPackageName, pkg.Name); err != nil {
+		return nil, err
+	}
+	command := commandrunner.ExecCommand{Args: []string{z.CLI, "--non-interactive", "update", "--dry-run", pkg.Name}, Env: zypperEnv, Elevated: true}
+
+	return packagemanager.StreamOutput(z.CommandRunner, command, successExitCodes...)
+}
+
+// UpdatePackage updates a package and forwards the output to the output channel, in case the command fails
+// the error is returned
+func (z *Zypper) UpdatePackage(pkg *models.Package, output chan<- string) error {
+	if err := packagemanager.ValidatePackageName(packagemanager.RPMPackageName, pkg.Name); err != nil {
+		return err
+	}
+
+	return z.exec([]string{z.CLI, "--non-interactive", "update", pkg.Name}, output)
+}
+
+// UpdateAllPackages updates all packages and forwards the output to the output channel, in case the command fails
+// the error is returned
+func (z *Zypper) UpdateAllPackages(output chan<- string) error {
+	return z.exec([]string{z.CLI, "--non-interactive", "update"}, output)
+}
+
+// Refresh refreshes the metadata of the enabled repositories
+func (z *Zypper) Refresh(output chan<- string) error {
+	return z.exec([]string{z.CLI, "--non-interactive", "refresh"}, output)
+}
+
+// exec runs the command given as arguments and forwards its stdout and stderr lines to the output channel, it returns
+// as soon as the command finished while the remaining lines keep being delivered until the output channel is closed.
+// Updates asking for a reboot or a restart of services succeeded
+func (z *Zypper) exec(args []string, output chan<- string) error {
+	command := commandrunner.ExecCommand{Args: args, Env: zypperEnv, Elevated: true}
+
+	return packagemanager.Exec(z.CommandRunner, command, output, func(err error) error {
+		var exitErr *commandrunner.ExitError
+		if errors.As(err, &exitErr) && slices.Contains(successExitCodes, exitErr.Result.ExitCode) {
+			return nil
+		}
+		return classifyError(err)
+	})
+}
@@ -0,0 +1,129 @@
+package zypper
+
+import (
+	"fmt"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/persistence/models"
+)
+
+// zypperEnv keeps the messages of zypper independent of the locale of the host
+var zypperEnv = map[string]string{
+	"LC_ALL": "C",
+}
+
+// Zypper is a struct that represents the zypper package manager of SUSE Linux Enterprise and openSUSE
+type Zypper struct {
+	CLI           string
+	CommandRunner commandrunner.CommandRunner
+}
+
+// GetPackages lists all installed packages and returns them as a slice of Package structs, the version of
+// a package is the candidate version when an update is available and the installed version otherwise
+func (z *Zypper) GetPackages() ([]*models.Package, error) {
+	installed, err := z.searchInstalled()
+	if err != nil {
+		return nil, err
+	}
+
+	updates, err := z.listUpdates()
+	if err != nil {
+		return nil, err
+	}
+	candidates := make(map[string]string, len(updates))
+	for _, update := range updates {
+		candidates[update.key()] = update.Edition
+	}
+
+	packages := make([]*models.Package, 0, len(installed))
+	for _, solvable := range installed {
+		version := solvable.Edition
+		if candidate, ok := candidates[solvable.key()]; ok {
+			version = candidate
+		}
+		packages = append(packages, &models.Package{
+			Name:             solvable.Name,
+			Version:          version,
+			InstalledVersion: solvable.Edition,
+			Installed:        true,
+		})
+	}
+	return packages, nil
+}
+
+// GetUpgradablePackages lists all upgradeable packages and returns them as a slice of Package structs. Older
+// versions of zypper do not report the installed version of an update, it is looked up among the installed packages
+func (z *Zypper) GetUpgradablePackages() ([]*models.Package, error) {
+	updates, err := z.listUpdates()
+	if err != nil {
+		return nil, err
+	}
+
+	var installedVersions map[string]string
+	packages := make([]*models.Package, 0, len(updates))
+	for _, update := range updates {
+		installedVersion := update.EditionOld
+		if installedVersion == "" {
+			if installedVersions == nil {
+				if installedVersions, err = z.installedVersions(); err != nil {
+					return nil, err
+				}
+			}
+			installedVersion = installedVersions[update.key()]
+		}
+		packages = append(packages, &models.Package{
+			Name:             update.Name,
+			Version:          update.Edition,
+			InstalledVersion: installedVersion,
+			Installed:        true,
+		})
+	}
+	return packages, nil
+}
+
+// listUpdates lists the package updates of the enabled repositories
+func (z *Zypper) listUpdates() ([]zypperUpdate, error) {
+	stream, err := z.query(z.CLI, "--non-interactive", "--xmlout", "list-updates")
+	if err != nil {
+		return nil, err
+	}
+	return stream.packageUpdates(), nil
+}
+
+// searchInstalled lists the installed packages with their versions and architectures
+func (z *Zypper) searchInstalled() ([]zypperSolvable, error) {
+	stream, err := z.query(z.CLI, "--non-interactive", "--xmlout", "search", "-i", "-s", "-t", "package")
+	if err != nil {
+		return nil, err
+	}
+	return stream.installedPackages(), nil
+}
+
+// installedVersions returns the installed version of each package by name and architecture
+func (z *Zypper) installedVersions() (map[string]string, error) {
+	installed, err := z.searchInstalled()
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]string, len(installed))
+	for _, solvable := range installed {
+		versions[solvable.key()] = solvable.Edition
+	}
+	return versions, nil
+}
+
+// query runs the read-only command given as arguments and decodes its XML output
+func (z *Zypper) query(args ...string) (*zypperStream, error) {
+	command := commandrunner.ExecCommand{Args: args, Env: zypperEnv}
+
+	result, err := z.CommandRunner.RunCommand(command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute command: %s, err: %w", command.CommandLine(), classifyError(err))
+	}
+
+	stream, err := parseStream(result.Stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse output: %w", err)
+	}
+
+	return stream, nil
+}
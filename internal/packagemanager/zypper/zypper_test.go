@@ -0,0 +1,177 @@
+package zypper
+
+import (
+	"errors"
+	"os"
+	"sahand.dev/chisme/internal/commandrunner"
+	"sahand.dev/chisme/internal/packagemanager"
+	"sahand.dev/chisme/internal/persistence/models"
+	"slices"
+	"testing"
+)
+
+func TestZypper_GetPackages(t *testing.T) {
+	installed := `<?xml version='1.0'?>
+<stream>
+<search-result version="0.0">
+<solvable-list>
+<solvable status="installed" name="glibc" kind="package" edition="2.38-150600.14.5.1" arch="x86_64" repository="(System Packages)"/>
+<solvable status="installed" name="glibc" kind="package" edition="2.38-150600.14.5.1" arch="i586" repository="(System Packages)"/>
+</solvable-list>
+</search-result>
+</stream>
+`
+	updates := `<?xml version='1.0'?>
+<stream>
+<update-status version="0.6">
+<update-list>
+<update kind="package" name="glibc" edition="2.38-150600.14.8.2" edition-old="2.38-150600.14.5.1" arch="x86_64"/>
+</update-list>
+</update-status>
+</stream>
+`
+	replay, err := commandrunner.NewReplayRunner(&commandrunner.Cassette{Interactions: []commandrunner.Interaction{
+		{Command: "zypper --non-interactive --xmlout search -i -s -t package", Stdout: installed},
+		{Command: "zypper --non-interactive --xmlout list-updates", Stdout: updates},
+	}})
+	if err != nil {
+		t.Fatalf("NewReplayRunner() error = %v", err)
+	}
+	zypper := &Zypper{CLI: "zypper", CommandRunner: replay}
+
+	packages, err := zypper.GetPackages()
+	if err != nil {
+		t.Fatalf("GetPackages() failed: %v", err)
+	}
+	// only the architecture the update is for gets the candidate version
+	expected := []*models.Package{
+		{Name: "glibc", InstalledVersion: "2.38-150600.14.5.1", Version: "2.38-150600.14.8.2", Installed: true},
+		{Name: "glibc", InstalledVersion: "2.38-150600.14.5.1", Version: "2.38-150600.14.5.1", Installed: true},
+	}
+	if len(packages) != len(expected) {
+		t.Fatalf("GetPackages() returned %d packages, want %d", len(packages), len(expected))
+	}
+	for i := range packages {
+		if !packages[i].Equals(expected[i]) {
+			t.Errorf("Package at index %d is = %v, expected = %v", i, packages[i], expected[i])
+		}
+	}
+}
+
+func TestZypper_GetUpgradablePackages_Legacy(t *testing.T) {
+	updates, err := os.ReadFile("testdata/list-updates-legacy.xml")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	installed := `<?xml version='1.0'?>
+<stream>
+<search-result version="0.0">
+<solvable-list>
+<solvable status="installed" name="curl" kind="package" edition="7.60.0-11.43.1" arch="x86_64" repository="(System Packages)"/>
+</solvable-list>
+</search-result>
+</stream>
+`
+	replay, err := commandrunner.NewReplayRunner(&commandrunner.Cassette{Interactions: []commandrunner.Interaction{
+		{Command: "zypper --non-interactive --xmlout list-updates", Stdout: string(updates)},
+		{Command: "zypper --non-interactive --xmlout search -i -s -t package", Stdout: installed},
+	}})
+	if err != nil {
+		t.Fatalf("NewReplayRunner() error = %v", err)
+	}
+	zypper := &Zypper{CLI: "zypper", CommandRunner: replay}
+
+	upgradable, err := zypper.GetUpgradablePackages()
+	if err != nil {
+		t.Fatalf("GetUpgradablePackages() failed: %v", err)
+	}
+	expected := &models.Package{Name: "curl", InstalledVersion: "7.60.0-11.43.1", Version: "7.60.0-11.49.1", Installed: true}
+	if len(upgradable) != 1 || !upgradable[0].Equals(expected) {
+		t.Fatalf("GetUpgradablePackages() = %v, want %v", upgradable, expected)
+	}
+}
+
+func TestZypper_LockedError(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{
+		ExitCode: 7,
+		Output:   "System management is locked by the application with pid 1234 (zypper).",
+	}
+	zypper := &Zypper{CLI: "zypper", CommandRunner: mockRunner}
+
+	_, err := zypper.GetUpgradablePackages()
+	if !errors.Is(err, packagemanager.ErrLocked) {
+		t.Fatalf("GetUpgradablePackages() error = %v, want %v", err, packagemanager.ErrLocked)
+	}
+}
+
+func TestZypper_UpdateArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		run      func(z *Zypper, output chan<- string) error
+		exitCode int
+		expect   []string
+		err      bool
+	}{
+		{
+			name:   "refresh",
+			run:    func(z *Zypper, output chan<- string) error { return z.Refresh(output) },
+			expect: []string{"zypper", "--non-interactive", "refresh"},
+		},
+		{
+			name: "update package",
+			run: func(z *Zypper, output chan<- string) error {
+				return z.UpdatePackage(&models.Package{Name: "curl"}, output)
+			},
+			expect: []string{"zypper", "--non-interactive", "update", "curl"},
+		},
+		{
+			name:     "update all packages needing a reboot",
+			run:      func(z *Zypper, output chan<- string) error { return z.UpdateAllPackages(output) },
+			exitCode: 102,
+			expect:   []string{"zypper", "--non-interactive", "update"},
+		},
+		{
+			name:     "failed update",
+			run:      func(z *Zypper, output chan<- string) error { return z.UpdateAllPackages(output) },
+			exitCode: 8,
+			expect:   []string{"zypper", "--non-interactive", "update"},
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &commandrunner.MockCommandRunner{Output: "Loading repository data...", ExitCode: tt.exitCode}
+			zypper := &Zypper{CLI: "zypper", CommandRunner: mockRunner}
+
+			output := make(chan string)
+			go func() {
+				for range output {
+				}
+			}()
+
+			if err := tt.run(zypper, output); (err != nil) != tt.err {
+				t.Fatalf("%s error = %v, want error: %v", tt.name, err, tt.err)
+			}
+			commands := mockRunner.Commands()
+			if len(commands) != 1 || !slices.Equal(commands[0].Args, tt.expect) || !commands[0].Elevated {
+				t.Errorf("commands = %v, want the elevated command %v", commands, tt.expect)
+			}
+		})
+	}
+}
+
+func TestZypper_InvalidName(t *testing.T) {
+	mockRunner := &commandrunner.MockCommandRunner{}
+	zypper := &Zypper{CLI: "zypper", CommandRunner: mockRunner}
+
+	if _, err := zypper.UpdatePackageSimulation(&models.Package{Name: "--no-gpg-checks"}); !errors.Is(err, packagemanager.ErrInvalidPackageName) {
+		t.Errorf("UpdatePackageSimulation() error = %v, want %v", err, packagemanager.ErrInvalidPackageName)
+	}
+	if err := zypper.UpdatePackage(&models.Package{Name: "-y"}, make(chan string)); !errors.Is(err, packagemanager.ErrInvalidPackageName) {
+		t.Errorf("UpdatePackage() error = %v, want %v", err, packagemanager.ErrInvalidPackageName)
+	}
+	if commands := mockRunner.Commands(); len(commands) != 0 {
+		t.Errorf("commands = %v, want none for invalid names", commands)
+	}
+}
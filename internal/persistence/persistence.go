@@ -15,3 +15,10 @@ type PackageStore interface {
 	GetByName(name string) (*models.Package, error)
 	SaveOrUpdatePackage(pkg *models.Package) error
 }
+
+// HostKeyStore is an interface that represents the persistence layer for pinned ssh host key fingerprints,
+// GetFingerprint returns an empty fingerprint when no key has been pinned for the host yet
+type HostKeyStore interface {
+	GetFingerprint(host string) (string, error)
+	SaveFingerprint(host string, fingerprint string) error
+}
@@ -0,0 +1,44 @@
+package sqllitestore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// SQLiteHostKeyStore is a struct that represents a SQLite implementation of the HostKeyStore interface
+type SQLiteHostKeyStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteHostKeyStore is a function that returns a new SQLiteHostKeyStore
+func NewSQLiteHostKeyStore(db *sql.DB) *SQLiteHostKeyStore {
+	return &SQLiteHostKeyStore{db: db}
+}
+
+// GetFingerprint is a method that retrieves the pinned fingerprint of a host, it returns an empty
+// fingerprint when no key has been pinned for the host
+func (s *SQLiteHostKeyStore) GetFingerprint(host string) (string, error) {
+	row := s.db.QueryRow("SELECT fingerprint FROM host_keys WHERE host = ?", host)
+
+	var fingerprint string
+	err := row.Scan(&fingerprint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error getting host key fingerprint: %w", err)
+	}
+
+	return fingerprint, nil
+}
+
+// SaveFingerprint is a method that pins the fingerprint of a host, a host can only be pinned once
+func (s *SQLiteHostKeyStore) SaveFingerprint(host string, fingerprint string) error {
+	_, err := s.db.Exec("INSERT INTO host_keys (host, fingerprint) VALUES (?, ?)", host, fingerprint)
+	if err != nil {
+		return fmt.Errorf("error saving host key fingerprint: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package sqllitestore
+
+import (
+	"testing"
+)
+
+func TestSQLiteHostKeyStore_SaveAndGetFingerprint(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewSQLiteHostKeyStore(db)
+
+	fingerprint, err := store.GetFingerprint("[10.0.0.1]:2222")
+	if err != nil {
+		t.Fatalf("failed to get fingerprint: %v", err)
+	}
+	if fingerprint != "" {
+		t.Errorf("expected no fingerprint for an unknown host, got %q", fingerprint)
+	}
+
+	if err := store.SaveFingerprint("[10.0.0.1]:2222", "SHA256:abc"); err != nil {
+		t.Fatalf("failed to save fingerprint: %v", err)
+	}
+
+	fingerprint, err = store.GetFingerprint("[10.0.0.1]:2222")
+	if err != nil {
+		t.Fatalf("failed to get fingerprint: %v", err)
+	}
+	if fingerprint != "SHA256:abc" {
+		t.Errorf("retrieved fingerprint does not match saved fingerprint: got %q, want %q", fingerprint, "SHA256:abc")
+	}
+}
+
+func TestSQLiteHostKeyStore_SaveFingerprintTwice(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewSQLiteHostKeyStore(db)
+
+	if err := store.SaveFingerprint("10.0.0.1", "SHA256:abc"); err != nil {
+		t.Fatalf("failed to save fingerprint: %v", err)
+	}
+	if err := store.SaveFingerprint("10.0.0.1", "SHA256:def"); err == nil {
+		t.Fatalf("expected pinning a host twice to fail")
+	}
+}
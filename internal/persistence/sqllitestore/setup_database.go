@@ -5,7 +5,7 @@ import (
 	"fmt"
 )
 
-// SetupDatabase initializes the SQLite database and creates the packages table if it doesn't exist
+// SetupDatabase initializes the SQLite database and creates the packages and host_keys tables if they don't exist
 func SetupDatabase(db *sql.DB) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS packages (
@@ -15,6 +15,11 @@ func SetupDatabase(db *sql.DB) error {
 	    version TEXT NOT NULL,
 	    installed BOOLEAN NOT NULL,
 	    last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS host_keys (
+	    host TEXT PRIMARY KEY,
+	    fingerprint TEXT NOT NULL,
+	    first_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);`
 
 	_, err := db.Exec(query)